@@ -11,11 +11,17 @@ import (
 
 type (
 	Config struct {
-		App      `yaml:"app"`
-		Log      `yaml:"logger"`
-		RMQ      `yaml:"rabbitmq"`
-		Database `yaml:"database"`
-		Services `yaml:"services"`
+		App       `yaml:"app"`
+		Log       `yaml:"logger"`
+		RMQ       `yaml:"rabbitmq"`
+		Database  `yaml:"database"`
+		Services  `yaml:"services"`
+		Builds    `yaml:"builds"`
+		Signing   `yaml:"signing"`
+		SBOM      `yaml:"sbom"`
+		Scanning  `yaml:"scanning"`
+		Retention `yaml:"retention"`
+		Promotion `yaml:"promotion"`
 	}
 
 	App struct {
@@ -37,6 +43,100 @@ type (
 	Database struct {
 		Driver string `env-required:"true"  yaml:"driver" env:"DATABASE_DRIVER"`
 		URI    string `                                   env:"DATABASE_URI"`
+		// json file the memory (or mock) driver is seeded with, see memory.Fixtures
+		Fixtures string `yaml:"fixtures" env:"DATABASE_FIXTURES"`
+	}
+
+	Builds struct {
+		// used when the request doesn't set them
+		Limits Limits `yaml:"limits"`
+		// cap the limits set by the requests, zero values are uncapped
+		MaxLimits Limits  `yaml:"maxLimits"`
+		Network   Network `yaml:"network"`
+		// repositories the base images can come from and the docker hub mirror they
+		// are pulled through, every image is pulled from its registry if both are empty
+		BaseImages struct {
+			Allowed []string `yaml:"allowed"`
+			Mirror  string   `yaml:"mirror" env:"BUILD_BASE_IMAGE_MIRROR"`
+		} `yaml:"baseImages"`
+		// size of the build log sent in the response, the middle lines are dropped
+		MaxLogSizeKB int `yaml:"maxLogSizeKB"`
+	}
+
+	// the pushed images are signed when a key is set, the key can be generated with
+	// cosign generate-key-pair, its password is read from COSIGN_PASSWORD
+	Signing struct {
+		KeyPath string `yaml:"keyPath" env:"SIGNING_KEY_PATH"`
+	}
+
+	// the sbom (spdx) of the built images is attached to the pushed images
+	SBOM struct {
+		Enabled bool `yaml:"enabled" env:"SBOM_ENABLED"`
+	}
+
+	// the built images are scanned before the push when a scanner is set
+	Scanning struct {
+		Scanner string `yaml:"scanner" env:"SCANNER"` // trivy | harbor | fake
+		// the vulnerabilities of this severity or higher (CRITICAL, HIGH...) block the push,
+		// nothing is blocked if empty
+		BlockSeverity string `yaml:"blockSeverity"`
+		// the vulnerabilities without a fix don't block the push
+		IgnoreUnfixed bool `yaml:"ignoreUnfixed"`
+		// ids of the vulnerabilities that never block the push
+		Allowlist []string      `yaml:"allowlist"`
+		Trivy     Trivy         `yaml:"trivy"`
+		Harbor    HarborScanner `yaml:"harbor"`
+	}
+
+	// the images left on the host and in the registries by the builds
+	Retention struct {
+		// remove the built images from the host once pushed (or failed)
+		RemoveLocalImages bool `yaml:"removeLocalImages" env:"RETENTION_REMOVE_LOCAL_IMAGES"`
+		// the build cache, the dangling images and the isolated builders unused for
+		// cacheMaxAgeHours (24 by default) are pruned every cachePruneIntervalMinutes, never if zero
+		CachePruneIntervalMinutes int `yaml:"cachePruneIntervalMinutes"`
+		CacheMaxAgeHours          int `yaml:"cacheMaxAgeHours"`
+		// images of each application kept in the registries: the last keepLast and the ones
+		// built in the last keepDays. Nothing is deleted if both are zero
+		KeepLast int `yaml:"keepLast"`
+		KeepDays int `yaml:"keepDays"`
+	}
+
+	// the pushed images can be tagged with environment tags without rebuilding them
+	Promotion struct {
+		// tags the images can be promoted to (staging, production), the promotions
+		// are refused if empty. The promoted images are never deleted by the retention
+		Tags []string `yaml:"tags"`
+	}
+
+	Trivy struct {
+		Path           string `yaml:"path"`
+		CacheDir       string `yaml:"cacheDir"`
+		TimeoutSeconds int    `yaml:"timeoutSeconds"`
+	}
+
+	// the images are pushed to a namespace of the primary registry to be scanned,
+	// the adapter pulls them with the pull credentials of the registry
+	HarborScanner struct {
+		AdapterURL string `yaml:"adapterURL" env:"HARBOR_SCANNER_URL"`
+		Namespace  string `yaml:"namespace"`
+	}
+
+	Network struct {
+		Mode string `yaml:"mode"` // none | proxy | full (default), requests can override it
+		// docker network where the proxy is the only way out, required by the
+		// proxy mode since the tools can ignore the proxy env
+		ProxyNetwork string `yaml:"proxyNetwork"`
+		HTTPProxy    string `yaml:"httpProxy"  env:"BUILD_HTTP_PROXY"`
+		HTTPSProxy   string `yaml:"httpsProxy" env:"BUILD_HTTPS_PROXY"`
+		NoProxy      string `yaml:"noProxy"    env:"BUILD_NO_PROXY"`
+	}
+
+	Limits struct {
+		CPUs           float64 `yaml:"cpus"`
+		MemoryMB       int64   `yaml:"memoryMB"`
+		DiskMB         int64   `yaml:"diskMB"` // build context, disk written while building and built image
+		TimeoutSeconds int     `yaml:"timeoutSeconds"`
 	}
 
 	Services struct {
@@ -52,14 +152,79 @@ type (
 
 	Builder struct {
 		Name string `yaml:"name"`
+		// docker only
+		Legacy      bool `yaml:"legacy"`      // use the legacy builder instead of buildkit
+		InlineCache bool `yaml:"inlineCache"` // export the build cache inside the image
+		// buildx builder (docker-container driver) used for multi-platform images,
+		// create it with `docker buildx create --name <name> --driver docker-container`
+		MultiPlatformBuilder string `yaml:"multiPlatformBuilder"`
+		// build without the docker daemon using rootless buildkit (buildctl-daemonless.sh),
+		// it's used for the Dockerfiles generated by nixpacks too
+		Daemonless     bool   `yaml:"daemonless"`
+		BuildctlPath   string `yaml:"buildctlPath"`
+		BuildkitdFlags string `yaml:"buildkitdFlags"`
+		// buildpacks only
+		BuilderImage string `yaml:"builderImage"` // defaults to paketobuildpacks/builder-jammy-base
+		PackPath     string `yaml:"packPath"`
+		// static only
+		ServerImage string `yaml:"serverImage"` // defaults to nginxinc/nginx-unprivileged:stable-alpine
 	}
 
 	Registry struct {
 		Name          string `yaml:"name"`
 		ServerAddress string `env-required:"true" yaml:"serverAddress"` //env:"REGISTRY_SERVER_ADDRESS"
+		// store the layer cache of the applications as <user>/<application>:buildcache,
+		// buildkit pushes it with the credentials of the docker config (docker login)
+		BuildCache bool `yaml:"buildCache"`
+		// the first registry is the primary one, the images are pushed to the others
+		// (mirrors) in parallel. A failed push to an optional mirror doesn't fail the build
+		Optional bool `yaml:"optional"`
+		// prefix of the env variables with the credentials (<prefix>_USERNAME, <prefix>_PASSWORD,
+		// <prefix>_PULL_USERNAME, <prefix>_PULL_PASSWORD for the harbor scanner), defaults to REGISTRY
+		EnvPrefix string `yaml:"envPrefix"`
+		// harbor only, the project of each user is created with a storage quota (unlimited
+		// if zero) and a retention policy that keeps the retainTags most recently pushed
+		// tags of each repository (every tag if zero). The pull credentials of the users
+		// are robot accounts valid for credentialsDays (no expiry if zero)
+		StorageQuotaMB  int64 `yaml:"storageQuotaMB"`
+		RetainTags      int   `yaml:"retainTags"`
+		CredentialsDays int   `yaml:"credentialsDays"`
+		// refuse to push an image with a tag (the commit) that exists with a different
+		// image, the promotion tags and the build cache are moved anyway
+		ImmutableTags bool `yaml:"immutableTags"`
 	}
 )
 
+// Env returns the value of the credential env variable of the registry (USERNAME, PASSWORD...)
+func (r Registry) Env(name string) string {
+	prefix := r.EnvPrefix
+	if prefix == "" {
+		prefix = "REGISTRY"
+	}
+	return os.Getenv(prefix + "_" + name)
+}
+
+// HasBuilder reports if the builder is in the config
+func (s Services) HasBuilder(name string) bool {
+	for _, b := range s.Builders {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Builder returns the config of the builder with the given name,
+// the zero value is returned if the builder is not configured
+func (s Services) Builder(name string) Builder {
+	for _, b := range s.Builders {
+		if b.Name == name {
+			return b
+		}
+	}
+	return Builder{Name: name}
+}
+
 func NewConfig(configPath ...string) (*Config, error) {
 	cfg := new(Config)
 
@@ -99,7 +264,11 @@ func NewConfig(configPath ...string) (*Config, error) {
 		}
 	}
 
-	if cfg.Database.Driver != "mock" {
+	if len(cfg.Services.Registries) > 0 && cfg.Services.Registries[0].Optional {
+		return nil, fmt.Errorf("the primary registry %s can't be optional", cfg.Services.Registries[0].ServerAddress)
+	}
+
+	if cfg.Database.Driver != "mock" && cfg.Database.Driver != "memory" {
 		if cfg.Database.URI == "" {
 			return nil, fmt.Errorf("DATABASE_URI is not set, this env variable is required when using a non mock driver")
 		}
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 
 	"github.com/ipaas-org/image-builder/model"
+	buildpacksBuilder "github.com/ipaas-org/image-builder/providers/builders/buildpacks"
 	dockerBuilder "github.com/ipaas-org/image-builder/providers/builders/docker"
 	nixBuilder "github.com/ipaas-org/image-builder/providers/builders/nixpacks"
+	staticBuilder "github.com/ipaas-org/image-builder/providers/builders/static"
 )
 
 func (c *Controller) AnalyzeRepositoryContent(ctx context.Context, path, root, repo, branch string) (*model.RepoAnalisys, error) {
@@ -31,11 +33,18 @@ func (c *Controller) AnalyzeRepositoryContent(ctx context.Context, path, root, r
 	}
 	c.l.Infof("analyzed %s successfully", repo)
 	c.l.Debugf("repo info: %+v", repoInfo)
+	// the analyzer detects every builder, only the enabled ones can build the repo
+	detected := repoInfo.Builders
+	repoInfo.Builders = slices.DeleteFunc(slices.Clone(detected), func(kind model.BuilderKind) bool {
+		return c.Builders[kind] == nil
+	})
 	isBuildable := true
 	reason := ""
 	if len(repoInfo.Builders) == 0 {
 		isBuildable = false
-		if repoInfo.Docker == nil {
+		if len(detected) > 0 {
+			reason = fmt.Sprintf("the repo can only be built by %v, not enabled on this service", detected)
+		} else if repoInfo.Docker == nil {
 			reason = fmt.Sprintf("no Dockerfile found and in %s there are not enough information to automatically detect a build plan", root)
 		} else if repoInfo.Docker.DockerIgnoreFound {
 			reason = "no Dockerfile found and .dockerignore found, the dockerignore prevents our autobuilder from building the repo"
@@ -56,36 +65,53 @@ func (c *Controller) GenerateBuildConfig(ctx context.Context, repoAnalysis *mode
 	}
 
 	buildConfig := new(model.BuildConfig)
+	info := repoAnalysis.RepoInfo
 
+	switch {
 	// always default to docker
-	if repoAnalysis.RepoInfo.Docker == nil {
+	case info.Docker != nil && len(info.Docker.Dockerfiles) > 0:
+		// defaults to Dockerfile, if not found use the first dockerfile found
+		buildConfig.Builder = dockerBuilder.DockerBuilderKind
+		for _, dockerfile := range info.Docker.Dockerfiles {
+			if dockerfile == "Dockerfile" {
+				buildConfig.DockerfilePath = dockerfile
+				break
+			}
+		}
+		if buildConfig.DockerfilePath == "" {
+			buildConfig.DockerfilePath = info.Docker.Dockerfiles[0]
+		}
+	// spa and static sites don't need a runtime, serve them with the static builder
+	case info.Static != nil && c.Builders[staticBuilder.StaticBuilderKind] != nil:
+		c.l.Infof("using static for the detected %s site", info.Static.Framework)
+		buildConfig.Builder = staticBuilder.StaticBuilderKind
+		buildConfig.OutputDirectory = info.Static.OutputDirectory
+		buildConfig.InstallCommands = info.Static.InstallCommands
+		buildConfig.BuildCommands = info.Static.BuildCommands
+		spa := info.Static.SPA
+		buildConfig.SPAFallback = &spa
+	// java and dotnet build better with buildpacks, if the builder is enabled
+	case info.Buildpacks != nil && c.Builders[buildpacksBuilder.BuildpacksBuilderKind] != nil:
+		c.l.Infof("using buildpacks for the detected stacks %v", info.Buildpacks.Stacks)
+		buildConfig.Builder = buildpacksBuilder.BuildpacksBuilderKind
+	case info.NixPacks != nil:
 		// if there is a nixpacks.[json|toml] file it will defaults to that
 		// otherwise use the just generated plan
-		nixpacks := repoAnalysis.RepoInfo.NixPacks
+		nixpacks := info.NixPacks
+		buildConfig.Builder = nixBuilder.NixPackBuilderKind
 		if nixpacks.NixPacksConfigPath != "" {
 			buildConfig.NixpacksPath = nixpacks.NixPacksConfigPath
 		} else {
-			buildConfig.Builder = nixBuilder.NixPackBuilderKind
 			buildConfig.Envs = convertNixpacksVariablesToModelKeyValue(nixpacks.Variables)
 			buildConfig.NixPkgs = nixpacks.NixPackages
 			buildConfig.AptPkgs = nixpacks.AptPackages
 			buildConfig.NixLibs = nixpacks.NixLibraries
-			buildConfig.InstallCommand = strings.Join(nixpacks.InstallCommands, " ")
-			buildConfig.BuildCommand = strings.Join(nixpacks.BuildCommands, " ") //todo: check if we should add "; " as join
+			buildConfig.InstallCommands = nixpacks.InstallCommands
+			buildConfig.BuildCommands = nixpacks.BuildCommands
 			buildConfig.StartCommand = nixpacks.StartCommand
 		}
-	} else {
-		// defaults to Dockerfile, if not found use the first dockerfile found
-		buildConfig.Builder = dockerBuilder.DockerBuilderKind
-		for _, dockerfile := range repoAnalysis.RepoInfo.Docker.Dockerfiles {
-			if dockerfile == "Dockerfile" {
-				buildConfig.DockerfilePath = dockerfile
-				break
-			}
-		}
-		if buildConfig.DockerfilePath == "" {
-			buildConfig.DockerfilePath = repoAnalysis.RepoInfo.Docker.Dockerfiles[0]
-		}
+	default:
+		return nil, ErrNotBuildable
 	}
 
 	return buildConfig, nil
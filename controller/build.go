@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/repo"
+)
+
+// StartBuild creates the build of the request in the history, the history is
+// optional and a failure to write it never fails the build, it's only logged
+func (c *Controller) StartBuild(ctx context.Context, request *model.Request) *model.Build {
+	build := model.NewBuild(request, time.Now())
+	if c.BuildRepo == nil {
+		return build
+	}
+	if err := c.BuildRepo.Create(ctx, build); err != nil {
+		c.l.Warnf("unable to save build of %s in the history: %v", request.ApplicationID, err)
+		// it's created again once finished
+		build.ID = ""
+	}
+	return build
+}
+
+// stageStates is the state of the application during each stage of the build
+var stageStates = map[model.BuildStageName]model.ApplicationState{
+	model.BuildStagePull:    model.ApplicationStatePulling,
+	model.BuildStageAnalyze: model.ApplicationStateAnalyzing,
+	model.BuildStagePlan:    model.ApplicationStateAnalyzing,
+	model.BuildStageBuild:   model.ApplicationStateBuilding,
+	model.BuildStageSBOM:    model.ApplicationStateBuilding,
+	model.BuildStageScan:    model.ApplicationStateBuilding,
+	model.BuildStagePush:    model.ApplicationStatePushing,
+}
+
+// StartBuildStage marks the start of a stage of the build, the previous one is finished,
+// and moves the application to the state of the stage (see UpdateApplicationState)
+func (c *Controller) StartBuildStage(ctx context.Context, build *model.Build, stage model.BuildStageName) error {
+	if err := c.UpdateApplicationState(ctx, build.ApplicationID, stageStates[stage]); err != nil {
+		return err
+	}
+	build.StartStage(stage, time.Now())
+	return nil
+}
+
+// FinishBuild saves the result of the build, it must be called with the response sent
+func (c *Controller) FinishBuild(ctx context.Context, build *model.Build, response *model.BuildResponse) {
+	if build == nil {
+		return
+	}
+	build.Finish(response, time.Now())
+	c.saveBuild(ctx, build)
+}
+
+// RecordPushedImage saves the image pushed for the response in the running build,
+// a retry of the request reuses it instead of building the commit again (see FindPushedImage)
+func (c *Controller) RecordPushedImage(ctx context.Context, build *model.Build, response *model.BuildResponse) {
+	build.SetImage(response)
+	c.saveBuild(ctx, build)
+}
+
+// saveBuild saves the build in the history, it's created if the creation failed before
+func (c *Controller) saveBuild(ctx context.Context, build *model.Build) {
+	if c.BuildRepo == nil {
+		return
+	}
+	var err error
+	if build.ID == "" {
+		err = c.BuildRepo.Create(ctx, build)
+	} else {
+		err = c.BuildRepo.Update(ctx, build)
+	}
+	if err != nil {
+		c.l.Warnf("unable to save build of %s in the history: %v", build.ApplicationID, err)
+	}
+}
+
+// PreviousBuild returns the last build of the request, nil if the request
+// was never received or the history is not stored
+func (c *Controller) PreviousBuild(ctx context.Context, requestID string) *model.Build {
+	if c.BuildRepo == nil || requestID == "" {
+		return nil
+	}
+	build, err := c.BuildRepo.GetByRequestID(ctx, requestID)
+	if err != nil {
+		if err != repo.ErrNotFound {
+			c.l.Warnf("unable to get the builds of request %s: %v", requestID, err)
+		}
+		return nil
+	}
+	return build
+}
+
+// FindBuiltImage returns the last successful build of the commit with the same plan
+// if its image is still in the registry, the image name and digest are the ones in the registry.
+// Nil is returned if the image must be built
+func (c *Controller) FindBuiltImage(ctx context.Context, applicationID, userID, commit string, config *model.BuildConfig) (*model.Build, error) {
+	inspector, ok := c.Registry.(registry.ImageInspector)
+	if c.BuildRepo == nil || !ok || commit == "" {
+		return nil, nil
+	}
+	previous, err := c.BuildRepo.GetLastSuccessful(ctx, applicationID, commit, config.Hash())
+	if err != nil {
+		if err == repo.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	imageName, digest, err := inspector.ImageDigest(ctx, userID, applicationID+":"+commit)
+	if err != nil {
+		if errors.Is(err, registry.ErrImageNotFound) {
+			c.l.Infof("image of build %s was removed from the registry, building it again", previous.ID)
+			return nil, nil
+		}
+		return nil, err
+	}
+	previous.ImageName = imageName
+	previous.ImageDigest = digest
+	return previous, nil
+}
+
+// FindPushedImage returns previous, an earlier attempt of the request, if it pushed the
+// image of the commit with the same plan and the image is still the one in the registry.
+// With immutable tags a rebuilt image can't be pushed over it, the retry of a request
+// that failed after the push must reuse its image. Nil is returned if the image must be built
+func (c *Controller) FindPushedImage(ctx context.Context, previous *model.Build, userID, commit string, config *model.BuildConfig) (*model.Build, error) {
+	inspector, ok := c.Registry.(registry.ImageInspector)
+	if !ok || previous == nil || previous.ImageDigest == "" || commit == "" {
+		return nil, nil
+	}
+	if previous.Commit != commit || previous.PlanHash != config.Hash() {
+		return nil, nil
+	}
+	_, digest, err := inspector.ImageDigest(ctx, userID, previous.ApplicationID+":"+commit)
+	if err != nil {
+		if errors.Is(err, registry.ErrImageNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if digest != previous.ImageDigest {
+		c.l.Infof("image of build %s was replaced in the registry, building it again", previous.ID)
+		return nil, nil
+	}
+	return previous, nil
+}
+
+// ReuseImage sets the image of the previous build (see FindBuiltImage) as the result of build
+func (c *Controller) ReuseImage(build, previous *model.Build, response *model.BuildResponse) {
+	build.ReusedFrom = previous.ID
+	response.Reused = true
+	response.ImageID = previous.ImageID
+	response.SetImage(&model.PushedImage{
+		Name:      previous.ImageName,
+		Digest:    previous.ImageDigest,
+		MediaType: previous.ImageMediaType,
+		Size:      previous.ImageSize,
+		Platforms: previous.Platforms,
+	})
+	response.Registries = previous.Registries
+	response.Message = "the commit was already built with the same plan in build " + previous.ID
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/registry"
+)
+
+// applicationCache returns the layer cache of the application,
+// nil if the cache is disabled or the registry can't store it
+func (b *Controller) applicationCache(ctx context.Context, userID, applicationID string) *builders.Cache {
+	if !b.BuildCache || b.Registry == nil || applicationID == "" {
+		return nil
+	}
+	cacheRegistry, ok := b.Registry.(registry.CacheRegistry)
+	if !ok {
+		b.l.Warn("the registry can't store the build cache, building without it")
+		return nil
+	}
+	ref, err := cacheRegistry.CacheReference(ctx, userID, applicationID)
+	if err != nil {
+		// a cold build is better than a failed one
+		b.l.Warnf("error getting the cache reference of %s, building without cache: %v", applicationID, err)
+		return nil
+	}
+	return &builders.Cache{
+		Key:  applicationID,
+		From: []string{ref},
+		To:   ref,
+	}
+}
+
+// PushCache pushes the image with the cache tag of the application, so the next build
+// can import the cache written in the image. Images in an oci layout are skipped
+// since their builder already exported the cache
+func (b *Controller) PushCache(ctx context.Context, imageID, userID, applicationID string) error {
+	if !b.BuildCache || b.Registry == nil {
+		return nil
+	}
+	if _, ok := builders.OCILayoutPath(imageID); ok {
+		return nil
+	}
+	if _, ok := b.Registry.(registry.CacheRegistry); !ok {
+		return nil
+	}
+
+	b.l.Infof("pushing build cache of %s", applicationID)
+	appName := fmt.Sprintf("%s:%s", applicationID, registry.CacheTag)
+	if pusher, ok := b.Registry.(registry.LocalImagePusher); ok {
+		_, err := pusher.PushLocalImage(ctx, imageID, userID, appName)
+		return err
+	}
+	toPush, err := b.Registry.TagImage(ctx, imageID, userID, appName)
+	if err != nil {
+		return err
+	}
+	_, err = b.Registry.PushImage(ctx, toPush)
+	return err
+}
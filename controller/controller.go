@@ -1,11 +1,19 @@
 package controller
 
 import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/ipaas-org/image-builder/model"
 	"github.com/ipaas-org/image-builder/providers/analyzers"
 	"github.com/ipaas-org/image-builder/providers/builders"
 	"github.com/ipaas-org/image-builder/providers/connectors"
 	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/sbom"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+	"github.com/ipaas-org/image-builder/providers/signing"
 	"github.com/ipaas-org/image-builder/repo"
 	"github.com/sirupsen/logrus"
 )
@@ -13,12 +21,55 @@ import (
 // var _ BuilderController = new(Builder)
 
 type Controller struct {
-	connectors      map[string]connectors.Connector
-	Builders        map[model.BuilderKind]builders.Builder
-	Analyzer        analyzers.Analyzer
-	Registry        registry.Registryer
+	connectors map[string]connectors.Connector
+	Builders   map[model.BuilderKind]builders.Builder
+	Analyzer   analyzers.Analyzer
+	Registry   registry.Registryer
+	// server address of the primary registry, reported in the push results
+	RegistryName string
+	// registries the images are pushed to besides the primary one
+	Mirrors []RegistryTarget
+	// import and export the layer cache of the applications from the registry
+	BuildCache bool
+	// signs the pushed images and attests their provenance, optional
+	Signer *signing.Signer
+	// lists the packages of the built images, the sbom is attached to the pushed images, optional
+	SBOM *sbom.Generator
+	// scans the built images before the push, the policy decides which vulnerabilities
+	// block the push. Optional
+	Scanner    scanners.Scanner
+	ScanPolicy scanners.Policy
+	// remove the built images from the host once the build is done, only if they are pushed
+	RemoveLocalImages bool
+	// images of the applications kept in the registries, it requires the history of the builds
+	Retention RetentionPolicy
+	// environment tags (staging, production) the images can be promoted to, the promoted
+	// images are never deleted by the retention. The promotions are refused if empty
+	PromotionTags []string
+	// version of the service, recorded in the provenance of the images
+	Version string
+	// limits of the builds that don't override them and the max the requests can set
+	DefaultLimits model.BuildLimits
+	MaxLimits     model.BuildLimits
+	// default network policy of the builds, requests can change the mode
+	Network builders.Network
+	// base images the builds can pull and the mirror they are pulled through,
+	// nil if the builds can pull any image from its registry
+	BaseImages *builders.BaseImages
+	// where the log of each build is streamed while it runs,
+	// if nil the log is streamed to the logger at debug level
+	LogSink func(applicationID string) io.Writer
+	// size of the log kept for the response, builders.DefaultLogSize if not set
+	MaxLogSize      int
 	ApplicationRepo repo.ApplicationRepoer
-	l               *logrus.Logger
+	// history of the builds, optional
+	BuildRepo repo.BuildRepoer
+	// leases of the applications being built, optional with a single replica
+	LockRepo repo.LockRepoer
+	LeaseTTL time.Duration
+	// owner of the leases, unique per replica
+	id string
+	l  *logrus.Logger
 }
 
 func NewController(log *logrus.Logger) *Controller {
@@ -26,7 +77,17 @@ func NewController(log *logrus.Logger) *Controller {
 		connectors: make(map[string]connectors.Connector),
 		Builders:   make(map[model.BuilderKind]builders.Builder),
 		l:          log,
+		id:         replicaID(),
+	}
+}
+
+// replicaID identifies the replica, the hostname is the pod name in kubernetes
+func replicaID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "image-builder"
 	}
+	return hostname + "-" + uuid.NewString()[:8]
 }
 
 func (c *Controller) AddConnector(name string, conn connectors.Connector) {
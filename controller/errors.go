@@ -10,4 +10,20 @@ var (
 	ErrMissingRegistry   = errors.New("missing registry")
 	ErrInexistingRootDir = errors.New("inexisting root directory")
 	ErrNotBuildable      = errors.New("not buildable")
+
+	ErrOCILayoutNotSupported  = errors.New("the registry does not support pushing oci layouts")
+	ErrMissingProxy           = errors.New("proxy network mode requested but no proxy is configured")
+	ErrSigningNotSupported    = errors.New("the registry does not support storing signatures")
+	ErrArtifactsNotSupported  = errors.New("the registry does not support storing artifacts")
+	ErrImageVulnerable        = errors.New("the image has vulnerabilities blocked by the policy")
+	ErrUsersNotSupported      = errors.New("the registry does not manage the users")
+	ErrMissingUser            = errors.New("missing user")
+	ErrPromotionNotSupported  = errors.New("the registry does not support promoting images")
+	ErrInvalidPromotion       = errors.New("invalid promotion")
+	ErrPromotionTagNotAllowed = errors.New("the tag is not a promotion tag")
+
+	ErrInvalidStateTransition = errors.New("invalid application state transition")
+	ErrStateConflict          = errors.New("application state changed concurrently")
+	ErrBuildCancelled         = errors.New("the build was cancelled")
+	ErrBuildLocked            = errors.New("the application is being built by another replica")
 )
@@ -6,14 +6,14 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/ipaas-org/image-builder/model"
 	"github.com/ipaas-org/image-builder/providers/builders"
-	"github.com/ipaas-org/image-builder/providers/builders/docker"
-	"github.com/ipaas-org/image-builder/providers/builders/nixpacks"
+	"github.com/ipaas-org/image-builder/providers/registry"
 )
 
-func (b *Controller) BuildImage(ctx context.Context, repo, userID, repoPath string, config *model.BuildConfig) (imageID string, imageOutput []byte, err error) {
+func (b *Controller) BuildImage(ctx context.Context, applicationID, repo, userID, repoPath string, config *model.BuildConfig) (imageID string, imageOutput []byte, err error) {
 	//clean up the path
 	defer func() {
 		b.l.Infof("cleaning up %s", repoPath)
@@ -34,17 +34,16 @@ func (b *Controller) BuildImage(ctx context.Context, repo, userID, repoPath stri
 		return "", nil, ErrBuilderNotFound
 	}
 
-	var builder builders.Builder
-	switch config.Builder {
-	case "docker":
-		b.l.Info("using docker as builder")
-		builder = b.Builders[docker.DockerBuilderKind]
-	case "nixpacks":
-		b.l.Info("using nixpacks as builder")
-		builder = b.Builders[nixpacks.NixPackBuilderKind]
-	default:
+	// multi-platform images are not loaded in the daemon, they only exist once pushed
+	if len(config.Platforms) > 1 && b.Registry == nil {
+		return "", nil, ErrMissingRegistry
+	}
+
+	builder, ok := b.Builders[config.Builder]
+	if !ok {
 		return "", nil, ErrBuilderNotFound
 	}
+	b.l.Infof("using %s as builder", config.Builder)
 
 	b.l.Debug("planning build")
 	buildPlan, err := builder.Plan(ctx, config, path)
@@ -55,13 +54,66 @@ func (b *Controller) BuildImage(ctx context.Context, repo, userID, repoPath stri
 
 	b.l.Debugf("build plan: %+v", buildPlan)
 	b.l.Info("plan created successfully")
+
+	network, err := b.buildNetwork(config.Network)
+	if err != nil {
+		return "", nil, err
+	}
+	limits := b.buildLimits(config.Limits)
+	// the disk limit is checked on the context and on the image, the builders watch
+	// the disk used while building
+	if limits != nil && limits.Disk > 0 {
+		size, err := builders.DirSize(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if size > limits.Disk {
+			return "", nil, builders.DiskLimitError(limits)
+		}
+	}
+	buildCtx := ctx
+	if limits != nil && limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		buildCtx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+
 	b.l.Debug("building image")
-	imageID, imageOutput, err = builder.Build(ctx, userID, repo, path, buildPlan)
+	imageID, imageOutput, err = builder.Build(buildCtx, userID, repo, path, buildPlan, builders.BuildOptions{
+		Secrets:    config.Secrets,
+		Platforms:  config.Platforms,
+		Cache:      b.applicationCache(ctx, userID, applicationID),
+		Limits:     limits,
+		Network:    network,
+		BaseImages: b.BaseImages,
+		Log:        b.buildLog(applicationID),
+	})
 	if err != nil {
+		// the builders are killed when the context expires, whatever error they return
+		if buildCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = builders.TimeoutLimitError(limits)
+		}
 		b.l.Errorf("error building image: %v", err)
 		b.l.Errorf("build output: %s", imageOutput)
 		return "", imageOutput, err
 	}
+	layoutPath, isLayout := builders.OCILayoutPath(imageID)
+	if limits != nil && limits.Disk > 0 {
+		size, err := imageSize(ctx, imageID)
+		if err != nil {
+			b.l.Warnf("unable to get the size of %s: %v", imageID, err)
+		} else if size > limits.Disk {
+			if err := b.RemoveLocalImage(ctx, imageID); err != nil {
+				b.l.Warnf("error removing the image %s over the disk limit: %v", imageID, err)
+			}
+			return "", imageOutput, builders.DiskLimitError(limits)
+		}
+	}
+	// images in an oci layout are not in the daemon, they only exist once pushed
+	if isLayout && b.Registry == nil {
+		os.RemoveAll(layoutPath)
+		return "", imageOutput, ErrMissingRegistry
+	}
 	b.l.Infof("image built successfully: id=%s", imageID)
 	return imageID, imageOutput, nil
 
@@ -72,23 +124,115 @@ func (b *Controller) GenerateImageName(userID string, info *model.PulledRepoInfo
 	return fmt.Sprintf("%s/%s:%s", "applications", repo, info.PulledCommit)
 }
 
-func (b *Controller) PushImage(ctx context.Context, imageID, username, appName string) (string, error) {
+// RegistryTarget is a registry the images are pushed to besides the primary one
+type RegistryTarget struct {
+	Name     string // server address, reported in the push results
+	Registry registry.Registryer
+	// a failed push is reported but doesn't fail the build
+	Optional bool
+}
+
+// PushImage pushes the image to the primary registry and to the mirrors in parallel,
+// the image pushed to the primary registry and the result of every push are returned.
+// An error is returned if the push to the primary registry or to a required mirror fails.
+// Images built as an oci layout are pushed directly and removed once every push is done
+func (b *Controller) PushImage(ctx context.Context, imageID, username, appName string) (*model.PushedImage, []model.RegistryPush, error) {
 	if b.Registry == nil {
-		return "", ErrMissingRegistry
+		return nil, nil, ErrMissingRegistry
+	}
+	if layoutPath, ok := builders.OCILayoutPath(imageID); ok {
+		defer func() {
+			b.l.Infof("cleaning up %s", layoutPath)
+			os.RemoveAll(layoutPath)
+		}()
+	}
+
+	targets := b.registryTargets()
+	images := make([]*model.PushedImage, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target RegistryTarget) {
+			defer wg.Done()
+			images[i], errs[i] = b.pushTo(ctx, target, imageID, username, appName)
+		}(i, target)
 	}
+	wg.Wait()
+
+	results := make([]model.RegistryPush, len(targets))
+	var err error
+	for i, target := range targets {
+		results[i] = model.RegistryPush{
+			Registry: target.Name,
+			Optional: target.Optional,
+			Status:   model.ResponseStatusSuccess,
+			Image:    images[i],
+		}
+		if errs[i] == nil {
+			continue
+		}
+		results[i].Status = model.ResponseStatusFailed
+		results[i].Error = errs[i].Error()
+		if target.Optional {
+			b.l.Warnf("push to optional registry %s failed: %v", target.Name, errs[i])
+		} else if err == nil {
+			err = fmt.Errorf("push to %s: %w", target.Name, errs[i])
+		}
+	}
+	if err != nil {
+		return nil, results, err
+	}
+	return images[0], results, nil
+}
+
+// registryTargets returns the primary registry followed by the mirrors
+func (b *Controller) registryTargets() []RegistryTarget {
+	return append([]RegistryTarget{{Name: b.RegistryName, Registry: b.Registry}}, b.Mirrors...)
+}
+
+// pushTo pushes the image to a single registry, see PushImage
+func (b *Controller) pushTo(ctx context.Context, target RegistryTarget, imageID, username, appName string) (*model.PushedImage, error) {
 	newImageName := fmt.Sprintf("%s/%s", username, appName)
-	b.l.Infof("pushing image %s as %s", imageID, newImageName)
-	toPush, err := b.Registry.TagImage(ctx, imageID, username, appName)
+
+	if layoutPath, ok := builders.OCILayoutPath(imageID); ok {
+		pusher, ok := target.Registry.(registry.IndexPusher)
+		if !ok {
+			return nil, ErrOCILayoutNotSupported
+		}
+		b.l.Infof("pushing oci layout %s as %s to %s", layoutPath, newImageName, target.Name)
+		pushed, err := pusher.PushIndex(ctx, layoutPath, username, appName)
+		if err != nil {
+			b.l.Errorf("error pushing oci layout %s to %s: %v", newImageName, target.Name, err)
+			return nil, err
+		}
+		b.l.Infof("pushed %s", pushed.Reference())
+		return pushed, nil
+	}
+
+	b.l.Infof("pushing image %s as %s to %s", imageID, newImageName, target.Name)
+	if pusher, ok := target.Registry.(registry.LocalImagePusher); ok {
+		pushed, err := pusher.PushLocalImage(ctx, imageID, username, appName)
+		if err != nil {
+			b.l.Errorf("error pushing image %s as %s: %v", imageID, newImageName, err)
+			return nil, err
+		}
+		b.l.Infof("pushed %s", pushed.Reference())
+		return pushed, nil
+	}
+	toPush, err := target.Registry.TagImage(ctx, imageID, username, appName)
 	if err != nil {
 		b.l.Errorf("error tagging image %s as %s: %v", imageID, newImageName, err)
-		return "", err
+		return nil, err
 	}
 
-	if err := b.Registry.PushImage(ctx, toPush); err != nil {
+	pushed, err := target.Registry.PushImage(ctx, toPush)
+	if err != nil {
 		b.l.Errorf("error pushing image %s: %v", toPush, err)
-		return "", err
+		return nil, err
 	}
-	return toPush, nil
+	b.l.Infof("pushed %s", pushed.Reference())
+	return pushed, nil
 }
 
 func (b *Controller) IsPushRequired() bool {
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultLeaseTTL is the duration of the build leases when not configured,
+// the lease is renewed while the build runs so it only matters if the replica dies
+const DefaultLeaseTTL = 2 * time.Minute
+
+// BuildLease is held by the replica building an application,
+// it's renewed in background until released
+type BuildLease struct {
+	key    string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// AcquireBuildLease takes the lease of the application, ErrBuildLocked is returned
+// if another replica is building it. Nil is returned if the leases are not stored.
+// A running state with a free lease is left by a replica that died while building,
+// the application is moved back to queued so it can be built again
+func (c *Controller) AcquireBuildLease(ctx context.Context, applicationID string) (*BuildLease, error) {
+	if c.LockRepo == nil {
+		return nil, nil
+	}
+	ttl := c.LeaseTTL
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	key := "build:" + applicationID
+	acquired, err := c.LockRepo.AcquireLease(ctx, key, c.id, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, ErrBuildLocked
+	}
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	lease := &BuildLease{key: key, cancel: cancel, done: make(chan struct{})}
+	go c.renewLease(renewCtx, lease, ttl)
+
+	if err := c.recoverApplicationState(ctx, applicationID); err != nil {
+		c.ReleaseBuildLease(lease)
+		return nil, err
+	}
+	return lease, nil
+}
+
+// ReleaseBuildLease stops renewing the lease and releases it
+func (c *Controller) ReleaseBuildLease(lease *BuildLease) {
+	if lease == nil {
+		return
+	}
+	lease.cancel()
+	<-lease.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.LockRepo.ReleaseLease(ctx, lease.key, c.id); err != nil {
+		// the lease expires on its own
+		c.l.Warnf("unable to release lease %s: %v", lease.key, err)
+	}
+}
+
+func (c *Controller) renewLease(ctx context.Context, lease *BuildLease, ttl time.Duration) {
+	defer close(lease.done)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := c.LockRepo.AcquireLease(ctx, lease.key, c.id, ttl)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				c.l.Warnf("unable to renew lease %s: %v", lease.key, err)
+			case !renewed:
+				c.l.Errorf("lease %s was taken by another replica", lease.key)
+			}
+		}
+	}
+}
+
+func (c *Controller) recoverApplicationState(ctx context.Context, applicationID string) error {
+	appID, err := primitive.ObjectIDFromHex(applicationID)
+	if err != nil {
+		return err
+	}
+	state, err := c.ApplicationRepo.GetStateByID(ctx, appID)
+	if err != nil || !state.IsBuilding() {
+		return err
+	}
+	c.l.Warnf("application %s was left %q by a previous build, moving it back to queued", applicationID, state)
+	return c.UpdateApplicationState(ctx, applicationID, model.ApplicationStateQueued)
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	dockerBuilder "github.com/ipaas-org/image-builder/providers/builders/docker"
+)
+
+// buildLimits merges the limits of the request with the default ones,
+// the result is capped by the max limits. Nil is returned if the build is unlimited
+func (b *Controller) buildLimits(override *model.BuildLimits) *builders.Limits {
+	limits := b.DefaultLimits
+	if override != nil {
+		if override.CPUs > 0 {
+			limits.CPUs = override.CPUs
+		}
+		if override.MemoryMB > 0 {
+			limits.MemoryMB = override.MemoryMB
+		}
+		if override.DiskMB > 0 {
+			limits.DiskMB = override.DiskMB
+		}
+		if override.TimeoutSeconds > 0 {
+			limits.TimeoutSeconds = override.TimeoutSeconds
+		}
+	}
+
+	max := b.MaxLimits
+	if max.CPUs > 0 && (limits.CPUs == 0 || limits.CPUs > max.CPUs) {
+		limits.CPUs = max.CPUs
+	}
+	if max.MemoryMB > 0 && (limits.MemoryMB == 0 || limits.MemoryMB > max.MemoryMB) {
+		limits.MemoryMB = max.MemoryMB
+	}
+	if max.DiskMB > 0 && (limits.DiskMB == 0 || limits.DiskMB > max.DiskMB) {
+		limits.DiskMB = max.DiskMB
+	}
+	if max.TimeoutSeconds > 0 && (limits.TimeoutSeconds == 0 || limits.TimeoutSeconds > max.TimeoutSeconds) {
+		limits.TimeoutSeconds = max.TimeoutSeconds
+	}
+
+	if limits == (model.BuildLimits{}) {
+		return nil
+	}
+	return &builders.Limits{
+		CPUs:    limits.CPUs,
+		Memory:  limits.MemoryMB * 1024 * 1024,
+		Disk:    limits.DiskMB * 1024 * 1024,
+		Timeout: time.Duration(limits.TimeoutSeconds) * time.Second,
+	}
+}
+
+// buildNetwork returns the network policy of the build, the mode of the request
+// overrides the default one. Nil is returned if the build can use the network freely
+func (b *Controller) buildNetwork(mode string) (*builders.Network, error) {
+	network := b.Network
+	if mode != "" {
+		m, err := builders.ParseNetworkMode(mode)
+		if err != nil {
+			return nil, err
+		}
+		network.Mode = m
+	}
+	if !network.Isolated() {
+		return nil, nil
+	}
+	if network.Mode == builders.NetworkProxy && network.HTTPProxy == "" && network.HTTPSProxy == "" {
+		return nil, ErrMissingProxy
+	}
+	if err := network.Check(); err != nil {
+		return nil, err
+	}
+	return &network, nil
+}
+
+// imageSize returns the size of the built image, on disk for oci layouts
+func imageSize(ctx context.Context, imageID string) (int64, error) {
+	if layoutPath, ok := builders.OCILayoutPath(imageID); ok {
+		return builders.DirSize(layoutPath)
+	}
+	return dockerBuilder.ImageSize(ctx, imageID)
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"io"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/sirupsen/logrus"
+)
+
+// buildLog returns the log the builders write the output of the build in,
+// without a LogSink the lines are streamed to the logger at debug level
+func (b *Controller) buildLog(applicationID string) *builders.Log {
+	var sink io.Writer
+	switch {
+	case b.LogSink != nil:
+		sink = b.LogSink(applicationID)
+	case b.l.IsLevelEnabled(logrus.DebugLevel):
+		sink = &loggerSink{l: b.l.WithField("applicationID", applicationID)}
+	}
+	return builders.NewLog(sink, b.MaxLogSize)
+}
+
+// loggerSink writes each line of the build log as a log entry
+type loggerSink struct {
+	l *logrus.Entry
+}
+
+func (s *loggerSink) Write(p []byte) (int, error) {
+	// the build log writes one line at a time
+	s.l.Debug(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/opencontainers/go-digest"
+)
+
+// PromoteImage tags a pushed image of the application as each of the promotion tags in the
+// primary registry and in the mirrors, the image is not rebuilt nor pushed again. The image
+// is the digest of the promotion or, if empty, the image of its commit in the primary registry.
+// The promotions to the primary registry and to the required mirrors must succeed, the image
+// of the first tag in the primary registry and the result of each promotion are returned
+func (b *Controller) PromoteImage(ctx context.Context, userCode, applicationID string, promotion *model.PromotionRequest) (*model.PushedImage, []model.RegistryPush, error) {
+	if err := b.ValidatePromotion(userCode, applicationID, promotion); err != nil {
+		return nil, nil, err
+	}
+	imageDigest, err := b.promotedImage(ctx, userCode, applicationID, promotion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var primary *model.PushedImage
+	var results []model.RegistryPush
+	for _, target := range b.registryTargets() {
+		for _, tag := range promotion.Tags {
+			pushed, err := b.promoteTo(ctx, target, userCode, applicationID+":"+tag, imageDigest)
+			result := model.RegistryPush{
+				Registry: target.Name,
+				Optional: target.Optional,
+				Status:   model.ResponseStatusSuccess,
+				Image:    pushed,
+			}
+			if err != nil {
+				result.Status = model.ResponseStatusFailed
+				result.Error = err.Error()
+				if !target.Optional {
+					return nil, append(results, result), fmt.Errorf("promote to %s: %w", target.Name, err)
+				}
+				b.l.Warnf("promotion of %s to optional registry %s failed: %v", applicationID, target.Name, err)
+			}
+			if primary == nil {
+				primary = pushed
+			}
+			results = append(results, result)
+		}
+	}
+	return primary, results, nil
+}
+
+// ValidatePromotion checks the promotion before the registries are involved, the
+// tags must be promotion tags and the primary registry must support the promotions
+func (b *Controller) ValidatePromotion(userCode, applicationID string, promotion *model.PromotionRequest) error {
+	if userCode == "" {
+		return ErrMissingUser
+	}
+	if applicationID == "" || promotion == nil || len(promotion.Tags) == 0 {
+		return fmt.Errorf("%w: the application and the tags are required", ErrInvalidPromotion)
+	}
+	if promotion.Digest != "" {
+		if _, err := digest.Parse(promotion.Digest); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPromotion, err)
+		}
+	}
+	for _, tag := range promotion.Tags {
+		if !slices.Contains(b.PromotionTags, tag) {
+			return fmt.Errorf("%w: %q", ErrPromotionTagNotAllowed, tag)
+		}
+	}
+	if b.Registry == nil {
+		return ErrMissingRegistry
+	}
+	if _, ok := b.Registry.(registry.ImagePromoter); !ok {
+		return ErrPromotionNotSupported
+	}
+	return nil
+}
+
+// promotedImage returns the digest of the image to promote, the image of the commit
+// is resolved in the primary registry
+func (b *Controller) promotedImage(ctx context.Context, userCode, applicationID string, promotion *model.PromotionRequest) (string, error) {
+	if promotion.Digest != "" {
+		return promotion.Digest, nil
+	}
+	if promotion.Commit == "" {
+		return "", fmt.Errorf("%w: the digest or the commit of the image is required", ErrInvalidPromotion)
+	}
+	inspector, ok := b.Registry.(registry.ImageInspector)
+	if !ok {
+		return "", ErrPromotionNotSupported
+	}
+	_, imageDigest, err := inspector.ImageDigest(ctx, userCode, applicationID+":"+promotion.Commit)
+	if err != nil {
+		return "", fmt.Errorf("image of commit %s: %w", promotion.Commit, err)
+	}
+	return imageDigest, nil
+}
+
+// promoteTo tags the image in a single registry, see PromoteImage
+func (b *Controller) promoteTo(ctx context.Context, target RegistryTarget, userCode, appName, imageDigest string) (*model.PushedImage, error) {
+	promoter, ok := target.Registry.(registry.ImagePromoter)
+	if !ok {
+		return nil, ErrPromotionNotSupported
+	}
+	b.l.Infof("promoting %s as %s/%s in %s", imageDigest, userCode, appName, target.Name)
+	pushed, err := promoter.PromoteImage(ctx, userCode, appName, imageDigest)
+	if err != nil {
+		b.l.Errorf("error promoting %s as %s/%s in %s: %v", imageDigest, userCode, appName, target.Name, err)
+		return nil, err
+	}
+	b.l.Infof("promoted %s", pushed.Name)
+	return pushed, nil
+}
+
+// promotedDigests returns the digests of the images of the application that have a promotion
+// tag in the primary registry, they must not be deleted
+func (b *Controller) promotedDigests(ctx context.Context, userCode, applicationID string) (map[string]bool, error) {
+	promoted := make(map[string]bool)
+	if len(b.PromotionTags) == 0 || userCode == "" {
+		return promoted, nil
+	}
+	inspector, ok := b.Registry.(registry.ImageInspector)
+	if !ok {
+		return promoted, nil
+	}
+	for _, tag := range b.PromotionTags {
+		_, imageDigest, err := inspector.ImageDigest(ctx, userCode, applicationID+":"+tag)
+		switch {
+		case err == nil:
+			promoted[imageDigest] = true
+		case !errors.Is(err, registry.ErrImageNotFound):
+			return nil, fmt.Errorf("resolve the %s image of %s: %w", tag, applicationID, err)
+		}
+	}
+	return promoted, nil
+}
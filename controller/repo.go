@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"os"
 
 	"github.com/ipaas-org/image-builder/model"
 )
@@ -27,3 +28,11 @@ func (b *Controller) PullRepo(ctx context.Context, info *model.PullInfoRequest)
 	b.l.Infof("pulled %s successfully in %q", info.Repo, pullInfo.Path)
 	return pullInfo, nil
 }
+
+// RemovePulledRepo removes the repo when it's not built, BuildImage removes it otherwise
+func (b *Controller) RemovePulledRepo(info *model.PulledRepoInfo) {
+	b.l.Infof("cleaning up %s", info.Path)
+	if err := os.RemoveAll(info.Path); err != nil {
+		b.l.Warnf("unable to remove %s: %v", info.Path, err)
+	}
+}
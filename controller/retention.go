@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	dockerBuilder "github.com/ipaas-org/image-builder/providers/builders/docker"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
+)
+
+// retentionHistory is how many builds of an application the retention looks at,
+// the images of the older builds were already deleted by the previous runs
+const retentionHistory = 200
+
+// RetentionPolicy decides which images of an application are kept in the registries,
+// an image is kept if it's one of the last KeepLast or it was built in the last KeepFor.
+// The image of the last build and the promoted images are always kept, nothing is
+// deleted if both are zero
+type RetentionPolicy struct {
+	KeepLast int
+	KeepFor  time.Duration
+}
+
+func (p RetentionPolicy) Enabled() bool {
+	return p.KeepLast > 0 || p.KeepFor > 0
+}
+
+// keep reports if the i-th image (the newest is 0) built at builtAt is kept
+func (p RetentionPolicy) keep(i int, builtAt, now time.Time) bool {
+	return i == 0 || i < p.KeepLast || (p.KeepFor > 0 && now.Sub(builtAt) < p.KeepFor)
+}
+
+// RemoveLocalImage removes the built image from the host: the oci layout, the docker save
+// tarball or the image of the daemon with its tags (the ones added by the push too)
+func (b *Controller) RemoveLocalImage(ctx context.Context, imageID string) error {
+	if imageID == "" {
+		return nil
+	}
+	if layoutPath, ok := builders.OCILayoutPath(imageID); ok {
+		return os.RemoveAll(layoutPath)
+	}
+	if archivePath, ok := strings.CutPrefix(imageID, distribution.ArchivePrefix); ok {
+		if err := os.Remove(archivePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return dockerBuilder.RemoveImage(ctx, imageID)
+}
+
+// CleanupLocalImage removes the built image once the build is done (pushed or failed)
+// if RemoveLocalImages is set. The images are kept if they are not pushed since the
+// host is the only place they exist
+func (b *Controller) CleanupLocalImage(ctx context.Context, imageID string) {
+	if !b.RemoveLocalImages || !b.IsPushRequired() {
+		return
+	}
+	b.l.Infof("removing the local image %s", imageID)
+	if err := b.RemoveLocalImage(ctx, imageID); err != nil {
+		b.l.Warnf("error removing the local image %s: %v", imageID, err)
+	}
+}
+
+// PruneBuildCache removes the build cache and the dangling images of the daemon
+// that were not used in the last olderThan, the isolated builders (see the docker
+// builder) not used in the last olderThan are removed with their cache
+func (b *Controller) PruneBuildCache(ctx context.Context, olderThan time.Duration) error {
+	b.l.Infof("pruning the build cache older than %s", olderThan)
+	removed, err := dockerBuilder.RemoveIdleBuilders(ctx, olderThan)
+	if len(removed) > 0 {
+		b.l.Infof("removed the idle builders %v", removed)
+	}
+	if err != nil {
+		return err
+	}
+	// the builder knows the other buildx builders it uses
+	if pruner, ok := b.Builders[dockerBuilder.DockerBuilderKind].(builders.CachePruner); ok {
+		return pruner.PruneBuildCache(ctx, olderThan)
+	}
+	return dockerBuilder.PruneBuildCache(ctx, olderThan)
+}
+
+// PruneBuildCachePeriodically prunes the build cache every interval until ctx is done
+func (b *Controller) PruneBuildCachePeriodically(ctx context.Context, interval, olderThan time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.PruneBuildCache(ctx, olderThan); err != nil && ctx.Err() == nil {
+				b.l.Errorf("error pruning the build cache: %v", err)
+			}
+		}
+	}
+}
+
+// retainedImage is an image of an application with the builds that produced (or reused) it
+type retainedImage struct {
+	digest  string
+	builtAt time.Time // of the newest build
+	builds  []*model.Build
+}
+
+// PruneApplicationImages deletes from the registries the images of the application that
+// are not kept by the retention policy, with their signatures, attestations and sboms.
+// The builds of the deleted images are marked as pruned, the images that can't be deleted
+// are tried again the next time. The number of deleted images is returned
+func (b *Controller) PruneApplicationImages(ctx context.Context, applicationID string) (int, error) {
+	if !b.Retention.Enabled() || b.BuildRepo == nil || applicationID == "" {
+		return 0, nil
+	}
+	history, err := b.BuildRepo.ListByApplicationID(ctx, applicationID, retentionHistory)
+	if err != nil {
+		return 0, err
+	}
+
+	var userCode string
+	for _, build := range history {
+		if build.UserID != "" {
+			userCode = build.UserID
+			break
+		}
+	}
+	promoted, err := b.promotedDigests(ctx, userCode, applicationID)
+	if err != nil {
+		return 0, err
+	}
+
+	// the history is sorted from the newest build, so are the images
+	var images []*retainedImage
+	byDigest := make(map[string]*retainedImage)
+	for _, build := range history {
+		if build.Status != model.BuildStatusSuccess || build.ImageDigest == "" || build.PrunedAt != nil {
+			continue
+		}
+		image, ok := byDigest[build.ImageDigest]
+		if !ok {
+			image = &retainedImage{digest: build.ImageDigest, builtAt: build.StartedAt}
+			if build.FinishedAt != nil {
+				image.builtAt = *build.FinishedAt
+			}
+			byDigest[build.ImageDigest] = image
+			images = append(images, image)
+		}
+		image.builds = append(image.builds, build)
+	}
+
+	now := time.Now()
+	deleted := 0
+	var errs []error
+	for i, image := range images {
+		if b.Retention.keep(i, image.builtAt, now) || promoted[image.digest] {
+			continue
+		}
+		b.l.Infof("deleting the image %s of %s from the registries", image.digest, applicationID)
+		if err := b.deleteImage(ctx, image); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, build := range image.builds {
+			build.PrunedAt = &now
+			if err := b.BuildRepo.Update(ctx, build); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// deleteImage deletes the image and its artifacts from every registry it was pushed to,
+// the registries that can't delete images (or are not configured anymore) are skipped
+func (b *Controller) deleteImage(ctx context.Context, image *retainedImage) error {
+	targets := make(map[string]registry.Registryer)
+	for _, target := range b.registryTargets() {
+		targets[target.Name] = target.Registry
+	}
+
+	var errs []error
+	for _, build := range image.builds {
+		for _, push := range build.Registries {
+			if push.Status != model.ResponseStatusSuccess || push.Image == nil {
+				continue
+			}
+			deleter, ok := targets[push.Registry].(registry.ImageDeleter)
+			if !ok {
+				b.l.Debugf("the registry %s can't delete images, skipping %s", push.Registry, push.Image.Name)
+				continue
+			}
+			// the artifacts refer to the image, they are deleted first
+			for _, d := range []string{push.Signature, push.Attestation, push.SBOM, push.Image.Digest} {
+				if d == "" {
+					continue
+				}
+				err := deleter.DeleteImage(ctx, push.Image.Name, d)
+				if err != nil && !errors.Is(err, registry.ErrImageNotFound) {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/sbom"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func (c *Controller) IsSBOMRequired() bool {
+	return c.SBOM != nil
+}
+
+// GenerateSBOM lists the packages of the built image, it must be called before the push
+// since the oci layouts are removed once pushed. The summary of the sbom is set in the response
+func (c *Controller) GenerateSBOM(ctx context.Context, imageID string, response *model.BuildResponse) (*sbom.SBOM, error) {
+	if c.SBOM == nil {
+		return nil, nil
+	}
+	s, err := c.SBOM.Generate(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	response.SBOM = &model.SBOMSummary{
+		Format:     sbom.SPDXVersion,
+		OS:         s.OS,
+		Packages:   len(s.Packages),
+		Ecosystems: s.Ecosystems(),
+	}
+	return s, nil
+}
+
+// AttachSBOM pushes the sbom to each registry the image was pushed to, it refers to the
+// image so it's listed by the referrers api. The digest of the artifact is added to the
+// push results of the response. Like the push, an error is returned if it fails in the
+// primary registry or in a required mirror
+func (c *Controller) AttachSBOM(ctx context.Context, s *sbom.SBOM, response *model.BuildResponse) error {
+	if s == nil {
+		return nil
+	}
+	targets := c.registryTargets()
+	now := time.Now()
+	var err error
+	for i := range response.Registries {
+		result := &response.Registries[i]
+		if result.Image == nil || i >= len(targets) {
+			continue
+		}
+		attachErr := c.attachIn(ctx, targets[i].Registry, s, result, now)
+		switch {
+		case attachErr == nil:
+			if i == 0 && response.SBOM != nil {
+				response.SBOM.Digest = result.SBOM
+			}
+			c.l.Infof("sbom attached to %s", result.Image.Reference())
+		case result.Optional:
+			c.l.Warnf("unable to attach the sbom in optional registry %s: %v", result.Registry, attachErr)
+		case err == nil:
+			err = fmt.Errorf("attach sbom in %s: %w", result.Registry, attachErr)
+		}
+	}
+	return err
+}
+
+func (c *Controller) attachIn(ctx context.Context, r registry.Registryer, s *sbom.SBOM, result *model.RegistryPush, now time.Time) error {
+	pusher, ok := r.(registry.ArtifactPusher)
+	if !ok {
+		return ErrArtifactsNotSupported
+	}
+	d, err := digest.Parse(result.Image.Digest)
+	if err != nil {
+		return err
+	}
+	subject := ocispec.Descriptor{MediaType: result.Image.MediaType, Digest: d, Size: result.Image.Size}
+	artifact, err := s.Artifact(result.Image.Repository(), subject, now)
+	if err != nil {
+		return err
+	}
+	desc, err := pusher.PushArtifact(ctx, result.Image.Name, "", artifact)
+	if err != nil {
+		return err
+	}
+	result.SBOM = desc.Digest.String()
+	return nil
+}
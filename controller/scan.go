@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+)
+
+// maxViolationsInMessage caps the vulnerabilities listed in the error, the others are in the summary
+const maxViolationsInMessage = 5
+
+func (c *Controller) IsScanRequired() bool {
+	return c.Scanner != nil
+}
+
+// ScanImage scans the built image and sets the summary of the scan in the response.
+// ErrImageVulnerable is returned if the image has vulnerabilities blocked by the
+// policy, the image must not be pushed and its oci layout is removed
+func (c *Controller) ScanImage(ctx context.Context, imageID string, response *model.BuildResponse) error {
+	if c.Scanner == nil {
+		return nil
+	}
+	report, err := c.Scanner.Scan(ctx, imageID)
+	if err != nil {
+		return err
+	}
+	violations := c.ScanPolicy.Violations(report)
+	response.Scan = report.Summary(violations)
+	c.l.Infof("scanned %s: %d vulnerabilities, %d blocked", imageID, len(report.Vulnerabilities), len(violations))
+	if len(violations) == 0 {
+		return nil
+	}
+
+	if layoutPath, ok := builders.OCILayoutPath(imageID); ok {
+		os.RemoveAll(layoutPath)
+	}
+	found := make([]string, 0, maxViolationsInMessage)
+	for i, v := range violations {
+		if i == maxViolationsInMessage {
+			found = append(found, fmt.Sprintf("and %d more", len(violations)-i))
+			break
+		}
+		found = append(found, describeVulnerability(v))
+	}
+	return fmt.Errorf("%w: %s", ErrImageVulnerable, strings.Join(found, ", "))
+}
+
+// describeVulnerability returns CVE-2024-1234 in openssl 3.0.1 (CRITICAL, fixed in 3.0.2)
+func describeVulnerability(v model.Vulnerability) string {
+	s := fmt.Sprintf("%s in %s %s (%s", v.ID, v.Package, v.InstalledVersion, v.Severity)
+	if v.FixedVersion != "" {
+		s += ", fixed in " + v.FixedVersion
+	}
+	return s + ")"
+}
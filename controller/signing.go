@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/signing"
+	"github.com/opencontainers/go-digest"
+)
+
+// SignImage signs the image pushed to each registry and attaches the attestation of its
+// provenance, nothing is done without a signer. The digests of the signature and of the
+// attestation are added to the push results of the response. Like the push, an error is
+// returned if signing fails in the primary registry or in a required mirror
+func (c *Controller) SignImage(ctx context.Context, build *model.Build, response *model.BuildResponse) error {
+	if c.Signer == nil {
+		return nil
+	}
+	provenance := c.provenance(build, response)
+	targets := c.registryTargets()
+	var err error
+	for i := range response.Registries {
+		result := &response.Registries[i]
+		if result.Image == nil || i >= len(targets) {
+			continue
+		}
+		signErr := c.signIn(ctx, targets[i].Registry, result, provenance)
+		switch {
+		case signErr == nil:
+			c.l.Infof("signed %s", result.Image.Reference())
+		case result.Optional:
+			c.l.Warnf("unable to sign the image in optional registry %s: %v", result.Registry, signErr)
+		case err == nil:
+			err = fmt.Errorf("sign in %s: %w", result.Registry, signErr)
+		}
+	}
+	return err
+}
+
+func (c *Controller) signIn(ctx context.Context, r registry.Registryer, result *model.RegistryPush, provenance *signing.Provenance) error {
+	pusher, ok := r.(registry.ArtifactPusher)
+	if !ok {
+		return ErrSigningNotSupported
+	}
+	d, err := digest.Parse(result.Image.Digest)
+	if err != nil {
+		return err
+	}
+	repository := result.Image.Repository()
+
+	signature, err := c.Signer.Signature(repository, d, map[string]string{
+		"repo":   provenance.SourceURI,
+		"commit": provenance.Commit,
+	})
+	if err != nil {
+		return err
+	}
+	desc, err := pusher.PushArtifact(ctx, result.Image.Name, signing.SignatureTag(d), signature)
+	if err != nil {
+		return err
+	}
+	result.Signature = desc.Digest.String()
+
+	attestation, err := c.Signer.Attestation(repository, d, provenance)
+	if err != nil {
+		return err
+	}
+	desc, err = pusher.PushArtifact(ctx, result.Image.Name, signing.AttestationTag(d), attestation)
+	if err != nil {
+		return err
+	}
+	result.Attestation = desc.Digest.String()
+	return nil
+}
+
+// provenance returns the provenance of the image built for the response
+func (c *Controller) provenance(build *model.Build, response *model.BuildResponse) *signing.Provenance {
+	provenance := &signing.Provenance{
+		BuilderVersion: c.Version,
+		SourceURI:      sourceURI(build.Connector, build.Repo),
+		Branch:         build.Branch,
+		Commit:         response.BuiltCommit,
+		Plan:           response.PlanUsed.WithoutSecrets(),
+		BuildID:        build.ID,
+		RequestID:      build.RequestID,
+		ApplicationID:  build.ApplicationID,
+		UserID:         build.UserID,
+		StartedOn:      build.StartedAt,
+		FinishedOn:     time.Now(),
+	}
+	if response.PlanUsed != nil {
+		provenance.BuilderKind = response.PlanUsed.Builder
+		provenance.PlanHash = response.PlanUsed.Hash()
+	}
+	return provenance
+}
+
+// sourceURI returns the url of the repository pulled by the connector
+func sourceURI(connector, repo string) string {
+	if connector == model.ConnectorGithub {
+		return "https://github.com/" + repo
+	}
+	return repo
+}
@@ -2,12 +2,17 @@ package controller
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/ipaas-org/image-builder/model"
 	"github.com/ipaas-org/image-builder/repo"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// ShouldBuild reports if the request of the application must be built, the requests
+// of the deleted (or deleting) applications are dropped. ErrBuildCancelled is returned
+// if the application was cancelled, the request was sent before the cancellation and
+// the application must be queued again to be rebuilt
 func (c *Controller) ShouldBuild(ctx context.Context, applicationID string) (bool, error) {
 	c.l.Println("ShouldBuild")
 	c.l.Printf("applicationID: %q\n", applicationID)
@@ -26,26 +31,54 @@ func (c *Controller) ShouldBuild(ctx context.Context, applicationID string) (boo
 			return false, err
 		}
 	}
-	if state == "deleting" {
+	switch state {
+	case model.ApplicationStateDeleting:
 		return false, nil
+	case model.ApplicationStateCancelled:
+		return false, fmt.Errorf("%w, the application must be queued again to be rebuilt", ErrBuildCancelled)
 	}
 	return true, nil
 }
 
-func (c *Controller) UpdateApplicationStateToBuilding(ctx context.Context, applicationID string) error {
+// UpdateApplicationState moves the application to state, the transition must be
+// allowed by the state machine (see model.ApplicationState.CanTransitionTo).
+// The state is compared and swapped so if another replica changed it in the
+// meantime ErrStateConflict is returned, ErrBuildCancelled if it was cancelled or deleted
+func (c *Controller) UpdateApplicationState(ctx context.Context, applicationID string, state model.ApplicationState) error {
 	appID, err := primitive.ObjectIDFromHex(applicationID)
 	if err != nil {
 		return err
 	}
-	_, err = c.ApplicationRepo.UpdateStateByID(ctx, model.ApplicationStateBuilding, appID)
-	return err
-}
+	current, err := c.ApplicationRepo.GetStateByID(ctx, appID)
+	if err != nil {
+		return err
+	}
+	if current == state {
+		return nil
+	}
+	if !current.CanTransitionTo(state) {
+		if isStopped(current) {
+			return ErrBuildCancelled
+		}
+		return fmt.Errorf("%w: from %q to %q", ErrInvalidStateTransition, current, state)
+	}
 
-func (c *Controller) UpdateApplicationStateToFailed(ctx context.Context, applicationID string) error {
-	appID, err := primitive.ObjectIDFromHex(applicationID)
+	swapped, err := c.ApplicationRepo.CompareAndSwapStateByID(ctx, current, state, appID)
 	if err != nil {
 		return err
 	}
-	_, err = c.ApplicationRepo.UpdateStateByID(ctx, model.ApplicationStateFailed, appID)
-	return err
+	if !swapped {
+		now, err := c.ApplicationRepo.GetStateByID(ctx, appID)
+		if err == nil && isStopped(now) {
+			return ErrBuildCancelled
+		}
+		return fmt.Errorf("%w: %q changed while moving to %q", ErrStateConflict, current, state)
+	}
+	c.l.Debugf("application %s moved from %q to %q", applicationID, current, state)
+	return nil
+}
+
+// isStopped reports if the application must not be built anymore
+func isStopped(state model.ApplicationState) bool {
+	return state == model.ApplicationStateCancelled || state == model.ApplicationStateDeleting
 }
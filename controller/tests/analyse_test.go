@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/providers/builders/buildpacks"
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
+	"github.com/ipaas-org/image-builder/providers/builders/static"
+	"gotest.tools/assert"
+)
+
+// detectedAnalyzer detects the same builders in every repo
+type detectedAnalyzer struct {
+	info model.DetectedInfo
+}
+
+func (a *detectedAnalyzer) DetectBuilders(ctx context.Context, path string) (*model.DetectedInfo, error) {
+	info := a.info
+	return &info, nil
+}
+
+func TestAnalyzeDisabledBuilders(t *testing.T) {
+	ctx := context.Background()
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	c.AddBuilder(docker.DockerBuilderKind, &docker.DockerBuilder{})
+
+	// a java repo with a .dockerignore
+	c.Analyzer = &detectedAnalyzer{info: model.DetectedInfo{
+		Builders:   []model.BuilderKind{buildpacks.BuildpacksBuilderKind},
+		Docker:     &model.DockerInfo{DockerIgnoreFound: true},
+		Buildpacks: &model.BuildpacksInfo{Stacks: []string{"java"}, Files: []string{"pom.xml"}},
+	}}
+	analysis, err := c.AnalyzeRepositoryContent(ctx, t.TempDir(), "", "repo", "main")
+	assert.NilError(t, err)
+	assert.Assert(t, !analysis.IsBuildable)
+	assert.Assert(t, strings.Contains(analysis.Reason, "buildpacks"), analysis.Reason)
+	assert.Equal(t, len(analysis.RepoInfo.Builders), 0)
+
+	c.AddBuilder(buildpacks.BuildpacksBuilderKind, &buildpacks.BuildpacksBuilder{})
+	analysis, err = c.AnalyzeRepositoryContent(ctx, t.TempDir(), "", "repo", "main")
+	assert.NilError(t, err)
+	assert.Assert(t, analysis.IsBuildable)
+	config, err := c.GenerateBuildConfig(ctx, analysis)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Builder, buildpacks.BuildpacksBuilderKind)
+}
+
+func TestAnalyzeDisabledStatic(t *testing.T) {
+	ctx := context.Background()
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	c.AddBuilder(docker.DockerBuilderKind, &docker.DockerBuilder{})
+
+	// a site without a runtime, only the static builder can serve it
+	c.Analyzer = &detectedAnalyzer{info: model.DetectedInfo{
+		Builders: []model.BuilderKind{static.StaticBuilderKind},
+		Static:   &model.StaticInfo{Framework: "html", OutputDirectory: "."},
+	}}
+	analysis, err := c.AnalyzeRepositoryContent(ctx, t.TempDir(), "", "repo", "main")
+	assert.NilError(t, err)
+	assert.Assert(t, !analysis.IsBuildable)
+	assert.Assert(t, strings.Contains(analysis.Reason, "static"), analysis.Reason)
+	_, err = c.GenerateBuildConfig(ctx, analysis)
+	assert.Assert(t, errors.Is(err, controller.ErrNotBuildable), "got %v", err)
+
+	c.AddBuilder(static.StaticBuilderKind, &static.StaticBuilder{})
+	analysis, err = c.AnalyzeRepositoryContent(ctx, t.TempDir(), "", "repo", "main")
+	assert.NilError(t, err)
+	assert.Assert(t, analysis.IsBuildable)
+	config, err := c.GenerateBuildConfig(ctx, analysis)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Builder, static.StaticBuilderKind)
+}
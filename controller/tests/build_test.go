@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/repo/memory"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gotest.tools/assert"
+)
+
+func TestBuildHistory(t *testing.T) {
+	ctx := context.Background()
+	appID := primitive.NewObjectID()
+	deletingID := primitive.NewObjectID()
+	cancelledID := primitive.NewObjectID()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	c.ApplicationRepo = memory.NewApplicationRepoer(
+		&memory.Application{ID: appID, State: model.ApplicationStateFailed},
+		&memory.Application{ID: deletingID, State: model.ApplicationStateDeleting},
+		&memory.Application{ID: cancelledID, State: model.ApplicationStateCancelled},
+	)
+	builds := memory.NewBuildRepoer()
+	c.BuildRepo = builds
+
+	shouldBuild, err := c.ShouldBuild(ctx, deletingID.Hex())
+	assert.NilError(t, err)
+	assert.Assert(t, !shouldBuild)
+	// the requests of the cancelled applications are rejected with a response
+	shouldBuild, err = c.ShouldBuild(ctx, cancelledID.Hex())
+	assert.Assert(t, errors.Is(err, controller.ErrBuildCancelled), "got %v", err)
+	assert.Assert(t, !shouldBuild)
+	shouldBuild, err = c.ShouldBuild(ctx, appID.Hex())
+	assert.NilError(t, err)
+	assert.Assert(t, shouldBuild)
+
+	request := &model.Request{
+		ApplicationID: appID.Hex(),
+		PullInfo: &model.PullInfoRequest{
+			UserID: "18008",
+			Token:  "secret-token",
+			Repo:   "vano2903/testing",
+			Commit: "latest",
+		},
+		BuildPlan: &model.BuildConfig{
+			Builder: "docker",
+			Secrets: []model.KeyValue{{Key: "NPM_TOKEN", Value: "secret"}},
+		},
+	}
+	build := c.StartBuild(ctx, request)
+	assert.NilError(t, c.StartBuildStage(ctx, build, model.BuildStagePull))
+	assert.NilError(t, c.StartBuildStage(ctx, build, model.BuildStageAnalyze))
+	assert.NilError(t, c.StartBuildStage(ctx, build, model.BuildStageBuild))
+	assert.NilError(t, c.UpdateApplicationState(ctx, appID.Hex(), model.ApplicationStateBuilt))
+	c.FinishBuild(ctx, build, &model.BuildResponse{
+		Status:      model.ResponseStatusSuccess,
+		BuiltCommit: "4f2a9c1",
+		ImageName:   "registry/18008/app:4f2a9c1",
+		BuildOutput: "Successfully built",
+		PlanUsed:    request.BuildPlan,
+	})
+
+	history, err := builds.ListByApplicationID(ctx, appID.Hex(), 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(history), 1)
+	stored := history[0]
+	assert.Equal(t, stored.Status, model.BuildStatusSuccess)
+	assert.Equal(t, stored.RequestedCommit, "latest")
+	assert.Equal(t, stored.Commit, "4f2a9c1")
+	assert.Equal(t, stored.Log, "Successfully built")
+	assert.Equal(t, len(stored.PlanUsed.Secrets), 0)
+	assert.Equal(t, len(stored.RequestPlan.Secrets), 0)
+	assert.Equal(t, len(stored.Stages), 3)
+	assert.Equal(t, stored.Stages[0].Name, model.BuildStagePull)
+	assert.Assert(t, stored.Stages[2].FinishedAt != nil)
+	assert.Assert(t, stored.FinishedAt != nil)
+}
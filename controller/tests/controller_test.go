@@ -325,7 +325,7 @@ func TestBuildImage(t *testing.T) {
 			t.Errorf("unable to pull repo: %v", err)
 		}
 
-		imageID, _, err := c.BuildImage(context.Background(), buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
+		imageID, _, err := c.BuildImage(context.Background(), buildRequest.ApplicationID, buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
 		if err != nil {
 			t.Errorf("unable to build image: %v", err)
 		}
@@ -361,7 +361,7 @@ func TestBuildImage(t *testing.T) {
 			t.Errorf("unable to pull repo: %v", err)
 		}
 
-		_, buildError, err := c.BuildImage(context.Background(), buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
+		_, buildError, err := c.BuildImage(context.Background(), buildRequest.ApplicationID, buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
 		if err == nil {
 			t.Errorf("expected error, got nil")
 		}
@@ -398,7 +398,7 @@ func TestBuildImage(t *testing.T) {
 
 		ctx, _ := context.WithTimeout(context.Background(), 100*time.Millisecond)
 
-		_, _, err = c.BuildImage(ctx, buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
+		_, _, err = c.BuildImage(ctx, buildRequest.ApplicationID, buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
 		if err == nil {
 			t.Errorf("expected error, got nil")
 		}
@@ -439,7 +439,7 @@ func TestPushImage(t *testing.T) {
 			t.Fatalf("unable to pull repo: %v", err)
 		}
 
-		imageID, _, err := c.BuildImage(ctx, buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
+		imageID, _, err := c.BuildImage(ctx, buildRequest.ApplicationID, buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
 		if err != nil {
 			t.Fatalf("unable to build image: %v", err)
 		}
@@ -479,7 +479,7 @@ func TestPushImage(t *testing.T) {
 			t.Fatalf("unable to pull repo: %v", err)
 		}
 
-		imageID, _, err := c.BuildImage(ctx, buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
+		imageID, _, err := c.BuildImage(ctx, buildRequest.ApplicationID, buildRequest.PullInfo.Repo, buildRequest.PullInfo.UserID, info.Path, buildRequest.BuildPlan)
 		if err != nil {
 			t.Fatalf("unable to build image: %v", err)
 		}
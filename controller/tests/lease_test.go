@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/repo/memory"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gotest.tools/assert"
+)
+
+// inspectorRegistry only knows the images in its map (name:tag -> digest)
+type inspectorRegistry struct {
+	images map[string]string
+}
+
+func (r *inspectorRegistry) TagImage(ctx context.Context, localImageID, userCode, appName string) (string, error) {
+	return "registry/" + userCode + "/" + appName, nil
+}
+
+func (r *inspectorRegistry) PushImage(ctx context.Context, localImageID string) (*model.PushedImage, error) {
+	return &model.PushedImage{Name: localImageID}, nil
+}
+
+func (r *inspectorRegistry) ImageDigest(ctx context.Context, userCode, appName string) (string, string, error) {
+	digest, ok := r.images[appName]
+	if !ok {
+		return "", "", registry.ErrImageNotFound
+	}
+	return "registry/" + userCode + "/" + appName, digest, nil
+}
+
+func TestAcquireBuildLease(t *testing.T) {
+	ctx := context.Background()
+	appID := primitive.NewObjectID()
+	apps := memory.NewApplicationRepoer(&memory.Application{ID: appID, State: model.ApplicationStateBuilding})
+	locks := memory.NewLockRepoer()
+
+	first := controller.NewController(logger.NewLogger(logLvl, logType))
+	first.ApplicationRepo = apps
+	first.LockRepo = locks
+	second := controller.NewController(logger.NewLogger(logLvl, logType))
+	second.ApplicationRepo = apps
+	second.LockRepo = locks
+
+	// the building state was left by a dead replica
+	lease, err := first.AcquireBuildLease(ctx, appID.Hex())
+	assert.NilError(t, err)
+	state, _ := apps.GetStateByID(ctx, appID)
+	assert.Equal(t, state, model.ApplicationStateQueued)
+
+	_, err = second.AcquireBuildLease(ctx, appID.Hex())
+	assert.Assert(t, errors.Is(err, controller.ErrBuildLocked), "got %v", err)
+
+	first.ReleaseBuildLease(lease)
+	lease, err = second.AcquireBuildLease(ctx, appID.Hex())
+	assert.NilError(t, err)
+	second.ReleaseBuildLease(lease)
+}
+
+func TestFindBuiltImage(t *testing.T) {
+	ctx := context.Background()
+	config := &model.BuildConfig{Builder: "docker", DockerfilePath: "Dockerfile"}
+	other := &model.BuildConfig{Builder: "docker", DockerfilePath: "Dockerfile.prod"}
+	built := &model.Build{
+		ApplicationID: "app",
+		Commit:        "abc",
+		PlanHash:      config.Hash(),
+		Status:        model.BuildStatusSuccess,
+		ImageID:       "sha256:image",
+		StartedAt:     time.Unix(100, 0),
+	}
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	c.BuildRepo = memory.NewBuildRepoer(built)
+	c.Registry = &inspectorRegistry{images: map[string]string{"app:abc": "sha256:digest"}}
+
+	previous, err := c.FindBuiltImage(ctx, "app", "user", "abc", config)
+	assert.NilError(t, err)
+	assert.Assert(t, previous != nil)
+	assert.Equal(t, previous.ImageName, "registry/user/app:abc")
+	assert.Equal(t, previous.ImageDigest, "sha256:digest")
+
+	build := &model.Build{}
+	response := &model.BuildResponse{}
+	c.ReuseImage(build, previous, response)
+	assert.Assert(t, response.Reused)
+	assert.Equal(t, response.ImageID, "sha256:image")
+	assert.Equal(t, response.ImageDigest, "sha256:digest")
+	assert.Equal(t, response.ImageReference, "registry/user/app@sha256:digest")
+	assert.Equal(t, build.ReusedFrom, previous.ID)
+
+	// different plan
+	previous, err = c.FindBuiltImage(ctx, "app", "user", "abc", other)
+	assert.NilError(t, err)
+	assert.Assert(t, previous == nil)
+
+	// removed from the registry
+	c.Registry = &inspectorRegistry{}
+	previous, err = c.FindBuiltImage(ctx, "app", "user", "abc", config)
+	assert.NilError(t, err)
+	assert.Assert(t, previous == nil)
+}
+
+func TestFindPushedImage(t *testing.T) {
+	ctx := context.Background()
+	config := &model.BuildConfig{Builder: "docker", DockerfilePath: "Dockerfile"}
+	builds := memory.NewBuildRepoer()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	c.BuildRepo = builds
+	c.Registry = &inspectorRegistry{images: map[string]string{"app:abc": "sha256:digest"}}
+
+	// the attempt failed after the push, before finishing
+	request := &model.Request{RequestID: "request", ApplicationID: "app", BuildPlan: config}
+	attempt := c.StartBuild(ctx, request)
+	response := &model.BuildResponse{BuiltCommit: "abc", PlanUsed: config, ImageID: "sha256:image"}
+	response.SetImage(&model.PushedImage{Name: "registry/user/app:abc", Digest: "sha256:digest"})
+	c.RecordPushedImage(ctx, attempt, response)
+
+	previous := c.PreviousBuild(ctx, "request")
+	assert.Assert(t, previous != nil)
+	assert.Equal(t, previous.Status, model.BuildStatusRunning)
+	pushed, err := c.FindPushedImage(ctx, previous, "user", "abc", config)
+	assert.NilError(t, err)
+	assert.Assert(t, pushed != nil)
+	assert.Equal(t, pushed.ID, attempt.ID)
+	assert.Equal(t, pushed.ImageDigest, "sha256:digest")
+
+	// another commit
+	pushed, err = c.FindPushedImage(ctx, previous, "user", "def", config)
+	assert.NilError(t, err)
+	assert.Assert(t, pushed == nil)
+
+	// replaced in the registry
+	c.Registry = &inspectorRegistry{images: map[string]string{"app:abc": "sha256:other"}}
+	pushed, err = c.FindPushedImage(ctx, previous, "user", "abc", config)
+	assert.NilError(t, err)
+	assert.Assert(t, pushed == nil)
+
+	// nothing was pushed
+	pushed, err = c.FindPushedImage(ctx, &model.Build{ApplicationID: "app", Commit: "abc", PlanHash: config.Hash()}, "user", "abc", config)
+	assert.NilError(t, err)
+	assert.Assert(t, pushed == nil)
+}
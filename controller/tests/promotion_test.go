@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/ipaas-org/image-builder/providers/registry/oci/ocitest"
+	"github.com/ipaas-org/image-builder/repo/memory"
+	"gotest.tools/assert"
+)
+
+func TestPushImageImmutableTags(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	r, err := distribution.NewDistributionRegistry(primary.Host(), "", "", oci.ClientOptions{ImmutableTags: true})
+	assert.NilError(t, err)
+	c.Registry = r
+	c.RegistryName = primary.Host()
+	c.PromotionTags = []string{"production"}
+
+	pushed, _, err := c.PushImage(ctx, uniqueArchive(t, "v1"), "user", "app:abc")
+	assert.NilError(t, err)
+	// a rebuild of the commit with a different image
+	_, results, err := c.PushImage(ctx, uniqueArchive(t, "v2"), "user", "app:abc")
+	assert.Assert(t, errors.Is(err, registry.ErrTagExists), "got %v", err)
+	assert.Equal(t, results[0].Status, model.ResponseStatusFailed)
+
+	// the promotion tags move
+	second, _, err := c.PushImage(ctx, uniqueArchive(t, "v2"), "user", "app:def")
+	assert.NilError(t, err)
+	for _, d := range []string{pushed.Digest, second.Digest} {
+		promoted, _, err := c.PromoteImage(ctx, "user", "app", &model.PromotionRequest{Digest: d, Tags: []string{"production"}})
+		assert.NilError(t, err)
+		assert.Equal(t, promoted.Digest, d)
+	}
+}
+
+func TestPromoteImage(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+	mirror := ocitest.NewRegistry()
+	defer mirror.Close()
+	// closed before the promotion
+	down := ocitest.NewRegistry()
+	down.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	target := newTarget(t, primary, false)
+	c.Registry = target.Registry
+	c.RegistryName = target.Name
+	c.Mirrors = []controller.RegistryTarget{newTarget(t, mirror, false)}
+	c.PromotionTags = []string{"staging", "production"}
+	pushed, _, err := c.PushImage(ctx, uniqueArchive(t, "abc"), "user", "app:abc")
+	assert.NilError(t, err)
+	c.Mirrors = append(c.Mirrors, newTarget(t, down, true))
+
+	promoted, results, err := c.PromoteImage(ctx, "user", "app", &model.PromotionRequest{
+		Digest: pushed.Digest,
+		Tags:   []string{"staging", "production"},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, promoted.Name, primary.Host()+"/user/app:staging")
+	assert.Equal(t, promoted.Digest, pushed.Digest)
+	assert.Equal(t, len(results), 6)
+	for _, result := range results[:4] {
+		assert.Equal(t, result.Status, model.ResponseStatusSuccess, result.Registry)
+		assert.Equal(t, result.Image.Digest, pushed.Digest)
+	}
+	for _, result := range results[4:] {
+		assert.Equal(t, result.Registry, down.Host())
+		assert.Equal(t, result.Status, model.ResponseStatusFailed)
+	}
+	for _, server := range []*ocitest.Registry{primary, mirror} {
+		for _, tag := range []string{"staging", "production"} {
+			_, _, ok := server.Manifest("user/app", tag)
+			assert.Assert(t, ok, "%s in %s", tag, server.Host())
+		}
+	}
+}
+
+func TestPromoteImageOfCommit(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	target := newTarget(t, primary, false)
+	c.Registry = target.Registry
+	c.RegistryName = target.Name
+	c.PromotionTags = []string{"production"}
+	first, _, err := c.PushImage(ctx, uniqueArchive(t, "abc"), "user", "app:abc")
+	assert.NilError(t, err)
+	second, _, err := c.PushImage(ctx, uniqueArchive(t, "def"), "user", "app:def")
+	assert.NilError(t, err)
+
+	for _, pushed := range []struct {
+		commit string
+		digest string
+	}{{"abc", first.Digest}, {"def", second.Digest}, {"abc", first.Digest}} {
+		promoted, _, err := c.PromoteImage(ctx, "user", "app", &model.PromotionRequest{Commit: pushed.commit, Tags: []string{"production"}})
+		assert.NilError(t, err)
+		assert.Equal(t, promoted.Digest, pushed.digest)
+		_, imageDigest, err := c.Registry.(registry.ImageInspector).ImageDigest(ctx, "user", "app:production")
+		assert.NilError(t, err)
+		assert.Equal(t, imageDigest, pushed.digest)
+	}
+
+	_, _, err = c.PromoteImage(ctx, "user", "app", &model.PromotionRequest{Commit: "missing", Tags: []string{"production"}})
+	assert.Assert(t, errors.Is(err, registry.ErrImageNotFound), "got %v", err)
+}
+
+func TestPromoteImageInvalid(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	c.PromotionTags = []string{"production"}
+	valid := &model.PromotionRequest{Digest: "sha256:" + strings.Repeat("0", 64), Tags: []string{"production"}}
+
+	_, _, err := c.PromoteImage(ctx, "user", "app", valid)
+	assert.Assert(t, errors.Is(err, controller.ErrMissingRegistry), "got %v", err)
+	c.Registry = newTarget(t, primary, false).Registry
+
+	for _, tc := range []struct {
+		name      string
+		userCode  string
+		appID     string
+		promotion *model.PromotionRequest
+		err       error
+	}{
+		{"missing user", "", "app", valid, controller.ErrMissingUser},
+		{"missing application", "user", "", valid, controller.ErrInvalidPromotion},
+		{"missing promotion", "user", "app", nil, controller.ErrInvalidPromotion},
+		{"missing tags", "user", "app", &model.PromotionRequest{Digest: valid.Digest}, controller.ErrInvalidPromotion},
+		{"missing image", "user", "app", &model.PromotionRequest{Tags: []string{"production"}}, controller.ErrInvalidPromotion},
+		{"invalid digest", "user", "app", &model.PromotionRequest{Digest: "sha256:abc", Tags: []string{"production"}}, controller.ErrInvalidPromotion},
+		{"commit tag", "user", "app", &model.PromotionRequest{Digest: valid.Digest, Tags: []string{"abc"}}, controller.ErrPromotionTagNotAllowed},
+		{"cache tag", "user", "app", &model.PromotionRequest{Digest: valid.Digest, Tags: []string{registry.CacheTag}}, controller.ErrPromotionTagNotAllowed},
+		{"missing digest", "user", "app", valid, registry.ErrImageNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := c.PromoteImage(ctx, tc.userCode, tc.appID, tc.promotion)
+			assert.Assert(t, errors.Is(err, tc.err), "got %v", err)
+		})
+	}
+}
+
+func TestPruneApplicationImagesPromoted(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	target := newTarget(t, primary, false)
+	c.Registry = target.Registry
+	c.RegistryName = target.Name
+	c.PromotionTags = []string{"production"}
+	builds := pushBuilds(t, c, time.Hour, 2*time.Hour, 3*time.Hour)
+	c.BuildRepo = memory.NewBuildRepoer(builds...)
+	c.Retention = controller.RetentionPolicy{KeepLast: 1}
+	_, _, err := c.PromoteImage(ctx, "user", "app", &model.PromotionRequest{Digest: builds[2].ImageDigest, Tags: []string{"production"}})
+	assert.NilError(t, err)
+
+	// the production image is kept
+	deleted, err := c.PruneApplicationImages(ctx, "app")
+	assert.NilError(t, err)
+	assert.Equal(t, deleted, 1)
+	for i, build := range builds {
+		_, _, exists := primary.Manifest("user/app", build.Commit)
+		assert.Equal(t, exists, i != 1, build.Commit)
+	}
+	_, _, exists := primary.Manifest("user/app", "production")
+	assert.Assert(t, exists)
+}
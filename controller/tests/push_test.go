@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/ipaas-org/image-builder/providers/registry/oci/ocitest"
+	"github.com/ipaas-org/image-builder/providers/sbom"
+	"github.com/ipaas-org/image-builder/providers/signing"
+	"github.com/opencontainers/go-digest"
+	"gotest.tools/assert"
+)
+
+// imageArchive writes a docker save tarball and returns its local image id
+func imageArchive(t *testing.T) string {
+	t.Helper()
+	return distribution.ArchivePrefix + ocitest.WriteArchive(t, [][2]string{
+		{"etc/os-release", "ID=alpine\nVERSION_ID=3.19.1\n"},
+		{"lib/apk/db/installed", "P:musl\nV:1.2.4-r2\nA:x86_64\nL:MIT\n"},
+	})
+}
+
+func newTarget(t *testing.T, server *ocitest.Registry, optional bool) controller.RegistryTarget {
+	t.Helper()
+	r, err := distribution.NewDistributionRegistry(server.Host(), "", "")
+	assert.NilError(t, err)
+	return controller.RegistryTarget{Name: server.Host(), Registry: r, Optional: optional}
+}
+
+func TestPushImageToMirrors(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+	mirror := ocitest.NewRegistry()
+	defer mirror.Close()
+	// closed before the push
+	down := ocitest.NewRegistry()
+	down.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	target := newTarget(t, primary, false)
+	c.Registry = target.Registry
+	c.RegistryName = target.Name
+	c.Mirrors = []controller.RegistryTarget{newTarget(t, mirror, false), newTarget(t, down, true)}
+
+	pushed, results, err := c.PushImage(ctx, imageArchive(t), "user", "app:abc")
+	assert.NilError(t, err)
+	assert.Equal(t, pushed.Name, primary.Host()+"/user/app:abc")
+	assert.Equal(t, len(results), 3)
+	assert.Equal(t, results[0].Registry, primary.Host())
+	assert.Equal(t, results[0].Status, model.ResponseStatusSuccess)
+	assert.Equal(t, results[1].Status, model.ResponseStatusSuccess)
+	assert.Equal(t, results[1].Image.Digest, pushed.Digest)
+	assert.Equal(t, results[2].Status, model.ResponseStatusFailed)
+	assert.Assert(t, results[2].Optional)
+	assert.Assert(t, results[2].Error != "")
+	_, _, ok := mirror.Manifest("user/app", "abc")
+	assert.Assert(t, ok)
+
+	// a required mirror fails the push
+	c.Mirrors[1].Optional = false
+	pushed, results, err = c.PushImage(ctx, imageArchive(t), "user", "app:def")
+	assert.ErrorContains(t, err, down.Host())
+	assert.Assert(t, pushed == nil)
+	assert.Equal(t, results[0].Status, model.ResponseStatusSuccess)
+	assert.Equal(t, results[2].Status, model.ResponseStatusFailed)
+}
+
+func TestSignImage(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+	mirror := ocitest.NewRegistry()
+	defer mirror.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	target := newTarget(t, primary, false)
+	c.Registry = target.Registry
+	c.RegistryName = target.Name
+	c.Mirrors = []controller.RegistryTarget{newTarget(t, mirror, true)}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	c.Signer = signing.NewSigner(key)
+
+	pushed, results, err := c.PushImage(ctx, imageArchive(t), "user", "app:abc")
+	assert.NilError(t, err)
+	response := &model.BuildResponse{BuiltCommit: "abc", PlanUsed: &model.BuildConfig{Builder: "docker"}, Registries: results}
+	response.SetImage(pushed)
+	build := &model.Build{Repo: "user/repo", Connector: model.ConnectorGithub, Branch: "main"}
+	assert.NilError(t, c.SignImage(ctx, build, response))
+
+	d := digest.Digest(pushed.Digest)
+	for i, server := range []*ocitest.Registry{primary, mirror} {
+		result := response.Registries[i]
+		_, _, ok := server.Manifest("user/app", signing.SignatureTag(d))
+		assert.Assert(t, ok)
+		_, _, ok = server.Manifest("user/app", signing.AttestationTag(d))
+		assert.Assert(t, ok)
+		assert.Assert(t, result.Signature != "")
+		assert.Assert(t, result.Attestation != "")
+	}
+
+	// without a signer nothing is pushed
+	c.Signer = nil
+	response.Registries[0].Signature = ""
+	assert.NilError(t, c.SignImage(ctx, build, response))
+	assert.Equal(t, response.Registries[0].Signature, "")
+}
+
+func TestAttachSBOM(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+	// the sbom is added to the referrers tag
+	mirror := ocitest.NewRegistry()
+	defer mirror.Close()
+	mirror.NoReferrers = true
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	target := newTarget(t, primary, false)
+	c.Registry = target.Registry
+	c.RegistryName = target.Name
+	c.Mirrors = []controller.RegistryTarget{newTarget(t, mirror, false)}
+	generator, err := sbom.NewGenerator("1.0.0")
+	assert.NilError(t, err)
+	c.SBOM = generator
+
+	imageID := imageArchive(t)
+	response := &model.BuildResponse{}
+	s, err := c.GenerateSBOM(ctx, imageID, response)
+	assert.NilError(t, err)
+	assert.Equal(t, response.SBOM.Format, sbom.SPDXVersion)
+	assert.Equal(t, response.SBOM.OS, "alpine 3.19.1")
+	assert.Equal(t, response.SBOM.Packages, 1)
+	assert.Equal(t, response.SBOM.Ecosystems[sbom.TypeApk], 1)
+
+	pushed, results, err := c.PushImage(ctx, imageID, "user", "app:abc")
+	assert.NilError(t, err)
+	response.Registries = results
+	response.SetImage(pushed)
+	assert.NilError(t, c.AttachSBOM(ctx, s, response))
+
+	d := digest.Digest(pushed.Digest)
+	referrers := primary.Referrers("user/app", d)
+	assert.Equal(t, len(referrers), 1)
+	assert.Equal(t, referrers[0].ArtifactType, sbom.SPDXMediaType)
+	assert.Equal(t, referrers[0].Digest.String(), response.SBOM.Digest)
+	assert.Equal(t, response.Registries[0].SBOM, response.SBOM.Digest)
+
+	assert.Equal(t, len(mirror.Referrers("user/app", d)), 0)
+	_, _, ok := mirror.Manifest("user/app", oci.ReferrersTag(d))
+	assert.Assert(t, ok)
+	assert.Assert(t, response.Registries[1].SBOM != "")
+}
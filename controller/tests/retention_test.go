@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
+	"github.com/ipaas-org/image-builder/providers/registry/oci/ocitest"
+	"github.com/ipaas-org/image-builder/repo/memory"
+	"gotest.tools/assert"
+)
+
+// uniqueArchive writes a docker save tarball whose image is different for each content
+func uniqueArchive(t *testing.T, content string) string {
+	t.Helper()
+	return distribution.ArchivePrefix + ocitest.WriteArchive(t, [][2]string{{"app/version", content}})
+}
+
+func TestRemoveLocalImage(t *testing.T) {
+	ctx := context.Background()
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+
+	layoutPath := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(layoutPath, "index.json"), []byte("{}"), 0644))
+	assert.NilError(t, c.RemoveLocalImage(ctx, builders.OCILayoutPrefix+layoutPath))
+	_, err := os.Stat(layoutPath)
+	assert.Assert(t, os.IsNotExist(err))
+
+	archive := imageArchive(t)
+	assert.NilError(t, c.RemoveLocalImage(ctx, archive))
+	_, err = os.Stat(archive[len(distribution.ArchivePrefix):])
+	assert.Assert(t, os.IsNotExist(err))
+	// already removed
+	assert.NilError(t, c.RemoveLocalImage(ctx, archive))
+	assert.NilError(t, c.RemoveLocalImage(ctx, ""))
+}
+
+func TestCleanupLocalImage(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	archive := imageArchive(t)
+	path := archive[len(distribution.ArchivePrefix):]
+
+	// the images that are not pushed only exist on the host
+	c.RemoveLocalImages = true
+	c.CleanupLocalImage(ctx, archive)
+	_, err := os.Stat(path)
+	assert.NilError(t, err)
+
+	c.Registry = newTarget(t, primary, false).Registry
+	c.RemoveLocalImages = false
+	c.CleanupLocalImage(ctx, archive)
+	_, err = os.Stat(path)
+	assert.NilError(t, err)
+
+	c.RemoveLocalImages = true
+	c.CleanupLocalImage(ctx, archive)
+	_, err = os.Stat(path)
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+// pushBuilds pushes an image for each build, the first build is the newest
+func pushBuilds(t *testing.T, c *controller.Controller, ages ...time.Duration) []*model.Build {
+	t.Helper()
+	now := time.Now()
+	builds := make([]*model.Build, len(ages))
+	for i, age := range ages {
+		commit := fmt.Sprintf("commit%d", i)
+		pushed, results, err := c.PushImage(context.Background(), uniqueArchive(t, commit), "user", "app:"+commit)
+		assert.NilError(t, err)
+		finishedAt := now.Add(-age)
+		builds[i] = &model.Build{
+			ID:            fmt.Sprintf("build%d", i),
+			ApplicationID: "app",
+			UserID:        "user",
+			Status:        model.BuildStatusSuccess,
+			Commit:        commit,
+			ImageName:     pushed.Name,
+			ImageDigest:   pushed.Digest,
+			Registries:    results,
+			StartedAt:     finishedAt.Add(-time.Minute),
+			FinishedAt:    &finishedAt,
+		}
+	}
+	return builds
+}
+
+func TestPruneApplicationImages(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+	mirror := ocitest.NewRegistry()
+	defer mirror.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	target := newTarget(t, primary, false)
+	c.Registry = target.Registry
+	c.RegistryName = target.Name
+	c.Mirrors = []controller.RegistryTarget{newTarget(t, mirror, true)}
+	day := 24 * time.Hour
+	builds := pushBuilds(t, c, time.Hour, 2*day, 5*day, 40*day, 60*day)
+	// a failed build and a build that reused the image of commit3
+	failed := &model.Build{ID: "failed", ApplicationID: "app", Status: model.BuildStatusFailed, StartedAt: time.Now()}
+	reused := *builds[3]
+	reused.ID = "reused"
+	reused.ReusedFrom = builds[3].ID
+	reusedAt := time.Now().Add(-3 * day)
+	reused.StartedAt = reusedAt
+	reused.FinishedAt = &reusedAt
+	buildRepo := memory.NewBuildRepoer(append(builds, failed, &reused)...)
+	c.BuildRepo = buildRepo
+
+	// disabled by default
+	deleted, err := c.PruneApplicationImages(ctx, "app")
+	assert.NilError(t, err)
+	assert.Equal(t, deleted, 0)
+
+	// the last 2 images and the ones of the last 30 days are kept, commit3 was reused 3 days ago
+	c.Retention = controller.RetentionPolicy{KeepLast: 2, KeepFor: 30 * day}
+	deleted, err = c.PruneApplicationImages(ctx, "app")
+	assert.NilError(t, err)
+	assert.Equal(t, deleted, 1)
+	for i, build := range builds {
+		for _, server := range []*ocitest.Registry{primary, mirror} {
+			_, _, exists := server.Manifest("user/app", build.Commit)
+			assert.Equal(t, exists, i != 4, "%s in %s", build.Commit, server.Host())
+		}
+	}
+	pruned, err := buildRepo.GetByID(ctx, "build4")
+	assert.NilError(t, err)
+	assert.Assert(t, pruned.PrunedAt != nil)
+
+	// the pruned builds are not deleted again
+	c.Retention = controller.RetentionPolicy{KeepLast: 1}
+	deleted, err = c.PruneApplicationImages(ctx, "app")
+	assert.NilError(t, err)
+	assert.Equal(t, deleted, 3)
+	for i, build := range builds {
+		_, _, exists := primary.Manifest("user/app", build.Commit)
+		assert.Equal(t, exists, i == 0, build.Commit)
+	}
+	for _, id := range []string{"build3", "reused"} {
+		build, err := buildRepo.GetByID(ctx, id)
+		assert.NilError(t, err)
+		assert.Assert(t, build.PrunedAt != nil, id)
+	}
+	deleted, err = c.PruneApplicationImages(ctx, "app")
+	assert.NilError(t, err)
+	assert.Equal(t, deleted, 0)
+}
+
+func TestPruneApplicationImagesNotDeleted(t *testing.T) {
+	ctx := context.Background()
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	target := newTarget(t, primary, false)
+	c.Registry = target.Registry
+	c.RegistryName = target.Name
+	builds := pushBuilds(t, c, time.Hour, 2*time.Hour)
+	buildRepo := memory.NewBuildRepoer(builds...)
+	c.BuildRepo = buildRepo
+	c.Retention = controller.RetentionPolicy{KeepLast: 1}
+
+	// the build is not pruned so the image is deleted the next time
+	primary.NoDelete = true
+	deleted, err := c.PruneApplicationImages(ctx, "app")
+	assert.ErrorContains(t, err, "405")
+	assert.Equal(t, deleted, 0)
+	build, err := buildRepo.GetByID(ctx, "build1")
+	assert.NilError(t, err)
+	assert.Assert(t, build.PrunedAt == nil)
+
+	primary.NoDelete = false
+	deleted, err = c.PruneApplicationImages(ctx, "app")
+	assert.NilError(t, err)
+	assert.Equal(t, deleted, 1)
+	_, _, exists := primary.Manifest("user/app", "commit1")
+	assert.Assert(t, !exists)
+}
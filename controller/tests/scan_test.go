@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+	"github.com/ipaas-org/image-builder/providers/scanners/fake"
+	"gotest.tools/assert"
+)
+
+func TestScanImage(t *testing.T) {
+	ctx := context.Background()
+	scanner := fake.NewFakeScanner(
+		model.Vulnerability{ID: "CVE-1", Package: "zlib", InstalledVersion: "1.2", Severity: model.SeverityHigh, FixedVersion: "1.3"},
+		model.Vulnerability{ID: "CVE-2", Package: "openssl", InstalledVersion: "3.0.1", Severity: model.SeverityCritical, FixedVersion: "3.0.2"},
+	)
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	assert.Assert(t, !c.IsScanRequired())
+	c.Scanner = scanner
+	assert.Assert(t, c.IsScanRequired())
+
+	// nothing is blocked without a block severity
+	response := new(model.BuildResponse)
+	assert.NilError(t, c.ScanImage(ctx, "sha256:abc", response))
+	assert.Assert(t, !response.Scan.Blocked)
+	assert.Equal(t, response.Scan.Scanner, fake.ScannerName)
+	assert.Equal(t, response.Scan.Severities[model.SeverityCritical], 1)
+
+	c.ScanPolicy = scanners.Policy{BlockSeverity: model.SeverityCritical}
+	response = new(model.BuildResponse)
+	err := c.ScanImage(ctx, "sha256:abc", response)
+	assert.Assert(t, errors.Is(err, controller.ErrImageVulnerable))
+	assert.ErrorContains(t, err, "CVE-2 in openssl 3.0.1 (CRITICAL, fixed in 3.0.2)")
+	assert.Assert(t, response.Scan.Blocked)
+	assert.Equal(t, len(response.Scan.Violations), 1)
+
+	c.ScanPolicy.Allowlist = []string{"CVE-2"}
+	assert.NilError(t, c.ScanImage(ctx, "sha256:abc", new(model.BuildResponse)))
+
+	scanner.SetError(scanners.ErrScanFailed)
+	err = c.ScanImage(ctx, "sha256:abc", new(model.BuildResponse))
+	assert.Assert(t, errors.Is(err, scanners.ErrScanFailed))
+	assert.Equal(t, len(scanner.Scanned()), 4)
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/repo/memory"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gotest.tools/assert"
+)
+
+// racingRepoer changes the state right before the swap, like another replica would
+type racingRepoer struct {
+	*memory.ApplicationRepoerMemory
+	state model.ApplicationState
+}
+
+func (r *racingRepoer) CompareAndSwapStateByID(ctx context.Context, expected, state model.ApplicationState, id primitive.ObjectID) (bool, error) {
+	if _, err := r.UpdateStateByID(ctx, r.state, id); err != nil {
+		return false, err
+	}
+	return r.ApplicationRepoerMemory.CompareAndSwapStateByID(ctx, expected, state, id)
+}
+
+func TestUpdateApplicationState(t *testing.T) {
+	ctx := context.Background()
+	appID := primitive.NewObjectID()
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+
+	tests := []struct {
+		name     string
+		from     model.ApplicationState
+		to       model.ApplicationState
+		race     model.ApplicationState
+		expected error
+	}{
+		{name: "first build", from: "", to: model.ApplicationStatePulling},
+		{name: "next stage", from: model.ApplicationStatePulling, to: model.ApplicationStateAnalyzing},
+		{name: "requeued", from: model.ApplicationStateBuilding, to: model.ApplicationStateQueued},
+		{name: "rebuild", from: model.ApplicationStateBuilt, to: model.ApplicationStatePulling},
+		{name: "same state", from: model.ApplicationStateBuilding, to: model.ApplicationStateBuilding},
+		{name: "skipped stage", from: model.ApplicationStatePulling, to: model.ApplicationStateBuilding, expected: controller.ErrInvalidStateTransition},
+		{name: "built twice", from: model.ApplicationStateFailed, to: model.ApplicationStateBuilt, expected: controller.ErrInvalidStateTransition},
+		{name: "cancelled", from: model.ApplicationStateCancelled, to: model.ApplicationStateBuilding, expected: controller.ErrBuildCancelled},
+		{name: "deleting", from: model.ApplicationStateDeleting, to: model.ApplicationStateFailed, expected: controller.ErrBuildCancelled},
+		{name: "cancelled concurrently", from: model.ApplicationStateBuilding, to: model.ApplicationStatePushing, race: model.ApplicationStateCancelled, expected: controller.ErrBuildCancelled},
+		{name: "changed concurrently", from: model.ApplicationStateBuilt, to: model.ApplicationStatePulling, race: model.ApplicationStatePulling, expected: controller.ErrStateConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apps := memory.NewApplicationRepoer(&memory.Application{ID: appID, State: tt.from})
+			c.ApplicationRepo = apps
+			if tt.race != "" {
+				c.ApplicationRepo = &racingRepoer{ApplicationRepoerMemory: apps, state: tt.race}
+			}
+
+			err := c.UpdateApplicationState(ctx, appID.Hex(), tt.to)
+			state, _ := apps.GetStateByID(ctx, appID)
+			if tt.expected != nil {
+				assert.Assert(t, errors.Is(err, tt.expected), "got %v", err)
+				if tt.race != "" {
+					assert.Equal(t, state, tt.race)
+				} else {
+					assert.Equal(t, state, tt.from)
+				}
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, state, tt.to)
+		})
+	}
+}
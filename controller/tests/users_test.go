@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/controller"
+	"github.com/ipaas-org/image-builder/pkg/logger"
+	"github.com/ipaas-org/image-builder/providers/registry/harbor"
+	"github.com/ipaas-org/image-builder/providers/registry/harbor/harbortest"
+	"github.com/ipaas-org/image-builder/providers/registry/oci/ocitest"
+	"gotest.tools/assert"
+)
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	server := harbortest.NewServer("admin", "Harbor12345")
+	defer server.Close()
+	r, err := harbor.NewHarborRegistry("registry.example.com", "admin", "Harbor12345", harbor.HarborOptions{APIURL: server.APIURL()})
+	assert.NilError(t, err)
+
+	c := controller.NewController(logger.NewLogger(logLvl, logType))
+	_, err = c.IssuePullCredentials(ctx, "us-test")
+	assert.Assert(t, errors.Is(err, controller.ErrMissingRegistry))
+	c.Registry = r
+
+	_, err = c.IssuePullCredentials(ctx, "")
+	assert.Assert(t, errors.Is(err, controller.ErrMissingUser))
+	credentials, err := c.IssuePullCredentials(ctx, "us-test")
+	assert.NilError(t, err)
+	assert.Assert(t, server.Login("us-test", credentials.Username, credentials.Password))
+
+	assert.NilError(t, c.DeleteUser(ctx, "us-test"))
+	_, ok := server.Project("us-test")
+	assert.Assert(t, !ok)
+
+	// the other registries don't manage the users
+	primary := ocitest.NewRegistry()
+	defer primary.Close()
+	c.Registry = newTarget(t, primary, false).Registry
+	err = c.DeleteUser(ctx, "us-test")
+	assert.Assert(t, errors.Is(err, controller.ErrUsersNotSupported))
+}
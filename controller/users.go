@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry"
+)
+
+// userManager returns the primary registry if it manages the users, the mirrors
+// are not managed since the users pull from the primary registry
+func (c *Controller) userManager(userCode string) (registry.UserManager, error) {
+	if userCode == "" {
+		return nil, ErrMissingUser
+	}
+	if c.Registry == nil {
+		return nil, ErrMissingRegistry
+	}
+	manager, ok := c.Registry.(registry.UserManager)
+	if !ok {
+		return nil, ErrUsersNotSupported
+	}
+	return manager, nil
+}
+
+// IssuePullCredentials returns new credentials to pull the images of the user from
+// the primary registry, the namespace of the user is created if missing
+func (c *Controller) IssuePullCredentials(ctx context.Context, userCode string) (*model.RegistryCredentials, error) {
+	manager, err := c.userManager(userCode)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := manager.PullCredentials(ctx, userCode)
+	if err != nil {
+		return nil, err
+	}
+	c.l.Infof("issued the pull credentials of %s (%s)", userCode, credentials.Username)
+	return credentials, nil
+}
+
+// DeleteUser removes the namespace of the user with its images from the primary registry
+func (c *Controller) DeleteUser(ctx context.Context, userCode string) error {
+	manager, err := c.userManager(userCode)
+	if err != nil {
+		return err
+	}
+	if err := manager.DeleteUser(ctx, userCode); err != nil {
+		return err
+	}
+	c.l.Infof("deleted the registry namespace of %s", userCode)
+	return nil
+}
@@ -3,17 +3,25 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"runtime/debug"
+	"time"
 
 	"github.com/ipaas-org/image-builder/controller"
 	"github.com/ipaas-org/image-builder/model"
 	"github.com/ipaas-org/image-builder/providers/builders"
 	"github.com/ipaas-org/image-builder/providers/connectors/github"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/sbom"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
+// lockedRetryDelay is how long a request waits before being requeued
+// when its application is being built by another replica
+const lockedRetryDelay = 10 * time.Second
+
 type RabbitMQ struct {
 	Connection        *amqp.Connection
 	Channel           *amqp.Channel
@@ -25,6 +33,8 @@ type RabbitMQ struct {
 
 	Controller *controller.Controller
 	l          *logrus.Logger
+	// lease of the request being handled, the requests are handled one at a time
+	lease *controller.BuildLease
 
 	Done  chan struct{}
 	Error <-chan error
@@ -150,211 +160,577 @@ func (r *RabbitMQ) consume(ctx context.Context) {
 			r.l.Info("stopping rabbitmq consumer, context cancelled")
 			return
 		case d := <-r.Delivery:
-			r.l.Info("received message from rabbitmq")
-			r.l.Debugf("received: %q", string(d.Body))
-			// r.l.Debugf("delivery: %+v", d)
-			if d.Body == nil {
-				if err := d.Ack(false); err != nil {
-					r.l.Errorf("r.Consume.Ack(): %v:", err)
-					return
-				}
-				continue
+			if !r.handle(ctx, d) {
+				return
 			}
+		}
+	}
+}
 
-			info := new(model.Request)
-			response := new(model.BuildResponse)
+// handle processes a request, false is returned if the consumer must stop
+func (r *RabbitMQ) handle(ctx context.Context, d amqp.Delivery) bool {
+	r.l.Info("received message from rabbitmq")
+	// the body has the values of the secrets, only the decoded request without them is logged
+	r.l.Debugf("received %d bytes", len(d.Body))
+	// r.l.Debugf("delivery: %+v", d)
+	if d.Body == nil {
+		if err := d.Ack(false); err != nil {
+			r.l.Errorf("r.Consume.Ack(): %v:", err)
+			return false
+		}
+		return true
+	}
 
-			response.Status = model.ResponseStatusFailed
-			response.IsError = true
+	info := new(model.Request)
+	response := new(model.BuildResponse)
 
-			if err := json.Unmarshal(d.Body, info); err != nil {
-				r.l.Errorf("r.Consume.json.Unmarshal(): %v:", err)
-				r.l.Debug(string(d.Body))
-				err := r.sendResponseWithFault(d, model.ResponseErrorFaultUser, response, "invalid request")
-				if err != nil {
-					return
-				}
-				continue
-			}
+	response.Status = model.ResponseStatusFailed
+	response.IsError = true
 
-			r.l.Debug(info)
+	if err := json.Unmarshal(d.Body, info); err != nil {
+		r.l.Errorf("r.Consume.json.Unmarshal(): %v:", err)
+		err := r.sendResponseWithFault(ctx, d, nil, model.ResponseErrorFaultUser, response, "invalid request")
+		if err != nil {
+			return false
+		}
+		return true
+	}
 
-			shouldBuild, err := r.Controller.ShouldBuild(ctx, info.ApplicationID)
-			if err != nil {
-				r.l.Errorf("r.Controller.ShouldBuild(): %v:", err)
-				err := r.sendResponseWithFault(d, model.ResponseErrorFaultService, response, err.Error())
-				if err != nil {
-					return
-				}
-				continue
+	r.l.Debug(info.WithoutSecrets())
+	if info.RequestID == "" {
+		info.RequestID = d.MessageId
+	}
+
+	switch info.Type {
+	case "", model.RequestTypeBuild:
+	case model.RequestTypeUserCredentials, model.RequestTypeUserDelete:
+		return r.handleUser(ctx, d, info, response)
+	case model.RequestTypePromote:
+		return r.handlePromote(ctx, d, info, response)
+	default:
+		err := r.sendResponseWithFault(ctx, d, nil, model.ResponseErrorFaultUser, response, fmt.Sprintf("unknown request type %q", info.Type))
+		return err == nil
+	}
+
+	response.ApplicationID = info.ApplicationID
+	shouldBuild, err := r.Controller.ShouldBuild(ctx, info.ApplicationID)
+	if err != nil {
+		r.l.Errorf("r.Controller.ShouldBuild(): %v:", err)
+		fault := model.ResponseErrorFaultService
+		if errors.Is(err, controller.ErrBuildCancelled) {
+			// the caller is told the request was rejected, it's not retried
+			fault = model.ResponseErrorFaultUser
+		}
+		err := r.sendResponseWithFault(ctx, d, nil, fault, response, err.Error())
+		if err != nil {
+			return false
+		}
+		return true
+	}
+	if !shouldBuild {
+		r.l.Infof("application should not be built, skipping")
+		if err := d.Ack(false); err != nil {
+			r.l.Errorf("r.Consume.Ack(): %v:", err)
+			return false
+		}
+		return true
+	}
+
+	previous := r.Controller.PreviousBuild(ctx, info.RequestID)
+	if previous != nil && previous.IsFinal() {
+		r.l.Infof("request %s was already handled by build %s, sending its response again", info.RequestID, previous.ID)
+		if err := d.Ack(false); err != nil {
+			r.l.Errorf("r.Consume.Ack(): %v:", err)
+			return false
+		}
+		if err := r.sendResponse(previous.Response()); err != nil {
+			return false
+		}
+		return true
+	}
+
+	lease, err := r.Controller.AcquireBuildLease(ctx, info.ApplicationID)
+	if err != nil {
+		if !errors.Is(err, controller.ErrBuildLocked) {
+			r.l.Errorf("r.Controller.AcquireBuildLease(): %v:", err)
+			err := r.sendResponseWithFault(ctx, d, nil, model.ResponseErrorFaultService, response, err.Error())
+			return err == nil
+		}
+		// the build may have finished meanwhile, its replica sent the response. Otherwise
+		// the request is requeued, the replica holding the lease may have requeued it
+		// with a service fault or lost its channel and can't answer it anymore
+		if previous := r.Controller.PreviousBuild(ctx, info.RequestID); previous != nil && previous.IsFinal() {
+			r.l.Infof("request %s was handled by another replica, skipping", info.RequestID)
+			if err := d.Ack(false); err != nil {
+				r.l.Errorf("r.Consume.Ack(): %v:", err)
+				return false
 			}
-			if !shouldBuild {
-				r.l.Infof("application should not be built, skipping")
-				if err := d.Ack(false); err != nil {
-					r.l.Errorf("r.Consume.Ack(): %v:", err)
-					return
-				}
-				continue
+			return true
+		}
+		r.l.Infof("application %s is being built by another replica, requeueing the request", info.ApplicationID)
+		return r.requeueLater(ctx, d)
+	}
+	r.lease = lease
+	defer r.releaseLease()
+
+	build := r.Controller.StartBuild(ctx, info)
+	response.Repo = info.PullInfo.Repo
+	if err := r.Controller.StartBuildStage(ctx, build, model.BuildStagePull); err != nil {
+		if err := r.sendStageError(ctx, d, build, response, err); err != nil {
+			return false
+		}
+		return true
+	}
+	pulledInfo, err := r.Controller.PullRepo(ctx, info.PullInfo)
+	if err != nil {
+		var fault model.ResponseErrorFault
+		switch err {
+		case github.ErrMissingRepoName,
+			github.ErrMissingUsername,
+			github.ErrInvalidUrl,
+			controller.ErrConnectorNotFound,
+			controller.ErrEmptyToken:
+			fault = model.ResponseErrorFaultUser
+		default: //in case of rate limit it should be put in a queue that retries after a while, or return an error
+			fault = model.ResponseErrorFaultService
+		}
+		r.l.Errorf("r.Controller.PullRepo(): %v", err)
+		err := r.sendResponseWithFault(ctx, d, build, fault, response, err.Error())
+		if err != nil {
+			return false
+		}
+		return true
+	}
+
+	response.BuiltCommit = pulledInfo.PulledCommit
+	r.l.Infof("repo %s pulled successfully", response.Repo)
+
+	if err := r.Controller.StartBuildStage(ctx, build, model.BuildStageAnalyze); err != nil {
+		if err := r.sendStageError(ctx, d, build, response, err); err != nil {
+			return false
+		}
+		return true
+	}
+	repoAnalysis, err := r.Controller.AnalyzeRepositoryContent(ctx, pulledInfo.Path, info.BuildPlan.RootDirectory, info.PullInfo.Repo, info.PullInfo.Branch)
+	if err != nil {
+		r.l.Errorf("error analyzing repository content: %v", err)
+		err := r.sendResponseWithFault(ctx, d, build, model.ResponseErrorFaultService, response, err.Error())
+		if err != nil {
+			return false
+		}
+		return true
+	}
+	response.RepoAnalisys = repoAnalysis
+	r.l.Infof("repo %s analyzed", response.Repo)
+
+	if !repoAnalysis.IsBuildable {
+		r.l.Infof("repo %s is not buildable: %s", response.Repo, repoAnalysis.Reason)
+		err := r.sendResponseWithFault(ctx, d, build, model.ResponseErrorFaultUser, response, repoAnalysis.Reason)
+		if err != nil {
+			return false
+		}
+		return true
+	}
+
+	if info.BuildPlan.Builder == "" {
+		r.l.Info("no build plan specified, generating one")
+		if err := r.Controller.StartBuildStage(ctx, build, model.BuildStagePlan); err != nil {
+			if err := r.sendStageError(ctx, d, build, response, err); err != nil {
+				return false
 			}
+			return true
+		}
 
-			if err := r.Controller.UpdateApplicationStateToBuilding(ctx, info.ApplicationID); err != nil {
-				r.l.Errorf("r.Controller.UpdateApplicationStateToBuilding(): %v:", err)
-				err := r.sendResponseWithFault(d, model.ResponseErrorFaultService, response, err.Error())
-				if err != nil {
-					return
-				}
-				continue
+		config, err := r.Controller.GenerateBuildConfig(ctx, repoAnalysis)
+		if err != nil {
+			r.l.Errorf("r.Controller.GenerateBuildConfig(): %v:", err)
+			// no enabled builder can build the repo, retrying doesn't help
+			fault := model.ResponseErrorFaultService
+			if errors.Is(err, controller.ErrNotBuildable) {
+				fault = model.ResponseErrorFaultUser
 			}
-			response.ApplicationID = info.ApplicationID
-			response.Repo = info.PullInfo.Repo
-			pulledInfo, err := r.Controller.PullRepo(ctx, info.PullInfo)
+			err := r.sendResponseWithFault(ctx, d, build, fault, response, err.Error())
 			if err != nil {
-				var fault model.ResponseErrorFault
-				switch err {
-				case github.ErrMissingRepoName,
-					github.ErrMissingUsername,
-					github.ErrInvalidUrl,
-					controller.ErrConnectorNotFound,
-					controller.ErrEmptyToken:
-					fault = model.ResponseErrorFaultUser
-					if err := r.Controller.UpdateApplicationStateToFailed(ctx, info.ApplicationID); err != nil {
-						err := r.sendResponseWithFault(d, model.ResponseErrorFaultService, response, err.Error())
-						if err != nil {
-							return
-						}
-						continue
-					}
-				default: //in case of rate limit it should be put in a queue that retries after a while, or return an error
-					fault = model.ResponseErrorFaultService
-				}
-				r.l.Errorf("r.Controller.PullRepo(): %v", err)
-				err := r.sendResponseWithFault(d, fault, response, err.Error())
-				if err != nil {
-					return
-				}
-				continue
+				return false
 			}
+			return true
+		}
+
+		// secrets can't be detected, keep the ones sent with the request
+		config.Secrets = info.BuildPlan.Secrets
+		info.BuildPlan = config
+	}
 
-			response.BuiltCommit = pulledInfo.PulledCommit
-			r.l.Infof("repo %s pulled successfully", response.Repo)
+	if r.Controller.IsPushRequired() {
+		pushed, err := r.Controller.FindPushedImage(ctx, previous, info.PullInfo.UserID, response.BuiltCommit, info.BuildPlan)
+		if err != nil {
+			r.l.Warnf("r.Controller.FindPushedImage(): %v:", err)
+		}
+		if pushed != nil {
+			// the previous attempt failed after the push, the image can be signed again
+			r.l.Infof("commit %s was pushed by build %s of the request, reusing its image", response.BuiltCommit, pushed.ID)
+			r.Controller.RemovePulledRepo(pulledInfo)
+			response.PlanUsed = info.BuildPlan.WithoutSecrets()
+			r.Controller.ReuseImage(build, pushed, response)
+			return r.signImage(ctx, d, build, response, nil)
+		}
 
-			repoAnalysis, err := r.Controller.AnalyzeRepositoryContent(ctx, pulledInfo.Path, info.BuildPlan.RootDirectory, info.PullInfo.Repo, info.PullInfo.Branch)
+		built, err := r.Controller.FindBuiltImage(ctx, info.ApplicationID, info.PullInfo.UserID, response.BuiltCommit, info.BuildPlan)
+		if err != nil {
+			r.l.Warnf("r.Controller.FindBuiltImage(): %v:", err)
+		}
+		if built != nil {
+			r.l.Infof("commit %s was already built by build %s, reusing its image", response.BuiltCommit, built.ID)
+			r.Controller.RemovePulledRepo(pulledInfo)
+			response.PlanUsed = info.BuildPlan.WithoutSecrets()
+			r.Controller.ReuseImage(build, built, response)
+			return r.sendSuccess(ctx, d, build, response)
+		}
+	}
+
+	if err := r.Controller.StartBuildStage(ctx, build, model.BuildStageBuild); err != nil {
+		if err := r.sendStageError(ctx, d, build, response, err); err != nil {
+			return false
+		}
+		return true
+	}
+	imageID, buildOutput, err := r.Controller.BuildImage(ctx, info.ApplicationID, info.PullInfo.Repo, info.PullInfo.UserID, pulledInfo.Path, info.BuildPlan)
+	response.BuildOutput = string(buildOutput)
+	response.Cache = builders.CacheStats(buildOutput)
+	response.PlanUsed = info.BuildPlan.WithoutSecrets()
+	if err != nil {
+		r.l.Errorf("r.Controller.BuildImage(): %v:", err)
+		r.l.Error(buildOutput)
+
+		// config errors can come with the output of the build, they have their own message
+		isConfigErr := errors.Is(err, builders.ErrMissingConfig) || errors.Is(err, builders.ErrInvalidConfig)
+		if errors.Is(err, builders.ErrLimitExceeded) || errors.Is(err, builders.ErrBaseImageNotAllowed) {
+			err := r.sendResponseWithFault(ctx, d, build, model.ResponseErrorFaultUser, response, err.Error())
 			if err != nil {
-				r.l.Errorf("error analyzing repository content: %v", err)
-				err := r.sendResponseWithFault(d, model.ResponseErrorFaultService, response, err.Error())
-				if err != nil {
-					return
-				}
-				continue
+				return false
 			}
-			response.RepoAnalisys = repoAnalysis
-			r.l.Infof("repo %s analyzed", response.Repo)
-
-			if !repoAnalysis.IsBuildable {
-				r.l.Infof("repo %s is not buildable: %s", response.Repo, repoAnalysis.Reason)
-				err := r.sendResponseWithFault(d, model.ResponseErrorFaultUser, response, repoAnalysis.Reason)
-				if err != nil {
-					return
-				}
-				continue
+			return true
+		}
+		if buildOutput != nil && !isConfigErr {
+			message := "fail to build image"
+			var buildErr *builders.BuildError
+			if errors.As(err, &buildErr) {
+				message = fmt.Sprintf("fail to build image, step %q failed: %s", buildErr.Step, buildErr.Message)
 			}
+			err := r.sendResponseWithFault(ctx, d, build, model.ResponseErrorFaultUser, response, message)
+			if err != nil {
+				return false
+			}
+			return true
+		}
 
-			if info.BuildPlan.Builder == "" {
-				r.l.Info("no build plan specified, generating one")
+		response.Fault = model.ResponseErrorFaultUser
+		switch {
+		case errors.Is(err, builders.ErrMissingConfig):
+			response.Message = "unable to find specified config file"
+		case errors.Is(err, builders.ErrInvalidConfig):
+			response.Message = "invalid config file"
+			// validation errors explain which field is invalid
+			if err != builders.ErrInvalidConfig {
+				response.Message = err.Error()
+			}
+		case errors.Is(err, controller.ErrBuilderNotFound):
+			response.Message = "builder not found"
+		case errors.Is(err, builders.ErrUnsupportedPlatform), errors.Is(err, builders.ErrUnsupportedLimits):
+			response.Message = err.Error()
+		case errors.Is(err, controller.ErrInexistingRootDir):
+			response.Message = "provided root directory is inexistent"
+		default:
+			response.Fault = model.ResponseErrorFaultService
+			response.Message = err.Error()
+		}
+		err := r.sendResponseWithFault(ctx, d, build, response.Fault, response, response.Message)
+		if err != nil {
+			return false
+		}
+		return true
+	}
 
-				config, err := r.Controller.GenerateBuildConfig(ctx, repoAnalysis)
-				if err != nil {
-					r.l.Errorf("r.Controller.GenerateBuildConfig(): %v:", err)
-					err := r.sendResponseWithFault(d, model.ResponseErrorFaultService, response, err.Error())
-					if err != nil {
-						return
-					}
-					continue
-				}
+	response.ImageID = imageID
+	// once pushed (or failed) the image is only needed in the registries
+	defer r.Controller.CleanupLocalImage(context.WithoutCancel(ctx), imageID)
 
-				info.BuildPlan = config
+	var imageSBOM *sbom.SBOM
+	if r.Controller.IsSBOMRequired() {
+		if err := r.Controller.StartBuildStage(ctx, build, model.BuildStageSBOM); err != nil {
+			if err := r.sendStageError(ctx, d, build, response, err); err != nil {
+				return false
 			}
+			return true
+		}
+		imageSBOM, err = r.Controller.GenerateSBOM(ctx, imageID, response)
+		if err != nil {
+			// the image is still pushed, only without its sbom
+			r.l.Warnf("r.Controller.GenerateSBOM(): %v:", err)
+		}
+	}
 
-			imageID, buildOutput, err := r.Controller.BuildImage(ctx, info.PullInfo.Repo, info.PullInfo.UserID, pulledInfo.Path, info.BuildPlan)
-			response.BuildOutput = string(buildOutput)
-			response.PlanUsed = info.BuildPlan
+	if r.Controller.IsScanRequired() {
+		if err := r.Controller.StartBuildStage(ctx, build, model.BuildStageScan); err != nil {
+			if err := r.sendStageError(ctx, d, build, response, err); err != nil {
+				return false
+			}
+			return true
+		}
+		if err := r.Controller.ScanImage(ctx, imageID, response); err != nil {
+			r.l.Errorf("r.Controller.ScanImage(): %v:", err)
+			// the vulnerabilities come from the user's image, the scan failures from the service
+			fault := model.ResponseErrorFaultService
+			if errors.Is(err, controller.ErrImageVulnerable) {
+				fault = model.ResponseErrorFaultUser
+			}
+			err := r.sendResponseWithFault(ctx, d, build, fault, response, err.Error())
 			if err != nil {
-				r.l.Errorf("r.Controller.BuildImage(): %v:", err)
-				r.l.Error(buildOutput)
-
-				if buildOutput != nil {
-					if err := r.Controller.UpdateApplicationStateToFailed(ctx, info.ApplicationID); err != nil {
-						r.l.Errorf("r.Controller.UpdateApplicationStateToFailed(): %v:", err)
-						err := r.sendResponseWithFault(d, model.ResponseErrorFaultService, response, err.Error())
-						if err != nil {
-							return
-						}
-						continue
-					}
-					err := r.sendResponseWithFault(d, model.ResponseErrorFaultUser, response, "fail to build image")
-					if err != nil {
-						return
-					}
-					continue
-				}
-
-				response.Fault = model.ResponseErrorFaultUser
-				switch err {
-				case builders.ErrMissingConfig:
-					response.Message = "unable to find specified config file"
-				case builders.ErrInvalidConfig:
-					response.Message = "invalid config file"
-				case controller.ErrBuilderNotFound:
-					response.Message = "builder not found"
-				case controller.ErrInexistingRootDir:
-					response.Message = "provided root directory is inexistent"
-				default:
-					response.Fault = model.ResponseErrorFaultService
-					response.Message = err.Error()
-				}
-				err := r.sendResponseWithFault(d, response.Fault, response, response.Message)
-				if err != nil {
-					return
-				}
-				continue
+				return false
 			}
+			return true
+		}
+	}
 
-			response.ImageID = imageID
-
-			if r.Controller.IsPushRequired() {
-				appName := info.ApplicationID + ":" + response.BuiltCommit
-				response.ImageName, err = r.Controller.PushImage(ctx, imageID, info.PullInfo.UserID, appName)
-				if err != nil {
-					r.l.Errorf("r.Controller.PushImage(): %v:", err)
-					err := r.sendResponseWithFault(d, model.ResponseErrorFaultService, response, err.Error())
-					if err != nil {
-						return
-					}
-					continue
-				}
-				r.l.Info("image pushed to regsitry correctly")
-			} else {
-				r.l.Info("pushing image to registry is not required")
+	if r.Controller.IsPushRequired() {
+		if err := r.Controller.StartBuildStage(ctx, build, model.BuildStagePush); err != nil {
+			if err := r.sendStageError(ctx, d, build, response, err); err != nil {
+				return false
 			}
-
-			if err := d.Ack(false); err != nil {
-				r.l.Errorf("r.Consume.Ack(): %v:", err)
-				return
+			return true
+		}
+		// the image is still in the daemon, the cache must be pushed before
+		// PushImage removes the oci layouts
+		if err := r.Controller.PushCache(ctx, imageID, info.PullInfo.UserID, info.ApplicationID); err != nil {
+			r.l.Warnf("r.Controller.PushCache(): %v:", err)
+		}
+		appName := info.ApplicationID + ":" + response.BuiltCommit
+		pushed, registries, err := r.Controller.PushImage(ctx, imageID, info.PullInfo.UserID, appName)
+		response.Registries = registries
+		if err != nil {
+			r.l.Errorf("r.Controller.PushImage(): %v:", err)
+			// the image of the commit was pushed before with a different digest, retrying doesn't help
+			fault := model.ResponseErrorFaultService
+			if errors.Is(err, registry.ErrTagExists) {
+				fault = model.ResponseErrorFaultUser
 			}
-
-			r.l.Infof("image %s built successfully", response.ImageID)
-			response.Status = model.ResponseStatusSuccess
-			response.IsError = false
-			if err := r.sendResponse(response); err != nil {
-				r.l.Errorf("r.SendResponse(): %v:", err)
-				r.l.Errorf("response: %v", response)
-				return
+			err := r.sendResponseWithFault(ctx, d, build, fault, response, err.Error())
+			if err != nil {
+				return false
 			}
+			return true
+		}
+		response.SetImage(pushed)
+		r.l.Info("image pushed to regsitry correctly")
+		// with immutable tags the commit can't be pushed again, a retry must find the image
+		r.Controller.RecordPushedImage(ctx, build, response)
+		return r.signImage(ctx, d, build, response, imageSBOM)
+	}
+
+	r.l.Info("pushing image to registry is not required")
+	return r.sendSuccess(ctx, d, build, response)
+}
+
+// signImage signs the pushed image and attaches its sbom (if not nil) before sending
+// the response of the successful build, false is returned if the consumer must stop
+func (r *RabbitMQ) signImage(ctx context.Context, d amqp.Delivery, build *model.Build, response *model.BuildResponse, imageSBOM *sbom.SBOM) bool {
+	if err := r.Controller.SignImage(ctx, build, response); err != nil {
+		r.l.Errorf("r.Controller.SignImage(): %v:", err)
+		err := r.sendResponseWithFault(ctx, d, build, model.ResponseErrorFaultService, response, err.Error())
+		return err == nil
+	}
+	if err := r.Controller.AttachSBOM(ctx, imageSBOM, response); err != nil {
+		r.l.Errorf("r.Controller.AttachSBOM(): %v:", err)
+		err := r.sendResponseWithFault(ctx, d, build, model.ResponseErrorFaultService, response, err.Error())
+		return err == nil
+	}
+	return r.sendSuccess(ctx, d, build, response)
+}
+
+// handleUser handles the requests that manage the registry namespace of a user,
+// false is returned if the consumer must stop
+func (r *RabbitMQ) handleUser(ctx context.Context, d amqp.Delivery, info *model.Request, response *model.BuildResponse) bool {
+	response.Type = info.Type
+	response.UserID = info.UserID
+
+	var err error
+	switch info.Type {
+	case model.RequestTypeUserCredentials:
+		response.PullCredentials, err = r.Controller.IssuePullCredentials(ctx, info.UserID)
+	case model.RequestTypeUserDelete:
+		err = r.Controller.DeleteUser(ctx, info.UserID)
+	}
+	if err != nil {
+		r.l.Errorf("%s request of %q: %v:", info.Type, info.UserID, err)
+		// retrying doesn't help if the user is missing or the registry can't manage the users
+		fault := model.ResponseErrorFaultService
+		if errors.Is(err, controller.ErrMissingUser) || errors.Is(err, controller.ErrUsersNotSupported) || errors.Is(err, controller.ErrMissingRegistry) {
+			fault = model.ResponseErrorFaultUser
+		}
+		err := r.sendResponseWithFault(ctx, d, nil, fault, response, err.Error())
+		return err == nil
+	}
+
+	if err := d.Ack(false); err != nil {
+		r.l.Errorf("r.Consume.Ack(): %v:", err)
+		return false
+	}
+	response.Status = model.ResponseStatusSuccess
+	response.IsError = false
+	if err := r.sendResponse(response); err != nil {
+		r.l.Errorf("r.SendResponse(): %v:", err)
+		return false
+	}
+	return true
+}
+
+// handlePromote handles the requests that tag a pushed image with environment tags,
+// the lease of the application is held so its images are not pruned meanwhile.
+// False is returned if the consumer must stop
+func (r *RabbitMQ) handlePromote(ctx context.Context, d amqp.Delivery, info *model.Request, response *model.BuildResponse) bool {
+	response.Type = info.Type
+	response.UserID = info.UserID
+	response.ApplicationID = info.ApplicationID
+
+	if err := r.Controller.ValidatePromotion(info.UserID, info.ApplicationID, info.Promotion); err != nil {
+		r.l.Errorf("invalid promotion of %q: %v:", info.ApplicationID, err)
+		err := r.sendResponseWithFault(ctx, d, nil, model.ResponseErrorFaultUser, response, err.Error())
+		return err == nil
+	}
+	lease, err := r.Controller.AcquireBuildLease(ctx, info.ApplicationID)
+	if err != nil {
+		if errors.Is(err, controller.ErrBuildLocked) {
+			r.l.Infof("application %s is being built by another replica, requeueing the promotion", info.ApplicationID)
+			return r.requeueLater(ctx, d)
+		}
+		r.l.Errorf("r.Controller.AcquireBuildLease(): %v:", err)
+		err := r.sendResponseWithFault(ctx, d, nil, model.ResponseErrorFaultService, response, err.Error())
+		return err == nil
+	}
+	r.lease = lease
+	defer r.releaseLease()
+
+	pushed, registries, err := r.Controller.PromoteImage(ctx, info.UserID, info.ApplicationID, info.Promotion)
+	response.Registries = registries
+	if err != nil {
+		r.l.Errorf("r.Controller.PromoteImage(): %v:", err)
+		// retrying doesn't help if the image doesn't exist
+		fault := model.ResponseErrorFaultService
+		if errors.Is(err, registry.ErrImageNotFound) || errors.Is(err, controller.ErrInvalidPromotion) || errors.Is(err, controller.ErrPromotionNotSupported) {
+			fault = model.ResponseErrorFaultUser
 		}
+		err := r.sendResponseWithFault(ctx, d, nil, fault, response, err.Error())
+		return err == nil
+	}
+	response.SetImage(pushed)
+
+	if err := d.Ack(false); err != nil {
+		r.l.Errorf("r.Consume.Ack(): %v:", err)
+		return false
 	}
+	r.l.Infof("image %s promoted to %v", pushed.Reference(), info.Promotion.Tags)
+	response.Status = model.ResponseStatusSuccess
+	response.IsError = false
+	if err := r.sendResponse(response); err != nil {
+		r.l.Errorf("r.SendResponse(): %v:", err)
+		return false
+	}
+	return true
 }
 
-func (r *RabbitMQ) sendResponseWithFault(d amqp.Delivery, fault model.ResponseErrorFault, response *model.BuildResponse, message string) error {
+// sendSuccess moves the application to built and sends the response of the successful build
+func (r *RabbitMQ) sendSuccess(ctx context.Context, d amqp.Delivery, build *model.Build, response *model.BuildResponse) bool {
+	if err := r.Controller.UpdateApplicationState(ctx, build.ApplicationID, model.ApplicationStateBuilt); err != nil {
+		if err := r.sendStageError(ctx, d, build, response, err); err != nil {
+			return false
+		}
+		return true
+	}
+	r.l.Infof("image %s built successfully", response.ImageID)
+	response.Status = model.ResponseStatusSuccess
+	response.IsError = false
+	// saved before the ack, a redelivery finds the response to send
+	r.Controller.FinishBuild(ctx, build, response)
+	if err := d.Ack(false); err != nil {
+		r.l.Errorf("r.Consume.Ack(): %v:", err)
+		return false
+	}
+
+	if err := r.sendResponse(response); err != nil {
+		r.l.Errorf("r.SendResponse(): %v:", err)
+		r.l.Errorf("response: %v", response)
+		return false
+	}
+
+	// the lease of the application is still held, no other build can push meanwhile
+	deleted, err := r.Controller.PruneApplicationImages(ctx, build.ApplicationID)
+	if err != nil {
+		r.l.Warnf("r.Controller.PruneApplicationImages(): %v:", err)
+	}
+	if deleted > 0 {
+		r.l.Infof("deleted %d old images of %s from the registries", deleted, build.ApplicationID)
+	}
+	return true
+}
+
+// releaseLease releases the lease of the request being handled, if any
+func (r *RabbitMQ) releaseLease() {
+	r.Controller.ReleaseBuildLease(r.lease)
+	r.lease = nil
+}
+
+// requeueLater requeues the request after lockedRetryDelay, the consumer
+// doesn't receive other requests in the meantime
+func (r *RabbitMQ) requeueLater(ctx context.Context, d amqp.Delivery) bool {
+	select {
+	case <-ctx.Done():
+	case <-time.After(lockedRetryDelay):
+	}
+	if err := d.Nack(false, true); err != nil {
+		r.l.Errorf("r.Consume.Nack(): %v:", err)
+		return false
+	}
+	return true
+}
+
+// sendStageError sends the response of a build that could not move to its next state
+func (r *RabbitMQ) sendStageError(ctx context.Context, d amqp.Delivery, build *model.Build, response *model.BuildResponse, err error) error {
+	r.l.Errorf("unable to update the state of %s: %v", build.ApplicationID, err)
+	if errors.Is(err, controller.ErrBuildCancelled) {
+		return r.sendResponseWithFault(ctx, d, build, model.ResponseErrorFaultUser, response, err.Error())
+	}
+	return r.sendResponseWithFault(ctx, d, build, model.ResponseErrorFaultService, response, err.Error())
+}
+
+// sendResponseWithFault acks (user faults) or requeues (service faults) the request
+// and sends the response, build is saved in the history if not nil.
+// If the build moved the application to one of its states, the application
+// is moved to failed (user faults) or back to queued (service faults).
+// The build is saved and the lease released before the request is requeued,
+// the replica that receives it again must find them
+func (r *RabbitMQ) sendResponseWithFault(ctx context.Context, d amqp.Delivery, build *model.Build, fault model.ResponseErrorFault, response *model.BuildResponse, message string) error {
+	if build != nil && len(build.Stages) > 0 {
+		state := model.ApplicationStateFailed
+		if fault == model.ResponseErrorFaultService {
+			state = model.ApplicationStateQueued
+		}
+		err := r.Controller.UpdateApplicationState(ctx, build.ApplicationID, state)
+		switch {
+		case err == nil, errors.Is(err, controller.ErrBuildCancelled):
+		case fault == model.ResponseErrorFaultUser:
+			// the request is retried until the failure is saved
+			r.l.Errorf("r.Controller.UpdateApplicationState(): %v:", err)
+			fault = model.ResponseErrorFaultService
+			message = err.Error()
+		default:
+			r.l.Errorf("r.Controller.UpdateApplicationState(): %v:", err)
+		}
+	}
+	response.Message = message
+	response.Fault = fault
+	r.Controller.FinishBuild(ctx, build, response)
+	r.releaseLease()
+
 	if fault == model.ResponseErrorFaultService {
 		if err := d.Nack(false, true); err != nil {
 			r.l.Errorf("r.Consume.Nack(): %v:", err)
@@ -367,8 +743,6 @@ func (r *RabbitMQ) sendResponseWithFault(d amqp.Delivery, fault model.ResponseEr
 		}
 	}
 
-	response.Message = message
-	response.Fault = fault
 	if err := r.sendResponse(response); err != nil {
 		r.l.Errorf("r.SendResponse(): %v:", err)
 		r.l.Errorf("response: %v", response)
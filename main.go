@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime/debug"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,11 +17,25 @@ import (
 	"github.com/ipaas-org/image-builder/model"
 	"github.com/ipaas-org/image-builder/pkg/logger"
 	"github.com/ipaas-org/image-builder/providers/analyzers/baseAnalyzer"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/buildpacks"
+	"github.com/ipaas-org/image-builder/providers/builders/daemonless"
 	"github.com/ipaas-org/image-builder/providers/builders/docker"
 	"github.com/ipaas-org/image-builder/providers/builders/nixpacks"
+	"github.com/ipaas-org/image-builder/providers/builders/static"
 	"github.com/ipaas-org/image-builder/providers/connectors/github"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
 	"github.com/ipaas-org/image-builder/providers/registry/harbor"
-	"github.com/ipaas-org/image-builder/providers/registry/registry"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	defaultRegistry "github.com/ipaas-org/image-builder/providers/registry/registry"
+	"github.com/ipaas-org/image-builder/providers/sbom"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+	"github.com/ipaas-org/image-builder/providers/scanners/fake"
+	harborScanner "github.com/ipaas-org/image-builder/providers/scanners/harbor"
+	"github.com/ipaas-org/image-builder/providers/scanners/trivy"
+	"github.com/ipaas-org/image-builder/providers/signing"
+	memoryRepo "github.com/ipaas-org/image-builder/repo/memory"
 	mongoRepo "github.com/ipaas-org/image-builder/repo/mongo"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -74,6 +89,25 @@ func main() {
 		applicationCollection := client.Database("ipaas").Collection("application")
 		applicationRepo := mongoRepo.NewApplicationRepoer(applicationCollection)
 		c.ApplicationRepo = applicationRepo
+
+		l.Debug("connecting to build collection")
+		buildCollection := client.Database("ipaas").Collection("build")
+		ctx, cancel = context.WithTimeout(context.TODO(), 10*time.Second)
+		if err := mongoRepo.EnsureBuildIndexes(ctx, buildCollection); err != nil {
+			l.Fatalf("main - mongoRepo.EnsureBuildIndexes - error creating the build indexes: %s", err.Error())
+		}
+		cancel()
+		c.BuildRepo = mongoRepo.NewBuildRepoer(buildCollection)
+		c.LockRepo = mongoRepo.NewLockRepoer(client.Database("ipaas").Collection("lock"))
+	case "memory", "mock":
+		l.Info("using in-memory database, the state is lost on restart")
+		fixtures, err := memoryRepo.LoadFixtures(conf.Database.Fixtures)
+		if err != nil {
+			l.Fatalf("main - memoryRepo.LoadFixtures - error loading fixtures: %s", err.Error())
+		}
+		c.ApplicationRepo = memoryRepo.NewApplicationRepoer(fixtures.Applications...)
+		c.BuildRepo = memoryRepo.NewBuildRepoer(fixtures.Builds...)
+		c.LockRepo = memoryRepo.NewLockRepoer()
 	default:
 		l.Fatalf("main - unknown database driver: %s", conf.Database.Driver)
 	}
@@ -107,50 +141,196 @@ func main() {
 		log.Fatal("only nixpacks builder is supported in the app version:", conf.App.Version)
 	}
 
-	nixpacksBuilder := nixpacks.NewNixPackBuilder(conf.App.Version)
+	dockerConf := conf.Services.Builder(string(docker.DockerBuilderKind))
+	var dockerBuilder interface {
+		builders.Builder
+		docker.DockerfileBuilder
+	}
+	if dockerConf.Daemonless {
+		dockerBuilder, err = daemonless.NewDaemonlessBuilder(conf.App.Version, daemonless.DaemonlessBuilderOptions{
+			BuildctlPath:   dockerConf.BuildctlPath,
+			BuildkitdFlags: dockerConf.BuildkitdFlags,
+		})
+		if err != nil {
+			log.Fatalf("error creating daemonless builder: %v", err)
+		}
+		l.Info("using rootless buildkit, images will be built without the docker daemon")
+	} else {
+		dockerBuilder, err = docker.NewDockerBuilder(conf.App.Version, docker.DockerBuilderOptions{
+			Legacy:               dockerConf.Legacy,
+			InlineCache:          dockerConf.InlineCache,
+			MultiPlatformBuilder: dockerConf.MultiPlatformBuilder,
+		})
+		if err != nil {
+			log.Fatalf("error creating docker builder: %v", err)
+		}
+	}
+
+	nixpacksBuilder := nixpacks.NewNixPackBuilder(conf.App.Version, nixpacks.NixPackBuilderOptions{
+		Docker:       dockerBuilder,
+		GenerateOnly: dockerConf.Daemonless,
+	})
 
 	c.AddBuilder(nixpacks.NixPackBuilderKind, nixpacksBuilder)
 	l.Info("succesfully added nixpacks as builder")
 
-	dockerBuilder, err := docker.NewDockerBuilder(conf.App.Version)
-	if err != nil {
-		log.Fatalf("error creating docker builder: %v", err)
-	}
 	c.AddBuilder(docker.DockerBuilderKind, dockerBuilder)
 	l.Info("succesfully added docker as builder")
 
+	if conf.Services.HasBuilder(string(buildpacks.BuildpacksBuilderKind)) {
+		// the max limits apply to the builds without limits too
+		for _, limits := range []config.Limits{conf.Builds.Limits, conf.Builds.MaxLimits} {
+			if limits.CPUs > 0 || limits.MemoryMB > 0 || limits.DiskMB > 0 {
+				log.Fatalf("the buildpacks builder can't limit the cpu, memory and disk of the builds, remove the limits or the builder")
+			}
+		}
+		buildpacksConf := conf.Services.Builder(string(buildpacks.BuildpacksBuilderKind))
+		buildpacksBuilder, err := buildpacks.NewBuildpacksBuilder(conf.App.Version, buildpacks.BuildpacksBuilderOptions{
+			PackPath:     buildpacksConf.PackPath,
+			BuilderImage: buildpacksConf.BuilderImage,
+		})
+		if err != nil {
+			log.Fatalf("error creating buildpacks builder: %v", err)
+		}
+		c.AddBuilder(buildpacks.BuildpacksBuilderKind, buildpacksBuilder)
+		l.Info("succesfully added buildpacks as builder")
+	}
+
+	if conf.Services.HasBuilder(string(static.StaticBuilderKind)) {
+		staticConf := conf.Services.Builder(string(static.StaticBuilderKind))
+		staticBuilder := static.NewStaticBuilder(conf.App.Version, dockerBuilder, static.StaticBuilderOptions{
+			ServerImage: staticConf.ServerImage,
+		})
+		c.AddBuilder(static.StaticBuilderKind, staticBuilder)
+		l.Info("succesfully added static as builder")
+	}
+
 	baseAnalyzer, err := baseAnalyzer.NewBaseAnalyzer()
 	if err != nil {
 		log.Fatalf("error creating base analyzer: %v", err)
 	}
 	c.Analyzer = baseAnalyzer
+
+	c.DefaultLimits = model.BuildLimits(conf.Builds.Limits)
+	c.MaxLimits = model.BuildLimits(conf.Builds.MaxLimits)
+	// the max limits apply to the builds without limits too
+	if dockerConf.Legacy && !dockerConf.Daemonless && (c.DefaultLimits.DiskMB > 0 || c.MaxLimits.DiskMB > 0) {
+		log.Fatalf("the legacy docker builder can't limit the disk used by the builds, remove the disk limits")
+	}
+	c.MaxLogSize = conf.Builds.MaxLogSizeKB * 1024
+	networkMode, err := builders.ParseNetworkMode(conf.Builds.Network.Mode)
+	if err != nil {
+		log.Fatalf("error parsing the build network: %v", err)
+	}
+	c.Network = builders.Network{
+		Mode:         networkMode,
+		ProxyNetwork: conf.Builds.Network.ProxyNetwork,
+		HTTPProxy:    conf.Builds.Network.HTTPProxy,
+		HTTPSProxy:   conf.Builds.Network.HTTPSProxy,
+		NoProxy:      conf.Builds.Network.NoProxy,
+	}
+	if err := c.Network.Check(); err != nil {
+		log.Fatalf("error configuring the build network: %v", err)
+	}
+	baseImages := &builders.BaseImages{
+		Allowed: conf.Builds.BaseImages.Allowed,
+		Mirror:  conf.Builds.BaseImages.Mirror,
+	}
+	if baseImages.Enabled() {
+		c.BaseImages = baseImages
+		l.Infof("the base images are restricted to %v and pulled through %q", baseImages.Allowed, baseImages.Mirror)
+	}
 	l.Info("succesfully added base analyzer")
 
-	if conf.Services.Registries != nil {
-		switch conf.Services.Registries[0].Name {
-		case model.RegistryDocker:
-			r, err := registry.NewDefaultRegistry(conf.Services.Registries[0].ServerAddress, os.Getenv("REGISTRY_USERNAME"), os.Getenv("REGISTRY_PASSWORD"))
+	if len(conf.Services.Registries) > 0 {
+		for i, registryConf := range conf.Services.Registries {
+			r, err := newRegistry(registryConf, conf.Promotion.Tags)
 			if err != nil {
-				log.Fatalf("error building docker registry: %v\n", err)
+				log.Fatalf("error building %s registry %s: %v\n", registryConf.Name, registryConf.ServerAddress, err)
 			}
-			c.Registry = r
-			l.Info("succesfully added docker registry")
-		case model.RegistryHarbor:
-			r, err := harbor.NewHarborRegistry(conf.Services.Registries[0].ServerAddress, os.Getenv("REGISTRY_USERNAME"), os.Getenv("REGISTRY_PASSWORD"), os.Getenv("REGISTRY_PULL_USERNAME"), os.Getenv("REGISTRY_PULL_PASSWORD"))
-			if err != nil {
-				log.Fatalf("error building harbor registry: %v\n", err)
+			if i == 0 {
+				c.Registry = r
+				c.RegistryName = registryConf.ServerAddress
+				l.Infof("succesfully added %s registry %s", registryConf.Name, registryConf.ServerAddress)
+				continue
 			}
-			c.Registry = r
-			l.Info("succesfully added harbor registry")
+			c.Mirrors = append(c.Mirrors, controller.RegistryTarget{
+				Name:     registryConf.ServerAddress,
+				Registry: r,
+				Optional: registryConf.Optional,
+			})
+			l.Infof("succesfully added %s mirror %s (optional: %t)", registryConf.Name, registryConf.ServerAddress, registryConf.Optional)
+		}
+		c.BuildCache = conf.Services.Registries[0].BuildCache
+		if c.BuildCache {
+			l.Info("the build cache of the applications will be stored in the registry")
 		}
 	} else {
 		c.Registry = nil
 		l.Warn("no registry provided, the service will not push the images to any registry")
 	}
 
+	c.Version = conf.App.Version
+	if conf.Signing.KeyPath != "" {
+		key, err := signing.LoadKey(conf.Signing.KeyPath, os.Getenv("COSIGN_PASSWORD"))
+		if err != nil {
+			log.Fatalf("error loading the signing key: %v\n", err)
+		}
+		c.Signer = signing.NewSigner(key)
+		l.Info("the pushed images will be signed with their provenance")
+	}
+	if conf.SBOM.Enabled {
+		generator, err := sbom.NewGenerator(conf.App.Version)
+		if err != nil {
+			log.Fatalf("error creating the sbom generator: %v\n", err)
+		}
+		c.SBOM = generator
+		l.Info("the sbom of the built images will be generated")
+	}
+	if conf.Scanning.Scanner != "" {
+		scanner, err := newScanner(conf.Scanning, conf.Services.Registries, c.Registry)
+		if err != nil {
+			log.Fatalf("error creating the %s scanner: %v\n", conf.Scanning.Scanner, err)
+		}
+		severity := model.ParseSeverity(conf.Scanning.BlockSeverity)
+		if conf.Scanning.BlockSeverity != "" && string(severity) != strings.ToUpper(conf.Scanning.BlockSeverity) {
+			log.Fatalf("invalid block severity %q\n", conf.Scanning.BlockSeverity)
+		}
+		c.Scanner = scanner
+		c.ScanPolicy = scanners.Policy{
+			IgnoreUnfixed: conf.Scanning.IgnoreUnfixed,
+			Allowlist:     conf.Scanning.Allowlist,
+		}
+		if conf.Scanning.BlockSeverity != "" {
+			c.ScanPolicy.BlockSeverity = severity
+		}
+		l.Infof("the built images will be scanned by %s, blocking the %q vulnerabilities", conf.Scanning.Scanner, c.ScanPolicy.BlockSeverity)
+	}
+
+	c.RemoveLocalImages = conf.Retention.RemoveLocalImages
+	c.Retention = controller.RetentionPolicy{
+		KeepLast: conf.Retention.KeepLast,
+		KeepFor:  time.Duration(conf.Retention.KeepDays) * 24 * time.Hour,
+	}
+	if c.Retention.Enabled() {
+		l.Infof("keeping the last %d images of the applications and the ones of the last %d days", conf.Retention.KeepLast, conf.Retention.KeepDays)
+	}
+
+	c.PromotionTags = conf.Promotion.Tags
+	if len(c.PromotionTags) > 0 {
+		l.Infof("the images can be promoted to %v", c.PromotionTags)
+	}
+
 	rmq := rabbitmq.NewRabbitMQ(conf.RMQ.URI, conf.RMQ.RequestQueue, conf.RMQ.ResponseQueue, c, l)
 
 	ctx, cancel := context.WithCancel(context.Background())
+	if conf.Retention.CachePruneIntervalMinutes > 0 {
+		maxAge := time.Duration(conf.Retention.CacheMaxAgeHours) * time.Hour
+		if maxAge <= 0 {
+			maxAge = 24 * time.Hour
+		}
+		go c.PruneBuildCachePeriodically(ctx, time.Duration(conf.Retention.CachePruneIntervalMinutes)*time.Minute, maxAge)
+	}
 	// Waiting signal
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt,
@@ -196,3 +376,50 @@ func main() {
 		time.Sleep(50 * time.Millisecond)
 	}
 }
+
+func newScanner(conf config.Scanning, registries []config.Registry, primary registry.Registryer) (scanners.Scanner, error) {
+	switch conf.Scanner {
+	case trivy.ScannerName:
+		return trivy.NewTrivyScanner(trivy.TrivyScannerOptions{
+			Path:     conf.Trivy.Path,
+			CacheDir: conf.Trivy.CacheDir,
+			Timeout:  time.Duration(conf.Trivy.TimeoutSeconds) * time.Second,
+		}), nil
+	case harborScanner.ScannerName:
+		if primary == nil {
+			return nil, fmt.Errorf("the harbor scanner requires a registry")
+		}
+		return harborScanner.NewHarborScanner(conf.Harbor.AdapterURL, primary, harborScanner.HarborScannerOptions{
+			RegistryURL: "https://" + registries[0].ServerAddress,
+			Username:    registries[0].Env("PULL_USERNAME"),
+			Password:    registries[0].Env("PULL_PASSWORD"),
+			Namespace:   conf.Harbor.Namespace,
+		})
+	case fake.ScannerName:
+		return fake.NewFakeScanner(), nil
+	}
+	return nil, fmt.Errorf("unknown scanner %q", conf.Scanner)
+}
+
+// newRegistry creates the registry of the config, the harbor retention keeps the promotion tags
+func newRegistry(conf config.Registry, promotionTags []string) (registry.Registryer, error) {
+	switch conf.Name {
+	case model.RegistryDocker:
+		return defaultRegistry.NewDefaultRegistry(conf.ServerAddress, conf.Env("USERNAME"), conf.Env("PASSWORD"), oci.ClientOptions{
+			ImmutableTags: conf.ImmutableTags,
+		})
+	case model.RegistryHarbor:
+		return harbor.NewHarborRegistry(conf.ServerAddress, conf.Env("USERNAME"), conf.Env("PASSWORD"), harbor.HarborOptions{
+			StorageQuota:    conf.StorageQuotaMB * 1024 * 1024,
+			RetainTags:      conf.RetainTags,
+			KeepTags:        promotionTags,
+			CredentialsDays: conf.CredentialsDays,
+			ImmutableTags:   conf.ImmutableTags,
+		})
+	case model.RegistryDistribution:
+		return distribution.NewDistributionRegistry(conf.ServerAddress, conf.Env("USERNAME"), conf.Env("PASSWORD"), oci.ClientOptions{
+			ImmutableTags: conf.ImmutableTags,
+		})
+	}
+	return nil, fmt.Errorf("unknown registry %q", conf.Name)
+}
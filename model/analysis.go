@@ -8,9 +8,11 @@ type (
 	}
 
 	DetectedInfo struct {
-		Builders []BuilderKind `json:"builders"`
-		Docker   *DockerInfo   `json:"docker,omitempty"`
-		NixPacks *NixPacksInfo `json:"nixpacks,omitempty"`
+		Builders   []BuilderKind   `json:"builders"`
+		Docker     *DockerInfo     `json:"docker,omitempty"`
+		NixPacks   *NixPacksInfo   `json:"nixpacks,omitempty"`
+		Buildpacks *BuildpacksInfo `json:"buildpacks,omitempty"`
+		Static     *StaticInfo     `json:"static,omitempty"`
 	}
 
 	DockerInfo struct {
@@ -30,5 +32,19 @@ type (
 		Variables          map[string]string `json:"variables"`
 	}
 
+	BuildpacksInfo struct {
+		Stacks []string `json:"stacks"` // stacks that build better with buildpacks (java, dotnet)
+		Files  []string `json:"files"`  // files that made the stack detectable
+	}
+
+	StaticInfo struct {
+		Framework       string   `json:"framework"`       // vite, next, hugo, html...
+		BuildImage      string   `json:"buildImage"`      // image of the builder stage
+		InstallCommands []string `json:"installCommands"` // empty if there are no dependencies to install
+		BuildCommands   []string `json:"buildCommands"`   // empty if the files are served as they are
+		OutputDirectory string   `json:"outputDirectory"` // directory with the files to serve, relative to the root directory
+		SPA             bool     `json:"spa"`             // true if unknown paths must be routed to index.html
+	}
+
 	BuilderKind string
 )
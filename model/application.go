@@ -3,6 +3,53 @@ package model
 type ApplicationState string
 
 const (
-	ApplicationStateBuilding ApplicationState = "building"
-	ApplicationStateFailed   ApplicationState = "failed"
+	// the states of the builds, in order
+	ApplicationStateQueued    ApplicationState = "queued"
+	ApplicationStatePulling   ApplicationState = "pulling"
+	ApplicationStateAnalyzing ApplicationState = "analyzing"
+	ApplicationStateBuilding  ApplicationState = "building"
+	ApplicationStatePushing   ApplicationState = "pushing"
+
+	// the states of the applications not being built
+	ApplicationStateBuilt     ApplicationState = "built"
+	ApplicationStateFailed    ApplicationState = "failed"
+	ApplicationStateCancelled ApplicationState = "cancelled"
+	ApplicationStateDeleting  ApplicationState = "deleting"
 )
+
+// applicationTransitions are the states each state can move to.
+// A build can be cancelled or fail at any point, the running ones go
+// back to queued when the request is requeued after an error of the service.
+// An application goes from analyzing to built when the commit was already built.
+// The empty state is the one of the applications never built
+var applicationTransitions = map[ApplicationState][]ApplicationState{
+	"":                        {ApplicationStateQueued, ApplicationStatePulling, ApplicationStateDeleting},
+	ApplicationStateQueued:    {ApplicationStatePulling, ApplicationStateFailed, ApplicationStateCancelled, ApplicationStateDeleting},
+	ApplicationStatePulling:   {ApplicationStateAnalyzing, ApplicationStateQueued, ApplicationStateFailed, ApplicationStateCancelled, ApplicationStateDeleting},
+	ApplicationStateAnalyzing: {ApplicationStateBuilding, ApplicationStateBuilt, ApplicationStateQueued, ApplicationStateFailed, ApplicationStateCancelled, ApplicationStateDeleting},
+	ApplicationStateBuilding:  {ApplicationStatePushing, ApplicationStateBuilt, ApplicationStateQueued, ApplicationStateFailed, ApplicationStateCancelled, ApplicationStateDeleting},
+	ApplicationStatePushing:   {ApplicationStateBuilt, ApplicationStateQueued, ApplicationStateFailed, ApplicationStateCancelled, ApplicationStateDeleting},
+	ApplicationStateBuilt:     {ApplicationStateQueued, ApplicationStatePulling, ApplicationStateDeleting},
+	ApplicationStateFailed:    {ApplicationStateQueued, ApplicationStatePulling, ApplicationStateDeleting},
+	ApplicationStateCancelled: {ApplicationStateQueued, ApplicationStatePulling, ApplicationStateDeleting},
+	ApplicationStateDeleting:  {},
+}
+
+// CanTransitionTo reports if the application can move from s to state
+func (s ApplicationState) CanTransitionTo(state ApplicationState) bool {
+	for _, allowed := range applicationTransitions[s] {
+		if allowed == state {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBuilding reports if a build of the application is running
+func (s ApplicationState) IsBuilding() bool {
+	switch s {
+	case ApplicationStatePulling, ApplicationStateAnalyzing, ApplicationStateBuilding, ApplicationStatePushing:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package model
+
+import "time"
+
+type BuildStatus string
+type BuildStageName string
+
+const (
+	BuildStatusRunning BuildStatus = "running"
+	BuildStatusSuccess BuildStatus = "success"
+	BuildStatusFailed  BuildStatus = "failed"
+
+	BuildStagePull    BuildStageName = "pull"
+	BuildStageAnalyze BuildStageName = "analyze"
+	BuildStagePlan    BuildStageName = "plan" // only when the request has no build plan
+	BuildStageBuild   BuildStageName = "build"
+	BuildStageSBOM    BuildStageName = "sbom" // only when the sbom is generated
+	BuildStageScan    BuildStageName = "scan" // only when the images are scanned
+	BuildStagePush    BuildStageName = "push"
+)
+
+type (
+	// Build is an attempt to build an application, every request creates one
+	// whatever the result so the history of the application can be shown
+	// and the failed builds can be debugged after the response is sent
+	Build struct {
+		ID            string `json:"id"            bson:"_id"`
+		RequestID     string `json:"requestID"     bson:"requestID"`
+		ApplicationID string `json:"applicationID" bson:"applicationID"`
+		UserID        string `json:"userID"        bson:"userID"`
+		Repo          string `json:"repo"          bson:"repo"`
+		Connector     string `json:"connector"     bson:"connector"`
+		Branch        string `json:"branch"        bson:"branch"`
+		// commit of the request (can be latest) and the one that was pulled
+		RequestedCommit string `json:"requestedCommit" bson:"requestedCommit"`
+		Commit          string `json:"commit"          bson:"commit"`
+
+		// plan of the request (nil if it must be generated) and the plan used, without the secrets
+		RequestPlan *BuildConfig  `json:"requestPlan" bson:"requestPlan"`
+		PlanUsed    *BuildConfig  `json:"planUsed"    bson:"planUsed"`
+		PlanHash    string        `json:"planHash"    bson:"planHash"` // see BuildConfig.Hash
+		Analysis    *RepoAnalisys `json:"analysis"    bson:"analysis"`
+
+		Status    BuildStatus        `json:"status"    bson:"status"`
+		Fault     ResponseErrorFault `json:"fault"     bson:"fault"`
+		Message   string             `json:"message"   bson:"message"`
+		ImageID   string             `json:"imageID"   bson:"imageID"`
+		ImageName string             `json:"imageName" bson:"imageName"`
+		// the pushed manifest (or image index), see BuildResponse
+		ImageDigest    string          `json:"imageDigest"              bson:"imageDigest"`
+		ImageMediaType string          `json:"imageMediaType,omitempty" bson:"imageMediaType,omitempty"`
+		ImageSize      int64           `json:"imageSize,omitempty"      bson:"imageSize,omitempty"`
+		Platforms      []PlatformImage `json:"platforms,omitempty"      bson:"platforms,omitempty"`
+		Cache          *CacheStats     `json:"cache,omitempty"          bson:"cache,omitempty"`
+		Registries     []RegistryPush  `json:"registries,omitempty"     bson:"registries,omitempty"`
+		SBOM           *SBOMSummary    `json:"sbom,omitempty"           bson:"sbom,omitempty"`
+		Scan           *ScanSummary    `json:"scan,omitempty"           bson:"scan,omitempty"`
+		// the build whose image was reused, the commit and the plan were already built
+		ReusedFrom string `json:"reusedFrom,omitempty" bson:"reusedFrom,omitempty"`
+		// when the images of the build were deleted from the registries by the retention
+		PrunedAt *time.Time `json:"prunedAt,omitempty" bson:"prunedAt,omitempty"`
+		// the log of the build as sent in the response, it's capped by the max log size
+		Log string `json:"log" bson:"log"`
+
+		Stages     []BuildStage `json:"stages"     bson:"stages"`
+		StartedAt  time.Time    `json:"startedAt"  bson:"startedAt"`
+		FinishedAt *time.Time   `json:"finishedAt" bson:"finishedAt"`
+	}
+
+	BuildStage struct {
+		Name       BuildStageName `json:"name"       bson:"name"`
+		StartedAt  time.Time      `json:"startedAt"  bson:"startedAt"`
+		FinishedAt *time.Time     `json:"finishedAt" bson:"finishedAt"`
+		DurationMs int64          `json:"durationMs" bson:"durationMs"`
+	}
+)
+
+// NewBuild returns the running build of the request, the token
+// and the secrets of the request are not part of it
+func NewBuild(request *Request, now time.Time) *Build {
+	build := &Build{
+		RequestID:     request.RequestID,
+		ApplicationID: request.ApplicationID,
+		RequestPlan:   request.BuildPlan.WithoutSecrets(),
+		Status:        BuildStatusRunning,
+		StartedAt:     now,
+	}
+	if request.BuildPlan != nil && request.BuildPlan.Builder == "" {
+		build.RequestPlan = nil
+	}
+	if info := request.PullInfo; info != nil {
+		build.UserID = info.UserID
+		build.Repo = info.Repo
+		build.Connector = info.Connector
+		build.Branch = info.Branch
+		build.RequestedCommit = info.Commit
+	}
+	return build
+}
+
+// StartStage starts a new stage of the build, the running one is finished
+func (b *Build) StartStage(name BuildStageName, now time.Time) {
+	b.finishStage(now)
+	b.Stages = append(b.Stages, BuildStage{Name: name, StartedAt: now})
+}
+
+// Finish finishes the running stage and sets the result of the build from the response
+func (b *Build) Finish(response *BuildResponse, now time.Time) {
+	b.finishStage(now)
+	b.FinishedAt = &now
+
+	b.Status = BuildStatusFailed
+	if response.Status == ResponseStatusSuccess {
+		b.Status = BuildStatusSuccess
+	}
+	b.Fault = response.Fault
+	b.Message = response.Message
+	b.SetImage(response)
+	b.Analysis = response.RepoAnalisys
+	b.Cache = response.Cache
+	b.Scan = response.Scan
+	b.Log = response.BuildOutput
+}
+
+// SetImage sets the commit, the plan and the image of the response, the image is
+// set once pushed so a retry of the request can find it before the build finishes
+func (b *Build) SetImage(response *BuildResponse) {
+	b.Commit = response.BuiltCommit
+	b.PlanUsed = response.PlanUsed.WithoutSecrets()
+	if b.PlanUsed != nil {
+		b.PlanHash = b.PlanUsed.Hash()
+	}
+	b.ImageID = response.ImageID
+	b.ImageName = response.ImageName
+	b.ImageDigest = response.ImageDigest
+	b.ImageMediaType = response.ImageMediaType
+	b.ImageSize = response.ImageSize
+	b.Platforms = response.Platforms
+	b.Registries = response.Registries
+	b.SBOM = response.SBOM
+}
+
+// IsFinal reports if the build has a result that doesn't change if the request
+// is sent again, the builds failed for an error of the service are retried
+func (b *Build) IsFinal() bool {
+	return b.Status == BuildStatusSuccess || (b.Status == BuildStatusFailed && b.Fault == ResponseErrorFaultUser)
+}
+
+// Response returns the response of the finished build, it's sent again
+// when the request is redelivered or when the image is reused
+func (b *Build) Response() *BuildResponse {
+	response := &BuildResponse{
+		ApplicationID: b.ApplicationID,
+		Repo:          b.Repo,
+		Status:        ResponseStatusFailed,
+		ImageID:       b.ImageID,
+		ImageName:     b.ImageName,
+		BuiltCommit:   b.Commit,
+		IsError:       b.Status != BuildStatusSuccess,
+		Fault:         b.Fault,
+		Message:       b.Message,
+		BuildOutput:   b.Log,
+		PlanUsed:      b.PlanUsed,
+		RepoAnalisys:  b.Analysis,
+		Platforms:     b.Platforms,
+		Cache:         b.Cache,
+		Registries:    b.Registries,
+		SBOM:          b.SBOM,
+		Scan:          b.Scan,
+		Reused:        b.ReusedFrom != "",
+	}
+	if b.ImageDigest != "" {
+		response.SetImage(&PushedImage{
+			Name:      b.ImageName,
+			Digest:    b.ImageDigest,
+			MediaType: b.ImageMediaType,
+			Size:      b.ImageSize,
+			Platforms: b.Platforms,
+		})
+	}
+	if b.Status == BuildStatusSuccess {
+		response.Status = ResponseStatusSuccess
+	}
+	return response
+}
+
+func (b *Build) finishStage(now time.Time) {
+	if len(b.Stages) == 0 {
+		return
+	}
+	stage := &b.Stages[len(b.Stages)-1]
+	if stage.FinishedAt != nil {
+		return
+	}
+	stage.FinishedAt = &now
+	stage.DurationMs = now.Sub(stage.StartedAt).Milliseconds()
+}
@@ -1,8 +1,25 @@
 package model
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
 /*
 {
+	"type":"build|userCredentials|userDelete|promote (default build). userCredentials crea il progetto dell'utente nel registry
+		e restituisce nuove credenziali di pull (pullCredentials), userDelete elimina il progetto con le immagini dell'utente,
+		promote tagga un'immagine già pushata con i tag di ambiente senza rifare la build"
+	"userID":"id dell'utente (solo per userCredentials, userDelete e promote)"
+	"requestID":"id univoco della richiesta, le richieste già eseguite non vengono ripetute (default il message id)"
 	"applicationID":"id dell'applicazione da builder (per aggiornare lo stato)"
+	"promotion":{ solo per promote
+		"digest":"digest dell'immagine da promuovere (imageDigest della risposta della build)"
+		"commit":"commit dell'immagine da promuovere, usato se il digest è vuoto"
+		"tags":["staging","production"] tag di ambiente (tra quelli configurati), spostati se esistono già
+	}
 	"pullInfo":{
 		"userID":"id dell'utente"
 		"token":"per fare la pull"
@@ -13,9 +30,26 @@ package model
 	"buildPlan":{
 		"builder":"dockerfile|nixpacks"
 		"rootDirectory":"path in cui fare la build (/ di default, può essere /backend)"
+		"platforms":["linux/amd64","linux/arm64"] piattaforme per cui buildare l'immagine (default quella dell'host)
+		"limits":{"cpus":1.5,"memoryMB":2048,"diskMB":10240,"timeoutSeconds":1800} limiti della build (default quelli configurati)
+		"network":"none|proxy|full" accesso alla rete durante la build (default quello configurato)
 
 		SE BUILDER DOCKERFILE
 		"dockerfilePath":"path del dockerfile (se builder è dockerfile)"
+		"buildArgs":[{"key":"value"}] valori passati come --build-arg
+		"target":"stage del multi-stage build da usare come immagine finale"
+		"secrets":[{"key":"value"}] montati come secret di buildkit, mai salvati nell'immagine o nella risposta
+
+		SE BUILDER BUILDPACKS
+		"buildpacksBuilder":"immagine del builder (default quella configurata)"
+		"buildpacks":["buildpack da usare al posto di quelli rilevati"]
+		"envs":[{"key":"value"}] passati al lifecycle come env di build
+
+		SE BUILDER STATIC
+		"outputDirectory":"cartella con i file da servire (rilevata per vite, next export, hugo...)"
+		"spaFallback":true|false se i path sconosciuti devono restituire index.html (rilevato se assente)
+		"installCommands" e "buildCommands" eseguiti in ordine nello stage di build (rilevati se assenti)
+		"envs":[{"key":"value"}] passati allo stage di build, non finiscono nell'immagine finale
 
 		SE BUILDER NIXPACKS
 			"nixpacksPath":"path del nixpacks.toml file (builder nixpacks)"
@@ -25,9 +59,20 @@ package model
 			"nixPkgs":"nixpkgs to add to the final image (added in setup phase)"
 			"nixLibs":"nix libs to add to the final image (added in setup phase)"
 			"aptPkgs":"base image is ubuntu, it will add the apt packages in the setup phase"
-			"installCommand":"command to execute in the install phase"
-			"buildCommand": "command to execute in the build phase"
+			"installCommands":["commands to execute in order in the install phase"]
+			"buildCommands":["commands to execute in order in the build phase"]
+			"installCommand" and "buildCommand" (deprecated) are accepted as the only command of installCommands and buildCommands
 			"startCommand": "command to execute in the start phase"
+			"installCacheDirectories":["directories cached between builds in the install phase"]
+			"buildCacheDirectories":["directories cached between builds in the build phase"]
+			"nixpacksPhases":[{ additional phases
+				"name":"name of the phase",
+				"dependsOn":["phases to run before this one, build if empty"],
+				"cmds":["commands"],
+				"nixPkgs":[], "aptPkgs":[], "cacheDirectories":[]
+			}]
+			"cacheKey":"key of the build cache"
+			"noCache":true|false build without cache
 	}
 }
 */
@@ -39,9 +84,24 @@ type (
 	}
 
 	Request struct {
-		ApplicationID string           `json:"applicationID"`
-		PullInfo      *PullInfoRequest `json:"pullInfo"`
-		BuildPlan     *BuildConfig     `json:"buildPlan"`
+		// build by default, the user requests only have the user id
+		Type RequestType `json:"type,omitempty"`
+		// unique per request, the redeliveries of a request have the same id.
+		// If empty the message id of the delivery is used
+		RequestID     string            `json:"requestID,omitempty"`
+		UserID        string            `json:"userID,omitempty"` // user of the user and promotion requests
+		ApplicationID string            `json:"applicationID"`
+		PullInfo      *PullInfoRequest  `json:"pullInfo"`
+		BuildPlan     *BuildConfig      `json:"buildPlan"`
+		Promotion     *PromotionRequest `json:"promotion,omitempty"`
+	}
+
+	// PromotionRequest tags a pushed image of the application with environment tags,
+	// the image is the digest or, if empty, the image of the commit
+	PromotionRequest struct {
+		Digest string   `json:"digest,omitempty"`
+		Commit string   `json:"commit,omitempty"`
+		Tags   []string `json:"tags"`
 	}
 
 	BuildConfig struct {
@@ -51,18 +111,62 @@ type (
 		// shared
 		Builder      BuilderKind `json:"builder"`
 		StartCommand string      `json:"startCommand"`
+		Platforms    []string    `json:"platforms"` // os/arch[/variant], empty means the host platform
+		// overrides the default limits of the service (per user or per plan),
+		// the values are capped by the maximum limits configured
+		Limits *BuildLimits `json:"limits,omitempty"`
+		// none | proxy | full, overrides the default network mode of the service
+		Network string `json:"network,omitempty"`
 
 		// docker
-		DockerfilePath string `json:"dockerfilePath"`
+		DockerfilePath string     `json:"dockerfilePath"`
+		BuildArgs      []KeyValue `json:"buildArgs"`
+		Target         string     `json:"target"`
+		// secrets are mounted with buildkit's --secret, they are never part of
+		// the plan and are stripped from the config sent back in the response
+		Secrets []KeyValue `json:"secrets,omitempty"`
+
+		// buildpacks
+		BuildpacksBuilder string   `json:"buildpacksBuilder"` // builder image, defaults to the one configured
+		Buildpacks        []string `json:"buildpacks"`        // buildpacks to use instead of the detected ones
 
-		// nixpacks
-		NixpacksPath   string     `json:"nixpacksPath"`
-		Envs           []KeyValue `json:"envs"`
-		NixPkgs        []string   `json:"nixPkgs"`
-		NixLibs        []string   `json:"nixLibs"`
-		AptPkgs        []string   `json:"aptPkgs"`
-		InstallCommand string     `json:"installCommand"`
-		BuildCommand   string     `json:"buildCommand"`
+		// static (install and build commands and envs are shared with nixpacks)
+		OutputDirectory string `json:"outputDirectory"`
+		SPAFallback     *bool  `json:"spaFallback"` // nil means detected
+
+		// nixpacks (envs and commands are shared with buildpacks and static)
+		NixpacksPath    string     `json:"nixpacksPath"`
+		Envs            []KeyValue `json:"envs"`
+		NixPkgs         []string   `json:"nixPkgs"`
+		NixLibs         []string   `json:"nixLibs"`
+		AptPkgs         []string   `json:"aptPkgs"`
+		InstallCommands []string   `json:"installCommands"` // executed in order, they replace the detected ones
+		BuildCommands   []string   `json:"buildCommands"`   // executed in order, they replace the detected ones
+		// directories cached between builds, added to the detected ones
+		InstallCacheDirectories []string        `json:"installCacheDirectories"`
+		BuildCacheDirectories   []string        `json:"buildCacheDirectories"`
+		NixpacksPhases          []NixpacksPhase `json:"nixpacksPhases"` // phases added to the plan
+		CacheKey                string          `json:"cacheKey"`       // prefix of the cache mounts, defaults to the build directory
+		NoCache                 bool            `json:"noCache"`        // build without the cache mounts and the docker cache
+	}
+
+	// BuildLimits are the resources a build can use, zero values keep the default
+	BuildLimits struct {
+		CPUs           float64 `json:"cpus"`
+		MemoryMB       int64   `json:"memoryMB"`
+		DiskMB         int64   `json:"diskMB"` // build context, disk written while building and built image
+		TimeoutSeconds int     `json:"timeoutSeconds"`
+	}
+
+	// NixpacksPhase is a phase added to the nixpacks plan,
+	// phases run after the phases they depend on (build if empty)
+	NixpacksPhase struct {
+		Name             string   `json:"name"`
+		DependsOn        []string `json:"dependsOn"`
+		Cmds             []string `json:"cmds"`
+		NixPkgs          []string `json:"nixPkgs"`
+		AptPkgs          []string `json:"aptPkgs"`
+		CacheDirectories []string `json:"cacheDirectories"`
 	}
 
 	PullInfoRequest struct {
@@ -81,6 +185,79 @@ type (
 	}
 )
 
+// UnmarshalJSON accepts the installCommand and buildCommand of the previous versions,
+// each one is the only command of installCommands or buildCommands. Setting both
+// the old and the new key is an error
+func (c *BuildConfig) UnmarshalJSON(data []byte) error {
+	type config BuildConfig
+	aux := struct {
+		*config
+		InstallCommand string `json:"installCommand"`
+		BuildCommand   string `json:"buildCommand"`
+	}{config: (*config)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.InstallCommand != "" {
+		if len(c.InstallCommands) > 0 {
+			return fmt.Errorf("installCommand and installCommands can't be both set")
+		}
+		c.InstallCommands = []string{aux.InstallCommand}
+	}
+	if aux.BuildCommand != "" {
+		if len(c.BuildCommands) > 0 {
+			return fmt.Errorf("buildCommand and buildCommands can't be both set")
+		}
+		c.BuildCommands = []string{aux.BuildCommand}
+	}
+	return nil
+}
+
+// WithoutSecrets returns a copy of the config without the secrets,
+// use it every time the config leaves the service (responses, logs)
+func (c *BuildConfig) WithoutSecrets() *BuildConfig {
+	if c == nil {
+		return nil
+	}
+	clean := *c
+	clean.Secrets = nil
+	return &clean
+}
+
+// WithoutSecrets returns a copy of the request without the values of the secrets of
+// the plan, the one that can be logged
+func (r *Request) WithoutSecrets() *Request {
+	if r == nil {
+		return nil
+	}
+	clean := *r
+	clean.BuildPlan = r.BuildPlan.WithoutSecrets()
+	return &clean
+}
+
+// Hash identifies the plan, the builds of the same commit with the same plan
+// produce the same image. The secrets are not part of it
+func (c *BuildConfig) Hash() string {
+	raw, _ := json.Marshal(c.WithoutSecrets())
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestType is the kind of work a request asks for
+type RequestType string
+
+const (
+	RequestTypeBuild RequestType = "build"
+	// creates the namespace of the user in the registry if missing and sends new pull
+	// credentials in the response, the previous ones stop working
+	RequestTypeUserCredentials RequestType = "userCredentials"
+	// deletes the namespace of the user with its images, sent when the user is removed
+	RequestTypeUserDelete RequestType = "userDelete"
+	// tags a pushed image of the application with environment tags (staging, production)
+	// in the registries without rebuilding it
+	RequestTypePromote RequestType = "promote"
+)
+
 const (
 	TypeRepo    = "repo"
 	TypeTag     = "tag"
@@ -93,4 +270,6 @@ const (
 
 	RegistryDocker = "docker"
 	RegistryHarbor = "harbor"
+	// any registry with the oci distribution api, the images are pushed without the docker daemon
+	RegistryDistribution = "distribution"
 )
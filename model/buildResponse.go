@@ -1,5 +1,10 @@
 package model
 
+import (
+	"strings"
+	"time"
+)
+
 type (
 	BuildResponse struct {
 		ApplicationID string             `json:"applicationID"`
@@ -14,9 +19,110 @@ type (
 		BuildOutput   string             `json:"buildOutput"`
 		PlanUsed      *BuildConfig       `json:"buildPlan"`
 		RepoAnalisys  *RepoAnalisys      `json:"repoAnalysis"`
+		Platforms     []PlatformImage    `json:"platforms,omitempty"` // only for multi-platform images
+		Cache         *CacheStats        `json:"cache,omitempty"`
+		Reused        bool               `json:"reused,omitempty"` // the image of a previous build of the commit was returned
+
+		// the pushed manifest (or image index), the reference pins the image by digest
+		// (server/user/app@sha256:...) so it can't change if the tag is pushed again
+		ImageDigest    string `json:"imageDigest,omitempty"`
+		ImageReference string `json:"imageReference,omitempty"`
+		ImageMediaType string `json:"imageMediaType,omitempty"`
+		ImageSize      int64  `json:"imageSize,omitempty"` // size of the manifest in bytes
+		// result of the push to each registry, the primary one first
+		Registries []RegistryPush `json:"registries,omitempty"`
+		SBOM       *SBOMSummary   `json:"sbom,omitempty"`
+		Scan       *ScanSummary   `json:"scan,omitempty"` // vulnerabilities found before the push
+
+		// the responses of the user and promotion requests (see RequestType) have the type
+		// and the user. The promotions report the image of the first tag in the primary
+		// registry and a result for each tag in each registry (Registries)
+		Type   RequestType `json:"type,omitempty"`
+		UserID string      `json:"userID,omitempty"`
+		// credentials to pull the images of the user, sent once by RequestTypeUserCredentials
+		PullCredentials *RegistryCredentials `json:"pullCredentials,omitempty"`
+	}
+
+	// RegistryCredentials can only pull the images of a user from the registry
+	RegistryCredentials struct {
+		Registry  string     `json:"registry"` // server address of the registry
+		Username  string     `json:"username"`
+		Password  string     `json:"password"`
+		ExpiresAt *time.Time `json:"expiresAt,omitempty"` // nil if they never expire
+	}
+
+	// SBOMSummary describes the sbom of the image, the document is attached
+	// to the image in the registries (see RegistryPush.SBOM)
+	SBOMSummary struct {
+		Format   string `json:"format"`           // SPDX-2.3
+		OS       string `json:"os,omitempty"`     // debian 12, empty for the images from scratch
+		Digest   string `json:"digest,omitempty"` // digest of the sbom artifact in the primary registry
+		Packages int    `json:"packages"`
+		// packages by type (deb, apk, npm, pypi)
+		Ecosystems map[string]int `json:"ecosystems,omitempty"`
+	}
+
+	// RegistryPush is the result of the push of the image to a registry
+	RegistryPush struct {
+		Registry string         `json:"registry"` // server address of the registry
+		Optional bool           `json:"optional"` // the build doesn't fail if the push fails
+		Status   ResponseStatus `json:"status"`
+		Image    *PushedImage   `json:"image,omitempty"`
+		Error    string         `json:"error,omitempty"`
+		// digests of the signature and of the provenance attestation, when the images are signed
+		Signature   string `json:"signature,omitempty"`
+		Attestation string `json:"attestation,omitempty"`
+		// digest of the sbom artifact, it refers to the image
+		SBOM string `json:"sbom,omitempty"`
+	}
+
+	// PushedImage is the image pushed to the registry
+	PushedImage struct {
+		Name      string          `json:"name"`      // server/user/app:tag
+		Digest    string          `json:"digest"`    // digest of the manifest (or image index)
+		MediaType string          `json:"mediaType"` // media type of the manifest
+		Size      int64           `json:"size"`      // size of the manifest in bytes
+		Platforms []PlatformImage `json:"platforms,omitempty"`
+	}
+
+	PlatformImage struct {
+		Platform string `json:"platform"`
+		Digest   string `json:"digest"` // digest of the platform's manifest
+	}
+
+	CacheStats struct {
+		Steps       int `json:"steps"`       // steps (or layers) of the build
+		CachedSteps int `json:"cachedSteps"` // steps restored from the cache
 	}
 )
 
+// Reference returns the name of the image pinned to its digest (server/user/app@sha256:...),
+// the name is returned if the digest is unknown
+func (p *PushedImage) Reference() string {
+	if p.Digest == "" {
+		return p.Name
+	}
+	return p.Repository() + "@" + p.Digest
+}
+
+// Repository returns the name of the image without the tag (server/user/app)
+func (p *PushedImage) Repository() string {
+	if i := strings.LastIndex(p.Name, ":"); i > strings.LastIndex(p.Name, "/") {
+		return p.Name[:i]
+	}
+	return p.Name
+}
+
+// SetImage sets the pushed image as the image of the response
+func (r *BuildResponse) SetImage(pushed *PushedImage) {
+	r.ImageName = pushed.Name
+	r.ImageDigest = pushed.Digest
+	r.ImageReference = pushed.Reference()
+	r.ImageMediaType = pushed.MediaType
+	r.ImageSize = pushed.Size
+	r.Platforms = pushed.Platforms
+}
+
 type ResponseStatus string
 type ResponseErrorFault string
 
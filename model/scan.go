@@ -0,0 +1,85 @@
+package model
+
+import "strings"
+
+type Severity string
+
+// severities of the vulnerabilities, from the lowest
+const (
+	SeverityUnknown  Severity = "UNKNOWN"
+	SeverityLow      Severity = "LOW"
+	SeverityMedium   Severity = "MEDIUM"
+	SeverityHigh     Severity = "HIGH"
+	SeverityCritical Severity = "CRITICAL"
+)
+
+var severityRanks = map[Severity]int{
+	SeverityUnknown:  0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// ParseSeverity normalizes the severity reported by a scanner (Critical, critical...),
+// the severities that are not known (negligible, none...) are unknown
+func ParseSeverity(s string) Severity {
+	severity := Severity(strings.ToUpper(strings.TrimSpace(s)))
+	if _, ok := severityRanks[severity]; !ok {
+		return SeverityUnknown
+	}
+	return severity
+}
+
+// AtLeast reports if the severity is s or higher
+func (severity Severity) AtLeast(s Severity) bool {
+	return severityRanks[severity] >= severityRanks[s]
+}
+
+type (
+	// ScanReport is the result of the vulnerability scan of an image
+	ScanReport struct {
+		Scanner         string          `json:"scanner"` // trivy | harbor
+		Vulnerabilities []Vulnerability `json:"vulnerabilities"`
+	}
+
+	Vulnerability struct {
+		ID               string   `json:"id"` // CVE-2024-1234
+		Package          string   `json:"package"`
+		InstalledVersion string   `json:"installedVersion"`
+		FixedVersion     string   `json:"fixedVersion,omitempty"` // empty if there is no fix
+		Severity         Severity `json:"severity"`
+		Title            string   `json:"title,omitempty"`
+	}
+
+	// ScanSummary is the result of the scan sent in the response, the vulnerabilities
+	// that blocked the push are listed, the others are only counted
+	ScanSummary struct {
+		Scanner    string           `json:"scanner"`
+		Severities map[Severity]int `json:"severities"`
+		Blocked    bool             `json:"blocked"`
+		// the vulnerabilities that violate the policy, capped by MaxSummaryVulnerabilities
+		Violations []Vulnerability `json:"violations,omitempty"`
+	}
+)
+
+// MaxSummaryVulnerabilities caps the vulnerabilities listed in the summaries
+const MaxSummaryVulnerabilities = 20
+
+// Summary returns the summary of the report, violations are the vulnerabilities that
+// violate the policy, the push is blocked if there is any
+func (r *ScanReport) Summary(violations []Vulnerability) *ScanSummary {
+	summary := &ScanSummary{
+		Scanner:    r.Scanner,
+		Severities: make(map[Severity]int),
+		Blocked:    len(violations) > 0,
+		Violations: violations,
+	}
+	for _, v := range r.Vulnerabilities {
+		summary.Severities[v.Severity]++
+	}
+	if len(summary.Violations) > MaxSummaryVulnerabilities {
+		summary.Violations = summary.Violations[:MaxSummaryVulnerabilities]
+	}
+	return summary
+}
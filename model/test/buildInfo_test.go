@@ -0,0 +1,30 @@
+package model_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/model"
+	"gotest.tools/assert"
+)
+
+func TestBuildConfigDeprecatedCommands(t *testing.T) {
+	config := new(model.BuildConfig)
+	assert.NilError(t, json.Unmarshal([]byte(`{"builder":"nixpacks","installCommand":"npm ci","buildCommand":"npm run build"}`), config))
+	assert.Equal(t, config.Builder, model.BuilderKind("nixpacks"))
+	assert.DeepEqual(t, config.InstallCommands, []string{"npm ci"})
+	assert.DeepEqual(t, config.BuildCommands, []string{"npm run build"})
+
+	config = new(model.BuildConfig)
+	assert.NilError(t, json.Unmarshal([]byte(`{"installCommands":["npm ci","npx prisma generate"]}`), config))
+	assert.DeepEqual(t, config.InstallCommands, []string{"npm ci", "npx prisma generate"})
+	assert.Assert(t, config.BuildCommands == nil)
+
+	err := json.Unmarshal([]byte(`{"buildCommand":"make","buildCommands":["make all"]}`), new(model.BuildConfig))
+	assert.ErrorContains(t, err, "can't be both set")
+
+	// the request decodes the plan with the old keys too
+	request := new(model.Request)
+	assert.NilError(t, json.Unmarshal([]byte(`{"applicationID":"app","buildPlan":{"buildCommand":"make"}}`), request))
+	assert.DeepEqual(t, request.BuildPlan.BuildCommands, []string{"make"})
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders/buildpacks"
 	"github.com/ipaas-org/image-builder/providers/builders/docker"
 	nixBuilder "github.com/ipaas-org/image-builder/providers/builders/nixpacks"
+	"github.com/ipaas-org/image-builder/providers/builders/static"
 	"github.com/vano2903/nixpacks-go"
 )
 
@@ -64,8 +67,15 @@ func (b *BaseAnalyzer) DetectBuilders(ctx context.Context, path string) (*model.
 	fmt.Printf("nixInfo >>>>> %+v\n", nixInfo)
 
 	dockerInfo := new(model.DockerInfo)
+	buildpacksInfo := new(model.BuildpacksInfo)
 	for _, f := range files {
 		name := strings.ToLower(f.Name())
+		if stack := buildpacksStack(name); stack != "" {
+			buildpacksInfo.Files = append(buildpacksInfo.Files, f.Name())
+			if !slices.Contains(buildpacksInfo.Stacks, stack) {
+				buildpacksInfo.Stacks = append(buildpacksInfo.Stacks, stack)
+			}
+		}
 		if name == "nixpacks.json" || name == "nixpacks.toml" {
 			nixInfo.NixPacksConfigPath = f.Name()
 		}
@@ -93,5 +103,39 @@ func (b *BaseAnalyzer) DetectBuilders(ctx context.Context, path string) (*model.
 		}
 	}
 
+	// buildpacks don't use the .dockerignore
+	if len(buildpacksInfo.Stacks) > 0 {
+		info.Builders = append(info.Builders, buildpacks.BuildpacksBuilderKind)
+		info.Buildpacks = buildpacksInfo
+	}
+
+	// the generated Dockerfile copies the whole context, the .dockerignore is fine
+	staticInfo, err := static.Detect(path)
+	if err != nil {
+		return nil, err
+	}
+	// an index.html next to an application detected by nixpacks is a template, not a site
+	if staticInfo != nil && staticInfo.Framework == "html" && len(nixInfo.NixPacksProviders) > 0 {
+		staticInfo = nil
+	}
+	if staticInfo != nil {
+		info.Builders = append(info.Builders, static.StaticBuilderKind)
+		info.Static = staticInfo
+	}
+
 	return info, nil
 }
+
+// buildpacksStack returns the stack of the project file if the stack
+// builds better with buildpacks than with nixpacks (java/spring and dotnet)
+func buildpacksStack(name string) string {
+	switch {
+	case name == "pom.xml", name == "mvnw",
+		name == "build.gradle", name == "build.gradle.kts", name == "gradlew":
+		return "java"
+	case strings.HasSuffix(name, ".csproj"), strings.HasSuffix(name, ".fsproj"),
+		strings.HasSuffix(name, ".vbproj"), strings.HasSuffix(name, ".sln"):
+		return "dotnet"
+	}
+	return ""
+}
@@ -0,0 +1,364 @@
+package builders
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+var ErrBaseImageNotAllowed = fmt.Errorf("base image not allowed")
+
+// BaseImageError is returned when a build pulls a base image that is not allowed,
+// the image comes from the user's Dockerfile or config so it's a user fault
+type BaseImageError struct {
+	Image   string
+	Allowed []string
+	// why the image can't be checked, empty if it's not in the allowlist
+	Reason string
+}
+
+func (e *BaseImageError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("the base image %s is not allowed: %s", e.Image, e.Reason)
+	}
+	return fmt.Sprintf("the base image %s is not allowed, the allowed repositories are %s", e.Image, strings.Join(e.Allowed, ", "))
+}
+
+func (e *BaseImageError) Unwrap() error {
+	return ErrBaseImageNotAllowed
+}
+
+// BaseImages is the policy of the images the builds pull (FROM, COPY --from...),
+// nil allows every image and pulls it from its registry
+type BaseImages struct {
+	// repositories the images can come from, a pattern ending with /* allows every
+	// repository under it (docker.io/library/*). The docker hub names can be short
+	// (node, library/*). Every repository is allowed if empty
+	Allowed []string
+	// pull-through cache of docker hub (host[/path]), the docker hub images are pulled
+	// as <mirror>/<repository> (mirror.example.com/library/node:20)
+	Mirror string
+}
+
+// Enabled reports if the images of the builds must be checked or rewritten
+func (p *BaseImages) Enabled() bool {
+	return p != nil && (len(p.Allowed) > 0 || p.Mirror != "")
+}
+
+// mirror returns the mirror without the scheme and the trailing slash
+func (p *BaseImages) mirror() string {
+	mirror := strings.TrimPrefix(strings.TrimPrefix(p.Mirror, "https://"), "http://")
+	return strings.TrimSuffix(mirror, "/")
+}
+
+// Resolve checks the image against the allowlist and returns the reference to pull,
+// the docker hub images are pulled from the mirror. The images already referring
+// to the mirror are checked as the docker hub images they cache
+func (p *BaseImages) Resolve(image string) (string, error) {
+	if !p.Enabled() {
+		return image, nil
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		if len(p.Allowed) > 0 {
+			return "", &BaseImageError{Image: image, Reason: "invalid reference"}
+		}
+		// the builder reports the invalid references
+		return image, nil
+	}
+
+	name := named.Name()
+	if mirror := p.mirror(); mirror != "" {
+		if path, ok := strings.CutPrefix(name, mirror+"/"); ok {
+			name = "docker.io/" + path
+		}
+	}
+	if len(p.Allowed) > 0 && !p.allowed(name) {
+		return "", &BaseImageError{Image: image, Allowed: p.Allowed}
+	}
+
+	if p.Mirror == "" || reference.Domain(named) != "docker.io" {
+		return image, nil
+	}
+	resolved := p.mirror() + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		resolved += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		resolved += "@" + digested.Digest().String()
+	}
+	return resolved, nil
+}
+
+// allowed reports if the repository (docker.io/library/node) matches the allowlist
+func (p *BaseImages) allowed(name string) bool {
+	for _, pattern := range p.Allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			// the first component is a registry if it looks like a host
+			if domain, _, _ := strings.Cut(prefix, "/"); !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+				prefix = "docker.io/" + prefix
+			}
+			if strings.HasPrefix(name, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if named, err := reference.ParseNormalizedNamed(pattern); err == nil && named.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	syntaxDirective = regexp.MustCompile(`(?i)^#\s*syntax\s*=\s*(\S+)\s*$`)
+	escapeDirective = regexp.MustCompile(`(?i)^#\s*escape\s*=\s*(\S)\s*$`)
+	argDeclaration  = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(?:=(.*))?$`)
+)
+
+// instruction is an instruction of a Dockerfile, with its continuation lines
+type instruction struct {
+	fields []string
+	lines  []int
+}
+
+// RewriteDockerfile checks the images pulled by the Dockerfile (the syntax directive, FROM,
+// COPY --from and RUN --mount from) and rewrites the docker hub ones to be pulled from the
+// mirror, buildArgs resolve the variables of the images. The rewritten Dockerfile is written
+// next to the original, the path (relative to the context) of the Dockerfile to build is returned
+func (p *BaseImages) RewriteDockerfile(contextPath, dockerfile string, buildArgs map[string]*string) (string, error) {
+	if !p.Enabled() {
+		return dockerfile, nil
+	}
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	raw, err := os.ReadFile(filepath.Join(contextPath, dockerfile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// the builder reports the missing Dockerfile
+			return dockerfile, nil
+		}
+		return "", err
+	}
+
+	lines := strings.Split(string(raw), "\n")
+	changed := false
+	replace := func(lines []string, at []int, old, new string) {
+		for _, i := range at {
+			if line, ok := replaceField(lines[i], old, new); ok {
+				lines[i] = line
+				changed = true
+				return
+			}
+		}
+	}
+
+	// the parser directives are the comments at the top of the file
+	escape := "\\"
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			break
+		}
+		if m := escapeDirective.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			escape = m[1]
+		}
+		if m := syntaxDirective.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			resolved, err := p.Resolve(m[1])
+			if err != nil {
+				return "", err
+			}
+			if resolved != m[1] {
+				replace(lines, []int{i}, m[1], resolved)
+			}
+		}
+	}
+
+	args := make(map[string]string) // global args, declared before the first FROM
+	stages := make(map[string]bool) // names of the stages, lowercase
+	seenFrom := false
+	resolveImage := func(image string, at []int) error {
+		if stages[strings.ToLower(image)] {
+			return nil
+		}
+		expanded, ok := expandArgs(image, args)
+		if !ok {
+			if len(p.Allowed) > 0 {
+				return &BaseImageError{Image: image, Reason: "its variables are not set"}
+			}
+			return nil
+		}
+		if strings.EqualFold(expanded, "scratch") || stages[strings.ToLower(expanded)] {
+			return nil
+		}
+		resolved, err := p.Resolve(expanded)
+		if err != nil {
+			return err
+		}
+		if resolved != expanded {
+			replace(lines, at, image, resolved)
+		}
+		return nil
+	}
+
+	for _, inst := range parseInstructions(lines, escape) {
+		switch strings.ToUpper(inst.fields[0]) {
+		case "ARG":
+			if seenFrom {
+				continue
+			}
+			for _, field := range inst.fields[1:] {
+				m := argDeclaration.FindStringSubmatch(field)
+				if m == nil {
+					continue
+				}
+				if value, ok := buildArgs[m[1]]; ok && value != nil {
+					args[m[1]] = *value
+				} else if strings.Contains(field, "=") {
+					args[m[1]] = strings.Trim(m[2], `"'`)
+				}
+			}
+		case "FROM":
+			seenFrom = true
+			var image, stage string
+			for i := 1; i < len(inst.fields); i++ {
+				field := inst.fields[i]
+				switch {
+				case strings.HasPrefix(field, "--"):
+				case image == "":
+					image = field
+				case strings.EqualFold(field, "AS") && i+1 < len(inst.fields):
+					stage = inst.fields[i+1]
+					i++
+				}
+			}
+			if image == "" {
+				continue
+			}
+			if err := resolveImage(image, inst.lines); err != nil {
+				return "", err
+			}
+			if stage != "" {
+				stages[strings.ToLower(stage)] = true
+			}
+		case "COPY", "ADD":
+			for _, field := range inst.fields[1:] {
+				from, ok := strings.CutPrefix(field, "--from=")
+				if !ok {
+					continue
+				}
+				// the stages can be referred by index
+				if _, err := strconv.Atoi(from); err == nil {
+					continue
+				}
+				if err := resolveImage(from, inst.lines); err != nil {
+					return "", err
+				}
+			}
+		case "RUN":
+			for _, field := range inst.fields[1:] {
+				mount, ok := strings.CutPrefix(field, "--mount=")
+				if !ok {
+					continue
+				}
+				for _, option := range strings.Split(mount, ",") {
+					from, ok := strings.CutPrefix(option, "from=")
+					if !ok {
+						continue
+					}
+					if _, err := strconv.Atoi(from); err == nil {
+						continue
+					}
+					if err := resolveImage(from, inst.lines); err != nil {
+						return "", err
+					}
+				}
+			}
+		}
+	}
+
+	if !changed {
+		return dockerfile, nil
+	}
+	rewritten := filepath.Join(filepath.Dir(dockerfile), "."+filepath.Base(dockerfile)+".mirrored")
+	if err := os.WriteFile(filepath.Join(contextPath, rewritten), []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return "", err
+	}
+	return rewritten, nil
+}
+
+// parseInstructions splits the Dockerfile in instructions, the lines ending with the
+// escape character are joined with the next ones and the comments are skipped.
+// The content of the heredocs is parsed as instructions too, the images it refers
+// are checked even if they are not pulled
+func parseInstructions(lines []string, escape string) []instruction {
+	var instructions []instruction
+	var current *instruction
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if current == nil {
+			instructions = append(instructions, instruction{})
+			current = &instructions[len(instructions)-1]
+		}
+		current.lines = append(current.lines, i)
+		content, continued := strings.CutSuffix(trimmed, escape)
+		current.fields = append(current.fields, strings.Fields(content)...)
+		if !continued {
+			current = nil
+		}
+	}
+
+	// the instructions made only of escape characters have no fields
+	valid := instructions[:0]
+	for _, inst := range instructions {
+		if len(inst.fields) > 0 {
+			valid = append(valid, inst)
+		}
+	}
+	return valid
+}
+
+// replaceField replaces the first occurrence of old that is a whole field of the line
+// or the value of an option (--from=old, from=old)
+func replaceField(line, old, new string) (string, bool) {
+	for start := 0; ; {
+		i := strings.Index(line[start:], old)
+		if i < 0 {
+			return line, false
+		}
+		i += start
+		end := i + len(old)
+		before := i == 0 || strings.ContainsRune(" \t=", rune(line[i-1]))
+		after := end == len(line) || strings.ContainsRune(" \t,\r", rune(line[end]))
+		if before && after {
+			return line[:i] + new + line[end:], true
+		}
+		start = i + 1
+	}
+}
+
+// expandArgs replaces the $VAR, ${VAR} and ${VAR:-default} of the image with the args,
+// false is returned if one of the variables is not set
+func expandArgs(image string, args map[string]string) (string, bool) {
+	ok := true
+	expanded := os.Expand(image, func(name string) string {
+		name, fallback, hasFallback := strings.Cut(name, ":-")
+		if value, set := args[name]; set && value != "" {
+			return value
+		}
+		if hasFallback {
+			return fallback
+		}
+		ok = false
+		return ""
+	})
+	return expanded, ok
+}
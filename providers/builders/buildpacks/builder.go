@@ -0,0 +1,269 @@
+package buildpacks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
+)
+
+const (
+	BuildpacksBuilderKind model.BuilderKind = "buildpacks"
+
+	DefaultBuilderImage = "paketobuildpacks/builder-jammy-base"
+)
+
+var _ builders.Builder = new(BuildpacksBuilder)
+
+// BuildpacksBuilder builds images with the cloud native buildpacks lifecycle
+// through the pack cli, it needs the docker daemon. The builds can't have cpu,
+// memory or disk limits, only the timeout
+type BuildpacksBuilder struct {
+	builderVersion string
+	pack           string
+	builderImage   string
+}
+
+type BuildpacksBuilderOptions struct {
+	// path to the pack cli, defaults to the one found in PATH
+	PackPath string
+	// default builder image, can be overridden by the build config
+	BuilderImage string
+}
+
+type BuildpacksBuilderConfig struct {
+	BuilderImage string            `json:"builderImage"`
+	Buildpacks   []string          `json:"buildpacks"`
+	Envs         map[string]string `json:"envs"`
+	StartCommand string            `json:"startCommand"`
+}
+
+func NewBuildpacksBuilder(builderVersion string, opt ...BuildpacksBuilderOptions) (*BuildpacksBuilder, error) {
+	b := &BuildpacksBuilder{
+		builderVersion: builderVersion,
+		builderImage:   DefaultBuilderImage,
+	}
+	if len(opt) > 0 {
+		b.pack = opt[0].PackPath
+		if opt[0].BuilderImage != "" {
+			b.builderImage = opt[0].BuilderImage
+		}
+	}
+	if b.pack == "" {
+		path, err := exec.LookPath("pack")
+		if err != nil {
+			return nil, err
+		}
+		b.pack = path
+	}
+	return b, nil
+}
+
+func (b BuildpacksBuilder) Plan(ctx context.Context, config *model.BuildConfig, path string) (builders.Plan, error) {
+	// env vars are visible to every buildpack and can end up in the image, secrets can't be mounted
+	if len(config.Secrets) > 0 {
+		return "", builders.ErrInvalidConfig
+	}
+
+	plan := &BuildpacksBuilderConfig{
+		BuilderImage: config.BuildpacksBuilder,
+		Buildpacks:   config.Buildpacks,
+		Envs:         make(map[string]string),
+		StartCommand: config.StartCommand,
+	}
+	if plan.BuilderImage == "" {
+		plan.BuilderImage = b.builderImage
+	}
+	for _, env := range config.Envs {
+		if env.Key == "" {
+			return "", builders.ErrInvalidConfig
+		}
+		plan.Envs[env.Key] = env.Value
+	}
+
+	jsonPlan, err := json.Marshal(plan)
+	if err != nil {
+		return "", err
+	}
+	return builders.Plan(jsonPlan), nil
+}
+
+// first string is the image id, second is the build output
+func (b BuildpacksBuilder) Build(ctx context.Context, userID, repo, path string, plan builders.Plan, opt builders.BuildOptions) (string, []byte, error) {
+	config := new(BuildpacksBuilderConfig)
+	if err := json.Unmarshal([]byte(plan), config); err != nil {
+		return "", nil, builders.ErrInvalidPlan
+	}
+	if len(opt.Platforms) > 1 {
+		return "", nil, builders.ErrUnsupportedPlatform
+	}
+	// pack creates the containers of the lifecycle, their resources can't be limited
+	if opt.Limits.Constrained() {
+		return "", nil, fmt.Errorf("%w: the buildpacks builder can't limit the cpu, memory and disk of the build", builders.ErrUnsupportedLimits)
+	}
+	if err := opt.Network.Check(); err != nil {
+		return "", nil, err
+	}
+	builderImage, err := opt.BaseImages.Resolve(config.BuilderImage)
+	if err != nil {
+		return "", nil, err
+	}
+	// the run image comes from the builder metadata, it's the base of the image
+	// and must be checked and pulled through the mirror too
+	runImage := ""
+	if opt.BaseImages.Enabled() {
+		runImage, err = b.runImage(ctx, builderImage)
+		if err != nil {
+			return "", nil, err
+		}
+		runImage, err = opt.BaseImages.Resolve(runImage)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	imageName := uuid.New().String()
+	args := []string{"build", imageName,
+		"--path", path,
+		"--builder", builderImage,
+		"--trust-builder",
+		"--pull-policy", "if-not-present",
+		"--no-color",
+	}
+	if runImage != "" {
+		args = append(args, "--run-image", runImage)
+	}
+	if len(opt.Platforms) == 1 {
+		args = append(args, "--platform", opt.Platforms[0])
+	}
+	if opt.Cache != nil {
+		// the image name changes every build, key the cache volume by application
+		args = append(args, "--cache", "type=build;format=volume;name=ipaas-cache-"+opt.Cache.Key)
+	}
+	if opt.Network.Isolated() {
+		switch {
+		case opt.Network.Mode == builders.NetworkNone:
+			args = append(args, "--network", "none")
+		case opt.Network.Mode == builders.NetworkProxy:
+			args = append(args, "--network", opt.Network.ProxyNetwork)
+		}
+	}
+	for _, bp := range config.Buildpacks {
+		args = append(args, "--buildpack", bp)
+	}
+	if config.StartCommand != "" {
+		// the procfile buildpack creates the web process from BP_PROCFILE_DEFAULT_PROCESS
+		args = append(args, "--env", "BP_PROCFILE_DEFAULT_PROCESS="+config.StartCommand)
+	}
+	for _, k := range docker.SortedKeys(config.Envs) {
+		args = append(args, "--env", k+"="+config.Envs[k])
+	}
+	// after the user's envs, the proxy can't be overridden
+	proxyEnv := opt.Network.ProxyEnv()
+	for _, k := range docker.SortedKeys(proxyEnv) {
+		args = append(args, "--env", k+"="+proxyEnv[k])
+	}
+	args = append(args, "--env", "BP_IMAGE_LABELS="+imageLabels(docker.Labels(b.builderVersion, BuildpacksBuilderKind, userID, repo)))
+
+	out := opt.Output()
+	cmd := exec.CommandContext(ctx, b.pack, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return "", out.Bytes(), err
+		}
+		output := out.Bytes()
+		return "", output, packError(string(builders.StripTimestamps(output)))
+	}
+
+	imageID, err := getImageID(ctx, imageName)
+	if err != nil {
+		return "", out.Bytes(), err
+	}
+	return imageID, out.Bytes(), nil
+}
+
+// imageLabels formats the labels for the image-labels buildpack,
+// values are quoted since the buildpack splits them as shell words
+func imageLabels(labels map[string]string) string {
+	var parts []string
+	for _, k := range docker.SortedKeys(labels) {
+		parts = append(parts, k+"=\""+strings.ReplaceAll(labels[k], "\"", "\\\"")+"\"")
+	}
+	return strings.Join(parts, " ")
+}
+
+// packError maps the output of a failed pack build to the builders errors
+func packError(output string) error {
+	switch {
+	case strings.Contains(output, "No buildpack groups passed detection"):
+		return &builders.BuildError{Step: "detect", Message: "no buildpack can build this application"}
+	case strings.Contains(output, "failed to fetch builder image"),
+		strings.Contains(output, "invalid builder"):
+		return builders.ErrInvalidConfig
+	}
+	if i := strings.LastIndex(output, "ERROR: failed to build"); i >= 0 {
+		message, _, _ := strings.Cut(output[i:], "\n")
+		// the lifecycle prints a header (===> BUILDING) for each phase, the failed one is the last
+		step := "build"
+		if j := strings.LastIndex(output[:i], "===> "); j >= 0 {
+			header, _, _ := strings.Cut(output[j+len("===> "):], "\n")
+			step = strings.ToLower(strings.TrimSpace(header))
+		}
+		return &builders.BuildError{Step: step, Message: strings.TrimSpace(message)}
+	}
+	return builders.ErrImageNotCompiled
+}
+
+// runImage returns the run image of the builder image, read from its metadata
+func (b BuildpacksBuilder) runImage(ctx context.Context, builderImage string) (string, error) {
+	out, err := exec.CommandContext(ctx, b.pack, "builder", "inspect", builderImage, "--output", "json").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect the builder image %s: %w", builderImage, err)
+	}
+	return ParseRunImage(out)
+}
+
+// builderInfo is the part of the output of `pack builder inspect --output json` with the
+// run images, remote_info is read from the registry and local_info from the daemon
+type builderInfo struct {
+	RemoteInfo *builderRunImages `json:"remote_info"`
+	LocalInfo  *builderRunImages `json:"local_info"`
+}
+
+type builderRunImages struct {
+	RunImages []struct {
+		Name string `json:"name"`
+	} `json:"run_images"`
+}
+
+// ParseRunImage returns the first run image of the output of `pack builder inspect --output json`,
+// the other ones are mirrors of it
+func ParseRunImage(out []byte) (string, error) {
+	info := new(builderInfo)
+	if err := json.Unmarshal(out, info); err != nil {
+		return "", fmt.Errorf("invalid builder metadata: %w", err)
+	}
+	if info.RemoteInfo != nil && len(info.RemoteInfo.RunImages) > 0 {
+		return info.RemoteInfo.RunImages[0].Name, nil
+	}
+	if info.LocalInfo != nil && len(info.LocalInfo.RunImages) > 0 {
+		return info.LocalInfo.RunImages[0].Name, nil
+	}
+	return "", fmt.Errorf("%w: the builder image has no run image", builders.ErrInvalidConfig)
+}
+
+func getImageID(ctx context.Context, imageName string) (string, error) {
+	out, err := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", imageName).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,58 @@
+package buildpacks_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/buildpacks"
+	"gotest.tools/assert"
+)
+
+func TestBuildUnsupportedLimits(t *testing.T) {
+	ctx := context.Background()
+	b, err := buildpacks.NewBuildpacksBuilder("test", buildpacks.BuildpacksBuilderOptions{PackPath: "pack"})
+	assert.NilError(t, err)
+	plan, err := b.Plan(ctx, &model.BuildConfig{Builder: buildpacks.BuildpacksBuilderKind}, t.TempDir())
+	assert.NilError(t, err)
+
+	for _, limits := range []*builders.Limits{{CPUs: 1}, {Memory: 512 * 1024 * 1024}, {Disk: 1024 * 1024 * 1024}} {
+		_, _, err = b.Build(ctx, "user", "repo", t.TempDir(), plan, builders.BuildOptions{Limits: limits})
+		assert.Assert(t, errors.Is(err, builders.ErrUnsupportedLimits), "got %v", err)
+	}
+}
+
+func TestParseRunImage(t *testing.T) {
+	out := `{
+  "builder_name": "paketobuildpacks/builder-jammy-base",
+  "trusted": false,
+  "default": false,
+  "remote_info": {
+    "stack": {"id": "io.buildpacks.stacks.jammy"},
+    "run_images": [
+      {"name": "index.docker.io/paketobuildpacks/run-jammy-base:latest", "user_defined": false},
+      {"name": "mirror.example.com/paketobuildpacks/run-jammy-base:latest", "user_defined": false}
+    ]
+  },
+  "local_info": null
+}`
+	runImage, err := buildpacks.ParseRunImage([]byte(out))
+	assert.NilError(t, err)
+	assert.Equal(t, runImage, "index.docker.io/paketobuildpacks/run-jammy-base:latest")
+
+	// only in the daemon
+	runImage, err = buildpacks.ParseRunImage([]byte(`{"remote_info":null,"local_info":{"run_images":[{"name":"paketobuildpacks/run-jammy-full"}]}}`))
+	assert.NilError(t, err)
+	assert.Equal(t, runImage, "paketobuildpacks/run-jammy-full")
+
+	_, err = buildpacks.ParseRunImage([]byte(`{"remote_info":{"run_images":[]}}`))
+	assert.Assert(t, errors.Is(err, builders.ErrInvalidConfig), "got %v", err)
+
+	// the run image is checked like the builder image
+	policy := &builders.BaseImages{Allowed: []string{"paketobuildpacks/*"}, Mirror: "mirror.example.com"}
+	resolved, err := policy.Resolve("index.docker.io/paketobuildpacks/run-jammy-base:latest")
+	assert.NilError(t, err)
+	assert.Equal(t, resolved, "mirror.example.com/paketobuildpacks/run-jammy-base:latest")
+}
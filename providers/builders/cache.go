@@ -0,0 +1,71 @@
+package builders
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+
+	"github.com/ipaas-org/image-builder/model"
+)
+
+// Cache is the layer cache of an application, it's shared by all the builds
+// of the application so unchanged steps (like installing the dependencies)
+// are not executed again
+type Cache struct {
+	// unique per application, it keys the cache mounts and the cache volumes
+	Key string
+	// images in the registry the cache is imported from
+	From []string
+	// reference the builders that push on their own (buildctl, buildx with a
+	// container driver) export the cache to. The others write the cache in
+	// the image (inline cache) that is then pushed as To by the registry
+	To string
+}
+
+var (
+	// plain progress of buildkit, see docker.ProgressPrinter
+	buildKitStepRegex   = regexp.MustCompile(`^#(\d+) \[`)
+	buildKitCachedRegex = regexp.MustCompile(`^#(\d+) CACHED$`)
+)
+
+// CacheStats counts the steps of the build and the ones restored from the cache,
+// it reads the plain progress of buildkit, the output of the legacy builder
+// and the exporter logs of the buildpacks lifecycle. The steps in the lines
+// dropped from a truncated log are not counted
+func CacheStats(output []byte) *model.CacheStats {
+	stats := new(model.CacheStats)
+	steps := make(map[string]bool)
+	cached := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(StripTimestamps(output)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		switch {
+		// internal steps (loading the Dockerfile and the context) are never cached
+		case buildKitStepRegex.Match(line) && !bytes.Contains(line, []byte("[internal]")):
+			steps[string(buildKitStepRegex.FindSubmatch(line)[1])] = true
+		case buildKitCachedRegex.Match(line):
+			cached[string(buildKitCachedRegex.FindSubmatch(line)[1])] = true
+		// legacy builder
+		case bytes.HasPrefix(line, []byte("Step ")):
+			stats.Steps++
+		case bytes.Equal(line, []byte("---> Using cache")):
+			stats.CachedSteps++
+		// buildpacks exporter
+		case bytes.Contains(line, []byte("Reusing layer ")):
+			stats.Steps++
+			stats.CachedSteps++
+		case bytes.Contains(line, []byte("Adding layer ")):
+			stats.Steps++
+		}
+	}
+
+	stats.Steps += len(steps)
+	for id := range cached {
+		if steps[id] {
+			stats.CachedSteps++
+		}
+	}
+	return stats
+}
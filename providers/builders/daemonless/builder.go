@@ -0,0 +1,226 @@
+package daemonless
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
+)
+
+// buildctl-daemonless.sh ships with the moby/buildkit images, it starts a
+// rootless buildkitd for the duration of the build and runs buildctl against it
+const DefaultBuildctlCommand = "buildctl-daemonless.sh"
+
+var (
+	_ builders.Builder         = new(DaemonlessBuilder)
+	_ docker.DockerfileBuilder = new(DaemonlessBuilder)
+)
+
+// DaemonlessBuilder builds Dockerfiles with buildkit without a docker daemon,
+// so it can run in an unprivileged container. The images are written as
+// an oci layout (see builders.OCILayoutPrefix) and pushed by the registry.
+// buildkitd runs as a child of the service, cpu and memory limits are the
+// ones of the service's container and in proxy mode the service must run in
+// the proxy network, only the proxy env is set. The disk limit is enforced by
+// watching the state directory of buildkitd
+type DaemonlessBuilder struct {
+	builderVersion string
+	buildctl       string
+	buildkitdFlags string
+}
+
+type DaemonlessBuilderOptions struct {
+	// path to buildctl-daemonless.sh (or to a buildctl configured with BUILDKIT_HOST),
+	// defaults to the one found in PATH
+	BuildctlPath string
+	// flags for the buildkitd started by buildctl-daemonless.sh,
+	// use --oci-worker-no-process-sandbox when running in a pod without a dedicated pid namespace
+	BuildkitdFlags string
+}
+
+func NewDaemonlessBuilder(builderVersion string, opt ...DaemonlessBuilderOptions) (*DaemonlessBuilder, error) {
+	b := &DaemonlessBuilder{
+		builderVersion: builderVersion,
+	}
+	if len(opt) > 0 {
+		b.buildctl = opt[0].BuildctlPath
+		b.buildkitdFlags = opt[0].BuildkitdFlags
+	}
+	if b.buildctl == "" {
+		path, err := exec.LookPath(DefaultBuildctlCommand)
+		if err != nil {
+			return nil, err
+		}
+		b.buildctl = path
+	}
+	return b, nil
+}
+
+func (b DaemonlessBuilder) Plan(ctx context.Context, config *model.BuildConfig, path string) (builders.Plan, error) {
+	return docker.PlanFromConfig(config)
+}
+
+// first string is the image id (an oci layout), second is the build output
+func (b DaemonlessBuilder) Build(ctx context.Context, userID, repo, path string, plan builders.Plan, opt builders.BuildOptions) (string, []byte, error) {
+	config := new(docker.DockerBuilderConfig)
+	if err := json.Unmarshal([]byte(plan), config); err != nil {
+		return "", nil, builders.ErrInvalidPlan
+	}
+	return b.BuildDockerfile(ctx, docker.DockerBuilderKind, userID, repo, path, config, opt)
+}
+
+// BuildDockerfile builds the Dockerfile with the dockerfile.v0 frontend,
+// the oci layout is left in a temporary directory that the caller must remove.
+// The progress is written to the log of the build as the steps run
+func (b DaemonlessBuilder) BuildDockerfile(ctx context.Context, kind model.BuilderKind, userID, repo, path string, config *docker.DockerBuilderConfig, opt builders.BuildOptions) (string, []byte, error) {
+	secrets, err := docker.ResolveSecrets(config.Secrets, opt.Secrets)
+	if err != nil {
+		return "", nil, err
+	}
+	config, err = docker.CheckBaseImages(path, config, opt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dockerfile := config.DockerFilePath
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	dockerfile = filepath.Join(path, dockerfile)
+	if _, err := os.Stat(dockerfile); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, builders.ErrMissingConfig
+		}
+		return "", nil, err
+	}
+
+	layoutDir, err := os.MkdirTemp("", "ipaas-oci-layout-*")
+	if err != nil {
+		return "", nil, err
+	}
+
+	args := []string{"build",
+		"--progress", "rawjson",
+		"--frontend", "dockerfile.v0",
+		"--local", "context=" + path,
+		"--local", "dockerfile=" + filepath.Dir(dockerfile),
+		"--opt", "filename=" + filepath.Base(dockerfile),
+		"--output", "type=oci,tar=false,dest=" + layoutDir,
+	}
+	if config.Target != "" {
+		args = append(args, "--opt", "target="+config.Target)
+	}
+	if len(opt.Platforms) > 0 {
+		args = append(args, "--opt", "platform="+strings.Join(opt.Platforms, ","))
+	}
+	if opt.Network != nil && opt.Network.Mode == builders.NetworkNone {
+		args = append(args, "--opt", "force-network-mode=none")
+	}
+	if opt.Cache != nil {
+		// buildctl pushes the cache on its own, with the credentials in $DOCKER_CONFIG
+		for _, ref := range opt.Cache.From {
+			args = append(args, "--import-cache", "type=registry,ref="+ref)
+		}
+		if opt.Cache.To != "" {
+			args = append(args, "--export-cache", "type=registry,mode=max,ref="+opt.Cache.To)
+		}
+	}
+	labels := docker.Labels(b.builderVersion, kind, userID, repo)
+	for _, k := range docker.SortedKeys(labels) {
+		args = append(args, "--opt", "label:"+k+"="+labels[k])
+	}
+	buildArgs := docker.BuildArgs(config, opt.Network)
+	for _, k := range docker.SortedKeys(buildArgs) {
+		args = append(args, "--opt", "build-arg:"+k+"="+*buildArgs[k])
+	}
+
+	env := os.Environ()
+	if b.buildkitdFlags != "" {
+		env = append(env, "BUILDKITD_FLAGS="+b.buildkitdFlags)
+	}
+	secretArgs, secretEnv := docker.SecretArgs(config.Secrets, secrets)
+	args = append(args, secretArgs...)
+	env = append(env, secretEnv...)
+
+	// the builds of the service run one at a time, the state only grows with this build
+	buildCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopWatch, err := builders.WatchDisk(buildCtx, opt.Limits, b.stateDiskUsage, cancel)
+	if err != nil {
+		os.RemoveAll(layoutDir)
+		return "", nil, err
+	}
+	cmd := exec.CommandContext(buildCtx, b.buildctl, args...)
+	cmd.Env = env
+	progress, err := cmd.StderrPipe()
+	if err != nil {
+		stopWatch()
+		os.RemoveAll(layoutDir)
+		return "", nil, err
+	}
+	out := opt.Output()
+	cmd.Stdout = out
+	printer := docker.NewProgressPrinter(out)
+
+	if err := cmd.Start(); err != nil {
+		stopWatch()
+		os.RemoveAll(layoutDir)
+		return "", nil, err
+	}
+	consumeErr := printer.Consume(progress)
+	err = cmd.Wait()
+	if stopWatch() {
+		os.RemoveAll(layoutDir)
+		return "", out.Bytes(), builders.DiskLimitError(opt.Limits)
+	}
+	if err != nil {
+		os.RemoveAll(layoutDir)
+		if _, ok := err.(*exec.ExitError); !ok {
+			return "", out.Bytes(), err
+		}
+		return "", out.Bytes(), docker.OOMError(docker.BuildKitError(printer.Errors()), opt.Limits)
+	}
+	if consumeErr != nil {
+		os.RemoveAll(layoutDir)
+		return "", out.Bytes(), consumeErr
+	}
+
+	return builders.OCILayoutPrefix + layoutDir, out.Bytes(), nil
+}
+
+// stateDiskUsage returns the size of the state directory of buildkitd, the one set with
+// --root in the flags or the default one (rootless unless the service runs as root).
+// A missing directory is empty, buildkitd creates it when it starts
+func (b DaemonlessBuilder) stateDiskUsage(ctx context.Context) (int64, error) {
+	size, err := builders.DirSize(b.stateDir())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return size, err
+}
+
+func (b DaemonlessBuilder) stateDir() string {
+	flags := strings.Fields(b.buildkitdFlags)
+	for i, flag := range flags {
+		if root, ok := strings.CutPrefix(flag, "--root="); ok {
+			return root
+		}
+		if flag == "--root" && i+1 < len(flags) {
+			return flags[i+1]
+		}
+	}
+	if os.Geteuid() == 0 {
+		return "/var/lib/buildkit"
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "buildkit")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share", "buildkit")
+}
@@ -2,14 +2,10 @@ package docker
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
-
-	"github.com/ahmetb/go-cursor"
-	orderedmap "github.com/wk8/go-ordered-map"
 )
 
 const (
@@ -93,42 +89,36 @@ func (m *ResponseBodyStreamMessage) ProgressString() string {
 	return ""
 }
 
-func ConvertOutput(imageOutput io.Reader) ([]byte, error) {
-	writer := new(bytes.Buffer)
+// ConvertOutput writes the stream of the legacy builder to w as it's read.
+// The pull and push progress of each layer is only written when its status
+// changes (Downloading, Extracting, Pull complete...), the progress bars are dropped
+func ConvertOutput(imageOutput io.Reader, w io.Writer) error {
 	scanner := bufio.NewScanner(imageOutput)
+	scanner.Buffer(make([]byte, 0, 64*1024), 8*1024*1024)
 	lineBefore := ""
-	lines := orderedmap.New()
-	numLayers := 0
+	layers := make(map[string]string)
 
 	for scanner.Scan() {
 		streamMessage := &ResponseBodyStreamMessage{}
-		line := scanner.Bytes()
-
-		if err := json.Unmarshal(line, &streamMessage); err != nil {
-			return nil, err
+		if err := json.Unmarshal(scanner.Bytes(), &streamMessage); err != nil {
+			return err
 		}
 
 		streamMessageStr := streamMessage.String()
-		// fmt.Println("")
-		if streamMessageStr != lineBefore && streamMessageStr != "" {
-			if streamMessage.ID != "" {
-				// override layer outputs on pull or push messages
-				fmt.Fprintf(writer, "%s%s\n", cursor.MoveUp(numLayers+1), cursor.ClearEntireLine())
-
-				lines.Set(streamMessage.ID, fmt.Sprint(streamMessage.String(), streamMessage.ProgressString()))
-				for line := lines.Oldest(); line != nil; line = line.Next() {
-					fmt.Fprintf(writer, "%s%s\n", line.Value, cursor.ClearLineRight())
-				}
-				numLayers = lines.Len()
-			} else {
-				fmt.Fprintf(writer, "%s%s\n", streamMessage.String(), streamMessage.ProgressString())
-				lines = orderedmap.New()
-				numLayers = 0
-			}
+		if streamMessageStr == lineBefore || streamMessageStr == "" {
+			continue
 		}
-
 		lineBefore = streamMessageStr
+		if streamMessage.ID != "" {
+			if layers[streamMessage.ID] == streamMessageStr {
+				continue
+			}
+			layers[streamMessage.ID] = streamMessageStr
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimSpace(streamMessageStr)); err != nil {
+			return err
+		}
 	}
 
-	return writer.Bytes(), nil
+	return scanner.Err()
 }
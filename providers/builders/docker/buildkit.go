@@ -0,0 +1,256 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+)
+
+const secretEnvPrefix = "IPAAS_BUILD_SECRET_"
+
+// buildMetadata is the content of buildx's --metadata-file, it's the result of the solve
+type buildMetadata struct {
+	ConfigDigest string `json:"containerimage.config.digest"`
+	ImageDigest  string `json:"containerimage.digest"`
+}
+
+// buildWithBuildKit builds the image with `docker buildx build`.
+// The progress stream is read as rawjson and converted to plain logs and
+// the image id is read from the solve result instead of asking the daemon.
+// The secret values are only set in the environment of the cli process and
+// mounted with --secret id=<name>,env=<var>, so they are never written on disk,
+// in the build context or in any layer of the image.
+// The converted logs are written to the log of the build as the steps run.
+// Multi-platform images are exported as an oci layout in a temporary directory,
+// it's up to the caller to remove it once the image is pushed.
+// The cache of the application is exported to the registry by the multi-platform
+// builder, the default builder can only write it in the image (inline).
+// Builds with cpu, memory or disk limits or on the proxy network run on a dedicated
+// builder with the limits rounded up to its tiers, see isolatedBuilder. The disk it
+// uses is watched while the build runs
+func (b DockerBuilder) buildWithBuildKit(ctx context.Context, path, imageName string, config *DockerBuilderConfig, labels map[string]string, secrets map[string]string, opt builders.BuildOptions) (string, []byte, error) {
+	platforms := opt.Platforms
+	metadataFile, err := os.CreateTemp("", "ipaas-build-metadata-*.json")
+	if err != nil {
+		return "", nil, err
+	}
+	metadataFile.Close()
+	defer os.Remove(metadataFile.Name())
+
+	args := []string{"buildx", "build",
+		"--progress", "rawjson",
+		"--metadata-file", metadataFile.Name(),
+		"--tag", imageName,
+	}
+
+	layoutDir := ""
+	builder := ""
+	switch {
+	case needsIsolatedBuilder(opt):
+		// container builders can export image indexes too
+		opt.Limits = ProfileLimits(opt.Limits)
+		var release func()
+		builder, release, err = isolatedBuilder(ctx, opt.Limits, opt.Network)
+		if err != nil {
+			return "", nil, err
+		}
+		defer release()
+	case len(platforms) > 1:
+		// the default builder can't export image indexes
+		if b.multiPlatformBuilder == "" {
+			return "", nil, builders.ErrUnsupportedPlatform
+		}
+		builder = b.multiPlatformBuilder
+	}
+	if builder != "" {
+		args = append(args, "--builder", builder)
+	}
+	if len(platforms) > 0 {
+		if err := checkPlatforms(ctx, builder, platforms); err != nil {
+			return "", nil, err
+		}
+		args = append(args, "--platform", strings.Join(platforms, ","))
+	}
+	if len(platforms) > 1 {
+		layoutDir, err = os.MkdirTemp("", "ipaas-oci-layout-*")
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, "--output", "type=oci,tar=false,dest="+layoutDir)
+	} else {
+		args = append(args, "--load")
+	}
+	if config.DockerFilePath != "" {
+		args = append(args, "--file", filepath.Join(path, config.DockerFilePath))
+	}
+	if config.Target != "" {
+		args = append(args, "--target", config.Target)
+	}
+	if opt.Network != nil && opt.Network.Mode == builders.NetworkNone {
+		args = append(args, "--network", "none")
+	}
+	for _, k := range SortedKeys(labels) {
+		args = append(args, "--label", k+"="+labels[k])
+	}
+	if opt.Cache != nil {
+		for _, ref := range opt.Cache.From {
+			args = append(args, "--cache-from", "type=registry,ref="+ref)
+		}
+	}
+	switch {
+	case opt.Cache != nil && opt.Cache.To != "" && layoutDir != "":
+		args = append(args, "--cache-to", "type=registry,mode=max,ref="+opt.Cache.To)
+	case opt.Cache != nil || b.inlineCache:
+		args = append(args, "--cache-to", "type=inline")
+	}
+
+	env := append(os.Environ(), "DOCKER_BUILDKIT=1")
+	buildArgs := BuildArgs(config, opt.Network)
+	for _, k := range SortedKeys(buildArgs) {
+		args = append(args, "--build-arg", k+"="+*buildArgs[k])
+	}
+	secretArgs, secretEnv := SecretArgs(config.Secrets, secrets)
+	args = append(args, secretArgs...)
+	env = append(env, secretEnv...)
+	args = append(args, path)
+
+	// the builder is isolated if the disk is limited, its usage only grows with this build
+	buildCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopWatch, err := builders.WatchDisk(buildCtx, opt.Limits, BuilderDiskUsage(builder), cancel)
+	if err != nil {
+		return "", nil, err
+	}
+	cmd := exec.CommandContext(buildCtx, "docker", args...)
+	cmd.Env = env
+	progress, err := cmd.StderrPipe()
+	if err != nil {
+		return "", nil, err
+	}
+	out := opt.Output()
+	cmd.Stdout = out
+	printer := NewProgressPrinter(out)
+
+	removeLayout := func() {
+		if layoutDir != "" {
+			os.RemoveAll(layoutDir)
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		stopWatch()
+		removeLayout()
+		return "", nil, err
+	}
+	consumeErr := printer.Consume(progress)
+	err = cmd.Wait()
+	if stopWatch() {
+		removeLayout()
+		return "", out.Bytes(), builders.DiskLimitError(opt.Limits)
+	}
+	if err != nil {
+		removeLayout()
+		if _, ok := err.(*exec.ExitError); !ok {
+			return "", out.Bytes(), err
+		}
+		return "", out.Bytes(), OOMError(BuildKitError(printer.Errors()), opt.Limits)
+	}
+	if consumeErr != nil {
+		removeLayout()
+		return "", out.Bytes(), consumeErr
+	}
+	if layoutDir != "" {
+		return builders.OCILayoutPrefix + layoutDir, out.Bytes(), nil
+	}
+
+	metadata := new(buildMetadata)
+	raw, err := os.ReadFile(metadataFile.Name())
+	if err != nil {
+		return "", out.Bytes(), err
+	}
+	if err := json.Unmarshal(raw, metadata); err != nil {
+		return "", out.Bytes(), err
+	}
+	if metadata.ConfigDigest == "" {
+		return "", out.Bytes(), fmt.Errorf("buildkit did not return the image digest")
+	}
+	return metadata.ConfigDigest, out.Bytes(), nil
+}
+
+// BuildKitError maps the failed steps to the builders errors, errors in the
+// frontend steps are config errors, any other failed step is a build error
+func BuildKitError(stepErrors []*builders.BuildError) error {
+	if len(stepErrors) == 0 {
+		return builders.ErrImageNotCompiled
+	}
+	// the first error is the one that caused the others to be cancelled
+	stepErr := stepErrors[0]
+	switch {
+	case strings.Contains(stepErr.Message, "failed to read dockerfile"),
+		strings.Contains(stepErr.Message, "no such file or directory") && strings.Contains(stepErr.Step, "load build definition"):
+		return builders.ErrMissingConfig
+	case strings.Contains(stepErr.Message, "dockerfile parse error"),
+		strings.Contains(stepErr.Message, "target stage") && strings.Contains(stepErr.Message, "could not be found"):
+		return builders.ErrInvalidConfig
+	}
+	return stepErr
+}
+
+// SecretArgs returns the --secret args and the env of the cli process for the
+// secrets, the value is read by buildkit from the env so it never touches the disk
+func SecretArgs(names []string, secrets map[string]string) (args []string, env []string) {
+	for i, name := range names {
+		envName := fmt.Sprintf("%s%d", secretEnvPrefix, i)
+		env = append(env, envName+"="+secrets[name])
+		args = append(args, "--secret", "id="+name+",env="+envName)
+	}
+	return args, env
+}
+
+// checkPlatforms makes sure the buildx builder can build for every platform,
+// emulated platforms are listed only if the qemu binfmt handlers are installed
+func checkPlatforms(ctx context.Context, builder string, platforms []string) error {
+	args := []string{"buildx", "inspect", "--bootstrap"}
+	if builder != "" {
+		args = append(args, builder)
+	}
+	out, err := exec.CommandContext(ctx, "docker", args...).Output()
+	if err != nil {
+		return fmt.Errorf("unable to inspect buildx builder %q: %w", builder, err)
+	}
+
+	supported := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		list, ok := strings.CutPrefix(strings.TrimSpace(line), "Platforms:")
+		if !ok {
+			continue
+		}
+		for _, p := range strings.Split(list, ",") {
+			// buildx marks the platforms set in the builder's config with a *
+			supported[strings.TrimSuffix(strings.TrimSpace(p), "*")] = true
+		}
+	}
+
+	for _, p := range platforms {
+		if !supported[p] {
+			return fmt.Errorf("%w: %s", builders.ErrUnsupportedPlatform, p)
+		}
+	}
+	return nil
+}
+
+// SortedKeys returns the keys of m in order, used to keep the cli args stable
+func SortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
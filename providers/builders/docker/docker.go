@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"os/exec"
 	"strings"
 	"time"
@@ -18,31 +19,59 @@ import (
 
 const DockerBuilderKind model.BuilderKind = "docker"
 
-var _ builders.Builder = new(DockerBuilder)
+var (
+	_ builders.Builder     = new(DockerBuilder)
+	_ builders.CachePruner = new(DockerBuilder)
+)
 
 type DockerBuilder struct {
 	builderVersion string
 	cli            *client.Client
+	legacy         bool
+	inlineCache    bool
+	// buildx builder used for multi-platform builds
+	multiPlatformBuilder string
+}
+
+type DockerBuilderOptions struct {
+	// build with the legacy builder through the engine api instead of buildkit,
+	// secrets are not supported by the legacy builder
+	Legacy bool
+	// export the build cache inside the image so it can be used with --cache-from
+	InlineCache bool
+	// name of the buildx builder used for multi-platform builds, it must use the
+	// docker-container driver since the docker driver can't export image indexes.
+	// Emulated platforms depend on the qemu binfmt handlers installed on the host
+	MultiPlatformBuilder string
 }
 
 type DockerBuilderConfig struct {
 	DockerFilePath string            `json:"dockerfilePath"`
 	Envs           map[string]string `json:"envs"`
-	// Args           map[string]string `json:"args"`
+	Args           map[string]string `json:"args"`
+	Target         string            `json:"target"`
+	// names of the secrets the build expects, values are passed at build time
+	Secrets []string `json:"secrets"`
 	// StartCommand   string `json:"startCommand"`
 	// DockerIngorePath string `json:"dockerignorePath"`
 }
 
-func NewDockerBuilder(builderVersion string) (*DockerBuilder, error) {
+func NewDockerBuilder(builderVersion string, opt ...DockerBuilderOptions) (*DockerBuilder, error) {
 	// creating docker client from env
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return nil, err
 	}
-	return &DockerBuilder{
+	b := &DockerBuilder{
 		builderVersion: builderVersion,
 		cli:            cli,
-	}, nil
+	}
+	if len(opt) > 0 {
+		b.legacy = opt[0].Legacy
+		b.inlineCache = opt[0].InlineCache
+		b.multiPlatformBuilder = opt[0].MultiPlatformBuilder
+	}
+	return b, nil
 }
 
 func convertModelKeyValueToDockerEnvs(envs []model.KeyValue) map[string]string {
@@ -53,12 +82,34 @@ func convertModelKeyValueToDockerEnvs(envs []model.KeyValue) map[string]string {
 	return envMap
 }
 
+// DockerfileBuilder builds a Dockerfile, it's implemented by every builder
+// that can build the Dockerfiles generated by other builders (like nixpacks)
+type DockerfileBuilder interface {
+	BuildDockerfile(ctx context.Context, kind model.BuilderKind, userID, repo, path string, config *DockerBuilderConfig, opt builders.BuildOptions) (string, []byte, error)
+}
+
+var _ DockerfileBuilder = new(DockerBuilder)
+
 func (b DockerBuilder) Plan(ctx context.Context, config *model.BuildConfig, path string) (builders.Plan, error) {
+	return PlanFromConfig(config)
+}
+
+// PlanFromConfig creates the plan of a Dockerfile build, the plan is shared
+// by all the builders that build Dockerfiles
+func PlanFromConfig(config *model.BuildConfig) (builders.Plan, error) {
 	plan := new(DockerBuilderConfig)
 
 	// dockerfilePath := filepath.Join(config.RootDirectory, config.DockerfilePath)
 	plan.DockerFilePath = config.DockerfilePath
 	plan.Envs = convertModelKeyValueToDockerEnvs(config.Envs)
+	plan.Args = convertModelKeyValueToDockerEnvs(config.BuildArgs)
+	plan.Target = config.Target
+	for _, secret := range config.Secrets {
+		if secret.Key == "" {
+			return "", builders.ErrInvalidConfig
+		}
+		plan.Secrets = append(plan.Secrets, secret.Key)
+	}
 	jsonPlan, err := json.Marshal(plan)
 	if err != nil {
 		return "", err
@@ -66,12 +117,50 @@ func (b DockerBuilder) Plan(ctx context.Context, config *model.BuildConfig, path
 	return builders.Plan(jsonPlan), nil
 }
 
-// first string is the image name, second is an error message if there was an error building the image
-func (b DockerBuilder) Build(ctx context.Context, userID, repo, path string, plan builders.Plan) (string, []byte, error) {
+// first string is the image id, second is the build output
+func (b DockerBuilder) Build(ctx context.Context, userID, repo, path string, plan builders.Plan, opt builders.BuildOptions) (string, []byte, error) {
 	config := new(DockerBuilderConfig)
 	if err := json.Unmarshal([]byte(plan), config); err != nil {
 		return "", nil, builders.ErrInvalidPlan
 	}
+	return b.BuildDockerfile(ctx, DockerBuilderKind, userID, repo, path, config, opt)
+}
+
+// BuildDockerfile builds the Dockerfile described by config, kind is the builder
+// that generated the Dockerfile and is only used in the labels.
+// Uses buildkit unless the builder was created with the legacy option,
+// multi-platform images are returned as an oci layout (see builders.OCILayoutPrefix)
+// The output is streamed to the log of the build as it's read
+func (b DockerBuilder) BuildDockerfile(ctx context.Context, kind model.BuilderKind, userID, repo, path string, config *DockerBuilderConfig, opt builders.BuildOptions) (string, []byte, error) {
+	if err := opt.Network.Check(); err != nil {
+		return "", nil, err
+	}
+	secrets, err := ResolveSecrets(config.Secrets, opt.Secrets)
+	if err != nil {
+		return "", nil, err
+	}
+	config, err = CheckBaseImages(path, config, opt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	imageName := uuid.New().String()
+	labels := b.labels(kind, userID, repo)
+
+	if !b.legacy {
+		return b.buildWithBuildKit(ctx, path, imageName, config, labels, secrets, opt)
+	}
+	if len(config.Secrets) > 0 {
+		// the legacy builder can't mount secrets
+		return "", nil, builders.ErrInvalidConfig
+	}
+	if len(opt.Platforms) > 1 {
+		return "", nil, builders.ErrUnsupportedPlatform
+	}
+	if opt.Limits != nil && opt.Limits.Disk > 0 {
+		// the storage of the daemon is shared, the disk used by the build can't be watched
+		return "", nil, fmt.Errorf("%w: the legacy builder can't limit the disk used by the build", builders.ErrUnsupportedLimits)
+	}
 
 	//create a build context, is a tar with the temp repo,
 	//needed since we are not using the filesystem as a context
@@ -83,20 +172,40 @@ func (b DockerBuilder) Build(ctx context.Context, userID, repo, path string, pla
 	}
 	defer buildContext.Close()
 
-	imageName := uuid.New().String()
-
+	var cacheFrom []string
+	if opt.Cache != nil {
+		// the legacy builder only uses the cache of the images already pulled
+		cacheFrom = opt.Cache.From
+	}
+	var memory, period, quota int64
+	if opt.Limits != nil {
+		memory = opt.Limits.Memory
+		if opt.Limits.CPUs > 0 {
+			period, quota = cpuPeriod, int64(opt.Limits.CPUs*cpuPeriod)
+		}
+	}
+	networkMode := ""
+	if opt.Network.Isolated() {
+		networkMode = string(opt.Network.Mode)
+		if opt.Network.Mode == builders.NetworkProxy {
+			networkMode = opt.Network.ProxyNetwork
+		}
+	}
 	resp, err := b.cli.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
 		//Squash: true,
 		// Version: types.BuilderBuildKit,
-		Dockerfile: config.DockerFilePath,
-		Tags:       []string{imageName},
-		Labels: map[string]string{
-			"org.ipaas.image-builder.version": b.builderVersion,
-			"org.ipaas.image-builder.builder": string(DockerBuilderKind),
-			"application.repo":                repo,
-			"application.userID":              userID,
-			"application.builtAt":             time.Now().Format("02/01/2006 15:04:05"),
-		},
+		Dockerfile:  config.DockerFilePath,
+		Tags:        []string{imageName},
+		BuildArgs:   BuildArgs(config, opt.Network),
+		Target:      config.Target,
+		Platform:    strings.Join(opt.Platforms, ","),
+		Labels:      labels,
+		CacheFrom:   cacheFrom,
+		Memory:      memory,
+		MemorySwap:  memory,
+		CPUPeriod:   period,
+		CPUQuota:    quota,
+		NetworkMode: networkMode,
 		Remove:      true,
 		ForceRemove: true,
 	})
@@ -110,24 +219,90 @@ func (b DockerBuilder) Build(ctx context.Context, userID, repo, path string, pla
 		return "", nil, err
 	}
 
-	imageBuildOutput, err := ConvertOutput(resp.Body)
-	if err != nil {
-		return "", nil, err
+	defer resp.Body.Close()
+	log := opt.Output()
+	if err := ConvertOutput(resp.Body, log); err != nil {
+		return "", log.Bytes(), err
 	}
+	imageBuildOutput := log.Bytes()
 
 	imageID, err := getImageId(ctx, imageName)
 	if err != nil {
-		return "", nil, err
+		return "", imageBuildOutput, err
 	}
 
 	//find the id of the image just created
 	if !checkIfImageCompiled(imageBuildOutput) {
+		if memory > 0 && strings.Contains(string(imageBuildOutput), "returned a non-zero code: 137") {
+			return imageID, imageBuildOutput, builders.MemoryLimitError(opt.Limits)
+		}
 		return imageID, imageBuildOutput, builders.ErrImageNotCompiled
 	}
 
 	return imageID, imageBuildOutput, nil
 }
 
+// CheckBaseImages checks the images pulled by the Dockerfile against the base images
+// policy of the build, the returned config builds the Dockerfile rewritten to pull
+// the docker hub images from the mirror
+func CheckBaseImages(path string, config *DockerBuilderConfig, opt builders.BuildOptions) (*DockerBuilderConfig, error) {
+	if !opt.BaseImages.Enabled() {
+		return config, nil
+	}
+	dockerfile, err := opt.BaseImages.RewriteDockerfile(path, config.DockerFilePath, BuildArgs(config, opt.Network))
+	if err != nil {
+		return nil, err
+	}
+	rewritten := *config
+	rewritten.DockerFilePath = dockerfile
+	return &rewritten, nil
+}
+
+// ResolveSecrets returns the values of the secrets needed by the plan,
+// ErrMissingConfig is returned if one of them was not sent with the request
+func ResolveSecrets(names []string, values []model.KeyValue) (map[string]string, error) {
+	secrets := convertModelKeyValueToDockerEnvs(values)
+	for _, name := range names {
+		if _, ok := secrets[name]; !ok {
+			return nil, builders.ErrMissingConfig
+		}
+	}
+	return secrets, nil
+}
+
+func (b DockerBuilder) labels(kind model.BuilderKind, userID, repo string) map[string]string {
+	return Labels(b.builderVersion, kind, userID, repo)
+}
+
+// Labels returns the labels added to every image built from a Dockerfile
+func Labels(builderVersion string, kind model.BuilderKind, userID, repo string) map[string]string {
+	return map[string]string{
+		"org.ipaas.image-builder.version": builderVersion,
+		"org.ipaas.image-builder.builder": string(kind),
+		"application.repo":                repo,
+		"application.userID":              userID,
+		"application.builtAt":             time.Now().Format("02/01/2006 15:04:05"),
+	}
+}
+
+// BuildArgs merges the envs and the build args, the envs are passed as build args
+// so that a Dockerfile can read them with ARG, explicit build args have precedence.
+// The proxy variables of the network can't be overridden, they are predefined
+// args so every RUN step sees them without declaring them
+func BuildArgs(config *DockerBuilderConfig, network *builders.Network) map[string]*string {
+	args := make(map[string]*string, len(config.Envs)+len(config.Args))
+	for k, v := range config.Envs {
+		args[k] = &v
+	}
+	for k, v := range config.Args {
+		args[k] = &v
+	}
+	for k, v := range network.ProxyEnv() {
+		args[k] = &v
+	}
+	return args
+}
+
 func getImageId(ctx context.Context, imageName string) (string, error) {
 	var out bytes.Buffer
 	cmd := exec.CommandContext(ctx, "docker", "images", "-q", imageName)
@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+)
+
+const (
+	cpuPeriod = 100000
+
+	isolatedBuilderPrefix = "ipaas-isolated-"
+	// smallest tiers of the isolated builders, see ProfileLimits
+	minCPUTier    = 0.5
+	minMemoryTier = 256 * 1024 * 1024
+)
+
+// isolatedBuilders tracks the builds running on each isolated builder and when
+// it was last used, the idle ones are removed by RemoveIdleBuilders
+var isolatedBuilders = struct {
+	sync.Mutex
+	started time.Time // the builders created by a previous run are idle since the start
+	running map[string]int
+	used    map[string]time.Time
+}{started: time.Now(), running: make(map[string]int), used: make(map[string]time.Time)}
+
+// needsIsolatedBuilder reports if the build can't run on the daemon's buildkit,
+// it runs every build in the same cgroup and on the default network and its
+// storage is shared with the daemon, the disk used by a build can't be watched
+func needsIsolatedBuilder(opt builders.BuildOptions) bool {
+	return opt.Limits.Constrained() || opt.Network.Isolated() && opt.Network.Mode == builders.NetworkProxy
+}
+
+// ProfileLimits returns the limits rounded up to the tiers of the isolated builders,
+// cpus and memory are rounded up to a power of two (from 0.5 cpus and 256MB) so
+// the overrides of the requests share a few builders. The build can use up to
+// twice what it asked, never less
+func ProfileLimits(limits *builders.Limits) *builders.Limits {
+	if limits == nil {
+		return nil
+	}
+	rounded := *limits
+	if rounded.CPUs > 0 {
+		rounded.CPUs = minCPUTier * math.Pow(2, math.Ceil(math.Log2(rounded.CPUs/minCPUTier)))
+		rounded.CPUs = max(rounded.CPUs, minCPUTier)
+	}
+	if rounded.Memory > 0 {
+		tier := math.Pow(2, math.Ceil(math.Log2(float64(rounded.Memory)/minMemoryTier)))
+		rounded.Memory = int64(max(tier, 1)) * minMemoryTier
+	}
+	return &rounded
+}
+
+// isolatedBuilder returns the docker-container buildx builder that enforces the cpu
+// and memory limits (see ProfileLimits) and is attached to the proxy network. There is
+// one builder per profile, it's created the first time it's needed and kept to reuse
+// its cache until it's idle (see RemoveIdleBuilders). The caller must call release
+// once the build is done
+func isolatedBuilder(ctx context.Context, limits *builders.Limits, network *builders.Network) (name string, release func(), err error) {
+	var driverOpts []string
+	if limits != nil && limits.Memory > 0 {
+		memory := strconv.FormatInt(limits.Memory, 10)
+		// same value for memory-swap, so the build can't swap
+		driverOpts = append(driverOpts, "memory="+memory, "memory-swap="+memory)
+	}
+	if limits != nil && limits.CPUs > 0 {
+		driverOpts = append(driverOpts,
+			"cpu-period="+strconv.Itoa(cpuPeriod),
+			"cpu-quota="+strconv.FormatInt(int64(limits.CPUs*cpuPeriod), 10))
+	}
+	if network.Isolated() && network.Mode == builders.NetworkProxy {
+		driverOpts = append(driverOpts, "network="+network.ProxyNetwork)
+		// buildkitd pulls the base images through the proxy too
+		proxyEnv := network.ProxyEnv()
+		for _, k := range SortedKeys(proxyEnv) {
+			driverOpts = append(driverOpts, "env."+k+"="+proxyEnv[k])
+		}
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(driverOpts, "\n")))
+	name = isolatedBuilderPrefix + hex.EncodeToString(hash[:])[:12]
+
+	// the builder can't be removed while it's used
+	isolatedBuilders.Lock()
+	defer isolatedBuilders.Unlock()
+	release = func() {
+		isolatedBuilders.Lock()
+		defer isolatedBuilders.Unlock()
+		isolatedBuilders.running[name]--
+		isolatedBuilders.used[name] = time.Now()
+	}
+	if exec.CommandContext(ctx, "docker", "buildx", "inspect", name).Run() == nil {
+		isolatedBuilders.running[name]++
+		return name, release, nil
+	}
+
+	args := []string{"buildx", "create", "--name", name, "--driver", "docker-container", "--bootstrap"}
+	for _, opt := range driverOpts {
+		args = append(args, "--driver-opt", opt)
+	}
+	out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	// another replica may have created it in the meantime
+	if err != nil && exec.CommandContext(ctx, "docker", "buildx", "inspect", name).Run() != nil {
+		return "", nil, fmt.Errorf("unable to create the buildx builder %s: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	isolatedBuilders.running[name]++
+	return name, release, nil
+}
+
+// RemoveIdleBuilders removes the isolated builders, with their cache, that were not
+// used in the last idle. The builders of the running builds are never removed
+func RemoveIdleBuilders(ctx context.Context, idle time.Duration) ([]string, error) {
+	names, err := IsolatedBuilders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	isolatedBuilders.Lock()
+	defer isolatedBuilders.Unlock()
+	var removed []string
+	for _, name := range names {
+		used, ok := isolatedBuilders.used[name]
+		if !ok {
+			used = isolatedBuilders.started
+		}
+		if isolatedBuilders.running[name] > 0 || time.Since(used) < idle {
+			continue
+		}
+		if _, err := runDocker(ctx, "buildx", "rm", name); err != nil {
+			return removed, err
+		}
+		delete(isolatedBuilders.used, name)
+		delete(isolatedBuilders.running, name)
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// IsolatedBuilders returns the names of the isolated builders, the ones created by
+// previous runs of the service too
+func IsolatedBuilders(ctx context.Context) ([]string, error) {
+	out, err := runDocker(ctx, "buildx", "ls")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		// the nodes of a builder are listed under it, indented
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(line, isolatedBuilderPrefix) {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(fields[0], "*"))
+	}
+	return names, nil
+}
@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/ipaas-org/image-builder/providers/builders"
+)
+
+// OOMError replaces the error of a step killed by the oom killer with the memory limit error
+func OOMError(err error, limits *builders.Limits) error {
+	if limits == nil || limits.Memory == 0 {
+		return err
+	}
+	var stepErr *builders.BuildError
+	if errors.As(err, &stepErr) && strings.Contains(stepErr.Message, "exit code: 137") {
+		return builders.MemoryLimitError(limits)
+	}
+	return err
+}
+
+// ImageSize returns the size in bytes of an image in the daemon
+func ImageSize(ctx context.Context, imageID string) (int64, error) {
+	out, err := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{.Size}}", imageID).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// BuilderDiskUsage returns the disk used by the buildkit state of the buildx builder,
+// layers, cache mounts and the files written by the steps. It's what `docker buildx du`
+// reports as total
+func BuilderDiskUsage(builder string) builders.DiskUsage {
+	return func(ctx context.Context) (int64, error) {
+		out, err := exec.CommandContext(ctx, "docker", "buildx", "du", "--builder", builder).Output()
+		if err != nil {
+			return 0, err
+		}
+		return ParseDiskUsage(out)
+	}
+}
+
+// ParseDiskUsage returns the total of the output of `docker buildx du`
+func ParseDiskUsage(out []byte) (int64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		total, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "Total:")
+		if !ok {
+			continue
+		}
+		size, err := units.FromHumanSize(strings.TrimSpace(total))
+		if err != nil {
+			return 0, fmt.Errorf("invalid disk usage %q: %w", total, err)
+		}
+		return size, nil
+	}
+	// an empty builder has no records and no total
+	return 0, nil
+}
@@ -0,0 +1,156 @@
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+)
+
+// SolveStatus is a single message of the buildkit progress stream (--progress rawjson),
+// it mirrors github.com/moby/buildkit/client.SolveStatus with only the fields we use
+type SolveStatus struct {
+	Vertexes []*SolveVertex    `json:"vertexes"`
+	Statuses []*SolveStatusMsg `json:"statuses"`
+	Logs     []*SolveLog       `json:"logs"`
+	Warnings []*SolveWarning   `json:"warnings"`
+}
+
+type SolveVertex struct {
+	Digest    string     `json:"digest"`
+	Name      string     `json:"name"`
+	Started   *time.Time `json:"started"`
+	Completed *time.Time `json:"completed"`
+	Cached    bool       `json:"cached"`
+	Error     string     `json:"error"`
+}
+
+type SolveStatusMsg struct {
+	ID        string     `json:"id"`
+	Vertex    string     `json:"vertex"`
+	Name      string     `json:"name"`
+	Total     int64      `json:"total"`
+	Current   int64      `json:"current"`
+	Completed *time.Time `json:"completed"`
+}
+
+type SolveLog struct {
+	Vertex string `json:"vertex"`
+	Stream int    `json:"stream"`
+	Data   []byte `json:"data"`
+}
+
+type SolveWarning struct {
+	Vertex string `json:"vertex"`
+	Short  []byte `json:"short"`
+}
+
+// ProgressPrinter converts the buildkit progress stream to the same
+// readable format of `--progress plain`, steps are numbered in order of appearance
+type ProgressPrinter struct {
+	w        io.Writer
+	index    map[string]int
+	vertexes map[string]*SolveVertex
+	partial  map[string]string
+	errors   []*builders.BuildError
+}
+
+func NewProgressPrinter(w io.Writer) *ProgressPrinter {
+	return &ProgressPrinter{
+		w:        w,
+		index:    make(map[string]int),
+		vertexes: make(map[string]*SolveVertex),
+		partial:  make(map[string]string),
+	}
+}
+
+// Errors returns the errors of the failed steps, in order of appearance
+func (p *ProgressPrinter) Errors() []*builders.BuildError {
+	return p.errors
+}
+
+// Consume reads the whole progress stream, it returns when r is closed
+func (p *ProgressPrinter) Consume(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 8*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		status := new(SolveStatus)
+		if err := json.Unmarshal(line, status); err != nil {
+			// the cli writes plain text when it fails before starting the solve
+			fmt.Fprintf(p.w, "%s\n", line)
+			continue
+		}
+		p.print(status)
+	}
+	return scanner.Err()
+}
+
+func (p *ProgressPrinter) step(digest string) int {
+	i, ok := p.index[digest]
+	if !ok {
+		i = len(p.index) + 1
+		p.index[digest] = i
+	}
+	return i
+}
+
+func (p *ProgressPrinter) print(status *SolveStatus) {
+	for _, v := range status.Vertexes {
+		old, seen := p.vertexes[v.Digest]
+		p.vertexes[v.Digest] = v
+		i := p.step(v.Digest)
+		if !seen || (old.Started == nil && v.Started != nil) {
+			if v.Started != nil || v.Cached {
+				fmt.Fprintf(p.w, "#%d %s\n", i, v.Name)
+			}
+		}
+		if v.Cached && (!seen || !old.Cached) {
+			fmt.Fprintf(p.w, "#%d CACHED\n", i)
+		}
+		if v.Error != "" && (!seen || old.Error == "") {
+			p.flush(v.Digest)
+			fmt.Fprintf(p.w, "#%d ERROR: %s\n", i, v.Error)
+			p.errors = append(p.errors, &builders.BuildError{Step: v.Name, Message: v.Error})
+		} else if v.Completed != nil && v.Started != nil && (!seen || old.Completed == nil) && !v.Cached {
+			p.flush(v.Digest)
+			fmt.Fprintf(p.w, "#%d DONE %.1fs\n", i, v.Completed.Sub(*v.Started).Seconds())
+		}
+	}
+
+	for _, s := range status.Statuses {
+		if s.Completed == nil {
+			continue
+		}
+		name := s.Name
+		if name == "" {
+			name = s.ID
+		}
+		fmt.Fprintf(p.w, "#%d %s done\n", p.step(s.Vertex), name)
+	}
+
+	for _, l := range status.Logs {
+		text := p.partial[l.Vertex] + string(l.Data)
+		lines := strings.Split(text, "\n")
+		// the last element is an incomplete line, keep it until the next chunk
+		p.partial[l.Vertex] = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			fmt.Fprintf(p.w, "#%d %s\n", p.step(l.Vertex), strings.TrimRight(line, "\r"))
+		}
+	}
+
+	for _, w := range status.Warnings {
+		fmt.Fprintf(p.w, "#%d WARN: %s\n", p.step(w.Vertex), strings.TrimSpace(string(w.Short)))
+	}
+}
+
+func (p *ProgressPrinter) flush(digest string) {
+	if rest := p.partial[digest]; rest != "" {
+		fmt.Fprintf(p.w, "#%d %s\n", p.step(digest), rest)
+		delete(p.partial, digest)
+	}
+}
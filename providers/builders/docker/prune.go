@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RemoveImage removes an image from the daemon with all its tags, the ones added to
+// push it included. Nothing is done if the image doesn't exist
+func RemoveImage(ctx context.Context, imageID string) error {
+	out, err := runDocker(ctx, "image", "inspect", "--format", "{{.Id}}", imageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such image") || strings.Contains(err.Error(), "No such object") {
+			return nil
+		}
+		return err
+	}
+	_, err = runDocker(ctx, "image", "rm", "--force", strings.TrimSpace(out))
+	return err
+}
+
+// PruneBuildCache removes the build cache and the dangling images that were not used
+// in the last olderThan, the cache of the running builds is never removed. The cache
+// of the default builder, of the isolated builders and of the other buildx builders
+// (the multi-platform one) are pruned, each builder has its own buildkit state
+func PruneBuildCache(ctx context.Context, olderThan time.Duration, buildxBuilders ...string) error {
+	until := "until=" + olderThan.String()
+	if _, err := runDocker(ctx, "builder", "prune", "--force", "--filter", until); err != nil {
+		return err
+	}
+	isolated, err := IsolatedBuilders(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range append(isolated, buildxBuilders...) {
+		if name == "" {
+			continue
+		}
+		if _, err := runDocker(ctx, "buildx", "prune", "--builder", name, "--force", "--filter", until); err != nil {
+			return err
+		}
+	}
+	_, err = runDocker(ctx, "image", "prune", "--force", "--filter", until)
+	return err
+}
+
+// PruneBuildCache prunes the build cache of the daemon and of the buildx builders
+// used by the builder, see PruneBuildCache
+func (b DockerBuilder) PruneBuildCache(ctx context.Context, olderThan time.Duration) error {
+	return PruneBuildCache(ctx, olderThan, b.multiPlatformBuilder)
+}
+
+func runDocker(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", strings.Join(args[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
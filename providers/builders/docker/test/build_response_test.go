@@ -0,0 +1,40 @@
+package docker_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
+	"gotest.tools/assert"
+)
+
+const legacyBuild = `{"stream":"Step 1/2 : FROM alpine"}
+{"stream":"\n"}
+{"status":"Pulling fs layer","id":"a1"}
+{"status":"Downloading","progressDetail":{"current":10,"total":100},"progress":"[=>   ] 10B/100B","id":"a1"}
+{"status":"Downloading","progressDetail":{"current":50,"total":100},"progress":"[===> ] 50B/100B","id":"a1"}
+{"status":"Pull complete","id":"a1"}
+{"stream":" ---> 8ca4688f4f35\n"}
+{"stream":"Step 2/2 : RUN exit 1"}
+{"errorDetail":{"message":"The command '/bin/sh -c exit 1' returned a non-zero code: 1"},"error":"The command '/bin/sh -c exit 1' returned a non-zero code: 1"}
+`
+
+func TestConvertOutput(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := docker.ConvertOutput(strings.NewReader(legacyBuild), out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"Step 1/2 : FROM alpine",
+		"‣  a1:  Pulling fs layer",
+		"‣  a1:  Downloading",
+		"‣  a1:  Pull complete",
+		"---> 8ca4688f4f35",
+		"Step 2/2 : RUN exit 1",
+		"[ERROR] The command '/bin/sh -c exit 1' returned a non-zero code: 1",
+		"",
+	}, "\n")
+	assert.Equal(t, out.String(), expected)
+}
@@ -0,0 +1,71 @@
+package docker_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
+	"gotest.tools/assert"
+)
+
+func TestOOMError(t *testing.T) {
+	killed := &builders.BuildError{
+		Step:    "[3/4] RUN npm run build",
+		Message: `process "/bin/sh -c npm run build" did not complete successfully: exit code: 137`,
+	}
+	limits := &builders.Limits{Memory: 512 * 1024 * 1024}
+
+	err := docker.OOMError(killed, limits)
+	assert.Assert(t, errors.Is(err, builders.ErrLimitExceeded))
+	assert.Error(t, err, "the build exceeded the memory limit of 512MB")
+
+	// without a memory limit the step failed on its own
+	assert.Equal(t, docker.OOMError(killed, &builders.Limits{CPUs: 1}), error(killed))
+	assert.Equal(t, docker.OOMError(killed, nil), error(killed))
+
+	failed := &builders.BuildError{Step: "[3/4] RUN make", Message: "exit code: 2"}
+	assert.Equal(t, docker.OOMError(failed, limits), error(failed))
+}
+
+func TestParseDiskUsage(t *testing.T) {
+	out := `ID                                              RECLAIMABLE     SIZE            LAST ACCESSED
+k2f0mlnkh1owg2ctsp8trgqp4                       true            1.52GB          2 minutes ago
+x1dm3ptmiyzdnqrm6mdxl2ugf*                      true            12.3MB          2 minutes ago
+Shared:         0B
+Private:        1.53GB
+Reclaimable:    1.53GB
+Total:          1.53GB
+`
+	size, err := docker.ParseDiskUsage([]byte(out))
+	assert.NilError(t, err)
+	assert.Equal(t, size, int64(1530000000))
+
+	// an empty builder
+	size, err = docker.ParseDiskUsage(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, size, int64(0))
+
+	_, err = docker.ParseDiskUsage([]byte("Total: many"))
+	assert.ErrorContains(t, err, "invalid disk usage")
+}
+
+func TestProfileLimits(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		limits   builders.Limits
+		expected builders.Limits
+	}{
+		{builders.Limits{CPUs: 0.3, Memory: 100 * mb}, builders.Limits{CPUs: 0.5, Memory: 256 * mb}},
+		{builders.Limits{CPUs: 1, Memory: 512 * mb}, builders.Limits{CPUs: 1, Memory: 512 * mb}},
+		{builders.Limits{CPUs: 1.5, Memory: 700 * mb}, builders.Limits{CPUs: 2, Memory: 1024 * mb}},
+		{builders.Limits{CPUs: 3, Memory: 3000 * mb}, builders.Limits{CPUs: 4, Memory: 4096 * mb}},
+		// disk and timeout don't change the builder
+		{builders.Limits{Disk: 10 * mb, Timeout: time.Minute}, builders.Limits{Disk: 10 * mb, Timeout: time.Minute}},
+	}
+	for _, tt := range tests {
+		assert.DeepEqual(t, *docker.ProfileLimits(&tt.limits), tt.expected)
+	}
+	assert.Assert(t, docker.ProfileLimits(nil) == nil)
+}
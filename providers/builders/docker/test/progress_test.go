@@ -0,0 +1,53 @@
+package docker_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
+	"gotest.tools/assert"
+)
+
+// rawjson stream of a build where the second step fails, the log data is base64 encoded
+const failedBuild = `{"vertexes":[{"digest":"sha256:a","name":"[1/2] FROM docker.io/library/alpine","started":"2024-01-01T00:00:00Z","completed":"2024-01-01T00:00:01Z","cached":true}]}
+{"vertexes":[{"digest":"sha256:b","name":"[2/2] RUN make","started":"2024-01-01T00:00:01Z"}]}
+{"logs":[{"vertex":"sha256:b","stream":1,"data":"bWFrZTogKioqIE5vIHRh"}]}
+{"logs":[{"vertex":"sha256:b","stream":1,"data":"cmdldHMuICBTdG9wLgo="}]}
+{"vertexes":[{"digest":"sha256:b","name":"[2/2] RUN make","started":"2024-01-01T00:00:01Z","completed":"2024-01-01T00:00:02Z","error":"process \"/bin/sh -c make\" did not complete successfully: exit code: 2"}]}
+`
+
+func TestProgressPrinter(t *testing.T) {
+	out := new(bytes.Buffer)
+	p := docker.NewProgressPrinter(out)
+	if err := p.Consume(strings.NewReader(failedBuild)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"#1 [1/2] FROM docker.io/library/alpine",
+		"#1 CACHED",
+		"#2 [2/2] RUN make",
+		"#2 make: *** No targets.  Stop.",
+		`#2 ERROR: process "/bin/sh -c make" did not complete successfully: exit code: 2`,
+		"",
+	}, "\n")
+	assert.Equal(t, out.String(), expected)
+
+	stepErrors := p.Errors()
+	assert.Equal(t, len(stepErrors), 1)
+	assert.Equal(t, stepErrors[0].Step, "[2/2] RUN make")
+	assert.Assert(t, errors.Is(stepErrors[0], builders.ErrImageNotCompiled))
+}
+
+func TestProgressPrinterPlainText(t *testing.T) {
+	out := new(bytes.Buffer)
+	p := docker.NewProgressPrinter(out)
+	if err := p.Consume(strings.NewReader("ERROR: docker endpoint not found\n")); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out.String(), "ERROR: docker endpoint not found\n")
+	assert.Equal(t, len(p.Errors()), 0)
+}
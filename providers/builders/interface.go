@@ -3,20 +3,86 @@ package builders
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ipaas-org/image-builder/model"
 )
 
 type Plan string
 
+// OCILayoutPrefix prefixes the image ids of the images that are not in the docker
+// daemon (multi-platform or built without a daemon), they are left as an oci layout on disk
+const OCILayoutPrefix = "oci-layout:"
+
+// BuildOptions contains the per-build values that are not part of the plan,
+// the plan is logged and sent back to the user so it can't contain secrets
+type BuildOptions struct {
+	Secrets   []model.KeyValue
+	Platforms []string
+	// nil if the build must not use the cache of the application
+	Cache *Cache
+	// nil if the build is unlimited, the timeout and the disk limit
+	// are enforced by the caller for every builder
+	Limits *Limits
+	// nil if the build can use the network freely
+	Network *Network
+	// nil if the build can pull any base image from its registry
+	BaseImages *BaseImages
+	// where the builders write the output of the build, see Output
+	Log *Log
+}
+
+// Output returns the log of the build, a new one is created if it was not set.
+// The builders that delegate to another builder pass the same log to it
+func (o *BuildOptions) Output() *Log {
+	if o.Log == nil {
+		o.Log = NewLog(nil, 0)
+	}
+	return o.Log
+}
+
+// OCILayoutPath returns the path of the oci layout if the image id points to one
+func OCILayoutPath(imageID string) (string, bool) {
+	if !strings.HasPrefix(imageID, OCILayoutPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(imageID, OCILayoutPrefix), true
+}
+
 type Builder interface {
 	Plan(ctx context.Context, config *model.BuildConfig, path string) (plan Plan, err error)
-	Build(ctx context.Context, userID, repo, path string, plan Plan) (imageName string, imageOutput []byte, err error)
+	// imageOutput is the content of the log of the build (see BuildOptions.Output)
+	Build(ctx context.Context, userID, repo, path string, plan Plan, opt BuildOptions) (imageName string, imageOutput []byte, err error)
+}
+
+// CachePruner is implemented by the builders that know every build cache they leave on the host
+type CachePruner interface {
+	PruneBuildCache(ctx context.Context, olderThan time.Duration) error
+}
+
+// BuildError is returned when a step of the build failed, it's caused by the
+// user's code or config so it always wraps ErrImageNotCompiled
+type BuildError struct {
+	Step    string
+	Message string
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Step, e.Message)
+}
+
+func (e *BuildError) Unwrap() error {
+	return ErrImageNotCompiled
 }
 
 var (
-	ErrMissingConfig    = fmt.Errorf("missing config")
-	ErrInvalidConfig    = fmt.Errorf("invalid config")
-	ErrInvalidPlan      = fmt.Errorf("invalid plan")
-	ErrImageNotCompiled = fmt.Errorf("image not compiled")
+	ErrMissingConfig       = fmt.Errorf("missing config")
+	ErrInvalidConfig       = fmt.Errorf("invalid config")
+	ErrInvalidPlan         = fmt.Errorf("invalid plan")
+	ErrImageNotCompiled    = fmt.Errorf("image not compiled")
+	ErrUnsupportedPlatform = fmt.Errorf("unsupported platform")
+	ErrUnsupportedLimits   = fmt.Errorf("unsupported limits")
+	ErrLimitExceeded       = fmt.Errorf("limit exceeded")
+	ErrMissingProxyNetwork = fmt.Errorf("proxy network mode requested but no proxy network is configured")
 )
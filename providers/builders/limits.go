@@ -0,0 +1,127 @@
+package builders
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// DiskCheckInterval is how often the disk used by a running build is checked, see WatchDisk
+var DiskCheckInterval = 5 * time.Second
+
+// Limits are the resources a single build can use, zero values mean unlimited
+type Limits struct {
+	CPUs   float64
+	Memory int64 // bytes
+	// bytes, max size of the build context (checked before the build), of the disk
+	// written while the build runs (see WatchDisk) and of the built image (checked after it)
+	Disk    int64
+	Timeout time.Duration
+}
+
+// Constrained reports if cpu, memory or disk are limited, the builders
+// need an isolated build environment to enforce them
+func (l *Limits) Constrained() bool {
+	return l != nil && (l.CPUs > 0 || l.Memory > 0 || l.Disk > 0)
+}
+
+// DiskUsage returns the disk used by the environment a build runs in
+type DiskUsage func(ctx context.Context) (int64, error)
+
+// WatchDisk checks the disk used while the build runs, cancel is called (to kill
+// the build) once the usage grew more than the disk limit since the watch started.
+// The returned function stops the watch and reports if the limit was exceeded.
+// Nothing is watched without a disk limit
+func WatchDisk(ctx context.Context, limits *Limits, usage DiskUsage, cancel context.CancelFunc) (func() bool, error) {
+	if limits == nil || limits.Disk == 0 {
+		return func() bool { return false }, nil
+	}
+	initial, err := usage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the disk usage of the build: %w", err)
+	}
+
+	var exceeded atomic.Bool
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(DiskCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				// a failed check is retried at the next tick
+				used, err := usage(ctx)
+				if err == nil && used-initial > limits.Disk {
+					exceeded.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return func() bool {
+		close(done)
+		<-stopped
+		return exceeded.Load()
+	}, nil
+}
+
+// LimitError is returned when a build exceeds one of its limits,
+// it's caused by the user's code so it's a user fault
+type LimitError struct {
+	Limit string // cpu, memory, disk or timeout
+	Value string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("the build exceeded the %s limit of %s", e.Limit, e.Value)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+func MemoryLimitError(l *Limits) *LimitError {
+	return &LimitError{Limit: "memory", Value: FormatBytes(l.Memory)}
+}
+
+func DiskLimitError(l *Limits) *LimitError {
+	return &LimitError{Limit: "disk", Value: FormatBytes(l.Disk)}
+}
+
+func TimeoutLimitError(l *Limits) *LimitError {
+	return &LimitError{Limit: "timeout", Value: l.Timeout.String()}
+}
+
+// FormatBytes formats the size in MB, the unit the limits are configured with
+func FormatBytes(size int64) string {
+	return fmt.Sprintf("%dMB", size/1024/1024)
+}
+
+// DirSize returns the size of the regular files in the directory
+func DirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,163 @@
+package builders
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLogSize is the size of the stored log when none is set
+	DefaultLogSize = 1024 * 1024
+	// LogTimeFormat is the format of the timestamp at the start of each line
+	LogTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+var (
+	// CSI sequences (colors, cursor movements, line clears) and OSC sequences (titles, links)
+	ansiRegex      = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+	timestampRegex = regexp.MustCompile(`(?m)^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z `)
+)
+
+// Log is the output of a build, the builders write the output of the tools
+// in it as they run. Each line is stripped of the terminal control codes,
+// timestamped and written to the sink as soon as it's complete.
+// Only the first and the last lines are kept in memory: once the stored size
+// exceeds the max the lines in the middle are dropped and replaced by a marker.
+// It's safe to write it from multiple goroutines
+type Log struct {
+	mu   sync.Mutex
+	sink io.Writer
+	// half of the max size goes to the head, the rest to the tail
+	maxHead int
+	maxTail int
+
+	head     bytes.Buffer
+	tail     [][]byte
+	tailSize int
+	dropped  int
+	partial  []byte
+
+	now func() time.Time
+}
+
+// NewLog returns a log that streams the lines to sink, if not nil, and
+// stores up to maxSize bytes of them, DefaultLogSize is used if maxSize is not positive
+func NewLog(sink io.Writer, maxSize int) *Log {
+	if maxSize <= 0 {
+		maxSize = DefaultLogSize
+	}
+	return &Log{
+		sink:    sink,
+		maxHead: maxSize / 2,
+		maxTail: maxSize - maxSize/2,
+		now:     time.Now,
+	}
+}
+
+// SetClock changes the source of the timestamps, used in the tests
+func (l *Log) SetClock(now func() time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.now = now
+}
+
+func (l *Log) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data := append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		l.writeLine(data[:i])
+		data = data[i+1:]
+	}
+	l.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// Flush writes the last line even if it's not terminated by a newline
+func (l *Log) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.partial) > 0 {
+		l.writeLine(l.partial)
+		l.partial = nil
+	}
+}
+
+// Bytes flushes the log and returns the stored lines
+func (l *Log) Bytes() []byte {
+	l.Flush()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := bytes.NewBuffer(make([]byte, 0, l.head.Len()+l.tailSize+64))
+	out.Write(l.head.Bytes())
+	if l.dropped > 0 {
+		fmt.Fprintf(out, "[... %d lines truncated ...]\n", l.dropped)
+	}
+	for _, line := range l.tail {
+		out.Write(line)
+	}
+	return out.Bytes()
+}
+
+// Truncated reports if some of the lines were dropped
+func (l *Log) Truncated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped > 0
+}
+
+func (l *Log) writeLine(raw []byte) {
+	line := CleanLine(raw)
+	stamped := make([]byte, 0, len(LogTimeFormat)+len(line)+2)
+	stamped = l.now().UTC().AppendFormat(stamped, LogTimeFormat)
+	stamped = append(stamped, ' ')
+	stamped = append(stamped, line...)
+	stamped = append(stamped, '\n')
+
+	if l.sink != nil {
+		// a broken sink must not fail the build, the log is still stored
+		if _, err := l.sink.Write(stamped); err != nil {
+			l.sink = nil
+		}
+	}
+
+	if l.dropped == 0 && len(l.tail) == 0 && l.head.Len()+len(stamped) <= l.maxHead {
+		l.head.Write(stamped)
+		return
+	}
+	l.tail = append(l.tail, stamped)
+	l.tailSize += len(stamped)
+	for l.tailSize > l.maxTail && len(l.tail) > 0 {
+		l.tailSize -= len(l.tail[0])
+		l.tail[0] = nil
+		l.tail = l.tail[1:]
+		l.dropped++
+	}
+}
+
+// CleanLine converts a line written for a terminal to plain text: the control
+// codes are removed and, since a carriage return moves the cursor back to
+// redraw the line (like progress bars do), only the text after the last one is kept
+func CleanLine(line []byte) []byte {
+	line = bytes.TrimRight(line, "\r")
+	if i := bytes.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	return ansiRegex.ReplaceAll(line, nil)
+}
+
+// StripTimestamps removes the timestamps added by the log, used to
+// parse the output of the tools
+func StripTimestamps(output []byte) []byte {
+	return timestampRegex.ReplaceAll(output, nil)
+}
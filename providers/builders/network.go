@@ -0,0 +1,73 @@
+package builders
+
+import (
+	"fmt"
+	"strings"
+)
+
+type NetworkMode string
+
+const (
+	// no network in the build steps
+	NetworkNone NetworkMode = "none"
+	// the build steps can only reach the proxy
+	NetworkProxy NetworkMode = "proxy"
+	// no restrictions
+	NetworkFull NetworkMode = "full"
+)
+
+// ParseNetworkMode validates the mode, empty is full
+func ParseNetworkMode(mode string) (NetworkMode, error) {
+	switch m := NetworkMode(mode); m {
+	case NetworkNone, NetworkProxy, NetworkFull:
+		return m, nil
+	case "":
+		return NetworkFull, nil
+	}
+	return "", fmt.Errorf("%w: invalid network mode %q", ErrInvalidConfig, mode)
+}
+
+// Network is the network policy of a build
+type Network struct {
+	Mode NetworkMode
+	// docker network where the proxy is the only way out, the build steps
+	// are attached to it in proxy mode. Required by the proxy mode
+	ProxyNetwork string
+	HTTPProxy    string
+	HTTPSProxy   string
+	NoProxy      string
+}
+
+// Isolated reports if the build can't use the network freely
+func (n *Network) Isolated() bool {
+	return n != nil && n.Mode != "" && n.Mode != NetworkFull
+}
+
+// Check returns ErrMissingProxyNetwork in proxy mode without a proxy network, the
+// proxy env alone doesn't stop the tools that ignore it from reaching the internet
+func (n *Network) Check() error {
+	if n != nil && n.Mode == NetworkProxy && n.ProxyNetwork == "" {
+		return ErrMissingProxyNetwork
+	}
+	return nil
+}
+
+// ProxyEnv returns the proxy variables to inject in the build, in both
+// cases since every tool reads a different one. Empty if not in proxy mode
+func (n *Network) ProxyEnv() map[string]string {
+	env := make(map[string]string)
+	if n == nil || n.Mode != NetworkProxy {
+		return env
+	}
+	for k, v := range map[string]string{
+		"HTTP_PROXY":  n.HTTPProxy,
+		"HTTPS_PROXY": n.HTTPSProxy,
+		"NO_PROXY":    n.NoProxy,
+	} {
+		if v != "" {
+			env[k] = v
+			env[strings.ToLower(k)] = v
+		}
+	}
+	return env
+}
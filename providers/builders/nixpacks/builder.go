@@ -2,10 +2,16 @@ package nixpacks
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/ipaas-org/image-builder/model"
 	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
 	nixpacks "github.com/vano2903/nixpacks-go"
 )
 
@@ -15,12 +21,28 @@ var _ builders.Builder = new(NixPackBuilder)
 
 type NixPackBuilder struct {
 	builderVersion string
+	docker         docker.DockerfileBuilder
+	generateOnly   bool
 }
 
-func NewNixPackBuilder(builderVersion string) *NixPackBuilder {
-	return &NixPackBuilder{
+type NixPackBuilderOptions struct {
+	// builder used to build the Dockerfile generated by nixpacks
+	// for multi-platform images, nixpacks can only build for one platform
+	Docker docker.DockerfileBuilder
+	// never let nixpacks build the image, it only generates the Dockerfile
+	// that is then built by Docker. Needed when there is no docker daemon
+	GenerateOnly bool
+}
+
+func NewNixPackBuilder(builderVersion string, opt ...NixPackBuilderOptions) *NixPackBuilder {
+	b := &NixPackBuilder{
 		builderVersion: builderVersion,
 	}
+	if len(opt) > 0 {
+		b.docker = opt[0].Docker
+		b.generateOnly = opt[0].GenerateOnly
+	}
+	return b
 }
 
 func convertBuildConfigEnvsToNixpacksEnvs(envs []model.KeyValue) []nixpacks.Env {
@@ -35,24 +57,27 @@ func convertBuildConfigEnvsToNixpacksEnvs(envs []model.KeyValue) []nixpacks.Env
 }
 
 func (b NixPackBuilder) Plan(ctx context.Context, config *model.BuildConfig, path string) (builders.Plan, error) {
+	if err := ValidateConfig(config); err != nil {
+		return "", err
+	}
+
 	n, err := nixpacks.NewNixpacks()
 	if err != nil {
 		return "", err
 	}
 
+	// install and build commands are lists, they are set in the generated plan
 	opt := nixpacks.PlanOptions{
 		Path: path,
 		Envs: convertBuildConfigEnvsToNixpacksEnvs(config.Envs),
 
 		Config: config.NixpacksPath,
 
-		NixPackages: config.NixPkgs,
-		// NixLibraries: config.NixLibs,
-		AptPackages: config.AptPkgs,
+		NixPackages:  config.NixPkgs,
+		NixLibraries: config.NixLibs,
+		AptPackages:  config.AptPkgs,
 
-		InstallCommand: config.InstallCommand,
-		BuildCommand:   config.BuildCommand,
-		StartCommand:   config.StartCommand,
+		StartCommand: config.StartCommand,
 	}
 
 	planCmd := n.Plan(ctx, opt)
@@ -65,17 +90,38 @@ func (b NixPackBuilder) Plan(ctx context.Context, config *model.BuildConfig, pat
 		return "", err
 	}
 
-	return builders.Plan(plan.Response), nil
+	jsonPlan, err := ApplyConfig(plan.Response, config)
+	if err != nil {
+		return "", err
+	}
+	builderPlan, err := json.Marshal(&NixPackBuilderConfig{
+		Plan:     jsonPlan,
+		CacheKey: config.CacheKey,
+		NoCache:  config.NoCache,
+	})
+	if err != nil {
+		return "", err
+	}
+	return builders.Plan(builderPlan), nil
 }
 
 // first string is the image name, second is build output
-func (b NixPackBuilder) Build(ctx context.Context, userID, repo, path string, plan builders.Plan) (string, []byte, error) {
-	n, err := nixpacks.NewNixpacks()
-	if err != nil {
-		return "", nil, err
+func (b NixPackBuilder) Build(ctx context.Context, userID, repo, path string, plan builders.Plan, opt builders.BuildOptions) (string, []byte, error) {
+	config := new(NixPackBuilderConfig)
+	if err := json.Unmarshal([]byte(plan), config); err != nil || len(config.Plan) == 0 {
+		return "", nil, builders.ErrInvalidPlan
+	}
+	if config.NoCache {
+		opt.Cache = nil
+	}
+
+	// nixpacks can't limit the resources and the network of its docker build nor check
+	// its base images, the Dockerfile builder can
+	if len(opt.Platforms) > 1 || b.generateOnly || opt.Limits.Constrained() || opt.Network.Isolated() || opt.BaseImages.Enabled() {
+		return b.buildGenerated(ctx, userID, repo, path, config, opt)
 	}
 
-	buildCmd, err := n.Build(ctx, nixpacks.BuildOptions{
+	build, err := runBuild(ctx, nixpacks.BuildOptions{
 		Labels: []nixpacks.Label{
 			{
 				Key:   "org.ipaas.image-builder.version",
@@ -98,12 +144,106 @@ func (b NixPackBuilder) Build(ctx context.Context, userID, repo, path string, pl
 			},
 		},
 		Path:     path,
-		JsonPlan: string(plan),
-	})
+		JsonPlan: string(config.Plan),
+		Platform: strings.Join(opt.Platforms, ","),
+		NoCache:  config.NoCache,
+	}, cacheArgs(config, opt.Cache, true), opt.Output())
+	return build.ImageName, build.Response, err
+}
+
+// buildGenerated makes nixpacks generate the Dockerfile and the build context
+// without building them, the image is then built by the Dockerfile builder
+func (b NixPackBuilder) buildGenerated(ctx context.Context, userID, repo, path string, config *NixPackBuilderConfig, opt builders.BuildOptions) (string, []byte, error) {
+	if b.docker == nil {
+		return "", nil, builders.ErrUnsupportedPlatform
+	}
+
+	outDir, err := os.MkdirTemp("", "ipaas-nixpacks-*")
 	if err != nil {
 		return "", nil, err
 	}
+	defer os.RemoveAll(outDir)
 
-	build, err := buildCmd.Result()
-	return build.ImageName, build.Response, err
+	// the cache key and no-cache change the cache mounts of the generated Dockerfile
+	generated, err := runBuild(ctx, nixpacks.BuildOptions{
+		Path:     path,
+		JsonPlan: string(config.Plan),
+		Output:   outDir,
+		NoCache:  config.NoCache,
+	}, cacheArgs(config, opt.Cache, false), opt.Output())
+	if err != nil {
+		return "", generated.Response, err
+	}
+
+	dockerConfig, err := DockerfileConfig(config)
+	if err != nil {
+		return "", nil, err
+	}
+	// the Dockerfile builder writes in the same log, after the output of nixpacks
+	imageID, _, err := b.docker.BuildDockerfile(ctx, NixPackBuilderKind, userID, repo, outDir, dockerConfig, opt)
+	return imageID, opt.Output().Bytes(), err
+}
+
+// DockerfileConfig returns the config of the Dockerfile generated from the plan, the
+// Dockerfile declares the variables of the plan as args and copies them in the envs
+// of the image, so their values are passed as build args
+func DockerfileConfig(config *NixPackBuilderConfig) (*docker.DockerBuilderConfig, error) {
+	var plan struct {
+		Variables map[string]string `json:"variables"`
+	}
+	if err := json.Unmarshal(config.Plan, &plan); err != nil {
+		return nil, builders.ErrInvalidPlan
+	}
+	return &docker.DockerBuilderConfig{
+		DockerFilePath: filepath.Join(".nixpacks", "Dockerfile"),
+		Args:           plan.Variables,
+	}, nil
+}
+
+// cacheArgs returns the cache flags not supported by nixpacks-go, the cache key of
+// the config takes precedence over the one of the application.
+// When nixpacks only generates the Dockerfile the image cache is handled by the Dockerfile builder
+func cacheArgs(config *NixPackBuilderConfig, cache *builders.Cache, build bool) []string {
+	var args []string
+	key := config.CacheKey
+	if key == "" && cache != nil {
+		key = cache.Key
+	}
+	if key != "" {
+		args = append(args, "--cache-key", key)
+	}
+	if build && cache != nil && !config.NoCache {
+		for _, ref := range cache.From {
+			args = append(args, "--cache-from", ref)
+		}
+		args = append(args, "--inline-cache")
+	}
+	return args
+}
+
+// runBuild runs nixpacks build like nixpacks-go does, it's needed
+// since nixpacks-go doesn't support the cache flags yet and it only
+// returns the output once the build ends, here it's streamed to the log
+func runBuild(ctx context.Context, opt nixpacks.BuildOptions, extraArgs []string, out *builders.Log) (nixpacks.BuildOutput, error) {
+	build := nixpacks.BuildOutput{}
+	if err := opt.Validate(); err != nil {
+		return build, err
+	}
+	command, err := exec.LookPath("nixpacks")
+	if err != nil {
+		return build, err
+	}
+
+	args := append([]string{nixpacks.BuildCommand, opt.Path}, opt.ToArgs()...)
+	args = append(args, extraArgs...)
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+	// the output is parsed without the timestamps, the response keeps them
+	build.Response = builders.StripTimestamps(out.Bytes())
+	build.IsBrokenImage = err != nil
+	build.Parse()
+	build.Response = out.Bytes()
+	return build, err
 }
@@ -0,0 +1,194 @@
+package nixpacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+)
+
+// phases generated by every nixpacks provider, they can be changed but not redefined
+var basePhases = []string{"setup", "install", "build"}
+
+var (
+	phaseNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+	cacheKeyRegex  = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// NixPackBuilderConfig is the plan of the nixpacks builder, Plan is the
+// json plan generated by nixpacks and the other fields are the build options
+type NixPackBuilderConfig struct {
+	Plan     json.RawMessage `json:"plan"`
+	CacheKey string          `json:"cacheKey"`
+	NoCache  bool            `json:"noCache"`
+}
+
+// ValidateConfig checks the nixpacks fields of the config,
+// every error wraps builders.ErrInvalidConfig
+func ValidateConfig(config *model.BuildConfig) error {
+	for _, env := range config.Envs {
+		if env.Key == "" || strings.ContainsAny(env.Key, "= ") {
+			return fmt.Errorf("%w: invalid env name %q", builders.ErrInvalidConfig, env.Key)
+		}
+	}
+	for _, list := range [][]string{config.NixPkgs, config.NixLibs, config.AptPkgs} {
+		for _, pkg := range list {
+			if strings.TrimSpace(pkg) == "" || strings.ContainsAny(pkg, " \n") {
+				return fmt.Errorf("%w: invalid package %q", builders.ErrInvalidConfig, pkg)
+			}
+		}
+	}
+	for _, cmd := range append(slices.Clone(config.InstallCommands), config.BuildCommands...) {
+		if strings.TrimSpace(cmd) == "" {
+			return fmt.Errorf("%w: empty command", builders.ErrInvalidConfig)
+		}
+	}
+	if config.CacheKey != "" && !cacheKeyRegex.MatchString(config.CacheKey) {
+		return fmt.Errorf("%w: invalid cache key %q", builders.ErrInvalidConfig, config.CacheKey)
+	}
+
+	names := slices.Clone(basePhases)
+	for _, phase := range config.NixpacksPhases {
+		if !phaseNameRegex.MatchString(phase.Name) {
+			return fmt.Errorf("%w: invalid phase name %q", builders.ErrInvalidConfig, phase.Name)
+		}
+		if slices.Contains(names, phase.Name) {
+			return fmt.Errorf("%w: phase %q already defined", builders.ErrInvalidConfig, phase.Name)
+		}
+		if len(phase.Cmds) == 0 && len(phase.NixPkgs) == 0 && len(phase.AptPkgs) == 0 {
+			return fmt.Errorf("%w: phase %q does nothing", builders.ErrInvalidConfig, phase.Name)
+		}
+		names = append(names, phase.Name)
+	}
+	for _, phase := range config.NixpacksPhases {
+		for _, dep := range phase.DependsOn {
+			if !slices.Contains(names, dep) {
+				return fmt.Errorf("%w: phase %q depends on the unknown phase %q", builders.ErrInvalidConfig, phase.Name, dep)
+			}
+		}
+	}
+	if cycle := phasesCycle(config.NixpacksPhases); cycle != "" {
+		return fmt.Errorf("%w: phase %q depends on itself", builders.ErrInvalidConfig, cycle)
+	}
+	return nil
+}
+
+// phasesCycle returns the name of a phase in a dependency cycle, empty if there are none.
+// Only the added phases can form a cycle since the base phases don't depend on them
+func phasesCycle(phases []model.NixpacksPhase) string {
+	deps := make(map[string][]string)
+	for _, phase := range phases {
+		deps[phase.Name] = phaseDependsOn(phase)
+	}
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		switch state[name] {
+		case visiting:
+			return true
+		case done:
+			return false
+		}
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if visit(dep) {
+				return true
+			}
+		}
+		state[name] = done
+		return false
+	}
+	for _, phase := range phases {
+		if visit(phase.Name) {
+			return phase.Name
+		}
+	}
+	return ""
+}
+
+func phaseDependsOn(phase model.NixpacksPhase) []string {
+	if len(phase.DependsOn) == 0 {
+		return []string{"build"}
+	}
+	return phase.DependsOn
+}
+
+// ApplyConfig changes the plan generated by nixpacks with the options that can't be
+// passed as flags: ordered commands, cache directories and additional phases.
+// The fields of the plan that are not changed are kept as they are
+func ApplyConfig(plan []byte, config *model.BuildConfig) ([]byte, error) {
+	var p map[string]any
+	if err := json.Unmarshal(plan, &p); err != nil {
+		return nil, err
+	}
+	phases, _ := p["phases"].(map[string]any)
+	if phases == nil {
+		phases = make(map[string]any)
+		p["phases"] = phases
+	}
+
+	phase := func(name string) map[string]any {
+		ph, _ := phases[name].(map[string]any)
+		if ph == nil {
+			ph = map[string]any{"name": name}
+			phases[name] = ph
+		}
+		return ph
+	}
+
+	if len(config.InstallCommands) > 0 {
+		phase("install")["cmds"] = config.InstallCommands
+	}
+	if len(config.BuildCommands) > 0 {
+		phase("build")["cmds"] = config.BuildCommands
+	}
+	if len(config.InstallCacheDirectories) > 0 {
+		addCacheDirectories(phase("install"), config.InstallCacheDirectories)
+	}
+	if len(config.BuildCacheDirectories) > 0 {
+		addCacheDirectories(phase("build"), config.BuildCacheDirectories)
+	}
+
+	for _, extra := range config.NixpacksPhases {
+		ph := map[string]any{
+			"name":      extra.Name,
+			"dependsOn": phaseDependsOn(extra),
+		}
+		if len(extra.Cmds) > 0 {
+			ph["cmds"] = extra.Cmds
+		}
+		if len(extra.NixPkgs) > 0 {
+			ph["nixPkgs"] = extra.NixPkgs
+		}
+		if len(extra.AptPkgs) > 0 {
+			ph["aptPkgs"] = extra.AptPkgs
+		}
+		if len(extra.CacheDirectories) > 0 {
+			ph["cacheDirectories"] = extra.CacheDirectories
+		}
+		phases[extra.Name] = ph
+	}
+
+	return json.Marshal(p)
+}
+
+func addCacheDirectories(phase map[string]any, dirs []string) {
+	var current []any
+	if existing, ok := phase["cacheDirectories"].([]any); ok {
+		current = existing
+	}
+	for _, dir := range dirs {
+		if !slices.Contains(current, any(dir)) {
+			current = append(current, dir)
+		}
+	}
+	phase["cacheDirectories"] = current
+}
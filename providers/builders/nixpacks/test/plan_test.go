@@ -0,0 +1,102 @@
+package nixpacks
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
+	"github.com/ipaas-org/image-builder/providers/builders/nixpacks"
+	"gotest.tools/assert"
+)
+
+const generatedPlan = `{
+	"providers": [],
+	"buildImage": "ghcr.io/railwayapp/nixpacks:ubuntu",
+	"variables": {"NODE_ENV": "production"},
+	"phases": {
+		"setup": {"name": "setup", "nixPkgs": ["nodejs_18"]},
+		"install": {"name": "install", "dependsOn": ["setup"], "cmds": ["npm ci"], "cacheDirectories": ["/root/.npm"]},
+		"build": {"name": "build", "dependsOn": ["install"], "cmds": ["npm run build"]}
+	},
+	"start": {"cmd": "npm run start"}
+}`
+
+type phase struct {
+	DependsOn        []string `json:"dependsOn"`
+	Cmds             []string `json:"cmds"`
+	CacheDirectories []string `json:"cacheDirectories"`
+	NixPkgs          []string `json:"nixPkgs"`
+}
+
+type plan struct {
+	Variables map[string]string `json:"variables"`
+	Phases    map[string]phase  `json:"phases"`
+}
+
+func TestApplyConfig(t *testing.T) {
+	config := &model.BuildConfig{
+		InstallCommands:         []string{"npm ci --ignore-scripts", "npx prisma generate"},
+		InstallCacheDirectories: []string{"/root/.npm", "/root/.cache/prisma"},
+		NixpacksPhases: []model.NixpacksPhase{{
+			Name: "assets",
+			Cmds: []string{"npm run assets"},
+		}},
+	}
+	assert.NilError(t, nixpacks.ValidateConfig(config))
+
+	raw, err := nixpacks.ApplyConfig([]byte(generatedPlan), config)
+	assert.NilError(t, err)
+	p := new(plan)
+	assert.NilError(t, json.Unmarshal(raw, p))
+
+	assert.DeepEqual(t, p.Phases["install"].Cmds, config.InstallCommands)
+	assert.DeepEqual(t, p.Phases["install"].CacheDirectories, []string{"/root/.npm", "/root/.cache/prisma"})
+	// unchanged fields are kept
+	assert.DeepEqual(t, p.Phases["build"].Cmds, []string{"npm run build"})
+	assert.DeepEqual(t, p.Phases["setup"].NixPkgs, []string{"nodejs_18"})
+	assert.Equal(t, p.Variables["NODE_ENV"], "production")
+	// added phases run after build by default
+	assert.DeepEqual(t, p.Phases["assets"].DependsOn, []string{"build"})
+	assert.DeepEqual(t, p.Phases["assets"].Cmds, []string{"npm run assets"})
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := map[string]*model.BuildConfig{
+		"empty command":     {BuildCommands: []string{"npm run build", " "}},
+		"invalid lib":       {NixLibs: []string{"zlib openssl"}},
+		"invalid env":       {Envs: []model.KeyValue{{Key: "A=B", Value: "c"}}},
+		"invalid cache key": {CacheKey: "../app"},
+		"redefined phase":   {NixpacksPhases: []model.NixpacksPhase{{Name: "build", Cmds: []string{"make"}}}},
+		"empty phase":       {NixpacksPhases: []model.NixpacksPhase{{Name: "noop"}}},
+		"unknown dependency": {NixpacksPhases: []model.NixpacksPhase{
+			{Name: "assets", DependsOn: []string{"compile"}, Cmds: []string{"make assets"}},
+		}},
+		"cycle": {NixpacksPhases: []model.NixpacksPhase{
+			{Name: "a", DependsOn: []string{"b"}, Cmds: []string{"make a"}},
+			{Name: "b", DependsOn: []string{"a"}, Cmds: []string{"make b"}},
+		}},
+	}
+
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := nixpacks.ValidateConfig(config)
+			assert.Assert(t, errors.Is(err, builders.ErrInvalidConfig), "got %v", err)
+		})
+	}
+}
+
+func TestDockerfileConfig(t *testing.T) {
+	config, err := nixpacks.DockerfileConfig(&nixpacks.NixPackBuilderConfig{Plan: json.RawMessage(generatedPlan)})
+	assert.NilError(t, err)
+	assert.Equal(t, config.DockerFilePath, ".nixpacks/Dockerfile")
+	// the generated Dockerfile reads the variables from the build args
+	args := docker.BuildArgs(config, nil)
+	assert.Assert(t, args["NODE_ENV"] != nil)
+	assert.Equal(t, *args["NODE_ENV"], "production")
+
+	_, err = nixpacks.DockerfileConfig(&nixpacks.NixPackBuilderConfig{Plan: json.RawMessage(`[]`)})
+	assert.Assert(t, errors.Is(err, builders.ErrInvalidPlan))
+}
@@ -0,0 +1,210 @@
+package static
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/docker"
+)
+
+const (
+	StaticBuilderKind model.BuilderKind = "static"
+
+	// unprivileged nginx listening on 8080
+	DefaultServerImage = "nginxinc/nginx-unprivileged:stable-alpine"
+	ServerPort         = 8080
+
+	// the generated Dockerfile is written in the root of the build context
+	dockerfileName = ".ipaas-static.Dockerfile"
+)
+
+var (
+	_ builders.Builder = new(StaticBuilder)
+
+	argNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// StaticBuilder builds the site in a throwaway stage and serves the output
+// directory with nginx, the generated Dockerfile is built by a DockerfileBuilder
+type StaticBuilder struct {
+	builderVersion string
+	docker         docker.DockerfileBuilder
+	serverImage    string
+}
+
+type StaticBuilderOptions struct {
+	// image of the final stage, it must be nginx based and listen on ServerPort
+	ServerImage string
+}
+
+type StaticBuilderConfig struct {
+	BuildImage      string            `json:"buildImage"`
+	InstallCommands []string          `json:"installCommands"`
+	BuildCommands   []string          `json:"buildCommands"`
+	OutputDirectory string            `json:"outputDirectory"`
+	SPAFallback     bool              `json:"spaFallback"`
+	Envs            map[string]string `json:"envs"`
+}
+
+func NewStaticBuilder(builderVersion string, dockerBuilder docker.DockerfileBuilder, opt ...StaticBuilderOptions) *StaticBuilder {
+	b := &StaticBuilder{
+		builderVersion: builderVersion,
+		docker:         dockerBuilder,
+		serverImage:    DefaultServerImage,
+	}
+	if len(opt) > 0 && opt[0].ServerImage != "" {
+		b.serverImage = opt[0].ServerImage
+	}
+	return b
+}
+
+// Plan detects how to build the site, the values in the config take precedence
+func (b StaticBuilder) Plan(ctx context.Context, config *model.BuildConfig, path string) (builders.Plan, error) {
+	// the generated Dockerfile doesn't mount secrets
+	if len(config.Secrets) > 0 {
+		return "", builders.ErrInvalidConfig
+	}
+
+	info, err := Detect(path)
+	if err != nil {
+		return "", err
+	}
+	if info == nil {
+		// not detected, the output directory must be in the config
+		info = &model.StaticInfo{BuildImage: noBuildImage}
+		if len(config.InstallCommands) > 0 || len(config.BuildCommands) > 0 {
+			info.BuildImage = DefaultNodeImage
+		}
+	}
+
+	plan := &StaticBuilderConfig{
+		BuildImage:      info.BuildImage,
+		InstallCommands: info.InstallCommands,
+		BuildCommands:   info.BuildCommands,
+		OutputDirectory: info.OutputDirectory,
+		SPAFallback:     info.SPA,
+		Envs:            make(map[string]string),
+	}
+	if len(config.InstallCommands) > 0 {
+		plan.InstallCommands = config.InstallCommands
+	}
+	if len(config.BuildCommands) > 0 {
+		plan.BuildCommands = config.BuildCommands
+	}
+	if config.OutputDirectory != "" {
+		plan.OutputDirectory = config.OutputDirectory
+	}
+	if config.SPAFallback != nil {
+		plan.SPAFallback = *config.SPAFallback
+	}
+	if plan.OutputDirectory == "" {
+		return "", builders.ErrMissingConfig
+	}
+	for _, env := range config.Envs {
+		if !argNameRegex.MatchString(env.Key) {
+			return "", builders.ErrInvalidConfig
+		}
+		plan.Envs[env.Key] = env.Value
+	}
+	if _, err := GenerateDockerfile(plan, b.serverImage); err != nil {
+		return "", err
+	}
+
+	jsonPlan, err := json.Marshal(plan)
+	if err != nil {
+		return "", err
+	}
+	return builders.Plan(jsonPlan), nil
+}
+
+// first string is the image id, second is the build output
+func (b StaticBuilder) Build(ctx context.Context, userID, repo, path string, plan builders.Plan, opt builders.BuildOptions) (string, []byte, error) {
+	config := new(StaticBuilderConfig)
+	if err := json.Unmarshal([]byte(plan), config); err != nil {
+		return "", nil, builders.ErrInvalidPlan
+	}
+
+	dockerfile, err := GenerateDockerfile(config, b.serverImage)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.WriteFile(filepath.Join(path, dockerfileName), []byte(dockerfile), 0644); err != nil {
+		return "", nil, err
+	}
+
+	// envs are passed as build args so they stay in the build stage
+	return b.docker.BuildDockerfile(ctx, StaticBuilderKind, userID, repo, path, &docker.DockerBuilderConfig{
+		DockerFilePath: dockerfileName,
+		Args:           config.Envs,
+	}, opt)
+}
+
+// GenerateDockerfile returns the multi-stage Dockerfile that builds the site
+// with the build image and copies the output directory in the server image
+func GenerateDockerfile(config *StaticBuilderConfig, serverImage string) (string, error) {
+	output := filepath.ToSlash(filepath.Clean(config.OutputDirectory))
+	if config.OutputDirectory == "" || filepath.IsAbs(output) || output == ".." || strings.HasPrefix(output, "../") {
+		return "", builders.ErrInvalidConfig
+	}
+	// every command must be a single RUN instruction
+	values := append([]string{config.BuildImage, output}, config.InstallCommands...)
+	for _, v := range append(values, config.BuildCommands...) {
+		if strings.ContainsAny(v, "\r\n") {
+			return "", builders.ErrInvalidConfig
+		}
+	}
+	if config.BuildImage == "" {
+		return "", builders.ErrInvalidConfig
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "FROM %s AS build\n", config.BuildImage)
+	sb.WriteString("WORKDIR /app\n")
+	sb.WriteString("COPY . .\n")
+	for _, k := range docker.SortedKeys(config.Envs) {
+		fmt.Fprintf(&sb, "ARG %s\n", k)
+	}
+	for _, cmd := range append(config.InstallCommands, config.BuildCommands...) {
+		fmt.Fprintf(&sb, "RUN %s\n", cmd)
+	}
+	sb.WriteString("RUN printf '%s\\n'")
+	for _, line := range nginxConfig(config.SPAFallback) {
+		fmt.Fprintf(&sb, " '%s'", line)
+	}
+	sb.WriteString(" > /tmp/ipaas-nginx.conf\n\n")
+
+	fmt.Fprintf(&sb, "FROM %s\n", serverImage)
+	sb.WriteString("COPY --from=build /tmp/ipaas-nginx.conf /etc/nginx/conf.d/default.conf\n")
+	fmt.Fprintf(&sb, "COPY --from=build /app/%s/ /usr/share/nginx/html/\n", output)
+	fmt.Fprintf(&sb, "EXPOSE %d\n", ServerPort)
+	return sb.String(), nil
+}
+
+// nginxConfig returns the lines of the server block, with the spa fallback
+// unknown paths are served with index.html so the client router can handle them
+func nginxConfig(spa bool) []string {
+	fallback := "=404"
+	if spa {
+		fallback = "/index.html"
+	}
+	return []string{
+		"server {",
+		fmt.Sprintf("    listen %d;", ServerPort),
+		"    root /usr/share/nginx/html;",
+		"    index index.html;",
+		"    gzip on;",
+		"    gzip_types text/css application/javascript application/json image/svg+xml;",
+		"    location ~ /\\. { deny all; }",
+		"    location / {",
+		"        try_files $uri $uri/ $uri.html " + fallback + ";",
+		"    }",
+		"}",
+	}
+}
@@ -0,0 +1,152 @@
+package static
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/model"
+)
+
+const (
+	DefaultNodeImage = "node:lts-alpine"
+	DefaultHugoImage = "hugomods/hugo:exts"
+	// used when there is nothing to build, only to copy the files
+	noBuildImage = "busybox:stable"
+)
+
+type packageJSON struct {
+	Scripts         map[string]string `json:"scripts"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+func (p *packageJSON) has(dep string) bool {
+	_, ok := p.Dependencies[dep]
+	if !ok {
+		_, ok = p.DevDependencies[dep]
+	}
+	return ok
+}
+
+// if one of these is a dependency the project serves something at runtime
+var serverDependencies = []string{"express", "fastify", "koa", "@nestjs/core", "@remix-run/node", "nuxt", "hapi"}
+
+var nextExportRegex = regexp.MustCompile(`output\s*:\s*["']export["']`)
+
+// Detect returns how to build the project at path as a static site,
+// nil is returned if the project doesn't look like a static site
+func Detect(path string) (*model.StaticInfo, error) {
+	if info, err := detectNode(path); info != nil || err != nil {
+		return info, err
+	}
+	if info := detectHugo(path); info != nil {
+		return info, nil
+	}
+	if exists(path, "index.html") {
+		return &model.StaticInfo{
+			Framework:       "html",
+			BuildImage:      noBuildImage,
+			OutputDirectory: ".",
+		}, nil
+	}
+	return nil, nil
+}
+
+func detectNode(path string) (*model.StaticInfo, error) {
+	raw, err := os.ReadFile(filepath.Join(path, "package.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	pkg := new(packageJSON)
+	if err := json.Unmarshal(raw, pkg); err != nil {
+		// an invalid package.json is a problem of nixpacks, not ours
+		return nil, nil
+	}
+	if _, ok := pkg.Scripts["build"]; !ok {
+		return nil, nil
+	}
+	for _, dep := range serverDependencies {
+		if pkg.has(dep) {
+			return nil, nil
+		}
+	}
+
+	info := &model.StaticInfo{BuildImage: DefaultNodeImage}
+	switch {
+	case pkg.has("next"):
+		// only exported next apps are static
+		if !nextExport(path, pkg) {
+			return nil, nil
+		}
+		info.Framework, info.OutputDirectory = "next", "out"
+	case pkg.has("astro"):
+		info.Framework, info.OutputDirectory = "astro", "dist"
+	case pkg.has("@sveltejs/adapter-static"):
+		info.Framework, info.OutputDirectory, info.SPA = "sveltekit", "build", true
+	case pkg.has("react-scripts"):
+		info.Framework, info.OutputDirectory, info.SPA = "create-react-app", "build", true
+	case pkg.has("@vue/cli-service"):
+		info.Framework, info.OutputDirectory, info.SPA = "vue-cli", "dist", true
+	case pkg.has("vite"):
+		info.Framework, info.OutputDirectory, info.SPA = "vite", "dist", true
+	default:
+		return nil, nil
+	}
+
+	switch {
+	case exists(path, "pnpm-lock.yaml"):
+		info.InstallCommands = []string{"corepack enable && pnpm install --frozen-lockfile"}
+		info.BuildCommands = []string{"pnpm run build"}
+	case exists(path, "yarn.lock"):
+		info.InstallCommands = []string{"corepack enable && yarn install --frozen-lockfile"}
+		info.BuildCommands = []string{"yarn run build"}
+	case exists(path, "package-lock.json"):
+		info.InstallCommands = []string{"npm ci"}
+		info.BuildCommands = []string{"npm run build"}
+	default:
+		info.InstallCommands = []string{"npm install"}
+		info.BuildCommands = []string{"npm run build"}
+	}
+	return info, nil
+}
+
+func nextExport(path string, pkg *packageJSON) bool {
+	if strings.Contains(pkg.Scripts["build"], "next export") {
+		return true
+	}
+	for _, name := range []string{"next.config.js", "next.config.mjs", "next.config.ts"} {
+		raw, err := os.ReadFile(filepath.Join(path, name))
+		if err == nil && nextExportRegex.Match(raw) {
+			return true
+		}
+	}
+	return false
+}
+
+func detectHugo(path string) *model.StaticInfo {
+	isHugo := exists(path, "hugo.toml") || exists(path, "hugo.yaml") || exists(path, "hugo.json")
+	if !isHugo && (exists(path, "config.toml") || exists(path, "config.yaml")) {
+		// the old config name is too generic, look for the hugo directories too
+		isHugo = exists(path, "content") && (exists(path, "layouts") || exists(path, "themes"))
+	}
+	if !isHugo {
+		return nil
+	}
+	return &model.StaticInfo{
+		Framework:       "hugo",
+		BuildImage:      DefaultHugoImage,
+		BuildCommands:   []string{"hugo --minify"},
+		OutputDirectory: "public",
+	}
+}
+
+func exists(path, name string) bool {
+	_, err := os.Stat(filepath.Join(path, name))
+	return err == nil
+}
@@ -0,0 +1,137 @@
+package static_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/builders/static"
+	"gotest.tools/assert"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		framework string
+		output    string
+		install   []string
+		spa       bool
+	}{
+		{
+			name: "vite with pnpm",
+			files: map[string]string{
+				"package.json":   `{"scripts":{"build":"vite build"},"devDependencies":{"vite":"^5.0.0"}}`,
+				"pnpm-lock.yaml": "",
+			},
+			framework: "vite", output: "dist", spa: true,
+			install: []string{"corepack enable && pnpm install --frozen-lockfile"},
+		},
+		{
+			name: "next export",
+			files: map[string]string{
+				"package.json":      `{"scripts":{"build":"next build"},"dependencies":{"next":"14.0.0"}}`,
+				"package-lock.json": "{}",
+				"next.config.js":    `module.exports = { output: 'export' }`,
+			},
+			framework: "next", output: "out", install: []string{"npm ci"},
+		},
+		{
+			name: "hugo",
+			files: map[string]string{
+				"hugo.toml":       `title = "blog"`,
+				"content/post.md": "# post",
+			},
+			framework: "hugo", output: "public",
+		},
+		{
+			name:      "plain html",
+			files:     map[string]string{"index.html": "<html></html>"},
+			framework: "html", output: ".",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := static.Detect(writeFiles(t, tt.files))
+			assert.NilError(t, err)
+			assert.Assert(t, info != nil)
+			assert.Equal(t, info.Framework, tt.framework)
+			assert.Equal(t, info.OutputDirectory, tt.output)
+			assert.DeepEqual(t, info.InstallCommands, tt.install)
+			assert.Equal(t, info.SPA, tt.spa)
+		})
+	}
+}
+
+func TestDetectNotStatic(t *testing.T) {
+	tests := map[string]map[string]string{
+		"next server": {
+			"package.json": `{"scripts":{"build":"next build"},"dependencies":{"next":"14.0.0"}}`,
+		},
+		"vite with express": {
+			"package.json": `{"scripts":{"build":"vite build"},"dependencies":{"express":"4.0.0","vite":"5.0.0"}}`,
+		},
+		"no build script": {
+			"package.json": `{"dependencies":{"vite":"5.0.0"}}`,
+		},
+		"go": {
+			"go.mod": "module example.com/app",
+		},
+	}
+
+	for name, files := range tests {
+		t.Run(name, func(t *testing.T) {
+			info, err := static.Detect(writeFiles(t, files))
+			assert.NilError(t, err)
+			assert.Assert(t, info == nil)
+		})
+	}
+}
+
+func TestGenerateDockerfile(t *testing.T) {
+	dockerfile, err := static.GenerateDockerfile(&static.StaticBuilderConfig{
+		BuildImage:      static.DefaultNodeImage,
+		InstallCommands: []string{"npm ci"},
+		BuildCommands:   []string{"npm run build"},
+		OutputDirectory: "dist",
+		SPAFallback:     true,
+		Envs:            map[string]string{"VITE_API": "https://api.example.com"},
+	}, static.DefaultServerImage)
+	assert.NilError(t, err)
+
+	assert.Assert(t, strings.HasPrefix(dockerfile, "FROM node:lts-alpine AS build\n"))
+	assert.Assert(t, strings.Contains(dockerfile, "ARG VITE_API\n"))
+	assert.Assert(t, !strings.Contains(dockerfile, "https://api.example.com"), "env values must be passed as build args")
+	assert.Assert(t, strings.Contains(dockerfile, "RUN npm ci\nRUN npm run build\n"))
+	assert.Assert(t, strings.Contains(dockerfile, "try_files $uri $uri/ $uri.html /index.html;"))
+	assert.Assert(t, strings.Contains(dockerfile, "COPY --from=build /app/dist/ /usr/share/nginx/html/\n"))
+}
+
+func TestGenerateDockerfileInvalid(t *testing.T) {
+	for _, config := range []*static.StaticBuilderConfig{
+		{BuildImage: static.DefaultNodeImage, OutputDirectory: "../etc"},
+		{BuildImage: static.DefaultNodeImage, OutputDirectory: "/etc"},
+		{BuildImage: static.DefaultNodeImage, OutputDirectory: "dist", BuildCommands: []string{"npm run build\nUSER root"}},
+	} {
+		_, err := static.GenerateDockerfile(config, static.DefaultServerImage)
+		assert.Assert(t, errors.Is(err, builders.ErrInvalidConfig), "output %q", config.OutputDirectory)
+	}
+}
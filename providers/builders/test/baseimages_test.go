@@ -0,0 +1,145 @@
+package builders_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"gotest.tools/assert"
+)
+
+func TestBaseImagesResolve(t *testing.T) {
+	policy := &builders.BaseImages{
+		Allowed: []string{"library/*", "ghcr.io/railwayapp/nixpacks", "paketobuildpacks/builder-jammy-base"},
+		Mirror:  "https://mirror.example.com/",
+	}
+	tests := map[string]struct {
+		image    string
+		expected string
+		allowed  bool
+	}{
+		"official":        {image: "node:20", expected: "mirror.example.com/library/node:20", allowed: true},
+		"full name":       {image: "docker.io/library/node", expected: "mirror.example.com/library/node", allowed: true},
+		"digest":          {image: "alpine@sha256:" + sha, expected: "mirror.example.com/library/alpine@sha256:" + sha, allowed: true},
+		"other registry":  {image: "ghcr.io/railwayapp/nixpacks:ubuntu", expected: "ghcr.io/railwayapp/nixpacks:ubuntu", allowed: true},
+		"exact":           {image: "paketobuildpacks/builder-jammy-base", expected: "mirror.example.com/paketobuildpacks/builder-jammy-base", allowed: true},
+		"mirror":          {image: "mirror.example.com/library/node:20", expected: "mirror.example.com/library/node:20", allowed: true},
+		"user repository": {image: "someone/node:20"},
+		"other project":   {image: "ghcr.io/railwayapp/other"},
+		"mirror of user":  {image: "mirror.example.com/someone/node"},
+		"invalid":         {image: "Node:20"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resolved, err := policy.Resolve(tt.image)
+			if !tt.allowed {
+				assert.Assert(t, errors.Is(err, builders.ErrBaseImageNotAllowed), err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, resolved, tt.expected)
+		})
+	}
+
+	// only the mirror
+	resolved, err := (&builders.BaseImages{Mirror: "mirror.example.com"}).Resolve("someone/node")
+	assert.NilError(t, err)
+	assert.Equal(t, resolved, "mirror.example.com/someone/node")
+
+	var disabled *builders.BaseImages
+	resolved, err = disabled.Resolve("someone/node")
+	assert.NilError(t, err)
+	assert.Equal(t, resolved, "someone/node")
+}
+
+const sha = "0000000000000000000000000000000000000000000000000000000000000000"
+
+func TestBaseImagesRewriteDockerfile(t *testing.T) {
+	policy := &builders.BaseImages{Allowed: []string{"library/*"}, Mirror: "mirror.example.com"}
+	dockerfile := `# syntax=docker/dockerfile:1
+ARG VERSION=20
+ARG BASE
+
+FROM --platform=$BUILDPLATFORM node:${VERSION} AS build
+RUN --mount=type=cache,target=/root/.npm,from=alpine npm ci
+COPY --from=golang:1.22 /usr/local/go /usr/local/go
+
+FROM ${BASE:-nginx} \
+    AS runtime
+COPY --from=build /app /usr/share/nginx/html
+COPY --from=0 /app/package.json .
+
+FROM scratch
+COPY --from=runtime / /
+`
+	expected := `# syntax=mirror.example.com/docker/dockerfile:1
+ARG VERSION=20
+ARG BASE
+
+FROM --platform=$BUILDPLATFORM mirror.example.com/library/node:20 AS build
+RUN --mount=type=cache,target=/root/.npm,from=mirror.example.com/library/alpine npm ci
+COPY --from=mirror.example.com/library/golang:1.22 /usr/local/go /usr/local/go
+
+FROM mirror.example.com/library/nginx \
+    AS runtime
+COPY --from=build /app /usr/share/nginx/html
+COPY --from=0 /app/package.json .
+
+FROM scratch
+COPY --from=runtime / /
+`
+	// the syntax image is checked too
+	policy.Allowed = append(policy.Allowed, "docker/dockerfile")
+
+	path := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(path, "Dockerfile"), []byte(dockerfile), 0644))
+	rewritten, err := policy.RewriteDockerfile(path, "", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, rewritten, ".Dockerfile.mirrored")
+	raw, err := os.ReadFile(filepath.Join(path, rewritten))
+	assert.NilError(t, err)
+	assert.Equal(t, string(raw), expected)
+
+	// the build args override the defaults
+	base := "someone/nginx"
+	_, err = policy.RewriteDockerfile(path, "Dockerfile", map[string]*string{"BASE": &base})
+	var baseErr *builders.BaseImageError
+	assert.Assert(t, errors.As(err, &baseErr), err)
+	assert.Equal(t, baseErr.Image, "someone/nginx")
+	assert.ErrorContains(t, err, "library/*")
+}
+
+func TestBaseImagesRewriteDockerfileNotChanged(t *testing.T) {
+	path := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(path, "docker"), 0755))
+	dockerfile := filepath.Join("docker", "app.Dockerfile")
+	assert.NilError(t, os.WriteFile(filepath.Join(path, dockerfile), []byte("FROM ghcr.io/org/app:1\n"), 0644))
+
+	policy := &builders.BaseImages{Allowed: []string{"ghcr.io/org/*"}, Mirror: "mirror.example.com"}
+	rewritten, err := policy.RewriteDockerfile(path, dockerfile, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, rewritten, dockerfile)
+
+	// the missing Dockerfile is reported by the builder
+	rewritten, err = policy.RewriteDockerfile(path, "missing", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, rewritten, "missing")
+}
+
+func TestBaseImagesRewriteDockerfileUnsetVariable(t *testing.T) {
+	path := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(path, "Dockerfile"), []byte("ARG BASE\nFROM $BASE\n"), 0644))
+
+	policy := &builders.BaseImages{Allowed: []string{"library/*"}}
+	_, err := policy.RewriteDockerfile(path, "", nil)
+	assert.Assert(t, errors.Is(err, builders.ErrBaseImageNotAllowed), err)
+	assert.ErrorContains(t, err, "variables are not set")
+
+	// without an allowlist the builder reports it
+	policy = &builders.BaseImages{Mirror: "mirror.example.com"}
+	rewritten, err := policy.RewriteDockerfile(path, "", nil)
+	assert.NilError(t, err)
+	assert.Equal(t, rewritten, "Dockerfile")
+}
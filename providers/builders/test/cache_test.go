@@ -0,0 +1,57 @@
+package builders_test
+
+import (
+	"testing"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"gotest.tools/assert"
+)
+
+func TestCacheStats(t *testing.T) {
+	tests := map[string]struct {
+		output   string
+		expected model.CacheStats
+	}{
+		"buildkit": {
+			output: `#1 [internal] load build definition from Dockerfile
+#1 DONE 0.0s
+#2 [1/4] FROM docker.io/library/node:20
+#2 CACHED
+#3 [internal] load build context
+#3 DONE 0.1s
+#4 [2/4] COPY package.json package-lock.json ./
+#4 CACHED
+#5 [3/4] RUN npm ci
+#5 CACHED
+#6 [4/4] COPY . .
+#6 DONE 0.2s
+`,
+			expected: model.CacheStats{Steps: 4, CachedSteps: 3},
+		},
+		"legacy": {
+			output: `Step 1/3 : FROM alpine
+ ---> 05455a08881e
+Step 2/3 : RUN apk add git
+ ---> Using cache
+ ---> 1d2b1b1f0a2c
+Step 3/3 : COPY . .
+ ---> 9a8c1b2d3e4f
+`,
+			expected: model.CacheStats{Steps: 3, CachedSteps: 1},
+		},
+		"buildpacks": {
+			output: `[exporter] Reusing layer 'paketo-buildpacks/ca-certificates:helper'
+[exporter] Reusing layer 'paketo-buildpacks/bellsoft-liberica:jre'
+[exporter] Adding layer 'buildpacksio/lifecycle:launch.sbom'
+`,
+			expected: model.CacheStats{Steps: 3, CachedSteps: 2},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.DeepEqual(t, *builders.CacheStats([]byte(tt.output)), tt.expected)
+		})
+	}
+}
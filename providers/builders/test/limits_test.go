@@ -0,0 +1,62 @@
+package builders_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"gotest.tools/assert"
+)
+
+func TestWatchDisk(t *testing.T) {
+	builders.DiskCheckInterval = time.Millisecond
+	limits := &builders.Limits{Disk: 100}
+
+	// the usage before the build is not counted
+	var used atomic.Int64
+	used.Store(1000)
+	usage := func(ctx context.Context) (int64, error) {
+		return used.Load(), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop, err := builders.WatchDisk(ctx, limits, usage, cancel)
+	assert.NilError(t, err)
+	used.Store(1100)
+	time.Sleep(20 * time.Millisecond)
+	assert.NilError(t, ctx.Err())
+	assert.Assert(t, !stop())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	stop, err = builders.WatchDisk(ctx, limits, usage, cancel)
+	assert.NilError(t, err)
+	used.Store(1300)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the build was not cancelled")
+	}
+	assert.Assert(t, stop())
+
+	// without a disk limit nothing is watched
+	stop, err = builders.WatchDisk(context.Background(), &builders.Limits{CPUs: 1}, nil, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, !stop())
+}
+
+func TestDirSize(t *testing.T) {
+	path := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(path, "dir"), 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(path, "a"), make([]byte, 10), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(path, "dir", "b"), make([]byte, 5), 0644))
+
+	size, err := builders.DirSize(path)
+	assert.NilError(t, err)
+	assert.Equal(t, size, int64(15))
+}
@@ -0,0 +1,63 @@
+package builders_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"gotest.tools/assert"
+)
+
+func fixedClock() time.Time {
+	return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func TestLog(t *testing.T) {
+	sink := new(bytes.Buffer)
+	log := builders.NewLog(sink, 0)
+	log.SetClock(fixedClock)
+
+	fmt.Fprint(log, "\x1b[1;32mStep 1/2\x1b[0m : FROM alpine\n")
+	// the line is only written once it's complete
+	fmt.Fprint(log, "Downloading 10%\rDownloading 50%")
+	assert.Equal(t, sink.String(), "2024-01-01T12:00:00.000Z Step 1/2 : FROM alpine\n")
+	fmt.Fprint(log, "\rDownloading 100%\r\n\x1b[2K\x1b[1Adone")
+
+	expected := strings.Join([]string{
+		"2024-01-01T12:00:00.000Z Step 1/2 : FROM alpine",
+		"2024-01-01T12:00:00.000Z Downloading 100%",
+		"2024-01-01T12:00:00.000Z done",
+		"",
+	}, "\n")
+	assert.Equal(t, string(log.Bytes()), expected)
+	assert.Equal(t, sink.String(), expected)
+	assert.Assert(t, !log.Truncated())
+
+	assert.Equal(t, string(builders.StripTimestamps(log.Bytes())), "Step 1/2 : FROM alpine\nDownloading 100%\ndone\n")
+}
+
+func TestLogTruncation(t *testing.T) {
+	sink := new(bytes.Buffer)
+	// each line is 32 bytes, 2 fit in the head and 2 in the tail
+	log := builders.NewLog(sink, 128)
+	log.SetClock(fixedClock)
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(log, "line %d\n", i)
+	}
+
+	expected := strings.Join([]string{
+		"2024-01-01T12:00:00.000Z line 0",
+		"2024-01-01T12:00:00.000Z line 1",
+		"[... 6 lines truncated ...]",
+		"2024-01-01T12:00:00.000Z line 8",
+		"2024-01-01T12:00:00.000Z line 9",
+		"",
+	}, "\n")
+	assert.Equal(t, string(log.Bytes()), expected)
+	assert.Assert(t, log.Truncated())
+	// the sink gets every line
+	assert.Equal(t, strings.Count(sink.String(), "\n"), 10)
+}
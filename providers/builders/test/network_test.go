@@ -0,0 +1,57 @@
+package builders_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"gotest.tools/assert"
+)
+
+func TestParseNetworkMode(t *testing.T) {
+	mode, err := builders.ParseNetworkMode("")
+	assert.NilError(t, err)
+	assert.Equal(t, mode, builders.NetworkFull)
+
+	mode, err = builders.ParseNetworkMode("proxy")
+	assert.NilError(t, err)
+	assert.Equal(t, mode, builders.NetworkProxy)
+
+	_, err = builders.ParseNetworkMode("host")
+	assert.Assert(t, errors.Is(err, builders.ErrInvalidConfig))
+}
+
+func TestNetworkCheck(t *testing.T) {
+	var network *builders.Network
+	assert.NilError(t, network.Check())
+	assert.NilError(t, (&builders.Network{Mode: builders.NetworkNone}).Check())
+
+	// the proxy env alone doesn't restrict the egress
+	network = &builders.Network{Mode: builders.NetworkProxy, HTTPProxy: "http://proxy:3128"}
+	assert.Assert(t, errors.Is(network.Check(), builders.ErrMissingProxyNetwork))
+	network.ProxyNetwork = "ipaas-proxy"
+	assert.NilError(t, network.Check())
+}
+
+func TestProxyEnv(t *testing.T) {
+	network := &builders.Network{
+		Mode:       builders.NetworkProxy,
+		HTTPProxy:  "http://proxy:3128",
+		HTTPSProxy: "http://proxy:3128",
+	}
+	assert.DeepEqual(t, network.ProxyEnv(), map[string]string{
+		"HTTP_PROXY":  "http://proxy:3128",
+		"http_proxy":  "http://proxy:3128",
+		"HTTPS_PROXY": "http://proxy:3128",
+		"https_proxy": "http://proxy:3128",
+	})
+
+	// the proxy is only used in proxy mode
+	network.Mode = builders.NetworkNone
+	assert.Equal(t, len(network.ProxyEnv()), 0)
+	assert.Assert(t, network.Isolated())
+
+	var unrestricted *builders.Network
+	assert.Equal(t, len(unrestricted.ProxyEnv()), 0)
+	assert.Assert(t, !unrestricted.Isolated())
+}
@@ -0,0 +1,226 @@
+package distribution
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/client"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	_ registry.Registryer       = new(Registry)
+	_ registry.IndexPusher      = new(Registry)
+	_ registry.LocalImagePusher = new(Registry)
+	_ registry.CacheRegistry    = new(Registry)
+	_ registry.ImageInspector   = new(Registry)
+	_ registry.ArtifactPusher   = new(Registry)
+	_ registry.ImageDeleter     = new(Registry)
+	_ registry.ImagePromoter    = new(Registry)
+)
+
+// ArchivePrefix prefixes the local image ids that are the path of a docker save tarball
+const ArchivePrefix = "docker-archive:"
+
+var ErrImageNotTagged = errors.New("image not tagged")
+
+// Registry pushes the images with the oci distribution api, the docker daemon is only
+// used to export the images it stores (docker save), the tag and the push never go through it.
+// The local image id can be an image of the daemon, an oci layout (see builders.OCILayoutPrefix)
+// or a docker save tarball (see ArchivePrefix)
+type Registry struct {
+	serverAddress string
+	client        *oci.Client
+	dockerClient  *client.Client
+
+	// image name -> local image id of the images tagged and not pushed yet, the
+	// controller pushes with PushLocalImage so only the direct callers fill it
+	mu     sync.Mutex
+	tagged map[string]string
+}
+
+// NewDistributionRegistry creates the registry for serverAddress,
+// if no authentication is required, leave username and password empty
+func NewDistributionRegistry(serverAddress, username, password string, opt ...oci.ClientOptions) (*Registry, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	c := oci.NewClient(serverAddress, username, password, opt...)
+	return &Registry{
+		serverAddress: c.Host(),
+		client:        c,
+		dockerClient:  cli,
+		tagged:        make(map[string]string),
+	}, nil
+}
+
+// TagImage only records the name of the image, nothing is changed until it's pushed.
+// Every tagged image must be pushed, PushLocalImage doesn't need the tag
+func (r *Registry) TagImage(ctx context.Context, localImageID, userCode, appName string) (string, error) {
+	name := r.serverAddress + "/" + userCode + "/" + appName
+	r.mu.Lock()
+	r.tagged[name] = localImageID
+	r.mu.Unlock()
+	return name, nil
+}
+
+// PushImage pushes the image tagged as imageName (see TagImage),
+// for multi-platform images the digest of each platform is returned too
+func (r *Registry) PushImage(ctx context.Context, imageName string) (*model.PushedImage, error) {
+	r.mu.Lock()
+	localImageID, ok := r.tagged[imageName]
+	delete(r.tagged, imageName)
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotTagged, imageName)
+	}
+	repository, tag := registry.SplitReference(r.serverAddress, imageName)
+	return r.pushLocal(ctx, localImageID, repository, tag)
+}
+
+// PushLocalImage pushes the local image as userCode/appName without recording the tag,
+// appName can contain the tag (name:tag), latest is used otherwise
+func (r *Registry) PushLocalImage(ctx context.Context, localImageID, userCode, appName string) (*model.PushedImage, error) {
+	repository, tag := registry.SplitReference(r.serverAddress, r.serverAddress+"/"+userCode+"/"+appName)
+	return r.pushLocal(ctx, localImageID, repository, tag)
+}
+
+// pushLocal pushes the oci layout, the docker save tarball or the image of the daemon
+func (r *Registry) pushLocal(ctx context.Context, localImageID, repository, tag string) (*model.PushedImage, error) {
+	var store oci.Store
+	if layoutPath, ok := builders.OCILayoutPath(localImageID); ok {
+		store = oci.NewLayout(layoutPath)
+	} else {
+		archivePath, ok := strings.CutPrefix(localImageID, ArchivePrefix)
+		if !ok {
+			var err error
+			archivePath, err = r.saveImage(ctx, localImageID)
+			if err != nil {
+				return nil, err
+			}
+			defer os.Remove(archivePath)
+		}
+		archive, err := oci.OpenArchive(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		store = archive
+	}
+	return r.push(ctx, store, repository, tag)
+}
+
+func (r *Registry) push(ctx context.Context, store oci.Store, repository, tag string) (*model.PushedImage, error) {
+	desc, platforms, err := r.client.Push(ctx, store, repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &model.PushedImage{
+		Name:      r.serverAddress + "/" + repository + ":" + tag,
+		Digest:    desc.Digest.String(),
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+		Platforms: platforms,
+	}, nil
+}
+
+// saveImage exports the image of the daemon to a temporary tarball
+func (r *Registry) saveImage(ctx context.Context, imageID string) (string, error) {
+	rc, err := r.dockerClient.ImageSave(ctx, []string{imageID})
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	f, err := os.CreateTemp("", "image-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, rc); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// PushIndex pushes the image in the oci layout as userCode/appName,
+// appName can contain the tag (name:tag), latest is used otherwise
+func (r *Registry) PushIndex(ctx context.Context, layoutPath, userCode, appName string) (*model.PushedImage, error) {
+	repository, tag := registry.SplitReference(r.serverAddress, r.serverAddress+"/"+userCode+"/"+appName)
+	return r.push(ctx, oci.NewLayout(layoutPath), repository, tag)
+}
+
+// CacheReference returns the reference the builders import and export the cache of the application
+func (r *Registry) CacheReference(ctx context.Context, userCode, appName string) (string, error) {
+	return r.serverAddress + "/" + userCode + "/" + appName + ":" + registry.CacheTag, nil
+}
+
+// ImageDigest resolves the image in the registry
+func (r *Registry) ImageDigest(ctx context.Context, userCode, appName string) (string, string, error) {
+	repository, tag := registry.SplitReference(r.serverAddress, r.serverAddress+"/"+userCode+"/"+appName)
+	desc, exists, err := r.client.Resolve(ctx, repository, tag)
+	if err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return "", "", registry.ErrImageNotFound
+	}
+	return r.serverAddress + "/" + repository + ":" + tag, desc.Digest.String(), nil
+}
+
+// PushArtifact pushes the artifact in the repository of the image
+func (r *Registry) PushArtifact(ctx context.Context, imageName, reference string, artifact *oci.Artifact) (ocispec.Descriptor, error) {
+	repository, _ := registry.SplitReference(r.serverAddress, imageName)
+	return r.client.PushArtifact(ctx, repository, reference, artifact)
+}
+
+// DeleteImage deletes the manifest of the digest from the repository of the image
+func (r *Registry) DeleteImage(ctx context.Context, imageName, imageDigest string) error {
+	d, err := digest.Parse(imageDigest)
+	if err != nil {
+		return err
+	}
+	repository, _ := registry.SplitReference(r.serverAddress, imageName)
+	deleted, err := r.client.DeleteManifest(ctx, repository, d)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return registry.ErrImageNotFound
+	}
+	return nil
+}
+
+// PromoteImage tags the image of the digest as appName (name:tag) in the registry
+func (r *Registry) PromoteImage(ctx context.Context, userCode, appName, imageDigest string) (*model.PushedImage, error) {
+	d, err := digest.Parse(imageDigest)
+	if err != nil {
+		return nil, err
+	}
+	repository, tag := registry.SplitReference(r.serverAddress, r.serverAddress+"/"+userCode+"/"+appName)
+	desc, platforms, exists, err := r.client.Tag(ctx, repository, d, tag)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s@%s", registry.ErrImageNotFound, repository, d)
+	}
+	return &model.PushedImage{
+		Name:      r.serverAddress + "/" + repository + ":" + tag,
+		Digest:    desc.Digest.String(),
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+		Platforms: platforms,
+	}, nil
+}
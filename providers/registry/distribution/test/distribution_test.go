@@ -0,0 +1,196 @@
+package distribution_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/ipaas-org/image-builder/providers/registry/oci/ocitest"
+	"github.com/opencontainers/go-digest"
+	"gotest.tools/assert"
+)
+
+// writeArchive writes a docker save tarball in the legacy format
+func writeArchive(t *testing.T) string {
+	t.Helper()
+	return writeLayerArchive(t, "layer")
+}
+
+// writeLayerArchive writes a docker save tarball whose image has the layer, the
+// archives with different layers are different images
+func writeLayerArchive(t *testing.T, layer string) string {
+	t.Helper()
+	return ocitest.WriteArchive(t, [][2]string{{"app/layer", layer}})
+}
+
+func TestPushArchive(t *testing.T) {
+	ctx := context.Background()
+	server := ocitest.NewRegistryWithAuth("user", "password")
+	defer server.Close()
+	r, err := distribution.NewDistributionRegistry(server.Host(), "user", "password")
+	assert.NilError(t, err)
+
+	name, err := r.TagImage(ctx, distribution.ArchivePrefix+writeArchive(t), "us-test", "app:abc")
+	assert.NilError(t, err)
+	assert.Equal(t, name, server.Host()+"/us-test/app:abc")
+
+	pushed, err := r.PushImage(ctx, name)
+	assert.NilError(t, err)
+	raw, mediaType, ok := server.Manifest("us-test/app", "abc")
+	assert.Assert(t, ok)
+	assert.Equal(t, pushed.Name, name)
+	assert.Equal(t, pushed.Digest, digest.FromBytes(raw).String())
+	assert.Equal(t, pushed.MediaType, mediaType)
+	assert.Equal(t, pushed.Size, int64(len(raw)))
+	assert.Equal(t, pushed.Reference(), server.Host()+"/us-test/app@"+pushed.Digest)
+
+	// the tag is consumed by the push
+	_, err = r.PushImage(ctx, name)
+	assert.Assert(t, errors.Is(err, distribution.ErrImageNotTagged), "got %v", err)
+
+	imageName, imageDigest, err := r.ImageDigest(ctx, "us-test", "app:abc")
+	assert.NilError(t, err)
+	assert.Equal(t, imageName, name)
+	assert.Equal(t, imageDigest, pushed.Digest)
+
+	_, _, err = r.ImageDigest(ctx, "us-test", "app:def")
+	assert.Assert(t, errors.Is(err, registry.ErrImageNotFound), "got %v", err)
+}
+
+func TestPushLocalImage(t *testing.T) {
+	ctx := context.Background()
+	server := ocitest.NewRegistry()
+	defer server.Close()
+	r, err := distribution.NewDistributionRegistry(server.Host(), "", "")
+	assert.NilError(t, err)
+
+	pushed, err := r.PushLocalImage(ctx, distribution.ArchivePrefix+writeArchive(t), "us-test", "app:abc")
+	assert.NilError(t, err)
+	assert.Equal(t, pushed.Name, server.Host()+"/us-test/app:abc")
+	raw, _, ok := server.Manifest("us-test/app", "abc")
+	assert.Assert(t, ok)
+	assert.Equal(t, pushed.Digest, digest.FromBytes(raw).String())
+
+	// nothing is recorded, the name can't be pushed without the local image
+	_, err = r.PushImage(ctx, pushed.Name)
+	assert.Assert(t, errors.Is(err, distribution.ErrImageNotTagged), "got %v", err)
+}
+
+func TestDeleteImage(t *testing.T) {
+	ctx := context.Background()
+	server := ocitest.NewRegistryWithAuth("user", "password")
+	defer server.Close()
+	r, err := distribution.NewDistributionRegistry(server.Host(), "user", "password")
+	assert.NilError(t, err)
+
+	name, err := r.TagImage(ctx, distribution.ArchivePrefix+writeArchive(t), "us-test", "app:abc")
+	assert.NilError(t, err)
+	pushed, err := r.PushImage(ctx, name)
+	assert.NilError(t, err)
+
+	assert.NilError(t, r.DeleteImage(ctx, name, pushed.Digest))
+	_, _, ok := server.Manifest("us-test/app", "abc")
+	assert.Assert(t, !ok)
+	_, _, err = r.ImageDigest(ctx, "us-test", "app:abc")
+	assert.Assert(t, errors.Is(err, registry.ErrImageNotFound), "got %v", err)
+
+	err = r.DeleteImage(ctx, name, pushed.Digest)
+	assert.Assert(t, errors.Is(err, registry.ErrImageNotFound), "got %v", err)
+}
+
+func TestImmutableTags(t *testing.T) {
+	ctx := context.Background()
+	server := ocitest.NewRegistry()
+	defer server.Close()
+	r, err := distribution.NewDistributionRegistry(server.Host(), "", "", oci.ClientOptions{ImmutableTags: true})
+	assert.NilError(t, err)
+
+	push := func(layer string) (*model.PushedImage, error) {
+		name, err := r.TagImage(ctx, distribution.ArchivePrefix+writeLayerArchive(t, layer), "us-test", "app:abc")
+		assert.NilError(t, err)
+		return r.PushImage(ctx, name)
+	}
+	pushed, err := push("v1")
+	assert.NilError(t, err)
+	// the same image can be pushed again
+	_, err = push("v1")
+	assert.NilError(t, err)
+
+	_, err = push("v2")
+	assert.Assert(t, errors.Is(err, registry.ErrTagExists), "got %v", err)
+	_, imageDigest, err := r.ImageDigest(ctx, "us-test", "app:abc")
+	assert.NilError(t, err)
+	assert.Equal(t, imageDigest, pushed.Digest)
+}
+
+func TestImmutableTagsCache(t *testing.T) {
+	ctx := context.Background()
+	server := ocitest.NewRegistry()
+	defer server.Close()
+	r, err := distribution.NewDistributionRegistry(server.Host(), "", "", oci.ClientOptions{ImmutableTags: true})
+	assert.NilError(t, err)
+
+	// every build moves the cache tag to its image
+	for _, layer := range []string{"v1", "v2"} {
+		name, err := r.TagImage(ctx, distribution.ArchivePrefix+writeLayerArchive(t, layer), "us-test", "app:"+registry.CacheTag)
+		assert.NilError(t, err)
+		pushed, err := r.PushImage(ctx, name)
+		assert.NilError(t, err)
+		_, imageDigest, err := r.ImageDigest(ctx, "us-test", "app:"+registry.CacheTag)
+		assert.NilError(t, err)
+		assert.Equal(t, imageDigest, pushed.Digest)
+	}
+}
+
+func TestPromoteImage(t *testing.T) {
+	ctx := context.Background()
+	server := ocitest.NewRegistry()
+	defer server.Close()
+	r, err := distribution.NewDistributionRegistry(server.Host(), "", "", oci.ClientOptions{ImmutableTags: true})
+	assert.NilError(t, err)
+
+	var images []*model.PushedImage
+	for _, commit := range []string{"abc", "def"} {
+		name, err := r.TagImage(ctx, distribution.ArchivePrefix+writeLayerArchive(t, commit), "us-test", "app:"+commit)
+		assert.NilError(t, err)
+		pushed, err := r.PushImage(ctx, name)
+		assert.NilError(t, err)
+		images = append(images, pushed)
+	}
+
+	// the promotion tags move even if the tags are immutable
+	for _, image := range images {
+		promoted, err := r.PromoteImage(ctx, "us-test", "app:production", image.Digest)
+		assert.NilError(t, err)
+		assert.Equal(t, promoted.Name, server.Host()+"/us-test/app:production")
+		assert.Equal(t, promoted.Digest, image.Digest)
+		assert.Equal(t, promoted.MediaType, image.MediaType)
+		raw, _, ok := server.Manifest("us-test/app", "production")
+		assert.Assert(t, ok)
+		assert.Equal(t, digest.FromBytes(raw).String(), image.Digest)
+	}
+
+	_, err = r.PromoteImage(ctx, "us-test", "app:staging", digest.FromString("missing").String())
+	assert.Assert(t, errors.Is(err, registry.ErrImageNotFound), "got %v", err)
+	_, _, ok := server.Manifest("us-test/app", "staging")
+	assert.Assert(t, !ok)
+}
+
+func TestPushLatest(t *testing.T) {
+	ctx := context.Background()
+	server := ocitest.NewRegistry()
+	defer server.Close()
+	r, err := distribution.NewDistributionRegistry(server.Host(), "", "")
+	assert.NilError(t, err)
+
+	name, err := r.TagImage(ctx, distribution.ArchivePrefix+writeArchive(t), "us-test", "app")
+	assert.NilError(t, err)
+	_, err = r.PushImage(ctx, name)
+	assert.NilError(t, err)
+	_, _, ok := server.Manifest("us-test/app", "latest")
+	assert.Assert(t, ok)
+}
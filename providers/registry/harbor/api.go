@@ -0,0 +1,342 @@
+package harbor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+)
+
+// pullRobotName is the name of the robot account of the projects, harbor
+// prefixes it with robot$<project>+
+const pullRobotName = "pull"
+
+var (
+	errNotFound = errors.New("not found")
+	errConflict = errors.New("already exists")
+)
+
+// apiClient calls the harbor v2 api, only the endpoints used to manage the projects of the users
+type apiClient struct {
+	url      string // https://harbor.example.com/api/v2.0
+	username string
+	password string
+	http     *http.Client
+}
+
+type (
+	project struct {
+		ProjectID int64             `json:"project_id"`
+		Name      string            `json:"name"`
+		Metadata  map[string]string `json:"metadata"`
+	}
+
+	quota struct {
+		ID   int64            `json:"id,omitempty"`
+		Hard map[string]int64 `json:"hard"`
+	}
+
+	robot struct {
+		ID        int64  `json:"id"`
+		Name      string `json:"name"`
+		Secret    string `json:"secret,omitempty"`
+		ExpiresAt int64  `json:"expires_at"` // unix seconds, -1 if it never expires
+	}
+
+	robotPermission struct {
+		Kind      string        `json:"kind"`
+		Namespace string        `json:"namespace"`
+		Access    []robotAccess `json:"access"`
+	}
+
+	robotAccess struct {
+		Resource string `json:"resource"`
+		Action   string `json:"action"`
+	}
+
+	retentionPolicy struct {
+		ID        int64           `json:"id,omitempty"`
+		Algorithm string          `json:"algorithm"`
+		Rules     []retentionRule `json:"rules"`
+		Trigger   struct {
+			Kind     string            `json:"kind"`
+			Settings map[string]string `json:"settings"`
+		} `json:"trigger"`
+		Scope struct {
+			Level string `json:"level"`
+			Ref   int64  `json:"ref"`
+		} `json:"scope"`
+	}
+
+	retentionRule struct {
+		Action         string                         `json:"action"`
+		Template       string                         `json:"template"`
+		Params         map[string]int                 `json:"params"`
+		TagSelectors   []retentionSelector            `json:"tag_selectors"`
+		ScopeSelectors map[string][]retentionSelector `json:"scope_selectors"`
+	}
+
+	retentionSelector struct {
+		Kind       string `json:"kind"`
+		Decoration string `json:"decoration"`
+		Pattern    string `json:"pattern"`
+	}
+
+	apiErrors struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+)
+
+// do sends the request with body encoded as json and decodes the response in out if not nil
+func (c *apiClient) do(ctx context.Context, method, path string, body, out any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Is-Resource-Name", "true")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		var e apiErrors
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		message := strings.TrimSpace(string(raw))
+		if json.Unmarshal(raw, &e) == nil && len(e.Errors) > 0 {
+			message = e.Errors[0].Message
+		}
+		err := fmt.Errorf("harbor %s %s returned %s: %s", method, path, resp.Status, message)
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			err = fmt.Errorf("%w: %v", errNotFound, err)
+		case http.StatusConflict:
+			err = fmt.Errorf("%w: %v", errConflict, err)
+		}
+		return resp, err
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("harbor %s %s: invalid response: %w", method, path, err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *apiClient) getProject(ctx context.Context, name string) (*project, error) {
+	p := new(project)
+	if _, err := c.do(ctx, http.MethodGet, "/projects/"+url.PathEscape(name), nil, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// createProject creates a private project, a storageLimit of -1 means unlimited.
+// errConflict is returned if the project exists
+func (c *apiClient) createProject(ctx context.Context, name string, storageLimit int64) error {
+	body := map[string]any{
+		"project_name":  name,
+		"metadata":      map[string]string{"public": "false"},
+		"storage_limit": storageLimit,
+	}
+	_, err := c.do(ctx, http.MethodPost, "/projects", body, nil)
+	return err
+}
+
+// deleteProject deletes the repositories of the project and the project,
+// harbor refuses to delete the projects that are not empty
+func (c *apiClient) deleteProject(ctx context.Context, name string) error {
+	for {
+		var repositories []struct {
+			Name string `json:"name"` // project/repository
+		}
+		path := "/projects/" + url.PathEscape(name) + "/repositories?page=1&page_size=100"
+		if _, err := c.do(ctx, http.MethodGet, path, nil, &repositories); err != nil {
+			return err
+		}
+		if len(repositories) == 0 {
+			break
+		}
+		deleted := 0
+		for _, repository := range repositories {
+			// the repository names with a slash are escaped twice
+			repo := strings.TrimPrefix(repository.Name, name+"/")
+			path := "/projects/" + url.PathEscape(name) + "/repositories/" + url.PathEscape(url.PathEscape(repo))
+			_, err := c.do(ctx, http.MethodDelete, path, nil, nil)
+			switch {
+			case err == nil:
+				deleted++
+			case !errors.Is(err, errNotFound):
+				return err
+			}
+		}
+		if deleted == 0 {
+			return fmt.Errorf("the repositories of %s can't be deleted", name)
+		}
+	}
+	_, err := c.do(ctx, http.MethodDelete, "/projects/"+url.PathEscape(name), nil, nil)
+	return err
+}
+
+// setQuota sets the storage quota of the project, -1 means unlimited
+func (c *apiClient) setQuota(ctx context.Context, projectID, storageLimit int64) error {
+	var quotas []quota
+	path := "/quotas?reference=project&reference_id=" + strconv.FormatInt(projectID, 10)
+	if _, err := c.do(ctx, http.MethodGet, path, nil, &quotas); err != nil {
+		return err
+	}
+	if len(quotas) == 0 {
+		return fmt.Errorf("%w: quota of project %d", errNotFound, projectID)
+	}
+	if quotas[0].Hard["storage"] == storageLimit {
+		return nil
+	}
+	body := quota{Hard: map[string]int64{"storage": storageLimit}}
+	_, err := c.do(ctx, http.MethodPut, "/quotas/"+strconv.FormatInt(quotas[0].ID, 10), body, nil)
+	return err
+}
+
+// setRetention makes the project keep the retainTags most recently pushed tags of each
+// repository and the keepTags, the others are removed every day. The policy of the project is replaced
+func (c *apiClient) setRetention(ctx context.Context, p *project, retainTags int, keepTags []string) error {
+	policy := retentionPolicy{
+		Algorithm: "or",
+		Rules: []retentionRule{{
+			Action:       "retain",
+			Template:     "latestPushedK",
+			Params:       map[string]int{"latestPushedK": retainTags},
+			TagSelectors: []retentionSelector{{Kind: "doublestar", Decoration: "matches", Pattern: "**"}},
+			ScopeSelectors: map[string][]retentionSelector{
+				"repository": {{Kind: "doublestar", Decoration: "repoMatches", Pattern: "**"}},
+			},
+		}},
+	}
+	if len(keepTags) > 0 {
+		pattern := keepTags[0]
+		if len(keepTags) > 1 {
+			pattern = "{" + strings.Join(keepTags, ",") + "}"
+		}
+		policy.Rules = append(policy.Rules, retentionRule{
+			Action:       "retain",
+			Template:     "always",
+			Params:       map[string]int{},
+			TagSelectors: []retentionSelector{{Kind: "doublestar", Decoration: "matches", Pattern: pattern}},
+			ScopeSelectors: map[string][]retentionSelector{
+				"repository": {{Kind: "doublestar", Decoration: "repoMatches", Pattern: "**"}},
+			},
+		})
+	}
+	policy.Trigger.Kind = "Schedule"
+	policy.Trigger.Settings = map[string]string{"cron": "0 0 0 * * *"}
+	policy.Scope.Level = "project"
+	policy.Scope.Ref = p.ProjectID
+
+	if id := p.Metadata["retention_id"]; id != "" {
+		_, err := c.do(ctx, http.MethodPut, "/retentions/"+url.PathEscape(id), policy, nil)
+		return err
+	}
+	_, err := c.do(ctx, http.MethodPost, "/retentions", policy, nil)
+	return err
+}
+
+// pullRobot returns the pull robot of the project, errNotFound if it doesn't exist
+func (c *apiClient) pullRobot(ctx context.Context, p *project) (*robot, error) {
+	var robots []robot
+	query := url.QueryEscape("Level=project,ProjectID=" + strconv.FormatInt(p.ProjectID, 10))
+	if _, err := c.do(ctx, http.MethodGet, "/robots?page_size=100&q="+query, nil, &robots); err != nil {
+		return nil, err
+	}
+	for _, r := range robots {
+		if strings.HasSuffix(r.Name, "+"+pullRobotName) {
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: pull robot of %s", errNotFound, p.Name)
+}
+
+// createPullRobot creates the robot that can only pull the images of the project,
+// a duration of -1 days means it never expires
+func (c *apiClient) createPullRobot(ctx context.Context, p *project, durationDays int) (*robot, error) {
+	body := map[string]any{
+		"name":        pullRobotName,
+		"description": "pulls the images of " + p.Name,
+		"level":       "project",
+		"duration":    durationDays,
+		"disable":     false,
+		"permissions": []robotPermission{{
+			Kind:      "project",
+			Namespace: p.Name,
+			Access:    []robotAccess{{Resource: "repository", Action: "pull"}},
+		}},
+	}
+	r := new(robot)
+	if _, err := c.do(ctx, http.MethodPost, "/robots", body, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// refreshSecret replaces the secret of the robot, the previous one stops working
+func (c *apiClient) refreshSecret(ctx context.Context, r *robot) error {
+	var body struct {
+		Secret string `json:"secret"`
+	}
+	if _, err := c.do(ctx, http.MethodPatch, "/robots/"+strconv.FormatInt(r.ID, 10), body, &body); err != nil {
+		return err
+	}
+	if body.Secret == "" {
+		return fmt.Errorf("harbor returned an empty secret for robot %s", r.Name)
+	}
+	r.Secret = body.Secret
+	return nil
+}
+
+func (c *apiClient) deleteRobot(ctx context.Context, r *robot) error {
+	_, err := c.do(ctx, http.MethodDelete, "/robots/"+strconv.FormatInt(r.ID, 10), nil, nil)
+	return err
+}
+
+// expired reports if the robot can't be used anymore
+func (r *robot) expired(now time.Time) bool {
+	return r.ExpiresAt > 0 && !now.Before(time.Unix(r.ExpiresAt, 0))
+}
+
+// credentials returns the credentials of the robot, the robot must have its secret
+func (r *robot) credentials(serverAddress string) *model.RegistryCredentials {
+	credentials := &model.RegistryCredentials{
+		Registry: serverAddress,
+		Username: r.Name,
+		Password: r.Secret,
+	}
+	if r.ExpiresAt > 0 {
+		expiresAt := time.Unix(r.ExpiresAt, 0).UTC()
+		credentials.ExpiresAt = &expiresAt
+	}
+	return credentials
+}
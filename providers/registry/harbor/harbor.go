@@ -3,15 +3,28 @@ package harbor
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/docker/docker/errdefs"
-	"github.com/ipaas-org/image-builder/providers/registry/registry"
-	goharbor "github.com/x893675/go-harbor"
-	"github.com/x893675/go-harbor/schema"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	defaultRegistry "github.com/ipaas-org/image-builder/providers/registry/registry"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	_ registry.Registryer     = new(HarborClient)
+	_ registry.IndexPusher    = new(HarborClient)
+	_ registry.CacheRegistry  = new(HarborClient)
+	_ registry.ImageInspector = new(HarborClient)
+	_ registry.ArtifactPusher = new(HarborClient)
+	_ registry.UserManager    = new(HarborClient)
+	_ registry.ImageDeleter   = new(HarborClient)
+	_ registry.ImagePromoter  = new(HarborClient)
 )
 
 type ErrorLine struct {
@@ -23,50 +36,72 @@ type ErrorDetail struct {
 	Message string `json:"message"`
 }
 
+// HarborClient pushes the images of each user in a private project named after the user,
+// the users pull them with the credentials of a robot account of their project
 type HarborClient struct {
-	serverAddress    string
-	username         string
-	password         string
-	userPullUsername string
-	userPullPassword string
+	serverAddress   string
+	storageQuota    int64
+	retainTags      int
+	keepTags        []string
+	credentialsDays int
+
+	registry *defaultRegistry.Registry
+	api      *apiClient
+}
 
-	registry     *registry.Registry
-	harborClient *goharbor.Client
+type HarborOptions struct {
+	// url of the harbor api, https://<registryUri>/api/v2.0 by default
+	APIURL     string
+	HTTPClient *http.Client
+	// storage quota of the project of each user in bytes, unlimited if zero
+	StorageQuota int64
+	// tags kept in each repository of the users (the most recently pushed),
+	// the others are removed every day. Every tag is kept if zero
+	RetainTags int
+	// tags never removed by the retention policy, the environment tags of the promotions
+	KeepTags []string
+	// refuse to push an image with a tag that exists with a different image
+	ImmutableTags bool
+	// validity of the pull credentials in days, they never expire if zero
+	CredentialsDays int
 }
 
-// if no authentication is required, leave username and password empty
-func NewHarborRegistry(registryUri, username, password, userPullUsername, userPullPassword string) (*HarborClient, error) {
+func NewHarborRegistry(registryUri, username, password string, opt ...HarborOptions) (*HarborClient, error) {
 	if username == "" || password == "" {
 		return nil, fmt.Errorf("username and password are required")
 	}
-	if userPullUsername == "" || userPullPassword == "" {
-		return nil, fmt.Errorf("userPullUsername and userPullPassword are required")
+	var o HarborOptions
+	if len(opt) > 0 {
+		o = opt[0]
 	}
-
-	c := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	if o.APIURL == "" {
+		o.APIURL = "https://" + registryUri + "/api/v2.0"
 	}
-	harborClient, err := goharbor.NewClientWithOpts(goharbor.WithHost("https://"+registryUri),
-		goharbor.WithHTTPClient(c),
-		goharbor.WithBasicAuth(username, password))
-	if err != nil {
-		return nil, err
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
 	}
 
-	r, err := registry.NewDefaultRegistry(registryUri, username, password)
+	r, err := defaultRegistry.NewDefaultRegistry(registryUri, username, password, oci.ClientOptions{ImmutableTags: o.ImmutableTags})
 	if err != nil {
 		return nil, err
 	}
 	return &HarborClient{
-		serverAddress:    registryUri,
-		username:         username,
-		password:         password,
-		userPullUsername: userPullUsername,
-		// userPullPassword: userPullPassword,
-		registry:     r,
-		harborClient: harborClient,
+		serverAddress:   registryUri,
+		storageQuota:    o.StorageQuota,
+		retainTags:      o.RetainTags,
+		keepTags:        o.KeepTags,
+		credentialsDays: o.CredentialsDays,
+		registry:        r,
+		api: &apiClient{
+			url:      strings.TrimSuffix(o.APIURL, "/"),
+			username: username,
+			password: password,
+			http:     o.HTTPClient,
+		},
 	}, nil
 }
 
@@ -74,50 +109,144 @@ func (r *HarborClient) TagImage(ctx context.Context, localImageID, userCode, app
 	ctx, cancel := context.WithTimeout(ctx, time.Minute*3)
 	defer cancel()
 
-	_, err := r.harborClient.GetProject(ctx, userCode)
+	if err := r.ensureProject(ctx, userCode); err != nil {
+		return "", err
+	}
+	return r.registry.TagImage(ctx, localImageID, userCode, appName)
+}
+
+// PushIndex pushes an image from an oci layout, the user's project is created if missing
+func (r *HarborClient) PushIndex(ctx context.Context, layoutPath, userCode, appName string) (*model.PushedImage, error) {
+	if err := r.ensureProject(ctx, userCode); err != nil {
+		return nil, err
+	}
+	return r.registry.PushIndex(ctx, layoutPath, userCode, appName)
+}
+
+// CacheReference returns the reference of the cache of the application, the user's
+// project is created if missing since the builders push the cache on their own
+func (r *HarborClient) CacheReference(ctx context.Context, userCode, appName string) (string, error) {
+	if err := r.ensureProject(ctx, userCode); err != nil {
+		return "", err
+	}
+	return r.registry.CacheReference(ctx, userCode, appName)
+}
+
+// ImageDigest resolves the image in the registry, the project is not created if missing
+func (r *HarborClient) ImageDigest(ctx context.Context, userCode, appName string) (string, string, error) {
+	return r.registry.ImageDigest(ctx, userCode, appName)
+}
+
+// PushArtifact pushes the artifact in the repository of the image, the project exists since the image was pushed
+func (r *HarborClient) PushArtifact(ctx context.Context, imageName, reference string, artifact *oci.Artifact) (ocispec.Descriptor, error) {
+	return r.registry.PushArtifact(ctx, imageName, reference, artifact)
+}
+
+// DeleteImage deletes the artifact of the digest, harbor removes its accessories (signatures, sboms) too
+func (r *HarborClient) DeleteImage(ctx context.Context, imageName, digest string) error {
+	return r.registry.DeleteImage(ctx, imageName, digest)
+}
+
+// PromoteImage tags the image of the digest as appName (name:tag), the project exists since the image was pushed
+func (r *HarborClient) PromoteImage(ctx context.Context, userCode, appName, digest string) (*model.PushedImage, error) {
+	return r.registry.PromoteImage(ctx, userCode, appName, digest)
+}
+
+// ensureProject creates the project of the user if it doesn't exist yet,
+// with the storage quota and the retention policy configured
+func (r *HarborClient) ensureProject(ctx context.Context, userCode string) error {
+	_, _, err := r.project(ctx, userCode)
+	return err
+}
+
+// project returns the project of the user, created reports if it was created (and configured)
+func (r *HarborClient) project(ctx context.Context, userCode string) (p *project, created bool, err error) {
+	p, err = r.api.getProject(ctx, userCode)
+	if err == nil {
+		return p, false, nil
+	}
+	if !errors.Is(err, errNotFound) {
+		return nil, false, err
+	}
+
+	err = r.api.createProject(ctx, userCode, r.storageLimit())
+	created = err == nil
+	if err != nil && !errors.Is(err, errConflict) {
+		return nil, false, fmt.Errorf("create the project of %s: %w", userCode, err)
+	}
+	// the project may have been created by another replica, it configures it
+	p, err = r.api.getProject(ctx, userCode)
 	if err != nil {
-		log.Println("project not found, creating project of name:", userCode, "error is", err)
-		if errdefs.IsNotFound(err) {
-			log.Println("creating project")
-			err = r.harborClient.CreateProject(ctx, schema.CreateProjectOptions{
-				Name: userCode,
-				Metadata: &schema.ProjectMetadata{
-					Public: "false",
-				},
-			})
-			if err != nil {
-				log.Println("error creating project", err)
-				return "", err
-			}
-			// log.Println("sleeping cause it takes time to create project")
-			// time.Sleep(2 * time.Second)
-			log.Println("getting project after create")
-			project, err := r.harborClient.GetProject(ctx, userCode)
-			if err != nil {
-				log.Println("error getting project after create", err)
-				return "", err
-			}
+		return nil, false, err
+	}
+	if created && r.retainTags > 0 {
+		if err := r.api.setRetention(ctx, p, r.retainTags, r.keepTags); err != nil {
+			return nil, false, fmt.Errorf("set the retention policy of %s: %w", userCode, err)
+		}
+	}
+	return p, created, nil
+}
+
+// storageLimit returns the storage quota of the projects, -1 is unlimited for harbor
+func (r *HarborClient) storageLimit() int64 {
+	if r.storageQuota <= 0 {
+		return -1
+	}
+	return r.storageQuota
+}
 
-			log.Println("adding project member")
-			err = r.harborClient.AddProjectMember(ctx, project.ProjectID, schema.ProjectMember{
-				RoleID:      schema.Guest,
-				MemberGroup: schema.UserGroup{},
-				MemberUser: schema.UserEntity{
-					Username: r.userPullUsername,
-				},
-			})
-			if err != nil {
-				log.Println("error adding project member", err)
-				return "", err
+// PullCredentials creates the project of the user if missing and returns the credentials of
+// its pull robot, the secret of the robot is replaced so the previous credentials stop working.
+// The quota and the retention policy of the project are updated to the configured ones
+func (r *HarborClient) PullCredentials(ctx context.Context, userCode string) (*model.RegistryCredentials, error) {
+	p, created, err := r.project(ctx, userCode)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		if err := r.api.setQuota(ctx, p.ProjectID, r.storageLimit()); err != nil {
+			return nil, fmt.Errorf("set the quota of %s: %w", userCode, err)
+		}
+		if r.retainTags > 0 {
+			if err := r.api.setRetention(ctx, p, r.retainTags, r.keepTags); err != nil {
+				return nil, fmt.Errorf("set the retention policy of %s: %w", userCode, err)
 			}
-		} else {
-			return "", err
 		}
 	}
 
-	return r.registry.TagImage(ctx, localImageID, userCode, appName)
+	robot, err := r.api.pullRobot(ctx, p)
+	if err == nil && robot.expired(time.Now()) {
+		// refreshing the secret doesn't extend the robot, a new one is created
+		if err := r.api.deleteRobot(ctx, robot); err != nil && !errors.Is(err, errNotFound) {
+			return nil, fmt.Errorf("delete the expired pull robot of %s: %w", userCode, err)
+		}
+		err = fmt.Errorf("%w: pull robot of %s expired", errNotFound, userCode)
+	}
+	switch {
+	case err == nil:
+		err = r.api.refreshSecret(ctx, robot)
+	case errors.Is(err, errNotFound):
+		days := r.credentialsDays
+		if days <= 0 {
+			days = -1
+		}
+		robot, err = r.api.createPullRobot(ctx, p, days)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pull robot of %s: %w", userCode, err)
+	}
+	return robot.credentials(r.serverAddress), nil
+}
+
+// DeleteUser deletes the project of the user with its images and its robot,
+// nothing is done if the project doesn't exist
+func (r *HarborClient) DeleteUser(ctx context.Context, userCode string) error {
+	if err := r.api.deleteProject(ctx, userCode); err != nil && !errors.Is(err, errNotFound) {
+		return fmt.Errorf("delete the project of %s: %w", userCode, err)
+	}
+	return nil
 }
 
-func (r *HarborClient) PushImage(ctx context.Context, imageID string) error {
+func (r *HarborClient) PushImage(ctx context.Context, imageID string) (*model.PushedImage, error) {
 	return r.registry.PushImage(ctx, imageID)
 }
@@ -0,0 +1,506 @@
+// Package harbortest provides an in-process fake of the harbor v2 api for the tests,
+// only the projects, repositories, quotas, retentions and robots are supported
+package harbortest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Project is the state of a project of the fake
+type Project struct {
+	ID           int64
+	Name         string
+	Public       bool
+	StorageLimit int64 // -1 if unlimited
+	// most recently pushed tags kept by the retention policy, 0 if there is no policy
+	RetainTags int
+	// pattern of the tags always kept by the retention policy ({staging,production})
+	KeepTags     string
+	Repositories []string // without the project (app, not project/app)
+}
+
+type robot struct {
+	id        int64
+	name      string // robot$project+name
+	projectID int64
+	secret    string
+	expiresAt int64
+	actions   []string // resource:action
+}
+
+// Server is the fake harbor, the api is served under /api/v2.0 and requires basic auth
+type Server struct {
+	*httptest.Server
+
+	username string
+	password string
+
+	mu         sync.Mutex
+	nextID     int64
+	projects   map[string]*Project
+	robots     map[int64]*robot
+	retentions map[int64]int64 // retention id -> project id
+	requests   int
+}
+
+func NewServer(username, password string) *Server {
+	s := &Server{
+		username:   username,
+		password:   password,
+		projects:   make(map[string]*Project),
+		robots:     make(map[int64]*robot),
+		retentions: make(map[int64]int64),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2.0/projects/{name}", s.getProject)
+	mux.HandleFunc("POST /api/v2.0/projects", s.createProject)
+	mux.HandleFunc("DELETE /api/v2.0/projects/{name}", s.deleteProject)
+	mux.HandleFunc("GET /api/v2.0/projects/{name}/repositories", s.listRepositories)
+	mux.HandleFunc("DELETE /api/v2.0/projects/{name}/repositories/{repo}", s.deleteRepository)
+	mux.HandleFunc("GET /api/v2.0/quotas", s.listQuotas)
+	mux.HandleFunc("PUT /api/v2.0/quotas/{id}", s.updateQuota)
+	mux.HandleFunc("POST /api/v2.0/retentions", s.createRetention)
+	mux.HandleFunc("PUT /api/v2.0/retentions/{id}", s.updateRetention)
+	mux.HandleFunc("GET /api/v2.0/robots", s.listRobots)
+	mux.HandleFunc("POST /api/v2.0/robots", s.createRobot)
+	mux.HandleFunc("PATCH /api/v2.0/robots/{id}", s.refreshRobot)
+	mux.HandleFunc("DELETE /api/v2.0/robots/{id}", s.deleteRobot)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != s.username || p != s.password {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "unauthorized")
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// APIURL returns the url of the api, to use as harbor.HarborOptions.APIURL
+func (s *Server) APIURL() string {
+	return s.URL + "/api/v2.0"
+}
+
+// Project returns a copy of the project
+func (s *Server) Project(name string) (Project, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.projects[name]
+	if !ok {
+		return Project{}, false
+	}
+	project := *p
+	project.Repositories = append([]string(nil), p.Repositories...)
+	return project, true
+}
+
+// AddProject creates a project as if it was created in the ui, without retention policy
+func (s *Server) AddProject(name string, repositories ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.projects[name] = &Project{ID: s.nextID, Name: name, StorageLimit: -1, Repositories: repositories}
+}
+
+// AddRepository adds a repository to the project, as if an image was pushed
+func (s *Server) AddRepository(project, repository string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.projects[project]; ok {
+		p.Repositories = append(p.Repositories, repository)
+	}
+}
+
+// Login reports if username and secret are the credentials of a robot that can
+// pull the images of the project, the expired robots can't log in
+func (s *Server) Login(project, username, secret string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.projects[project]
+	if !ok {
+		return false
+	}
+	for _, r := range s.robots {
+		if r.name == username && r.secret == secret && r.projectID == p.ID &&
+			(r.expiresAt < 0 || time.Now().Unix() < r.expiresAt) {
+			return len(r.actions) == 1 && r.actions[0] == "repository:pull"
+		}
+	}
+	return false
+}
+
+// ExpireRobots makes the robots of the project expired
+func (s *Server) ExpireRobots(project string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.projects[project]
+	if !ok {
+		return
+	}
+	for _, r := range s.robots {
+		if r.projectID == p.ID {
+			r.expiresAt = time.Now().Add(-time.Hour).Unix()
+		}
+	}
+}
+
+// Robots returns the number of robots
+func (s *Server) Robots() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.robots)
+}
+
+// Requests returns the number of requests served
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) projectByID(id int64) *Project {
+	for _, p := range s.projects {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *Server) getProject(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.projects[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "project not found")
+		return
+	}
+	metadata := map[string]string{"public": strconv.FormatBool(p.Public)}
+	for id, projectID := range s.retentions {
+		if projectID == p.ID {
+			metadata["retention_id"] = strconv.FormatInt(id, 10)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"project_id": p.ID, "name": p.Name, "metadata": metadata})
+}
+
+func (s *Server) createProject(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name         string            `json:"project_name"`
+		Metadata     map[string]string `json:"metadata"`
+		StorageLimit *int64            `json:"storage_limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid project")
+		return
+	}
+	if _, ok := s.projects[body.Name]; ok {
+		writeError(w, http.StatusConflict, "CONFLICT", "project "+body.Name+" already exists")
+		return
+	}
+	s.nextID++
+	p := &Project{ID: s.nextID, Name: body.Name, Public: body.Metadata["public"] == "true", StorageLimit: -1}
+	if body.StorageLimit != nil {
+		p.StorageLimit = *body.StorageLimit
+	}
+	s.projects[p.Name] = p
+	w.Header().Set("Location", "/api/v2.0/projects/"+strconv.FormatInt(p.ID, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) deleteProject(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.projects[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "project not found")
+		return
+	}
+	if len(p.Repositories) > 0 {
+		writeError(w, http.StatusPreconditionFailed, "PRECONDITION", "the project contains repositories, can not be deleted")
+		return
+	}
+	delete(s.projects, p.Name)
+	for id, r := range s.robots {
+		if r.projectID == p.ID {
+			delete(s.robots, id)
+		}
+	}
+	for id, projectID := range s.retentions {
+		if projectID == p.ID {
+			delete(s.retentions, id)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) listRepositories(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.projects[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "project not found")
+		return
+	}
+	// a page of two repositories, the client must list them again
+	repositories := []map[string]string{}
+	for i, name := range p.Repositories {
+		if i == 2 {
+			break
+		}
+		repositories = append(repositories, map[string]string{"name": p.Name + "/" + name})
+	}
+	writeJSON(w, http.StatusOK, repositories)
+}
+
+func (s *Server) deleteRepository(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.projects[r.PathValue("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "project not found")
+		return
+	}
+	// the names with a slash are escaped twice by the clients
+	name, err := url.PathUnescape(r.PathValue("repo"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	for i, repository := range p.Repositories {
+		if repository == name {
+			p.Repositories = append(p.Repositories[:i], p.Repositories[i+1:]...)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "repository not found")
+}
+
+// the quota of a project has the id of the project
+func (s *Server) listQuotas(w http.ResponseWriter, r *http.Request) {
+	quotas := []map[string]any{}
+	id, _ := strconv.ParseInt(r.URL.Query().Get("reference_id"), 10, 64)
+	if p := s.projectByID(id); p != nil && r.URL.Query().Get("reference") == "project" {
+		quotas = append(quotas, map[string]any{"id": p.ID, "hard": map[string]int64{"storage": p.StorageLimit}})
+	}
+	writeJSON(w, http.StatusOK, quotas)
+}
+
+func (s *Server) updateQuota(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	p := s.projectByID(id)
+	if p == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "quota not found")
+		return
+	}
+	var body struct {
+		Hard map[string]int64 `json:"hard"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	storage, ok := body.Hard["storage"]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "missing storage")
+		return
+	}
+	p.StorageLimit = storage
+	w.WriteHeader(http.StatusOK)
+}
+
+type retention struct {
+	Algorithm string `json:"algorithm"`
+	Rules     []struct {
+		Action       string         `json:"action"`
+		Template     string         `json:"template"`
+		Params       map[string]int `json:"params"`
+		TagSelectors []struct {
+			Pattern string `json:"pattern"`
+		} `json:"tag_selectors"`
+	} `json:"rules"`
+	Scope struct {
+		Level string `json:"level"`
+		Ref   int64  `json:"ref"`
+	} `json:"scope"`
+}
+
+// decodeRetention returns the project of the policy, the tags retained and the pattern of the
+// "always" rule. Only a latestPushedK rule followed by an optional "always" rule is supported
+func (s *Server) decodeRetention(w http.ResponseWriter, r *http.Request) (*Project, int, string, bool) {
+	var body retention
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return nil, 0, "", false
+	}
+	p := s.projectByID(body.Scope.Ref)
+	if body.Scope.Level != "project" || p == nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid scope")
+		return nil, 0, "", false
+	}
+	rules := body.Rules
+	if len(rules) == 0 || len(rules) > 2 || rules[0].Template != "latestPushedK" || rules[0].Action != "retain" {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "unsupported rules")
+		return nil, 0, "", false
+	}
+	keepTags := ""
+	if len(rules) == 2 {
+		if rules[1].Template != "always" || rules[1].Action != "retain" || len(rules[1].TagSelectors) != 1 {
+			writeError(w, http.StatusBadRequest, "BAD_REQUEST", "unsupported rules")
+			return nil, 0, "", false
+		}
+		keepTags = rules[1].TagSelectors[0].Pattern
+	}
+	return p, rules[0].Params["latestPushedK"], keepTags, true
+}
+
+func (s *Server) createRetention(w http.ResponseWriter, r *http.Request) {
+	p, retain, keepTags, ok := s.decodeRetention(w, r)
+	if !ok {
+		return
+	}
+	for _, projectID := range s.retentions {
+		if projectID == p.ID {
+			writeError(w, http.StatusConflict, "CONFLICT", "the project has a retention policy")
+			return
+		}
+	}
+	s.nextID++
+	s.retentions[s.nextID] = p.ID
+	p.RetainTags = retain
+	p.KeepTags = keepTags
+	w.Header().Set("Location", "/api/v2.0/retentions/"+strconv.FormatInt(s.nextID, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) updateRetention(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if _, ok := s.retentions[id]; !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "retention not found")
+		return
+	}
+	p, retain, keepTags, ok := s.decodeRetention(w, r)
+	if !ok {
+		return
+	}
+	p.RetainTags = retain
+	p.KeepTags = keepTags
+	w.WriteHeader(http.StatusOK)
+}
+
+// listRobots supports the Level=project,ProjectID=<id> query
+func (s *Server) listRobots(w http.ResponseWriter, r *http.Request) {
+	var projectID int64
+	for _, filter := range strings.Split(r.URL.Query().Get("q"), ",") {
+		if id, ok := strings.CutPrefix(filter, "ProjectID="); ok {
+			projectID, _ = strconv.ParseInt(id, 10, 64)
+		}
+	}
+	robots := []map[string]any{}
+	for _, robot := range s.robots {
+		if projectID == 0 || robot.projectID == projectID {
+			robots = append(robots, map[string]any{"id": robot.id, "name": robot.name, "expires_at": robot.expiresAt})
+		}
+	}
+	writeJSON(w, http.StatusOK, robots)
+}
+
+func (s *Server) createRobot(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name        string `json:"name"`
+		Level       string `json:"level"`
+		Duration    int    `json:"duration"`
+		Permissions []struct {
+			Kind      string `json:"kind"`
+			Namespace string `json:"namespace"`
+			Access    []struct {
+				Resource string `json:"resource"`
+				Action   string `json:"action"`
+			} `json:"access"`
+		} `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	if body.Level != "project" || len(body.Permissions) != 1 || body.Permissions[0].Kind != "project" {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "only the project robots are supported")
+		return
+	}
+	p, ok := s.projects[body.Permissions[0].Namespace]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "project not found")
+		return
+	}
+	name := fmt.Sprintf("robot$%s+%s", p.Name, body.Name)
+	for _, robot := range s.robots {
+		if robot.name == name {
+			writeError(w, http.StatusConflict, "CONFLICT", "robot "+name+" already exists")
+			return
+		}
+	}
+	s.nextID++
+	robot := &robot{id: s.nextID, name: name, projectID: p.ID, secret: uuid.NewString(), expiresAt: -1}
+	if body.Duration > 0 {
+		robot.expiresAt = time.Now().AddDate(0, 0, body.Duration).Unix()
+	}
+	for _, access := range body.Permissions[0].Access {
+		robot.actions = append(robot.actions, access.Resource+":"+access.Action)
+	}
+	s.robots[robot.id] = robot
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"id":         robot.id,
+		"name":       robot.name,
+		"secret":     robot.secret,
+		"expires_at": robot.expiresAt,
+	})
+}
+
+func (s *Server) refreshRobot(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	robot, ok := s.robots[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "robot not found")
+		return
+	}
+	var body struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	robot.secret = body.Secret
+	if robot.secret == "" {
+		robot.secret = uuid.NewString()
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"secret": robot.secret})
+}
+
+func (s *Server) deleteRobot(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if _, ok := s.robots[id]; !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "robot not found")
+		return
+	}
+	delete(s.robots, id)
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the errors like harbor does
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,165 @@
+package harbor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/providers/registry/harbor"
+	"github.com/ipaas-org/image-builder/providers/registry/harbor/harbortest"
+	"gotest.tools/assert"
+)
+
+func newFakeHarbor(t *testing.T, opt harbor.HarborOptions) (*harbor.HarborClient, *harbortest.Server) {
+	t.Helper()
+	server := harbortest.NewServer("admin", "Harbor12345")
+	t.Cleanup(server.Close)
+	opt.APIURL = server.APIURL()
+	r, err := harbor.NewHarborRegistry("registry.example.com", "admin", "Harbor12345", opt)
+	assert.NilError(t, err)
+	return r, server
+}
+
+func TestEnsureProject(t *testing.T) {
+	ctx := context.Background()
+	r, server := newFakeHarbor(t, harbor.HarborOptions{StorageQuota: 1 << 30, RetainTags: 5})
+
+	reference, err := r.CacheReference(ctx, "us-test", "app")
+	assert.NilError(t, err)
+	assert.Equal(t, reference, "registry.example.com/us-test/app:buildcache")
+	project, ok := server.Project("us-test")
+	assert.Assert(t, ok)
+	assert.Assert(t, !project.Public)
+	assert.Equal(t, project.StorageLimit, int64(1<<30))
+	assert.Equal(t, project.RetainTags, 5)
+
+	// the existing projects are only looked up
+	requests := server.Requests()
+	_, err = r.CacheReference(ctx, "us-test", "app")
+	assert.NilError(t, err)
+	assert.Equal(t, server.Requests(), requests+1)
+}
+
+func TestEnsureProjectKeepTags(t *testing.T) {
+	ctx := context.Background()
+	r, server := newFakeHarbor(t, harbor.HarborOptions{RetainTags: 5, KeepTags: []string{"staging", "production"}})
+
+	_, err := r.CacheReference(ctx, "us-test", "app")
+	assert.NilError(t, err)
+	project, _ := server.Project("us-test")
+	assert.Equal(t, project.RetainTags, 5)
+	assert.Equal(t, project.KeepTags, "{staging,production}")
+
+	r, server = newFakeHarbor(t, harbor.HarborOptions{RetainTags: 5, KeepTags: []string{"production"}})
+	_, err = r.CacheReference(ctx, "us-test", "app")
+	assert.NilError(t, err)
+	project, _ = server.Project("us-test")
+	assert.Equal(t, project.KeepTags, "production")
+}
+
+func TestEnsureProjectUnlimited(t *testing.T) {
+	r, server := newFakeHarbor(t, harbor.HarborOptions{})
+
+	_, err := r.CacheReference(context.Background(), "us-test", "app")
+	assert.NilError(t, err)
+	project, _ := server.Project("us-test")
+	assert.Equal(t, project.StorageLimit, int64(-1))
+	assert.Equal(t, project.RetainTags, 0)
+}
+
+func TestPullCredentials(t *testing.T) {
+	ctx := context.Background()
+	r, server := newFakeHarbor(t, harbor.HarborOptions{StorageQuota: 1 << 30, RetainTags: 5})
+
+	credentials, err := r.PullCredentials(ctx, "us-test")
+	assert.NilError(t, err)
+	assert.Equal(t, credentials.Registry, "registry.example.com")
+	assert.Equal(t, credentials.Username, "robot$us-test+pull")
+	assert.Assert(t, credentials.ExpiresAt == nil)
+	assert.Assert(t, server.Login("us-test", credentials.Username, credentials.Password))
+
+	// new credentials replace the previous ones
+	refreshed, err := r.PullCredentials(ctx, "us-test")
+	assert.NilError(t, err)
+	assert.Equal(t, refreshed.Username, credentials.Username)
+	assert.Assert(t, refreshed.Password != credentials.Password)
+	assert.Assert(t, !server.Login("us-test", credentials.Username, credentials.Password))
+	assert.Assert(t, server.Login("us-test", refreshed.Username, refreshed.Password))
+	assert.Equal(t, server.Robots(), 1)
+
+	// the credentials of a user can't pull the images of the others
+	_, err = r.PullCredentials(ctx, "us-other")
+	assert.NilError(t, err)
+	assert.Assert(t, !server.Login("us-other", refreshed.Username, refreshed.Password))
+}
+
+func TestPullCredentialsUpdatesProject(t *testing.T) {
+	ctx := context.Background()
+	r, server := newFakeHarbor(t, harbor.HarborOptions{StorageQuota: 2 << 30, RetainTags: 3, CredentialsDays: 30})
+	// created before the quota and the retention were configured
+	server.AddProject("us-test", "app")
+
+	credentials, err := r.PullCredentials(ctx, "us-test")
+	assert.NilError(t, err)
+	project, _ := server.Project("us-test")
+	assert.Equal(t, project.StorageLimit, int64(2<<30))
+	assert.Equal(t, project.RetainTags, 3)
+	assert.Assert(t, credentials.ExpiresAt != nil)
+	assert.Assert(t, credentials.ExpiresAt.After(time.Now().AddDate(0, 0, 29)))
+
+	// the policy is updated, not created again
+	r2, err := harbor.NewHarborRegistry("registry.example.com", "admin", "Harbor12345", harbor.HarborOptions{APIURL: server.APIURL(), RetainTags: 10})
+	assert.NilError(t, err)
+	_, err = r2.PullCredentials(ctx, "us-test")
+	assert.NilError(t, err)
+	project, _ = server.Project("us-test")
+	assert.Equal(t, project.RetainTags, 10)
+	assert.Equal(t, project.StorageLimit, int64(-1))
+}
+
+func TestPullCredentialsExpired(t *testing.T) {
+	ctx := context.Background()
+	r, server := newFakeHarbor(t, harbor.HarborOptions{CredentialsDays: 30})
+
+	credentials, err := r.PullCredentials(ctx, "us-test")
+	assert.NilError(t, err)
+	server.ExpireRobots("us-test")
+	assert.Assert(t, !server.Login("us-test", credentials.Username, credentials.Password))
+
+	// the expired robot is replaced
+	credentials, err = r.PullCredentials(ctx, "us-test")
+	assert.NilError(t, err)
+	assert.Assert(t, server.Login("us-test", credentials.Username, credentials.Password))
+	assert.Equal(t, server.Robots(), 1)
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	r, server := newFakeHarbor(t, harbor.HarborOptions{RetainTags: 5})
+	credentials, err := r.PullCredentials(ctx, "us-test")
+	assert.NilError(t, err)
+	for _, repository := range []string{"app", "api", "web", "team/worker"} {
+		server.AddRepository("us-test", repository)
+	}
+
+	assert.NilError(t, r.DeleteUser(ctx, "us-test"))
+	_, ok := server.Project("us-test")
+	assert.Assert(t, !ok)
+	assert.Assert(t, !server.Login("us-test", credentials.Username, credentials.Password))
+	assert.Equal(t, server.Robots(), 0)
+
+	// the users without a project are already deleted
+	assert.NilError(t, r.DeleteUser(ctx, "us-test"))
+}
+
+func TestHarborAPIErrors(t *testing.T) {
+	server := harbortest.NewServer("admin", "Harbor12345")
+	defer server.Close()
+	r, err := harbor.NewHarborRegistry("registry.example.com", "admin", "wrong", harbor.HarborOptions{APIURL: server.APIURL()})
+	assert.NilError(t, err)
+
+	_, err = r.PullCredentials(context.Background(), "us-test")
+	assert.ErrorContains(t, err, "401 Unauthorized: unauthorized")
+	_, err = r.CacheReference(context.Background(), "us-test", "app")
+	assert.ErrorContains(t, err, "unauthorized")
+}
@@ -21,7 +21,7 @@ func newHarborRegistry() *harbor.HarborClient {
 	l.Debug("initizalized logger")
 	l.Infof("conf: %+v\n", conf)
 
-	r, err := harbor.NewHarborRegistry(conf.Services.Registries[0].ServerAddress, os.Getenv("REGISTRY_USERNAME"), os.Getenv("REGISTRY_PASSWORD"), os.Getenv("REGISTRY_PULL_USERNAME"), os.Getenv("REGISTRY_PULL_PASSWORD"))
+	r, err := harbor.NewHarborRegistry(conf.Services.Registries[0].ServerAddress, os.Getenv("REGISTRY_USERNAME"), os.Getenv("REGISTRY_PASSWORD"))
 	if err != nil {
 		l.Fatalln(err)
 	}
@@ -42,8 +42,11 @@ func TestCompleteTransaction(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = h.PushImage(ctx, tag)
+	pushed, err := h.PushImage(ctx, tag)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if pushed.Digest == "" {
+		t.Fatal("missing digest of the pushed image")
+	}
 }
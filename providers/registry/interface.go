@@ -1,8 +1,97 @@
 package registry
 
-import "context"
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// CacheTag is the tag of the layer cache of an application, it's never immutable
+const CacheTag = oci.CacheTag
+
+var ErrImageNotFound = errors.New("image not found in the registry")
+
+// ErrTagExists is returned by the pushes of the registries with immutable tags
+// when the tag already exists with a different image
+var ErrTagExists = oci.ErrTagExists
 
 type Registryer interface {
 	TagImage(ctx context.Context, localImageID, userCode, appName string) (string, error)
-	PushImage(ctx context.Context, localImageID string) error
+	// PushImage pushes the image tagged by TagImage, the digest of the pushed manifest
+	// is always returned, the media type and the size when the registry reports them
+	PushImage(ctx context.Context, localImageID string) (*model.PushedImage, error)
+}
+
+// IndexPusher is implemented by the registries that can push the images that are
+// not in the docker daemon, the image (or image index) is read from an oci layout on disk
+// and pushed as userCode/appName
+type IndexPusher interface {
+	PushIndex(ctx context.Context, layoutPath, userCode, appName string) (*model.PushedImage, error)
+}
+
+// LocalImagePusher is implemented by the registries that push the local images without
+// tagging them first, the local image is pushed as userCode/appName (name:tag) in one call.
+// The callers use it instead of TagImage and PushImage when the registry implements it
+type LocalImagePusher interface {
+	PushLocalImage(ctx context.Context, localImageID, userCode, appName string) (*model.PushedImage, error)
+}
+
+// CacheRegistry is implemented by the registries that can store the layer cache of the
+// applications, the cache of userCode/appName is stored with the CacheTag
+type CacheRegistry interface {
+	CacheReference(ctx context.Context, userCode, appName string) (string, error)
+}
+
+// ImageInspector is implemented by the registries that can look up the pushed images,
+// appName can contain the tag (name:tag). ErrImageNotFound is returned if it's not in the registry
+type ImageInspector interface {
+	ImageDigest(ctx context.Context, userCode, appName string) (imageName string, digest string, err error)
+}
+
+// ArtifactPusher is implemented by the registries that can store artifacts (signatures,
+// attestations...) in the repository of a pushed image, imageName is the name returned
+// by the push (its tag is ignored) and reference the tag of the artifact. With an empty
+// reference the artifact is pushed by digest, the artifacts with a subject are found
+// with the referrers api of the registry (or the referrers tag, see oci.ReferrersTag)
+type ArtifactPusher interface {
+	PushArtifact(ctx context.Context, imageName, reference string, artifact *oci.Artifact) (ocispec.Descriptor, error)
+}
+
+// ImageDeleter is implemented by the registries that can delete the pushed images, the
+// manifest (or image index) of the digest is deleted with its tags from the repository
+// of imageName (its tag is ignored). ErrImageNotFound is returned if it's not in the registry
+type ImageDeleter interface {
+	DeleteImage(ctx context.Context, imageName, digest string) error
+}
+
+// ImagePromoter is implemented by the registries that can tag a pushed image again without
+// pushing it, the image of the digest in the repository of userCode/appName is tagged as
+// appName (name:tag). The tag is moved even if the tags are immutable, the environment
+// tags (staging, production) follow the promotions. ErrImageNotFound is returned if the
+// digest is not in the repository
+type ImagePromoter interface {
+	PromoteImage(ctx context.Context, userCode, appName, digest string) (*model.PushedImage, error)
+}
+
+// UserManager is implemented by the registries that manage a namespace per user.
+// PullCredentials creates the namespace of the user if missing and returns new credentials
+// that can only pull its images, the previous ones stop working. DeleteUser removes the
+// namespace with its images and credentials, nothing is done if it doesn't exist
+type UserManager interface {
+	PullCredentials(ctx context.Context, userCode string) (*model.RegistryCredentials, error)
+	DeleteUser(ctx context.Context, userCode string) error
+}
+
+// SplitReference splits serverAddress/repository[:tag] in the repository and the tag,
+// latest is returned if the tag is missing
+func SplitReference(serverAddress, imageName string) (repository, tag string) {
+	name := strings.TrimPrefix(imageName, serverAddress+"/")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:]
+	}
+	return name, "latest"
 }
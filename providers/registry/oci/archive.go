@@ -0,0 +1,240 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrInvalidArchive = errors.New("invalid image archive")
+
+// Archive is the tarball of an image written by docker save (docker image save -o),
+// both the oci format (docker 25+) and the legacy format with only manifest.json are read.
+// The images in the legacy format are pushed as an oci manifest with uncompressed layers
+type Archive struct {
+	path    string
+	entries map[string]archiveEntry
+	root    ocispec.Descriptor
+	// blobs of the legacy format: the file of each blob and the generated manifest
+	files  map[digest.Digest]string
+	inline map[digest.Digest][]byte
+}
+
+// archiveEntry is the position of the content of a file in the tarball
+type archiveEntry struct {
+	offset int64
+	size   int64
+}
+
+// legacyManifest is an entry of the manifest.json written by docker save
+type legacyManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// OpenArchive reads the index of the tarball, the archive must contain a single image.
+// The content is read from the file when pushed, it must not be removed before
+func OpenArchive(archivePath string) (*Archive, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := &Archive{
+		path:    archivePath,
+		entries: make(map[string]archiveEntry),
+		files:   make(map[digest.Digest]string),
+		inline:  make(map[digest.Digest][]byte),
+	}
+	links := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		name := cleanEntryName(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			// the reader is at the start of the content after Next
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			a.entries[name] = archiveEntry{offset: offset, size: hdr.Size}
+		case tar.TypeSymlink:
+			// the legacy format links the layers shared by the images
+			links[name] = cleanEntryName(path.Join(path.Dir(name), hdr.Linkname))
+		case tar.TypeLink:
+			links[name] = cleanEntryName(hdr.Linkname)
+		}
+	}
+	for name, target := range links {
+		// links to links, the depth is capped to stop on loops
+		for i := 0; i < 10; i++ {
+			next, ok := links[target]
+			if !ok {
+				break
+			}
+			target = next
+		}
+		if entry, ok := a.entries[target]; ok {
+			a.entries[name] = entry
+		}
+	}
+
+	if _, ok := a.entries[ocispec.ImageIndexFile]; ok {
+		err = a.readIndex()
+	} else {
+		err = a.readLegacyManifest()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Root returns the descriptor of the image stored in the archive
+func (a *Archive) Root() (ocispec.Descriptor, error) {
+	return a.root, nil
+}
+
+// Open returns the content of a blob
+func (a *Archive) Open(d digest.Digest) (io.ReadCloser, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if raw, ok := a.inline[d]; ok {
+		return io.NopCloser(bytes.NewReader(raw)), nil
+	}
+	name, ok := a.files[d]
+	if !ok {
+		name = path.Join("blobs", d.Algorithm().String(), d.Encoded())
+	}
+	return a.openEntry(name)
+}
+
+func (a *Archive) openEntry(name string) (io.ReadCloser, error) {
+	entry, ok := a.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s not found", ErrInvalidArchive, name)
+	}
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, entry.offset, entry.size), f}, nil
+}
+
+func (a *Archive) readEntry(name string) ([]byte, error) {
+	rc, err := a.openEntry(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// readIndex reads the oci layout stored in the archive
+func (a *Archive) readIndex() error {
+	raw, err := a.readEntry(ocispec.ImageIndexFile)
+	if err != nil {
+		return err
+	}
+	index := new(ocispec.Index)
+	if err := json.Unmarshal(raw, index); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if len(index.Manifests) != 1 {
+		return fmt.Errorf("%w: expected 1 image, found %d", ErrInvalidArchive, len(index.Manifests))
+	}
+	a.root = index.Manifests[0]
+	return nil
+}
+
+// readLegacyManifest generates the manifest of the image from manifest.json,
+// the digests of the config and of the layers are computed from their content
+func (a *Archive) readLegacyManifest() error {
+	raw, err := a.readEntry("manifest.json")
+	if err != nil {
+		return err
+	}
+	var manifests []legacyManifest
+	if err := json.Unmarshal(raw, &manifests); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if len(manifests) != 1 {
+		return fmt.Errorf("%w: expected 1 image, found %d", ErrInvalidArchive, len(manifests))
+	}
+
+	config, err := a.describeEntry(manifests[0].Config, ocispec.MediaTypeImageConfig)
+	if err != nil {
+		return err
+	}
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    make([]ocispec.Descriptor, 0, len(manifests[0].Layers)),
+	}
+	for _, layer := range manifests[0].Layers {
+		desc, err := a.describeEntry(layer, ocispec.MediaTypeImageLayer)
+		if err != nil {
+			return err
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+
+	raw, err = json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	d := digest.FromBytes(raw)
+	a.inline[d] = raw
+	a.root = ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    d,
+		Size:      int64(len(raw)),
+	}
+	return nil
+}
+
+// describeEntry hashes the file and registers it as a blob
+func (a *Archive) describeEntry(name, mediaType string) (ocispec.Descriptor, error) {
+	name = cleanEntryName(name)
+	rc, err := a.openEntry(name)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer rc.Close()
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(digester.Hash(), rc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	d := digester.Digest()
+	a.files[d] = name
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: size}, nil
+}
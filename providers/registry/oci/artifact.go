@@ -0,0 +1,133 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Blob is a blob held in memory, used for the small artifacts (signatures, attestations...)
+type Blob struct {
+	MediaType   string
+	Content     []byte
+	Annotations map[string]string
+}
+
+// Descriptor returns the descriptor of the blob
+func (b Blob) Descriptor() ocispec.Descriptor {
+	return ocispec.Descriptor{
+		MediaType:   b.MediaType,
+		Digest:      digest.FromBytes(b.Content),
+		Size:        int64(len(b.Content)),
+		Annotations: b.Annotations,
+	}
+}
+
+// Artifact is a manifest that is not an image, stored next to the images it refers to
+type Artifact struct {
+	ArtifactType string
+	Config       Blob
+	Layers       []Blob
+	// the image the artifact refers to, listed by the referrers api
+	Subject     *ocispec.Descriptor
+	Annotations map[string]string
+}
+
+// Manifest returns the manifest of the artifact
+func (a *Artifact) Manifest() ([]byte, error) {
+	manifest := ocispec.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: a.ArtifactType,
+		Config:       a.Config.Descriptor(),
+		Layers:       make([]ocispec.Descriptor, 0, len(a.Layers)),
+		Subject:      a.Subject,
+		Annotations:  a.Annotations,
+	}
+	for _, layer := range a.Layers {
+		manifest.Layers = append(manifest.Layers, layer.Descriptor())
+	}
+	return json.Marshal(manifest)
+}
+
+// PushArtifact pushes the blobs and the manifest of the artifact as repository:reference,
+// the artifact is pushed by digest if reference is empty. If the artifact has a subject and
+// the registry doesn't support the referrers api, the artifact is added to the referrers tag
+func (c *Client) PushArtifact(ctx context.Context, repository, reference string, a *Artifact) (ocispec.Descriptor, error) {
+	for _, blob := range append([]Blob{a.Config}, a.Layers...) {
+		content := blob.Content
+		if err := c.PushBlob(ctx, repository, blob.Descriptor(), func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		}); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+	}
+	manifest, err := a.Manifest()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if reference == "" {
+		reference = digest.FromBytes(manifest).String()
+	}
+	d, indexed, err := c.putManifest(ctx, repository, reference, ocispec.MediaTypeImageManifest, manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: a.ArtifactType,
+		Digest:       d,
+		Size:         int64(len(manifest)),
+		Annotations:  a.Annotations,
+	}
+	if a.Subject != nil && !indexed {
+		if err := c.addReferrer(ctx, repository, a.Subject.Digest, desc); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("tag referrer of %s: %w", a.Subject.Digest, err)
+		}
+	}
+	return desc, nil
+}
+
+// ReferrersTag is the tag of the index that lists the referrers of the manifest d
+// in the registries without the referrers api (sha256-<digest>)
+func ReferrersTag(d digest.Digest) string {
+	return d.Algorithm().String() + "-" + d.Encoded()
+}
+
+// addReferrer adds the artifact to the index tagged with the referrers tag of subject
+func (c *Client) addReferrer(ctx context.Context, repository string, subject digest.Digest, artifact ocispec.Descriptor) error {
+	tag := ReferrersTag(subject)
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+	}
+	raw, mediaType, ok, err := c.FetchManifest(ctx, repository, tag)
+	if err != nil {
+		return err
+	}
+	if ok && mediaType == ocispec.MediaTypeImageIndex {
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return err
+		}
+	}
+
+	manifests := []ocispec.Descriptor{artifact}
+	for _, m := range index.Manifests {
+		if m.Digest != artifact.Digest {
+			manifests = append(manifests, m)
+		}
+	}
+	index.Manifests = manifests
+	raw, err = json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	_, err = c.PutManifest(ctx, repository, tag, ocispec.MediaTypeImageIndex, raw)
+	return err
+}
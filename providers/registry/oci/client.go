@@ -0,0 +1,567 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	ErrUnexpectedStatus = errors.New("unexpected status from registry")
+	ErrUnauthorized     = errors.New("unauthorized")
+	// ErrTagExists is returned when an immutable tag would be moved to a different image
+	ErrTagExists = errors.New("the tag already exists with a different image")
+)
+
+// Client speaks the oci distribution api of a single registry,
+// it supports anonymous, basic and token (bearer) authentication
+type Client struct {
+	scheme   string
+	host     string
+	username string
+	password string
+	http     *http.Client
+	// the pushes refuse to move the existing tags, see CheckTag
+	immutableTags bool
+
+	mu     sync.Mutex
+	basic  bool
+	tokens map[string]string // scope -> bearer token
+	// a repository known to have each blob, used to mount the blobs
+	// shared between repositories instead of uploading them again
+	locations map[digest.Digest]string
+}
+
+// maxBlobLocations caps the blobs whose location is remembered
+const maxBlobLocations = 10000
+
+type ClientOptions struct {
+	// use http instead of https, it's the default for localhost registries
+	PlainHTTP bool
+	// skip the tls certificate verification
+	InsecureSkipVerify bool
+	// custom http client, the other options are ignored if set
+	HTTPClient *http.Client
+	// refuse to push an image with a tag that exists with a different image,
+	// the same image can be pushed again
+	ImmutableTags bool
+}
+
+// NewClient creates a client for the registry at serverAddress (host[:port]),
+// the address can be prefixed with http:// or https:// to force the scheme.
+// If no authentication is required, leave username and password empty
+func NewClient(serverAddress, username, password string, opt ...ClientOptions) *Client {
+	o := ClientOptions{}
+	if len(opt) > 0 {
+		o = opt[0]
+	}
+
+	scheme := "https"
+	host := serverAddress
+	if h, ok := strings.CutPrefix(serverAddress, "http://"); ok {
+		scheme, host = "http", h
+	} else if h, ok := strings.CutPrefix(serverAddress, "https://"); ok {
+		host = h
+	} else if o.PlainHTTP || strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
+		scheme = "http"
+	}
+
+	httpClient := o.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify},
+			},
+		}
+	}
+
+	return &Client{
+		scheme:        scheme,
+		host:          strings.TrimSuffix(host, "/"),
+		username:      username,
+		password:      password,
+		http:          httpClient,
+		immutableTags: o.ImmutableTags,
+		tokens:        make(map[string]string),
+		locations:     make(map[digest.Digest]string),
+	}
+}
+
+// ImmutableTags reports if the pushes refuse to move the existing tags
+func (c *Client) ImmutableTags() bool {
+	return c.immutableTags
+}
+
+// Host returns the host of the registry, without the scheme
+func (c *Client) Host() string {
+	return c.host
+}
+
+func (c *Client) url(format string, args ...any) string {
+	return fmt.Sprintf("%s://%s%s", c.scheme, c.host, fmt.Sprintf(format, args...))
+}
+
+func pushScope(repository string) string {
+	return "repository:" + repository + ":pull,push"
+}
+
+func pullScope(repository string) string {
+	return "repository:" + repository + ":pull"
+}
+
+func deleteScope(repository string) string {
+	return "repository:" + repository + ":delete"
+}
+
+// mountScope allows pushing to repository and reading the blobs of from
+func mountScope(repository, from string) string {
+	return pushScope(repository) + " " + pullScope(from)
+}
+
+// do sends the request created by newReq, if the registry asks for
+// authentication it authenticates and retries once.
+// newReq is called again for the retry since the body may be already consumed
+func (c *Client) do(ctx context.Context, scope string, newReq func() (*http.Request, error)) (*http.Response, error) {
+	req, err := newReq()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req, scope)
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := c.authenticate(ctx, scope, challenge); err != nil {
+		return nil, err
+	}
+
+	req, err = newReq()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req, scope)
+	resp, err = c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s %s", ErrUnauthorized, req.Method, req.URL.Path)
+	}
+	return resp, nil
+}
+
+func (c *Client) authorize(req *http.Request, scope string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if token, ok := c.tokens[scope]; ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.basic && c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// authenticate handles the WWW-Authenticate challenge of the registry,
+// for bearer challenges it requests a token for scope to the token service
+func (c *Client) authenticate(ctx context.Context, scope, challenge string) error {
+	authScheme, params := parseChallenge(challenge)
+	switch strings.ToLower(authScheme) {
+	case "basic":
+		if c.username == "" {
+			return ErrUnauthorized
+		}
+		c.mu.Lock()
+		c.basic = true
+		c.mu.Unlock()
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("%w: unsupported challenge %q", ErrUnauthorized, challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("%w: invalid realm in challenge %q", ErrUnauthorized, challenge)
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	for _, s := range strings.Fields(scope) {
+		q.Add("scope", s)
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: token service returned %d", ErrUnauthorized, resp.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("%w: empty token", ErrUnauthorized)
+	}
+
+	c.mu.Lock()
+	c.tokens[scope] = token.Token
+	c.mu.Unlock()
+	return nil
+}
+
+// parseChallenge parses `Bearer realm="...",service="...",scope="..."`,
+// quoted values can contain commas (like the scope actions)
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	authScheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			v, r, _ := strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(v)
+			rest = r
+		}
+	}
+	return authScheme, params
+}
+
+func unexpectedStatus(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4*1024))
+	return fmt.Errorf("%w: %s %s: %d %s", ErrUnexpectedStatus, resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (c *Client) blobLocation(d digest.Digest) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.locations[d]
+}
+
+func (c *Client) setBlobLocation(d digest.Digest, repository string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.locations) >= maxBlobLocations {
+		c.locations = make(map[digest.Digest]string)
+	}
+	c.locations[d] = repository
+}
+
+// BlobExists checks if the blob is already in the repository
+func (c *Client) BlobExists(ctx context.Context, repository string, d digest.Digest) (bool, error) {
+	resp, err := c.do(ctx, pushScope(repository), func() (*http.Request, error) {
+		return http.NewRequest(http.MethodHead, c.url("/v2/%s/blobs/%s", repository, d), nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		c.setBlobLocation(d, repository)
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, unexpectedStatus(resp)
+}
+
+// PushBlob uploads the blob described by desc with a monolithic upload,
+// open is called every time the content has to be (re)sent.
+// The upload is skipped if the registry already has the blob, if the blob was
+// pushed to another repository of the registry it's mounted from there
+func (c *Client) PushBlob(ctx context.Context, repository string, desc ocispec.Descriptor, open func() (io.ReadCloser, error)) error {
+	exists, err := c.BlobExists(ctx, repository, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	scope := pushScope(repository)
+	uploadURL := c.url("/v2/%s/blobs/uploads/", repository)
+	if from := c.blobLocation(desc.Digest); from != "" && from != repository {
+		scope = mountScope(repository, from)
+		uploadURL += "?" + url.Values{"mount": {desc.Digest.String()}, "from": {from}}.Encode()
+	}
+	resp, err := c.do(ctx, scope, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, uploadURL, nil)
+	})
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		// mounted
+		resp.Body.Close()
+	case http.StatusAccepted:
+		// the registry can refuse the mount and start a normal upload
+		resp.Body.Close()
+		if err := c.completeUpload(ctx, repository, resp, desc, open); err != nil {
+			return err
+		}
+	default:
+		defer resp.Body.Close()
+		return unexpectedStatus(resp)
+	}
+	c.setBlobLocation(desc.Digest, repository)
+	return nil
+}
+
+// completeUpload sends the content to the upload session started by resp
+func (c *Client) completeUpload(ctx context.Context, repository string, resp *http.Response, desc ocispec.Descriptor, open func() (io.ReadCloser, error)) error {
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	q := location.Query()
+	q.Set("digest", desc.Digest.String())
+	location.RawQuery = q.Encode()
+
+	resp, err = c.do(ctx, pushScope(repository), func() (*http.Request, error) {
+		content, err := open()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPut, location.String(), content)
+		if err != nil {
+			content.Close()
+			return nil, err
+		}
+		req.ContentLength = desc.Size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return unexpectedStatus(resp)
+	}
+	return nil
+}
+
+// PutManifest uploads a manifest or an index as repository:reference,
+// reference can be a tag or the digest of the manifest
+func (c *Client) PutManifest(ctx context.Context, repository, reference, mediaType string, manifest []byte) (digest.Digest, error) {
+	d, _, err := c.putManifest(ctx, repository, reference, mediaType, manifest)
+	return d, err
+}
+
+// putManifest also reports if the registry indexed the subject of the manifest
+// (OCI-Subject header), the referrers of the registries that don't must be tagged
+func (c *Client) putManifest(ctx context.Context, repository, reference, mediaType string, manifest []byte) (digest.Digest, bool, error) {
+	resp, err := c.do(ctx, pushScope(repository), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut, c.url("/v2/%s/manifests/%s", repository, reference), bytes.NewReader(manifest))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mediaType)
+		return req, nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", false, unexpectedStatus(resp)
+	}
+
+	subject := resp.Header.Get("OCI-Subject") != ""
+	if d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+		return d, subject, nil
+	}
+	return digest.FromBytes(manifest), subject, nil
+}
+
+// FetchManifest returns the manifest (or index) of repository:reference and its media type,
+// false is returned if the reference doesn't exist
+func (c *Client) FetchManifest(ctx context.Context, repository, reference string) ([]byte, string, bool, error) {
+	resp, err := c.do(ctx, pullScope(repository), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, c.url("/v2/%s/manifests/%s", repository, reference), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		return req, nil
+	})
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, "", false, nil
+	default:
+		return nil, "", false, unexpectedStatus(resp)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return raw, resp.Header.Get("Content-Type"), true, nil
+}
+
+// DeleteManifest deletes the manifest (or index) of the digest with all its tags,
+// false is returned if it doesn't exist. The registries can refuse deletes (405)
+func (c *Client) DeleteManifest(ctx context.Context, repository string, d digest.Digest) (bool, error) {
+	resp, err := c.do(ctx, deleteScope(repository), func() (*http.Request, error) {
+		return http.NewRequest(http.MethodDelete, c.url("/v2/%s/manifests/%s", repository, d), nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, unexpectedStatus(resp)
+	}
+}
+
+// CacheTag is the tag of the layer cache of the applications, every build moves it
+// so it's mutable even if the other tags are not
+const CacheTag = "buildcache"
+
+// CheckTag returns ErrTagExists if the tags are immutable and repository:tag is
+// an image other than the digest, nothing is checked if the tags are mutable
+// or the tag is the CacheTag
+func (c *Client) CheckTag(ctx context.Context, repository, tag string, d digest.Digest) error {
+	if !c.immutableTags || tag == CacheTag {
+		return nil
+	}
+	desc, exists, err := c.Resolve(ctx, repository, tag)
+	if err != nil {
+		return err
+	}
+	if exists && desc.Digest != d {
+		return fmt.Errorf("%w: %s:%s is %s", ErrTagExists, repository, tag, desc.Digest)
+	}
+	return nil
+}
+
+// Tag tags the manifest (or index) of the digest, already in the repository, as tag without
+// pushing it again. The tag is moved even if the tags are immutable, false is returned if
+// the digest doesn't exist. The digest of each platform is returned for the indexes
+func (c *Client) Tag(ctx context.Context, repository string, d digest.Digest, tag string) (ocispec.Descriptor, []model.PlatformImage, bool, error) {
+	raw, mediaType, exists, err := c.FetchManifest(ctx, repository, d.String())
+	if err != nil || !exists {
+		return ocispec.Descriptor{}, nil, false, err
+	}
+	if _, err := c.PutManifest(ctx, repository, tag, mediaType, raw); err != nil {
+		return ocispec.Descriptor{}, nil, false, err
+	}
+
+	var platforms []model.PlatformImage
+	if mediaType == ocispec.MediaTypeImageIndex || mediaType == mediaTypeDockerManifestList {
+		index := new(ocispec.Index)
+		if err := json.Unmarshal(raw, index); err != nil {
+			return ocispec.Descriptor{}, nil, false, fmt.Errorf("invalid index %s: %w", d, err)
+		}
+		for _, child := range index.Manifests {
+			if child.Platform != nil && child.Platform.OS != "unknown" {
+				platforms = append(platforms, model.PlatformImage{
+					Platform: PlatformString(child.Platform),
+					Digest:   child.Digest.String(),
+				})
+			}
+		}
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(raw))}, platforms, true, nil
+}
+
+// manifestMediaTypes are the manifests accepted when resolving a reference
+var manifestMediaTypes = []string{
+	ocispec.MediaTypeImageIndex,
+	ocispec.MediaTypeImageManifest,
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Resolve returns the descriptor of the manifest (or index) of repository:reference,
+// false is returned if the reference doesn't exist
+func (c *Client) Resolve(ctx context.Context, repository, reference string) (ocispec.Descriptor, bool, error) {
+	resp, err := c.do(ctx, pullScope(repository), func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodHead, c.url("/v2/%s/manifests/%s", repository, reference), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		return req, nil
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ocispec.Descriptor{}, false, nil
+	default:
+		return ocispec.Descriptor{}, false, unexpectedStatus(resp)
+	}
+	d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest"))
+	if err != nil {
+		return ocispec.Descriptor{}, false, fmt.Errorf("%w: missing manifest digest for %s:%s", ErrUnexpectedStatus, repository, reference)
+	}
+	return ocispec.Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    d,
+		Size:      resp.ContentLength,
+	}, true, nil
+}
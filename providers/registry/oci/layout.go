@@ -0,0 +1,167 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+var ErrInvalidLayout = errors.New("invalid oci layout")
+
+// Store holds a single image (a manifest or an index) and its blobs,
+// it's implemented by the oci layouts and the docker save archives
+type Store interface {
+	// Root returns the descriptor of the image
+	Root() (ocispec.Descriptor, error)
+	// Open returns the content of a blob
+	Open(d digest.Digest) (io.ReadCloser, error)
+}
+
+var (
+	_ Store = new(Layout)
+	_ Store = new(Archive)
+)
+
+// ReadBlob returns the whole content of a blob, use it only for manifests and configs
+func ReadBlob(store Store, d digest.Digest) ([]byte, error) {
+	rc, err := store.Open(d)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Layout is an oci image layout on disk
+type Layout struct {
+	root string
+}
+
+func NewLayout(root string) *Layout {
+	return &Layout{root: root}
+}
+
+func (l *Layout) blobPath(d digest.Digest) string {
+	return filepath.Join(l.root, "blobs", d.Algorithm().String(), d.Encoded())
+}
+
+// Open returns the content of a blob
+func (l *Layout) Open(d digest.Digest) (io.ReadCloser, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	return os.Open(l.blobPath(d))
+}
+
+// ReadBlob returns the whole content of a blob, use it only for manifests and configs
+func (l *Layout) ReadBlob(d digest.Digest) ([]byte, error) {
+	return ReadBlob(l, d)
+}
+
+// Root returns the descriptor of the image stored in the layout,
+// the layout must contain a single image (a manifest or an index)
+func (l *Layout) Root() (ocispec.Descriptor, error) {
+	raw, err := os.ReadFile(filepath.Join(l.root, ocispec.ImageIndexFile))
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	index := new(ocispec.Index)
+	if err := json.Unmarshal(raw, index); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	if len(index.Manifests) != 1 {
+		return ocispec.Descriptor{}, fmt.Errorf("%w: expected 1 image, found %d", ErrInvalidLayout, len(index.Manifests))
+	}
+	return index.Manifests[0], nil
+}
+
+// Push pushes the image in the store (an oci layout or an archive) as repository:tag,
+// it returns the descriptor of the root manifest and, if the image is an index,
+// the digest of the manifest of each platform. ErrTagExists is returned if the
+// tags are immutable and the tag is another image
+func (c *Client) Push(ctx context.Context, store Store, repository, tag string) (ocispec.Descriptor, []model.PlatformImage, error) {
+	root, err := store.Root()
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	// checked before pushing the blobs, the registry can't refuse the tag itself
+	if err := c.CheckTag(ctx, repository, tag, root.Digest); err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	var platforms []model.PlatformImage
+	d, err := c.pushDescriptor(ctx, store, repository, tag, root, &platforms)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	return ocispec.Descriptor{MediaType: root.MediaType, Digest: d, Size: root.Size}, platforms, nil
+}
+
+// pushDescriptor pushes a manifest or an index with everything it references,
+// children are pushed by digest before their parent as required by the registries
+func (c *Client) pushDescriptor(ctx context.Context, store Store, repository, reference string, desc ocispec.Descriptor, platforms *[]model.PlatformImage) (digest.Digest, error) {
+	raw, err := ReadBlob(store, desc.Digest)
+	if err != nil {
+		return "", err
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, mediaTypeDockerManifestList:
+		index := new(ocispec.Index)
+		if err := json.Unmarshal(raw, index); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+		}
+		for _, child := range index.Manifests {
+			d, err := c.pushDescriptor(ctx, store, repository, child.Digest.String(), child, nil)
+			if err != nil {
+				return "", err
+			}
+			// attestations are stored as manifests of the unknown/unknown platform
+			if platforms != nil && child.Platform != nil && child.Platform.OS != "unknown" {
+				*platforms = append(*platforms, model.PlatformImage{
+					Platform: PlatformString(child.Platform),
+					Digest:   d.String(),
+				})
+			}
+		}
+	case ocispec.MediaTypeImageManifest, mediaTypeDockerManifest:
+		manifest := new(ocispec.Manifest)
+		if err := json.Unmarshal(raw, manifest); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+		}
+		blobs := append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...)
+		for _, blob := range blobs {
+			blob := blob
+			if err := c.PushBlob(ctx, repository, blob, func() (io.ReadCloser, error) {
+				return store.Open(blob.Digest)
+			}); err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("%w: unsupported media type %q", ErrInvalidLayout, desc.MediaType)
+	}
+
+	return c.PutManifest(ctx, repository, reference, desc.MediaType, raw)
+}
+
+// PlatformString formats the platform as os/arch[/variant]
+func PlatformString(p *ocispec.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
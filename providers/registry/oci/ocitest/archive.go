@@ -0,0 +1,46 @@
+package ocitest
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// WriteArchive writes a docker save tarball of a linux/amd64 image with a single
+// layer holding the files (name, content) and returns its path. The archives with
+// different files are different images
+func WriteArchive(t testing.TB, layerFiles [][2]string) string {
+	t.Helper()
+	layer := tarball(t, layerFiles)
+	archive := tarball(t, [][2]string{
+		{"manifest.json", `[{"Config":"config.json","Layers":["layer/layer.tar"]}]`},
+		{"config.json", `{"architecture":"amd64","os":"linux"}`},
+		{"layer/layer.tar", string(layer)},
+	})
+	path := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(path, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// tarball returns a tar with the files (name, content)
+func tarball(t testing.TB, files [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, file := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: file[0], Mode: 0644, Size: int64(len(file[1]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(file[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
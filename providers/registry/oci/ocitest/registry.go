@@ -0,0 +1,463 @@
+// Package ocitest provides an in-process oci distribution registry for the tests,
+// the content is stored in memory and lost when the registry is closed
+package ocitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type manifest struct {
+	mediaType string
+	content   []byte
+	// the manifest it refers to, see Referrers
+	subject      digest.Digest
+	artifactType string
+	annotations  map[string]string
+}
+
+// Registry is a minimal oci distribution registry, it supports monolithic
+// uploads, cross repository mounts, the referrers api, the deletes by digest and, if created with credentials,
+// token authentication
+type Registry struct {
+	*httptest.Server
+
+	// the referrers api is not supported, the clients must use the referrers tag
+	NoReferrers bool
+	// the manifests can't be deleted (405 Method Not Allowed)
+	NoDelete bool
+
+	username string
+	password string
+
+	mu        sync.Mutex
+	blobs     map[string]map[digest.Digest][]byte // repository -> digest -> content
+	manifests map[string]map[digest.Digest]manifest
+	tags      map[string]map[string]digest.Digest
+	uploads   map[string]string   // upload id -> repository
+	tokens    map[string][]string // token -> scopes
+	uploaded  int
+	mounted   int
+}
+
+// NewRegistry starts a registry that doesn't require authentication
+func NewRegistry() *Registry {
+	return NewRegistryWithAuth("", "")
+}
+
+// NewRegistryWithAuth starts a registry that requires a bearer token, the tokens are
+// issued by the /token endpoint to the clients authenticated with username and password
+func NewRegistryWithAuth(username, password string) *Registry {
+	r := &Registry{
+		username:  username,
+		password:  password,
+		blobs:     make(map[string]map[digest.Digest][]byte),
+		manifests: make(map[string]map[digest.Digest]manifest),
+		tags:      make(map[string]map[string]digest.Digest),
+		uploads:   make(map[string]string),
+		tokens:    make(map[string][]string),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// Host returns the address of the registry, without the scheme
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// Blob returns the content of the blob in the repository
+func (r *Registry) Blob(repository string, d digest.Digest) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	content, ok := r.blobs[repository][d]
+	return content, ok
+}
+
+// Manifest returns the manifest of repository:reference (a tag or a digest) and its media type
+func (r *Registry) Manifest(repository, reference string) ([]byte, string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.manifest(repository, reference)
+	return m.content, m.mediaType, ok
+}
+
+// Uploads returns the number of blobs uploaded, the mounted ones are not counted
+func (r *Registry) Uploads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uploaded
+}
+
+// Mounts returns the number of blobs mounted from another repository
+func (r *Registry) Mounts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mounted
+}
+
+// Referrers returns the manifests of the repository whose subject is d,
+// the manifests listed in the referrers tag are not returned
+func (r *Registry) Referrers(repository string, d digest.Digest) []ocispec.Descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.referrers(repository, d)
+}
+
+func (r *Registry) referrers(repository string, d digest.Digest) []ocispec.Descriptor {
+	referrers := []ocispec.Descriptor{}
+	for md, m := range r.manifests[repository] {
+		if m.subject != d {
+			continue
+		}
+		referrers = append(referrers, ocispec.Descriptor{
+			MediaType:    m.mediaType,
+			ArtifactType: m.artifactType,
+			Digest:       md,
+			Size:         int64(len(m.content)),
+			Annotations:  m.annotations,
+		})
+	}
+	return referrers
+}
+
+func (r *Registry) manifest(repository, reference string) (manifest, bool) {
+	d, err := digest.Parse(reference)
+	if err != nil {
+		d = r.tags[repository][reference]
+	}
+	m, ok := r.manifests[repository][d]
+	return m, ok
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.issueToken(w, req)
+		return
+	}
+	if req.URL.Path == "/v2/" {
+		if !r.authorized(w, req, "") {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(req.URL.Path, "/v2/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	if i := strings.LastIndex(rest, "/manifests/"); i > 0 {
+		repository, reference := rest[:i], rest[i+len("/manifests/"):]
+		if !r.authorized(w, req, repository) {
+			return
+		}
+		r.serveManifest(w, req, repository, reference)
+		return
+	}
+	if i := strings.LastIndex(rest, "/referrers/"); i > 0 {
+		repository, reference := rest[:i], rest[i+len("/referrers/"):]
+		if !r.authorized(w, req, repository) {
+			return
+		}
+		r.serveReferrers(w, req, repository, reference)
+		return
+	}
+	if i := strings.LastIndex(rest, "/blobs/uploads/"); i > 0 {
+		repository, id := rest[:i], rest[i+len("/blobs/uploads/"):]
+		if !r.authorized(w, req, repository) {
+			return
+		}
+		r.serveUpload(w, req, repository, id)
+		return
+	}
+	if i := strings.LastIndex(rest, "/blobs/"); i > 0 {
+		repository, reference := rest[:i], rest[i+len("/blobs/"):]
+		if !r.authorized(w, req, repository) {
+			return
+		}
+		r.serveBlob(w, req, repository, reference)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func (r *Registry) issueToken(w http.ResponseWriter, req *http.Request) {
+	username, password, _ := req.BasicAuth()
+	if username != r.username || password != r.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	token := uuid.NewString()
+	r.mu.Lock()
+	r.tokens[token] = req.URL.Query()["scope"]
+	r.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// authorized checks that the token of the request grants the access to the repository,
+// the pulls need the pull action, the deletes the delete action, everything else the push action
+func (r *Registry) authorized(w http.ResponseWriter, req *http.Request, repository string) bool {
+	if r.username == "" {
+		return true
+	}
+	action := "push"
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		action = "pull"
+	case http.MethodDelete:
+		action = "delete"
+	}
+	scope := "repository:" + repository + ":" + action
+
+	token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	r.mu.Lock()
+	scopes, ok := r.tokens[token]
+	r.mu.Unlock()
+	if ok && (repository == "" || hasScope(scopes, repository, action)) {
+		// mounting reads the blobs of the source repository
+		from := req.URL.Query().Get("from")
+		if from == "" || hasScope(scopes, from, "pull") {
+			return true
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="ocitest",scope="%s"`, r.URL, scope))
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+func hasScope(scopes []string, repository, action string) bool {
+	for _, scope := range scopes {
+		actions, ok := strings.CutPrefix(scope, "repository:"+repository+":")
+		if !ok {
+			continue
+		}
+		for _, a := range strings.Split(actions, ",") {
+			if a == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	d, err := digest.Parse(reference)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	content, ok := r.Blob(repository, d)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", d.String())
+	w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+	if req.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, repository, id string) {
+	switch req.Method {
+	case http.MethodPost:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		query := req.URL.Query()
+		if d, err := digest.Parse(query.Get("mount")); err == nil {
+			if content, ok := r.blobs[query.Get("from")][d]; ok {
+				r.storeBlob(repository, d, content)
+				r.mounted++
+				w.Header().Set("Docker-Content-Digest", d.String())
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		id := uuid.NewString()
+		r.uploads[id] = repository
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		d, err := digest.Parse(req.URL.Query().Get("digest"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if digest.FromBytes(content) != d {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.uploads[id] != repository {
+			http.NotFound(w, req)
+			return
+		}
+		delete(r.uploads, id)
+		r.storeBlob(repository, d, content)
+		r.uploaded++
+		w.Header().Set("Docker-Content-Digest", d.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) storeBlob(repository string, d digest.Digest, content []byte) {
+	if r.blobs[repository] == nil {
+		r.blobs[repository] = make(map[digest.Digest][]byte)
+	}
+	r.blobs[repository][d] = content
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		r.mu.Lock()
+		m, ok := r.manifest(repository, reference)
+		r.mu.Unlock()
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(m.content).String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(m.content)))
+		if req.Method == http.MethodGet {
+			w.Write(m.content)
+		}
+	case http.MethodPut:
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if missing := r.missingReference(repository, content); missing != "" {
+			http.Error(w, "unknown blob or manifest "+missing, http.StatusBadRequest)
+			return
+		}
+		d := digest.FromBytes(content)
+		if _, err := digest.Parse(reference); err == nil && reference != d.String() {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		if r.manifests[repository] == nil {
+			r.manifests[repository] = make(map[digest.Digest]manifest)
+			r.tags[repository] = make(map[string]digest.Digest)
+		}
+		m := manifest{mediaType: req.Header.Get("Content-Type"), content: content}
+		var parsed ocispec.Manifest
+		if json.Unmarshal(content, &parsed) == nil && parsed.Subject != nil && !r.NoReferrers {
+			m.subject = parsed.Subject.Digest
+			m.artifactType = parsed.ArtifactType
+			if m.artifactType == "" {
+				m.artifactType = parsed.Config.MediaType
+			}
+			m.annotations = parsed.Annotations
+			w.Header().Set("OCI-Subject", m.subject.String())
+		}
+		r.manifests[repository][d] = m
+		if reference != d.String() {
+			r.tags[repository][reference] = d
+		}
+		w.Header().Set("Docker-Content-Digest", d.String())
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		d, err := digest.Parse(reference)
+		if err != nil || r.NoDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.manifests[repository][d]; !ok {
+			http.NotFound(w, req)
+			return
+		}
+		delete(r.manifests[repository], d)
+		for tag, tagged := range r.tags[repository] {
+			if tagged == d {
+				delete(r.tags[repository], tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) serveReferrers(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	d, err := digest.Parse(reference)
+	if err != nil || r.NoReferrers || req.Method != http.MethodGet {
+		http.NotFound(w, req)
+		return
+	}
+	r.mu.Lock()
+	referrers := r.referrers(repository, d)
+	r.mu.Unlock()
+	if artifactType := req.URL.Query().Get("artifactType"); artifactType != "" {
+		filtered := []ocispec.Descriptor{}
+		for _, referrer := range referrers {
+			if referrer.ArtifactType == artifactType {
+				filtered = append(filtered, referrer)
+			}
+		}
+		referrers = filtered
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+	json.NewEncoder(w).Encode(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: referrers,
+	})
+}
+
+// missingReference returns the first blob or manifest referenced
+// by the manifest (or index) that is not in the repository
+func (r *Registry) missingReference(repository string, content []byte) string {
+	var m struct {
+		Config    *ocispec.Descriptor  `json:"config"`
+		Layers    []ocispec.Descriptor `json:"layers"`
+		Manifests []ocispec.Descriptor `json:"manifests"`
+	}
+	if err := json.NewDecoder(bytes.NewReader(content)).Decode(&m); err != nil {
+		return "(invalid manifest)"
+	}
+	blobs := m.Layers
+	if m.Config != nil {
+		blobs = append(blobs, *m.Config)
+	}
+	for _, blob := range blobs {
+		if _, ok := r.blobs[repository][blob.Digest]; !ok {
+			return blob.Digest.String()
+		}
+	}
+	for _, child := range m.Manifests {
+		if _, ok := r.manifests[repository][child.Digest]; !ok {
+			return child.Digest.String()
+		}
+	}
+	return ""
+}
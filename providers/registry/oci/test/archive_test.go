@@ -0,0 +1,191 @@
+package oci_test
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/ipaas-org/image-builder/providers/registry/oci/ocitest"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/assert"
+)
+
+type tarFile struct {
+	name    string
+	content []byte
+	link    string // symlink target, content is ignored
+}
+
+func writeTar(t *testing.T, files []tarFile) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "image.tar")
+	f, err := os.Create(path)
+	assert.NilError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, file := range files {
+		hdr := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+		if file.link != "" {
+			hdr = &tar.Header{Name: file.name, Mode: 0777, Linkname: file.link, Typeflag: tar.TypeSymlink}
+		}
+		assert.NilError(t, tw.WriteHeader(hdr))
+		if file.link == "" {
+			_, err := tw.Write(file.content)
+			assert.NilError(t, err)
+		}
+	}
+	assert.NilError(t, tw.Close())
+	return path
+}
+
+func marshal(t *testing.T, v any) []byte {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	assert.NilError(t, err)
+	return raw
+}
+
+// legacyArchive is the output of docker save before docker 25,
+// the second layer is a symlink to the first one
+func legacyArchive(t *testing.T) (string, [][]byte) {
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers"}}`)
+	layer := []byte("layer content")
+	path := writeTar(t, []tarFile{
+		{name: "manifest.json", content: marshal(t, []map[string]any{{
+			"Config":   "config.json",
+			"RepoTags": []string{"app:latest"},
+			"Layers":   []string{"aaa/layer.tar", "bbb/layer.tar"},
+		}})},
+		{name: "config.json", content: config},
+		{name: "aaa/layer.tar", content: layer},
+		{name: "bbb/layer.tar", link: "../aaa/layer.tar"},
+	})
+	return path, [][]byte{config, layer}
+}
+
+func TestPushLegacyArchive(t *testing.T) {
+	ctx := context.Background()
+	r := ocitest.NewRegistryWithAuth("user", "password")
+	defer r.Close()
+	c := oci.NewClient(r.Host(), "user", "password")
+
+	path, blobs := legacyArchive(t)
+	archive, err := oci.OpenArchive(path)
+	assert.NilError(t, err)
+
+	desc, platforms, err := c.Push(ctx, archive, "user/app", "v1")
+	assert.NilError(t, err)
+	assert.Equal(t, len(platforms), 0)
+
+	raw, mediaType, ok := r.Manifest("user/app", "v1")
+	assert.Assert(t, ok)
+	assert.Equal(t, mediaType, ocispec.MediaTypeImageManifest)
+	assert.Equal(t, desc.MediaType, ocispec.MediaTypeImageManifest)
+	assert.Equal(t, desc.Digest, digest.FromBytes(raw))
+	assert.Equal(t, desc.Size, int64(len(raw)))
+	manifest := new(ocispec.Manifest)
+	assert.NilError(t, json.Unmarshal(raw, manifest))
+	assert.Equal(t, manifest.Config.Digest, digest.FromBytes(blobs[0]))
+	assert.Equal(t, len(manifest.Layers), 2)
+	for _, layer := range manifest.Layers {
+		assert.Equal(t, layer.Digest, digest.FromBytes(blobs[1]))
+		content, ok := r.Blob("user/app", layer.Digest)
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, content, blobs[1])
+	}
+	assert.Equal(t, r.Uploads(), 2)
+
+	// the blobs are mounted in the other repositories of the registry
+	other, _, err := c.Push(ctx, archive, "user/other", "v1")
+	assert.NilError(t, err)
+	assert.Equal(t, other.Digest, desc.Digest)
+	assert.Equal(t, r.Uploads(), 2)
+	assert.Equal(t, r.Mounts(), 2)
+	_, ok = r.Blob("user/other", manifest.Config.Digest)
+	assert.Assert(t, ok)
+
+	// already there
+	_, _, err = c.Push(ctx, archive, "user/other", "v2")
+	assert.NilError(t, err)
+	assert.Equal(t, r.Uploads(), 2)
+	assert.Equal(t, r.Mounts(), 2)
+
+	resolved, exists, err := c.Resolve(ctx, "user/other", "v2")
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+	assert.DeepEqual(t, resolved, desc)
+
+	_, exists, err = c.Resolve(ctx, "user/other", "v3")
+	assert.NilError(t, err)
+	assert.Assert(t, !exists)
+}
+
+func TestPushOCIArchive(t *testing.T) {
+	ctx := context.Background()
+	r := ocitest.NewRegistry()
+	defer r.Close()
+	c := oci.NewClient(r.Host(), "", "")
+
+	config := []byte(`{"architecture":"arm64","os":"linux"}`)
+	layer := []byte("compressed layer")
+	manifest := marshal(t, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    []ocispec.Descriptor{{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	})
+	index := marshal(t, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(manifest), Size: int64(len(manifest))}},
+	})
+	blobPath := func(content []byte) string {
+		return "blobs/sha256/" + digest.FromBytes(content).Encoded()
+	}
+	path := writeTar(t, []tarFile{
+		{name: "oci-layout", content: []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		{name: "index.json", content: index},
+		{name: "manifest.json", content: []byte(`[]`)},
+		{name: "./" + blobPath(config), content: config},
+		{name: blobPath(layer), content: layer},
+		{name: blobPath(manifest), content: manifest},
+	})
+
+	archive, err := oci.OpenArchive(path)
+	assert.NilError(t, err)
+	desc, _, err := c.Push(ctx, archive, "user/app", "latest")
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, digest.FromBytes(manifest))
+	assert.Equal(t, desc.MediaType, ocispec.MediaTypeImageManifest)
+	_, ok := r.Blob("user/app", digest.FromBytes(layer))
+	assert.Assert(t, ok)
+}
+
+func TestOpenArchiveErrors(t *testing.T) {
+	path := writeTar(t, []tarFile{{name: "manifest.json", content: []byte(`[{"Config":"a.json"},{"Config":"b.json"}]`)}})
+	_, err := oci.OpenArchive(path)
+	assert.Assert(t, errors.Is(err, oci.ErrInvalidArchive), "got %v", err)
+
+	path = writeTar(t, []tarFile{{name: "manifest.json", content: []byte(`[{"Config":"missing.json"}]`)}})
+	_, err = oci.OpenArchive(path)
+	assert.Assert(t, errors.Is(err, oci.ErrInvalidArchive), "got %v", err)
+}
+
+func TestPushUnauthorized(t *testing.T) {
+	r := ocitest.NewRegistryWithAuth("user", "password")
+	defer r.Close()
+	c := oci.NewClient(r.Host(), "user", "wrong")
+
+	path, _ := legacyArchive(t)
+	archive, err := oci.OpenArchive(path)
+	assert.NilError(t, err)
+	_, _, err = c.Push(context.Background(), archive, "user/app", "v1")
+	assert.Assert(t, errors.Is(err, oci.ErrUnauthorized), "got %v", err)
+}
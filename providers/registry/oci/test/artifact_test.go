@@ -0,0 +1,84 @@
+package oci_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/ipaas-org/image-builder/providers/registry/oci/ocitest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/assert"
+)
+
+// pushReferrer pushes an image and an sbom that refers to it
+func pushReferrer(t *testing.T, server *ocitest.Registry) (ocispec.Descriptor, ocispec.Descriptor) {
+	t.Helper()
+	ctx := context.Background()
+	path, _ := legacyArchive(t)
+	archive, err := oci.OpenArchive(path)
+	assert.NilError(t, err)
+	c := oci.NewClient(server.Host(), "", "")
+	image, _, err := c.Push(ctx, archive, "user/app", "latest")
+	assert.NilError(t, err)
+
+	artifact := &oci.Artifact{
+		ArtifactType: "application/spdx+json",
+		Config:       oci.Blob{MediaType: ocispec.MediaTypeEmptyJSON, Content: []byte("{}")},
+		Layers:       []oci.Blob{{MediaType: "application/spdx+json", Content: []byte(`{"spdxVersion":"SPDX-2.3"}`)}},
+		Subject:      &image,
+	}
+	desc, err := c.PushArtifact(ctx, "user/app", "", artifact)
+	assert.NilError(t, err)
+	assert.Equal(t, desc.ArtifactType, "application/spdx+json")
+	// pushed by digest
+	_, _, ok := server.Manifest("user/app", desc.Digest.String())
+	assert.Assert(t, ok)
+	return image, desc
+}
+
+func TestPushArtifactReferrers(t *testing.T) {
+	server := ocitest.NewRegistry()
+	defer server.Close()
+
+	image, desc := pushReferrer(t, server)
+	referrers := server.Referrers("user/app", image.Digest)
+	assert.Equal(t, len(referrers), 1)
+	assert.Equal(t, referrers[0].Digest, desc.Digest)
+	assert.Equal(t, referrers[0].ArtifactType, "application/spdx+json")
+	// the registry indexed the subject, no referrers tag
+	_, _, ok := server.Manifest("user/app", oci.ReferrersTag(image.Digest))
+	assert.Assert(t, !ok)
+}
+
+func TestPushArtifactReferrersTag(t *testing.T) {
+	server := ocitest.NewRegistry()
+	defer server.Close()
+	server.NoReferrers = true
+
+	image, first := pushReferrer(t, server)
+	assert.Equal(t, len(server.Referrers("user/app", image.Digest)), 0)
+
+	// a second referrer is added to the same index
+	c := oci.NewClient(server.Host(), "", "")
+	second, err := c.PushArtifact(context.Background(), "user/app", "", &oci.Artifact{
+		ArtifactType: "application/example",
+		Config:       oci.Blob{MediaType: ocispec.MediaTypeEmptyJSON, Content: []byte("{}")},
+		Layers:       []oci.Blob{{MediaType: "text/plain", Content: []byte("example")}},
+		Subject:      &image,
+	})
+	assert.NilError(t, err)
+
+	raw, mediaType, ok := server.Manifest("user/app", oci.ReferrersTag(image.Digest))
+	assert.Assert(t, ok)
+	assert.Equal(t, mediaType, ocispec.MediaTypeImageIndex)
+	var index ocispec.Index
+	assert.NilError(t, json.Unmarshal(raw, &index))
+	assert.Equal(t, len(index.Manifests), 2)
+	digests := map[string]string{}
+	for _, m := range index.Manifests {
+		digests[m.Digest.String()] = m.ArtifactType
+	}
+	assert.Equal(t, digests[first.Digest.String()], "application/spdx+json")
+	assert.Equal(t, digests[second.Digest.String()], "application/example")
+}
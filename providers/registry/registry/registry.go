@@ -6,21 +6,43 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"time"
 
 	"github.com/docker/docker/api/types/image"
 	registryType "github.com/docker/docker/api/types/registry"
+	"github.com/ipaas-org/image-builder/model"
 	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/docker/docker/client"
 )
 
-var _ registry.Registryer = new(Registry)
+var (
+	_ registry.Registryer     = new(Registry)
+	_ registry.IndexPusher    = new(Registry)
+	_ registry.CacheRegistry  = new(Registry)
+	_ registry.ImageInspector = new(Registry)
+	_ registry.ArtifactPusher = new(Registry)
+	_ registry.ImageDeleter   = new(Registry)
+	_ registry.ImagePromoter  = new(Registry)
+)
 
-type ErrorLine struct {
+// PushLine is a line of the output of docker push
+type PushLine struct {
 	Error       string      `json:"error"`
 	ErrorDetail ErrorDetail `json:"errorDetail"`
+	Aux         *PushResult `json:"aux"`
+}
+
+// PushResult is the last aux message of docker push
+type PushResult struct {
+	Tag    string `json:"Tag"`
+	Digest string `json:"Digest"`
+	Size   int64  `json:"Size"`
 }
 
 type ErrorDetail struct {
@@ -33,10 +55,13 @@ type Registry struct {
 	password      string
 
 	dockerClient *client.Client
+	// used for the images that are not in the docker daemon (oci layouts)
+	ociClient *oci.Client
 }
 
-// if no authentication is required, leave username and password empty
-func NewDefaultRegistry(registryUri, username, password string) (*Registry, error) {
+// if no authentication is required, leave username and password empty,
+// the options are used by the client of the oci distribution api
+func NewDefaultRegistry(registryUri, username, password string, opt ...oci.ClientOptions) (*Registry, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, err
@@ -47,6 +72,7 @@ func NewDefaultRegistry(registryUri, username, password string) (*Registry, erro
 		username:      username,
 		password:      password,
 		dockerClient:  cli,
+		ociClient:     oci.NewClient(registryUri, username, password, opt...),
 	}, nil
 }
 
@@ -61,7 +87,13 @@ func (r *Registry) TagImage(ctx context.Context, localImageID, userCode, appName
 	return new, nil
 }
 
-func (r *Registry) PushImage(ctx context.Context, imageID string) error {
+// PushImage pushes the image through the docker daemon, the digest and the size
+// are read from the push output and the media type is resolved in the registry
+func (r *Registry) PushImage(ctx context.Context, imageName string) (*model.PushedImage, error) {
+	if err := r.checkTag(ctx, imageName); err != nil {
+		return nil, err
+	}
+
 	var authConfig = registryType.AuthConfig{
 		Username:      r.username,
 		Password:      r.password,
@@ -71,34 +103,169 @@ func (r *Registry) PushImage(ctx context.Context, imageID string) error {
 	authConfigEncoded := base64.URLEncoding.EncodeToString(authConfigBytes)
 
 	opts := image.PushOptions{RegistryAuth: authConfigEncoded}
-	rd, err := r.dockerClient.ImagePush(ctx, imageID, opts)
+	rd, err := r.dockerClient.ImagePush(ctx, imageName, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer rd.Close()
 
-	return checkErr(rd)
+	result, err := readPushOutput(rd)
+	if err != nil {
+		return nil, err
+	}
+	pushed := &model.PushedImage{Name: imageName, Digest: result.Digest, Size: result.Size}
+
+	repository, tag := registry.SplitReference(r.serverAddress, imageName)
+	reference := tag
+	if pushed.Digest != "" {
+		reference = pushed.Digest
+	}
+	desc, exists, err := r.ociClient.Resolve(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s after push", registry.ErrImageNotFound, imageName)
+	}
+	pushed.Digest = desc.Digest.String()
+	pushed.MediaType = desc.MediaType
+	if desc.Size > 0 {
+		pushed.Size = desc.Size
+	}
+	return pushed, nil
 }
 
-func checkErr(rd io.Reader) error {
-	var lastLine string
+// checkTag returns registry.ErrTagExists if the tags are immutable and the tag of the
+// image is in the registry but not among the digests of the local image. The digest
+// of the image is only known once pushed, the daemon records it in the repo digests.
+// The cache tag is moved by every build, it's never checked
+func (r *Registry) checkTag(ctx context.Context, imageName string) error {
+	if !r.ociClient.ImmutableTags() {
+		return nil
+	}
+	repository, tag := registry.SplitReference(r.serverAddress, imageName)
+	if tag == registry.CacheTag {
+		return nil
+	}
+	desc, exists, err := r.ociClient.Resolve(ctx, repository, tag)
+	if err != nil || !exists {
+		return err
+	}
+	inspect, _, err := r.dockerClient.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return err
+	}
+	pushed := r.serverAddress + "/" + repository + "@" + desc.Digest.String()
+	for _, repoDigest := range inspect.RepoDigests {
+		if repoDigest == pushed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is %s", registry.ErrTagExists, imageName, desc.Digest)
+}
 
+// PushIndex pushes the image in the oci layout directly to the registry,
+// appName can contain the tag (name:tag), latest is used otherwise
+func (r *Registry) PushIndex(ctx context.Context, layoutPath, userCode, appName string) (*model.PushedImage, error) {
+	repository, tag := registry.SplitReference(r.serverAddress, r.serverAddress+"/"+userCode+"/"+appName)
+	desc, platforms, err := r.ociClient.Push(ctx, oci.NewLayout(layoutPath), repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &model.PushedImage{
+		Name:      r.serverAddress + "/" + repository + ":" + tag,
+		Digest:    desc.Digest.String(),
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+		Platforms: platforms,
+	}, nil
+}
+
+// CacheReference returns the reference the builders import and export the cache of the application
+func (r *Registry) CacheReference(ctx context.Context, userCode, appName string) (string, error) {
+	return r.serverAddress + "/" + userCode + "/" + appName + ":" + registry.CacheTag, nil
+}
+
+// ImageDigest resolves the image in the registry, the docker daemon is not involved
+func (r *Registry) ImageDigest(ctx context.Context, userCode, appName string) (string, string, error) {
+	repository, tag := registry.SplitReference(r.serverAddress, r.serverAddress+"/"+userCode+"/"+appName)
+	desc, exists, err := r.ociClient.Resolve(ctx, repository, tag)
+	if err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return "", "", registry.ErrImageNotFound
+	}
+	return r.serverAddress + "/" + repository + ":" + tag, desc.Digest.String(), nil
+}
+
+// readPushOutput reads the whole output of the push, the first error reported
+// by the daemon is returned, otherwise the result of the push (the aux message)
+func readPushOutput(rd io.Reader) (*PushResult, error) {
+	result := new(PushResult)
 	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 	for scanner.Scan() {
-		lastLine = scanner.Text()
-		// fmt.Println(scanner.Text())
+		line := &PushLine{}
+		if err := json.Unmarshal(scanner.Bytes(), line); err != nil {
+			return nil, err
+		}
+		if line.Error != "" {
+			if line.ErrorDetail.Message != "" {
+				return nil, errors.New(line.ErrorDetail.Message)
+			}
+			return nil, errors.New(line.Error)
+		}
+		if line.Aux != nil && line.Aux.Digest != "" {
+			result = line.Aux
+		}
 	}
+	return result, scanner.Err()
+}
+
+// PushArtifact pushes the artifact in the repository of the image
+func (r *Registry) PushArtifact(ctx context.Context, imageName, reference string, artifact *oci.Artifact) (ocispec.Descriptor, error) {
+	repository, _ := registry.SplitReference(r.serverAddress, imageName)
+	return r.ociClient.PushArtifact(ctx, repository, reference, artifact)
+}
 
-	errLine := &ErrorLine{}
-	if err := json.Unmarshal([]byte(lastLine), errLine); err != nil {
+// DeleteImage deletes the manifest of the digest from the repository of the image
+func (r *Registry) DeleteImage(ctx context.Context, imageName, imageDigest string) error {
+	d, err := digest.Parse(imageDigest)
+	if err != nil {
 		return err
 	}
-
-	if errLine.Error != "" {
-		//TODO: we are ignoring the error detail here, we should consider them
-		return errors.New(errLine.Error)
+	repository, _ := registry.SplitReference(r.serverAddress, imageName)
+	deleted, err := r.ociClient.DeleteManifest(ctx, repository, d)
+	if err != nil {
+		return err
 	}
+	if !deleted {
+		return registry.ErrImageNotFound
+	}
+	return nil
+}
 
-	return scanner.Err()
+// PromoteImage tags the image of the digest as appName (name:tag) in the registry
+func (r *Registry) PromoteImage(ctx context.Context, userCode, appName, imageDigest string) (*model.PushedImage, error) {
+	d, err := digest.Parse(imageDigest)
+	if err != nil {
+		return nil, err
+	}
+	repository, tag := registry.SplitReference(r.serverAddress, r.serverAddress+"/"+userCode+"/"+appName)
+	desc, platforms, exists, err := r.ociClient.Tag(ctx, repository, d, tag)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s@%s", registry.ErrImageNotFound, repository, d)
+	}
+	return &model.PushedImage{
+		Name:      r.serverAddress + "/" + repository + ":" + tag,
+		Digest:    desc.Digest.String(),
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+		Platforms: platforms,
+	}, nil
 }
@@ -0,0 +1,80 @@
+package sbom
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+)
+
+// Generator generates the sbom of the built images, the images can be in the docker
+// daemon, in an oci layout (see builders.OCILayoutPrefix) or in a docker save tarball
+// (see distribution.ArchivePrefix). The images of the daemon are exported to be read
+type Generator struct {
+	version      string
+	dockerClient *client.Client
+}
+
+// NewGenerator returns a generator, version is the version of the service written in the documents
+func NewGenerator(version string) (*Generator, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{version: version, dockerClient: cli}, nil
+}
+
+// Generate lists the packages of the local image
+func (g *Generator) Generate(ctx context.Context, imageID string) (*SBOM, error) {
+	var store oci.Store
+	if layoutPath, ok := builders.OCILayoutPath(imageID); ok {
+		store = oci.NewLayout(layoutPath)
+	} else {
+		archivePath, ok := strings.CutPrefix(imageID, distribution.ArchivePrefix)
+		if !ok {
+			var err error
+			archivePath, err = g.saveImage(ctx, imageID)
+			if err != nil {
+				return nil, err
+			}
+			defer os.Remove(archivePath)
+		}
+		archive, err := oci.OpenArchive(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		store = archive
+	}
+
+	s, err := Scan(store)
+	if err != nil {
+		return nil, err
+	}
+	s.toolVersion = g.version
+	return s, nil
+}
+
+// saveImage exports the image of the daemon to a temporary tarball
+func (g *Generator) saveImage(ctx context.Context, imageID string) (string, error) {
+	rc, err := g.dockerClient.ImageSave(ctx, []string{imageID})
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	f, err := os.CreateTemp("", "image-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, rc); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
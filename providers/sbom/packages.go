@@ -0,0 +1,209 @@
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+const (
+	dpkgStatus    = "var/lib/dpkg/status"
+	dpkgStatusDir = "var/lib/dpkg/status.d/" // distroless images, one file per package
+	apkInstalled  = "lib/apk/db/installed"
+)
+
+var osReleases = []string{"etc/os-release", "usr/lib/os-release"}
+
+// isDatabase reports if the file lists installed packages (or describes the os)
+func isDatabase(name string) bool {
+	switch {
+	case name == dpkgStatus, name == apkInstalled:
+		return true
+	case strings.HasPrefix(name, dpkgStatusDir):
+		rest := strings.TrimPrefix(name, dpkgStatusDir)
+		return !strings.Contains(rest, "/") && !strings.HasSuffix(rest, ".md5sums")
+	case name == osReleases[0], name == osReleases[1]:
+		return true
+	}
+
+	dir, base := path.Split(name)
+	parts := strings.Split(strings.TrimSuffix(dir, "/"), "/")
+	switch base {
+	case "package.json":
+		// node_modules/name/package.json or node_modules/@scope/name/package.json
+		n := len(parts)
+		return (n >= 2 && parts[n-2] == "node_modules") ||
+			(n >= 3 && parts[n-3] == "node_modules" && strings.HasPrefix(parts[n-2], "@"))
+	case "METADATA":
+		// site-packages/name-version.dist-info/METADATA
+		n := len(parts)
+		return n >= 2 && strings.HasSuffix(parts[n-1], ".dist-info") &&
+			(parts[n-2] == "site-packages" || parts[n-2] == "dist-packages")
+	}
+	return false
+}
+
+// readPackages returns the os and the packages listed in the databases
+func readPackages(files map[string][]byte) (string, []Package) {
+	distro, osName := readOSRelease(files)
+
+	var packages []Package
+	for name, content := range files {
+		var found []Package
+		switch {
+		case name == dpkgStatus, strings.HasPrefix(name, dpkgStatusDir):
+			found = parseDpkg(content)
+		case name == apkInstalled:
+			found = parseApk(content)
+		case path.Base(name) == "package.json":
+			found = parsePackageJSON(content)
+		case path.Base(name) == "METADATA":
+			found = parseMetadata(content)
+		}
+		for i := range found {
+			found[i].Location = name
+			if found[i].Type == TypeDeb || found[i].Type == TypeApk {
+				found[i].Distro = distro
+			}
+		}
+		packages = append(packages, found...)
+	}
+	return osName, packages
+}
+
+// readOSRelease returns the id of the os (debian) and its name with the version (debian 12)
+func readOSRelease(files map[string][]byte) (string, string) {
+	for _, name := range osReleases {
+		content, ok := files[name]
+		if !ok {
+			continue
+		}
+		fields := make(map[string]string)
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if ok {
+				fields[key] = strings.Trim(value, `"'`)
+			}
+		}
+		id := fields["ID"]
+		if id == "" {
+			continue
+		}
+		if version := fields["VERSION_ID"]; version != "" {
+			return id, id + " " + version
+		}
+		return id, id
+	}
+	return "", ""
+}
+
+// stanzas splits the databases made of blocks of "Key: value" lines separated by
+// empty lines (dpkg, apk), the lines starting with a space continue the previous value
+func stanzas(content []byte) []map[string]string {
+	var blocks []map[string]string
+	block := make(map[string]string)
+	var last string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, maxFileSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+				block = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if last != "" {
+				block[last] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		last = key
+		block[key] = strings.TrimSpace(value)
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// parseDpkg parses the status of dpkg, only the installed packages are returned
+func parseDpkg(content []byte) []Package {
+	var packages []Package
+	for _, block := range stanzas(content) {
+		status, ok := block["Status"]
+		if block["Package"] == "" || (ok && !strings.HasSuffix(status, " installed")) {
+			continue
+		}
+		packages = append(packages, Package{
+			Name:    block["Package"],
+			Version: block["Version"],
+			Type:    TypeDeb,
+			Arch:    block["Architecture"],
+		})
+	}
+	return packages
+}
+
+// parseApk parses the installed database of apk
+func parseApk(content []byte) []Package {
+	var packages []Package
+	for _, block := range stanzas(content) {
+		if block["P"] == "" {
+			continue
+		}
+		packages = append(packages, Package{
+			Name:    block["P"],
+			Version: block["V"],
+			Type:    TypeApk,
+			Arch:    block["A"],
+			License: block["L"],
+		})
+	}
+	return packages
+}
+
+// parsePackageJSON parses the package.json of a package in node_modules
+func parsePackageJSON(content []byte) []Package {
+	var manifest struct {
+		Name    string          `json:"name"`
+		Version string          `json:"version"`
+		License json.RawMessage `json:"license"`
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil || manifest.Name == "" {
+		return nil
+	}
+	// the license is a string or, in the old packages, {"type": "MIT"}
+	var license string
+	if err := json.Unmarshal(manifest.License, &license); err != nil {
+		var object struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(manifest.License, &object)
+		license = object.Type
+	}
+	return []Package{{Name: manifest.Name, Version: manifest.Version, Type: TypeNpm, License: license}}
+}
+
+// parseMetadata parses the METADATA of an installed python distribution
+func parseMetadata(content []byte) []Package {
+	// the headers end at the first empty line, the description follows
+	if i := bytes.Index(content, []byte("\n\n")); i >= 0 {
+		content = content[:i]
+	}
+	blocks := stanzas(content)
+	if len(blocks) == 0 || blocks[0]["Name"] == "" {
+		return nil
+	}
+	block := blocks[0]
+	return []Package{{Name: block["Name"], Version: block["Version"], Type: TypePyPI, License: block["License"]}}
+}
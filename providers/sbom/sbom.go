@@ -0,0 +1,105 @@
+// Package sbom lists the packages installed in the built images, the package databases
+// of the os (dpkg and apk) and of the languages (npm and python) are read from the layers
+package sbom
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// package types, they are the types of the purls
+const (
+	TypeDeb  = "deb"
+	TypeApk  = "apk"
+	TypeNpm  = "npm"
+	TypePyPI = "pypi"
+)
+
+// Package is a package installed in the image
+type Package struct {
+	Name    string
+	Version string
+	Type    string // deb | apk | npm | pypi
+	License string
+	// architecture of the os packages and their distro (debian, alpine...)
+	Arch   string
+	Distro string
+	// path of the database (or of the manifest) the package was read from
+	Location string
+}
+
+// PURL returns the package url of the package (pkg:type/namespace/name@version)
+func (p Package) PURL() string {
+	name := p.Name
+	namespace := p.Distro
+	if p.Type == TypeNpm {
+		// scoped packages, the scope is the namespace
+		if scope, n, ok := strings.Cut(name, "/"); ok && strings.HasPrefix(scope, "@") {
+			namespace, name = scope, n
+		}
+	}
+	if p.Type == TypePyPI {
+		name = strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	}
+
+	purl := "pkg:" + p.Type + "/"
+	if namespace != "" {
+		// the @ of the npm scopes is encoded too
+		purl += strings.ReplaceAll(url.PathEscape(namespace), "@", "%40") + "/"
+	}
+	purl += url.PathEscape(name)
+	if p.Version != "" {
+		purl += "@" + url.PathEscape(p.Version)
+	}
+	if p.Arch != "" {
+		purl += "?arch=" + url.QueryEscape(p.Arch)
+	}
+	return purl
+}
+
+// SBOM is the list of the packages of an image, the packages of every platform
+// are listed once (the os packages of each architecture are different packages)
+type SBOM struct {
+	// name and version of the os of the image (debian 12), empty for the images from scratch
+	OS        string
+	Platforms []string
+	Packages  []Package
+
+	// version of the service, written as the tool that created the documents
+	toolVersion string
+}
+
+// Ecosystems returns the number of packages of each type
+func (s *SBOM) Ecosystems() map[string]int {
+	ecosystems := make(map[string]int)
+	for _, p := range s.Packages {
+		ecosystems[p.Type]++
+	}
+	return ecosystems
+}
+
+// add adds the packages that are not listed yet, the packages are identified by their purl
+func (s *SBOM) add(seen map[string]bool, packages []Package) {
+	for _, p := range packages {
+		purl := p.PURL()
+		if seen[purl] {
+			continue
+		}
+		seen[purl] = true
+		s.Packages = append(s.Packages, p)
+	}
+}
+
+func (s *SBOM) sort() {
+	sort.Slice(s.Packages, func(i, j int) bool {
+		a, b := s.Packages[i], s.Packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.PURL() < b.PURL()
+	})
+}
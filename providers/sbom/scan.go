@@ -0,0 +1,210 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/klauspost/compress/zstd"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var ErrInvalidImage = errors.New("invalid image")
+
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	// the package databases bigger than this are skipped
+	maxFileSize = 32 << 20
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Scan lists the packages of the image in the store, for the image indexes
+// the packages of every platform are listed
+func Scan(store oci.Store) (*SBOM, error) {
+	root, err := store.Root()
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := platformManifests(store, root)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(SBOM)
+	seen := make(map[string]bool)
+	for _, desc := range manifests {
+		platform, files, err := readImage(store, desc)
+		if err != nil {
+			return nil, err
+		}
+		s.Platforms = append(s.Platforms, platform)
+		osName, packages := readPackages(files)
+		if s.OS == "" {
+			s.OS = osName
+		}
+		s.add(seen, packages)
+	}
+	s.sort()
+	return s, nil
+}
+
+// platformManifests returns the manifest of each platform of the image,
+// the attestations (manifests of the unknown/unknown platform) are skipped
+func platformManifests(store oci.Store, root ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	if root.MediaType != ocispec.MediaTypeImageIndex && root.MediaType != mediaTypeDockerManifestList {
+		return []ocispec.Descriptor{root}, nil
+	}
+	raw, err := oci.ReadBlob(store, root.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	var manifests []ocispec.Descriptor
+	for _, child := range index.Manifests {
+		if child.Platform != nil && child.Platform.OS == "unknown" {
+			continue
+		}
+		manifests = append(manifests, child)
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("%w: no manifests in the index", ErrInvalidImage)
+	}
+	return manifests, nil
+}
+
+// readImage returns the platform of the manifest and the package databases of its filesystem
+func readImage(store oci.Store, desc ocispec.Descriptor) (string, map[string][]byte, error) {
+	raw, err := oci.ReadBlob(store, desc.Digest)
+	if err != nil {
+		return "", nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	platform := desc.Platform
+	if platform == nil {
+		raw, err := oci.ReadBlob(store, manifest.Config.Digest)
+		if err != nil {
+			return "", nil, err
+		}
+		var config ocispec.Image
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		platform = &config.Platform
+	}
+
+	files := make(map[string][]byte)
+	for _, layer := range manifest.Layers {
+		if err := readLayer(store, layer, files); err != nil {
+			return "", nil, fmt.Errorf("layer %s: %w", layer.Digest, err)
+		}
+	}
+	return oci.PlatformString(platform), files, nil
+}
+
+// readLayer applies the layer to files, the package databases are added (or replaced)
+// and the files deleted by the whiteouts are removed. The whiteouts only apply to the
+// lower layers, they are applied before the files of the layer are added
+func readLayer(store oci.Store, desc ocispec.Descriptor, files map[string][]byte) error {
+	rc, err := store.Open(desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	r, err := decompress(rc)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	added := make(map[string][]byte)
+	var removed []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			removed = append(removed, dir)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			removed = append(removed, dir+strings.TrimPrefix(base, whiteoutPrefix))
+			continue
+		}
+		if header.Typeflag != tar.TypeReg || header.Size > maxFileSize || !isDatabase(name) {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		added[name] = content
+	}
+
+	for _, name := range removed {
+		removeTree(files, name)
+	}
+	for name, content := range added {
+		files[name] = content
+	}
+	return nil
+}
+
+// removeTree removes the file and everything under it
+func removeTree(files map[string][]byte, name string) {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for file := range files {
+		if file == name || strings.HasPrefix(file, prefix) {
+			delete(files, file)
+		}
+	}
+}
+
+// decompress returns the tar of the layer, the compression is detected from its content
+// since the media types of the layers saved by docker don't always match it
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
@@ -0,0 +1,159 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	SPDXVersion   = "SPDX-2.3"
+	SPDXMediaType = "application/spdx+json"
+	// title of the document in the artifacts, the name it gets when it's pulled
+	SPDXFileName = "sbom.spdx.json"
+
+	namespacePrefix = "https://github.com/ipaas-org/image-builder/sbom/"
+	noAssertion     = "NOASSERTION"
+)
+
+// licenseExpression matches the licenses that are valid spdx expressions,
+// the others (free text like "BSD License") are not declared
+var licenseExpression = regexp.MustCompile(`^\(?[A-Za-z0-9.+-]+( (AND|OR|WITH) \(?[A-Za-z0-9.+-]+\)?)*\)?$`)
+
+type (
+	spdxDocument struct {
+		SPDXVersion       string             `json:"spdxVersion"`
+		DataLicense       string             `json:"dataLicense"`
+		SPDXID            string             `json:"SPDXID"`
+		Name              string             `json:"name"`
+		DocumentNamespace string             `json:"documentNamespace"`
+		CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+		Packages          []spdxPackage      `json:"packages"`
+		Relationships     []spdxRelationship `json:"relationships"`
+	}
+
+	spdxCreationInfo struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	}
+
+	spdxPackage struct {
+		Name             string            `json:"name"`
+		SPDXID           string            `json:"SPDXID"`
+		VersionInfo      string            `json:"versionInfo,omitempty"`
+		DownloadLocation string            `json:"downloadLocation"`
+		FilesAnalyzed    bool              `json:"filesAnalyzed"`
+		LicenseConcluded string            `json:"licenseConcluded"`
+		LicenseDeclared  string            `json:"licenseDeclared"`
+		SourceInfo       string            `json:"sourceInfo,omitempty"`
+		PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+		ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+	}
+
+	spdxExternalRef struct {
+		ReferenceCategory string `json:"referenceCategory"`
+		ReferenceType     string `json:"referenceType"`
+		ReferenceLocator  string `json:"referenceLocator"`
+	}
+
+	spdxRelationship struct {
+		SPDXElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSPDXElement string `json:"relatedSpdxElement"`
+	}
+)
+
+// SPDX returns the spdx json document of the image name (server/user/app) pushed with
+// the subject descriptor, the image is described by the document and contains the packages
+func (s *SBOM) SPDX(name string, subject ocispec.Descriptor, now time.Time) ([]byte, error) {
+	tool := "Tool: image-builder"
+	if s.toolVersion != "" {
+		tool += "-" + s.toolVersion
+	}
+	doc := spdxDocument{
+		SPDXVersion:       SPDXVersion,
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name + "@" + subject.Digest.String(),
+		DocumentNamespace: namespacePrefix + subject.Digest.Encoded() + "-" + uuid.NewString(),
+		CreationInfo: spdxCreationInfo{
+			Created:  now.UTC().Format(time.RFC3339),
+			Creators: []string{tool},
+		},
+	}
+
+	image := spdxPackage{
+		Name:             name,
+		SPDXID:           "SPDXRef-Image",
+		VersionInfo:      subject.Digest.String(),
+		DownloadLocation: noAssertion,
+		LicenseConcluded: noAssertion,
+		LicenseDeclared:  noAssertion,
+		PrimaryPurpose:   "CONTAINER",
+		ExternalRefs: []spdxExternalRef{{
+			ReferenceCategory: "PACKAGE-MANAGER",
+			ReferenceType:     "purl",
+			ReferenceLocator:  "pkg:oci/" + name + "@" + subject.Digest.String(),
+		}},
+	}
+	doc.Packages = append(doc.Packages, image)
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		SPDXElementID:      doc.SPDXID,
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: image.SPDXID,
+	})
+
+	for i, p := range s.Packages {
+		license := noAssertion
+		if licenseExpression.MatchString(p.License) {
+			license = p.License
+		}
+		pkg := spdxPackage{
+			Name:             p.Name,
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%s-%d", p.Type, i),
+			VersionInfo:      p.Version,
+			DownloadLocation: noAssertion,
+			LicenseConcluded: noAssertion,
+			LicenseDeclared:  license,
+			SourceInfo:       "read from " + p.Location,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  p.PURL(),
+			}},
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      image.SPDXID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: pkg.SPDXID,
+		})
+	}
+	return json.Marshal(doc)
+}
+
+// Artifact returns the spdx document as an artifact that refers to the image,
+// it's listed by the referrers api of the registry as an sbom of the image
+func (s *SBOM) Artifact(name string, subject ocispec.Descriptor, now time.Time) (*oci.Artifact, error) {
+	doc, err := s.SPDX(name, subject, now)
+	if err != nil {
+		return nil, err
+	}
+	subject = ocispec.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size}
+	return &oci.Artifact{
+		ArtifactType: SPDXMediaType,
+		Config:       oci.Blob{MediaType: ocispec.MediaTypeEmptyJSON, Content: []byte("{}")},
+		Layers: []oci.Blob{{
+			MediaType:   SPDXMediaType,
+			Content:     doc,
+			Annotations: map[string]string{ocispec.AnnotationTitle: SPDXFileName},
+		}},
+		Subject:     &subject,
+		Annotations: map[string]string{ocispec.AnnotationCreated: now.UTC().Format(time.RFC3339)},
+	}, nil
+}
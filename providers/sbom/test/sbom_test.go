@@ -0,0 +1,253 @@
+package sbom_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/ipaas-org/image-builder/providers/sbom"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/assert"
+)
+
+const dpkgStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.36-9+deb12u4
+Description: GNU C Library
+ shared libraries
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.2.15-2+b2
+`
+
+const apkInstalled = `C:Q1abc=
+P:musl
+V:1.2.4-r2
+A:x86_64
+L:MIT
+
+P:busybox
+V:1.36.1-r15
+A:x86_64
+L:GPL-2.0-only
+`
+
+type layout struct {
+	t    *testing.T
+	root string
+}
+
+func newLayout(t *testing.T) *layout {
+	root := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(root, "blobs", "sha256"), 0755))
+	return &layout{t: t, root: root}
+}
+
+func (l *layout) blob(mediaType string, content []byte) ocispec.Descriptor {
+	d := digest.FromBytes(content)
+	assert.NilError(l.t, os.WriteFile(filepath.Join(l.root, "blobs", "sha256", d.Encoded()), content, 0644))
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(content))}
+}
+
+// layer writes a gzipped layer with the files (path -> content)
+func (l *layout) layer(files map[string]string) ocispec.Descriptor {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		assert.NilError(l.t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		assert.NilError(l.t, err)
+	}
+	assert.NilError(l.t, tw.Close())
+	assert.NilError(l.t, gw.Close())
+	return l.blob(ocispec.MediaTypeImageLayerGzip, buf.Bytes())
+}
+
+func (l *layout) manifest(arch string, layers ...ocispec.Descriptor) ocispec.Descriptor {
+	config, err := json.Marshal(ocispec.Image{Platform: ocispec.Platform{OS: "linux", Architecture: arch}})
+	assert.NilError(l.t, err)
+	raw, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    l.blob(ocispec.MediaTypeImageConfig, config),
+		Layers:    layers,
+	})
+	assert.NilError(l.t, err)
+	return l.blob(ocispec.MediaTypeImageManifest, raw)
+}
+
+func (l *layout) index(manifests ...ocispec.Descriptor) ocispec.Descriptor {
+	raw, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: manifests,
+	})
+	assert.NilError(l.t, err)
+	return l.blob(ocispec.MediaTypeImageIndex, raw)
+}
+
+func (l *layout) store(root ocispec.Descriptor) oci.Store {
+	raw, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{root},
+	})
+	assert.NilError(l.t, err)
+	assert.NilError(l.t, os.WriteFile(filepath.Join(l.root, ocispec.ImageIndexFile), raw, 0644))
+	return oci.NewLayout(l.root)
+}
+
+func purls(s *sbom.SBOM) []string {
+	var purls []string
+	for _, p := range s.Packages {
+		purls = append(purls, p.PURL())
+	}
+	return purls
+}
+
+func TestScanDebian(t *testing.T) {
+	l := newLayout(t)
+	base := l.layer(map[string]string{
+		"etc/os-release":      "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\nVERSION_ID=\"12\"\n",
+		"var/lib/dpkg/status": dpkgStatus,
+		// removed by the next layer
+		"app/node_modules/left-pad/package.json": `{"name":"left-pad","version":"1.3.0","license":"WTFPL"}`,
+	})
+	app := l.layer(map[string]string{
+		"app/node_modules/.wh.left-pad":                                "",
+		"app/node_modules/@types/node/package.json":                    `{"name":"@types/node","version":"20.1.0","license":{"type":"MIT"}}`,
+		"app/node_modules/express/package.json":                        `{"name":"express","version":"4.19.2","license":"MIT"}`,
+		"app/node_modules/express/lib/package.json":                    `{"name":"not-a-package"}`,
+		"usr/lib/python3/dist-packages/Flask-3.0.0.dist-info/METADATA": "Metadata-Version: 2.1\nName: Flask\nVersion: 3.0.0\nLicense: BSD License\n\nFlask is a web framework\nName: other\n",
+		"usr/lib/python3/site-packages/my_pkg-1.0.dist-info/METADATA":  "Name: my_pkg\nVersion: 1.0\n",
+	})
+	s, err := sbom.Scan(l.store(l.manifest("amd64", base, app)))
+	assert.NilError(t, err)
+
+	assert.Equal(t, s.OS, "debian 12")
+	assert.DeepEqual(t, s.Platforms, []string{"linux/amd64"})
+	assert.DeepEqual(t, purls(s), []string{
+		"pkg:deb/debian/bash@5.2.15-2+b2?arch=amd64",
+		"pkg:deb/debian/libc6@2.36-9+deb12u4?arch=amd64",
+		"pkg:npm/%40types/node@20.1.0",
+		"pkg:npm/express@4.19.2",
+		"pkg:pypi/flask@3.0.0",
+		"pkg:pypi/my-pkg@1.0",
+	})
+	assert.DeepEqual(t, s.Ecosystems(), map[string]int{sbom.TypeDeb: 2, sbom.TypeNpm: 2, sbom.TypePyPI: 2})
+	for _, p := range s.Packages {
+		if p.Name == "@types/node" {
+			assert.Equal(t, p.License, "MIT")
+			assert.Equal(t, p.Location, "app/node_modules/@types/node/package.json")
+		}
+	}
+}
+
+func TestScanIndex(t *testing.T) {
+	l := newLayout(t)
+	files := map[string]string{
+		"etc/os-release":       "ID=alpine\nVERSION_ID=3.19.1\n",
+		"lib/apk/db/installed": apkInstalled,
+	}
+	amd64 := l.manifest("amd64", l.layer(files))
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := l.manifest("arm64", l.layer(map[string]string{
+		"etc/os-release":       files["etc/os-release"],
+		"lib/apk/db/installed": "P:musl\nV:1.2.4-r2\nA:aarch64\nL:MIT\n",
+	}))
+	arm64.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	// the attestation manifests of buildkit are skipped
+	attestation := l.manifest("unknown")
+	attestation.Platform = &ocispec.Platform{OS: "unknown", Architecture: "unknown"}
+
+	s, err := sbom.Scan(l.store(l.index(amd64, arm64, attestation)))
+	assert.NilError(t, err)
+	assert.Equal(t, s.OS, "alpine 3.19.1")
+	assert.DeepEqual(t, s.Platforms, []string{"linux/amd64", "linux/arm64"})
+	assert.DeepEqual(t, purls(s), []string{
+		"pkg:apk/alpine/busybox@1.36.1-r15?arch=x86_64",
+		"pkg:apk/alpine/musl@1.2.4-r2?arch=aarch64",
+		"pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64",
+	})
+}
+
+func TestScanOpaqueWhiteout(t *testing.T) {
+	l := newLayout(t)
+	base := l.layer(map[string]string{
+		"var/lib/dpkg/status":             dpkgStatus,
+		"app/node_modules/a/package.json": `{"name":"a","version":"1.0.0"}`,
+	})
+	app := l.layer(map[string]string{
+		"app/node_modules/.wh..wh..opq":   "",
+		"app/node_modules/b/package.json": `{"name":"b","version":"2.0.0"}`,
+	})
+	s, err := sbom.Scan(l.store(l.manifest("amd64", base, app)))
+	assert.NilError(t, err)
+	// no os-release, the os packages have no distro
+	assert.Equal(t, s.OS, "")
+	assert.DeepEqual(t, purls(s), []string{
+		"pkg:deb/bash@5.2.15-2+b2?arch=amd64",
+		"pkg:deb/libc6@2.36-9+deb12u4?arch=amd64",
+		"pkg:npm/b@2.0.0",
+	})
+}
+
+func TestArtifact(t *testing.T) {
+	l := newLayout(t)
+	s, err := sbom.Scan(l.store(l.manifest("amd64", l.layer(map[string]string{
+		"etc/os-release":       "ID=alpine\nVERSION_ID=3.19.1\n",
+		"lib/apk/db/installed": apkInstalled,
+	}))))
+	assert.NilError(t, err)
+
+	subject := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("image"), Size: 10}
+	artifact, err := s.Artifact("registry/user/app", subject, time.Unix(0, 0))
+	assert.NilError(t, err)
+	assert.Equal(t, artifact.ArtifactType, sbom.SPDXMediaType)
+	assert.Equal(t, artifact.Subject.Digest, subject.Digest)
+	assert.Equal(t, len(artifact.Layers), 1)
+	assert.Equal(t, artifact.Layers[0].Annotations[ocispec.AnnotationTitle], sbom.SPDXFileName)
+
+	var doc struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Name        string `json:"name"`
+		Packages    []struct {
+			Name            string `json:"name"`
+			SPDXID          string `json:"SPDXID"`
+			LicenseDeclared string `json:"licenseDeclared"`
+		} `json:"packages"`
+		Relationships []struct {
+			SPDXElementID      string `json:"spdxElementId"`
+			RelationshipType   string `json:"relationshipType"`
+			RelatedSPDXElement string `json:"relatedSpdxElement"`
+		} `json:"relationships"`
+	}
+	assert.NilError(t, json.Unmarshal(artifact.Layers[0].Content, &doc))
+	assert.Equal(t, doc.SPDXVersion, sbom.SPDXVersion)
+	assert.Equal(t, doc.Name, "registry/user/app@"+subject.Digest.String())
+	assert.Equal(t, len(doc.Packages), 3)
+	assert.Equal(t, doc.Packages[0].SPDXID, "SPDXRef-Image")
+	assert.Equal(t, doc.Packages[1].Name, "busybox")
+	assert.Equal(t, doc.Packages[1].LicenseDeclared, "GPL-2.0-only")
+	assert.Equal(t, len(doc.Relationships), 3)
+	assert.Equal(t, doc.Relationships[0].RelationshipType, "DESCRIBES")
+	assert.Equal(t, doc.Relationships[1].RelationshipType, "CONTAINS")
+	assert.Equal(t, doc.Relationships[1].SPDXElementID, "SPDXRef-Image")
+	assert.Equal(t, doc.Relationships[1].RelatedSPDXElement, doc.Packages[1].SPDXID)
+}
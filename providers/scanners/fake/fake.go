@@ -0,0 +1,55 @@
+// Package fake provides a scanner that returns preset reports, for the tests
+// and for running the service without a scanner
+package fake
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+)
+
+const ScannerName = "fake"
+
+var _ scanners.Scanner = new(FakeScanner)
+
+type FakeScanner struct {
+	mu sync.Mutex
+	// vulnerabilities found in every image
+	vulnerabilities []model.Vulnerability
+	err             error
+	scanned         []string
+}
+
+// NewFakeScanner returns a scanner that finds the vulnerabilities in every image
+func NewFakeScanner(vulnerabilities ...model.Vulnerability) *FakeScanner {
+	return &FakeScanner{vulnerabilities: vulnerabilities}
+}
+
+// SetError makes the scans fail with err, nil restores the reports
+func (s *FakeScanner) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Scanned returns the ids of the images scanned
+func (s *FakeScanner) Scanned() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.scanned...)
+}
+
+func (s *FakeScanner) Scan(ctx context.Context, imageID string) (*model.ScanReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanned = append(s.scanned, imageID)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &model.ScanReport{
+		Scanner:         ScannerName,
+		Vulnerabilities: append([]model.Vulnerability{}, s.vulnerabilities...),
+	}, nil
+}
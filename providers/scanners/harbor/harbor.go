@@ -0,0 +1,255 @@
+// Package harbor scans the images with a harbor pluggable scanner (the scanner adapter api
+// implemented by harbor-scanner-trivy and the other scanners harbor can use)
+package harbor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/registry"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+)
+
+const (
+	ScannerName = "harbor"
+
+	mediaTypeScanRequest  = "application/vnd.scanner.adapter.scan.request+json; version=1.0"
+	mediaTypeScanResponse = "application/vnd.scanner.adapter.scan.response+json; version=1.0"
+	mediaTypeReport       = "application/vnd.security.vulnerability.report; version=1.1"
+
+	defaultNamespace    = "scans"
+	defaultPollInterval = 2 * time.Second
+)
+
+var _ scanners.Scanner = new(HarborScanner)
+
+// HarborScanner scans the images with a scanner adapter, the adapter pulls the images
+// from a registry so they are pushed to a staging namespace of the registry before the
+// scan. The staging images are never the pushed ones, a retention policy should remove them
+type HarborScanner struct {
+	adapterURL   string
+	staging      registry.Registryer
+	registryURL  string
+	username     string
+	password     string
+	namespace    string
+	pollInterval time.Duration
+	http         *http.Client
+}
+
+type HarborScannerOptions struct {
+	// url of the registry the adapter pulls from (https://registry.example.com),
+	// it must be the registry of the staging registryer
+	RegistryURL string
+	// credentials the adapter pulls the images with, a robot account with pull access
+	Username string
+	Password string
+	// namespace (harbor project) the images are pushed to before the scan, scans by default
+	Namespace string
+	// how often the report is requested while the scan runs, the Refresh-After
+	// header of the adapter is used if set
+	PollInterval time.Duration
+	HTTPClient   *http.Client
+}
+
+func NewHarborScanner(adapterURL string, staging registry.Registryer, opt HarborScannerOptions) (*HarborScanner, error) {
+	if adapterURL == "" || opt.RegistryURL == "" {
+		return nil, fmt.Errorf("the adapter url and the registry url are required")
+	}
+	s := &HarborScanner{
+		adapterURL:   strings.TrimSuffix(adapterURL, "/"),
+		staging:      staging,
+		registryURL:  strings.TrimSuffix(opt.RegistryURL, "/"),
+		username:     opt.Username,
+		password:     opt.Password,
+		namespace:    opt.Namespace,
+		pollInterval: opt.PollInterval,
+		http:         opt.HTTPClient,
+	}
+	if s.namespace == "" {
+		s.namespace = defaultNamespace
+	}
+	if s.pollInterval <= 0 {
+		s.pollInterval = defaultPollInterval
+	}
+	if s.http == nil {
+		s.http = http.DefaultClient
+	}
+	return s, nil
+}
+
+type (
+	scanRequest struct {
+		Registry struct {
+			URL           string `json:"url"`
+			Authorization string `json:"authorization,omitempty"`
+		} `json:"registry"`
+		Artifact struct {
+			Repository string `json:"repository"`
+			Digest     string `json:"digest"`
+			MimeType   string `json:"mime_type,omitempty"`
+		} `json:"artifact"`
+	}
+
+	scanResponse struct {
+		ID string `json:"id"`
+	}
+
+	vulnerabilityReport struct {
+		Vulnerabilities []struct {
+			ID          string `json:"id"`
+			Package     string `json:"package"`
+			Version     string `json:"version"`
+			FixVersion  string `json:"fix_version"`
+			Severity    string `json:"severity"`
+			Description string `json:"description"`
+		} `json:"vulnerabilities"`
+	}
+)
+
+func (s *HarborScanner) Scan(ctx context.Context, imageID string) (*model.ScanReport, error) {
+	pushed, err := s.stage(ctx, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("push to the staging namespace: %w", err)
+	}
+
+	_, host, ok := strings.Cut(s.registryURL, "://")
+	if !ok {
+		host = s.registryURL
+	}
+	repository, _ := registry.SplitReference(host, pushed.Name)
+	var request scanRequest
+	request.Registry.URL = s.registryURL
+	if s.username != "" {
+		request.Registry.Authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(s.username+":"+s.password))
+	}
+	request.Artifact.Repository = repository
+	request.Artifact.Digest = pushed.Digest
+	request.Artifact.MimeType = pushed.MediaType
+
+	id, err := s.requestScan(ctx, &request)
+	if err != nil {
+		return nil, err
+	}
+	return s.waitReport(ctx, id)
+}
+
+// stage pushes the image to the staging namespace with a random name
+func (s *HarborScanner) stage(ctx context.Context, imageID string) (*model.PushedImage, error) {
+	appName := uuid.NewString()
+	if layoutPath, ok := builders.OCILayoutPath(imageID); ok {
+		pusher, ok := s.staging.(registry.IndexPusher)
+		if !ok {
+			return nil, fmt.Errorf("the staging registry can't push oci layouts")
+		}
+		return pusher.PushIndex(ctx, layoutPath, s.namespace, appName)
+	}
+	name, err := s.staging.TagImage(ctx, imageID, s.namespace, appName)
+	if err != nil {
+		return nil, err
+	}
+	return s.staging.PushImage(ctx, name)
+}
+
+func (s *HarborScanner) requestScan(ctx context.Context, request *scanRequest) (string, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.adapterURL+"/api/v1/scan", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mediaTypeScanRequest)
+	req.Header.Set("Accept", mediaTypeScanResponse)
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", unexpectedStatus(resp)
+	}
+	var response scanResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.ID == "" {
+		return "", fmt.Errorf("%w: invalid scan response", scanners.ErrScanFailed)
+	}
+	return response.ID, nil
+}
+
+// waitReport polls the report until the scan is done, the adapter answers
+// 302 Found while the scan is running
+func (s *HarborScanner) waitReport(ctx context.Context, id string) (*model.ScanReport, error) {
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.adapterURL+"/api/v1/scan/"+id+"/report", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", mediaTypeReport)
+		resp, err := s.noRedirect().Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			defer resp.Body.Close()
+			var report vulnerabilityReport
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				return nil, fmt.Errorf("%w: invalid report: %v", scanners.ErrScanFailed, err)
+			}
+			result := &model.ScanReport{Scanner: ScannerName, Vulnerabilities: []model.Vulnerability{}}
+			for _, v := range report.Vulnerabilities {
+				result.Vulnerabilities = append(result.Vulnerabilities, model.Vulnerability{
+					ID:               v.ID,
+					Package:          v.Package,
+					InstalledVersion: v.Version,
+					FixedVersion:     v.FixVersion,
+					Severity:         model.ParseSeverity(v.Severity),
+					Title:            v.Description,
+				})
+			}
+			return result, nil
+		case http.StatusFound:
+			resp.Body.Close()
+		default:
+			defer resp.Body.Close()
+			return nil, unexpectedStatus(resp)
+		}
+
+		wait := s.pollInterval
+		if seconds, err := strconv.Atoi(resp.Header.Get("Refresh-After")); err == nil && seconds > 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// noRedirect returns the client without following the 302 of the running scans
+func (s *HarborScanner) noRedirect() *http.Client {
+	c := *s.http
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &c
+}
+
+func unexpectedStatus(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%w: adapter returned %s: %s", scanners.ErrScanFailed, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,16 @@
+package scanners
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipaas-org/image-builder/model"
+)
+
+var ErrScanFailed = errors.New("vulnerability scan failed")
+
+// Scanner scans the built images for known vulnerabilities before they are pushed,
+// the image id is the one returned by the builders (see builders.OCILayoutPrefix)
+type Scanner interface {
+	Scan(ctx context.Context, imageID string) (*model.ScanReport, error)
+}
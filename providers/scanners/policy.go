@@ -0,0 +1,40 @@
+package scanners
+
+import (
+	"sort"
+
+	"github.com/ipaas-org/image-builder/model"
+)
+
+// Policy decides which vulnerabilities block the push of the images
+type Policy struct {
+	// the vulnerabilities of this severity or higher block the push, nothing is blocked if empty
+	BlockSeverity model.Severity
+	// the vulnerabilities without a fixed version don't block the push
+	IgnoreUnfixed bool
+	// ids of the vulnerabilities that never block the push (CVE-2024-1234)
+	Allowlist []string
+}
+
+// Violations returns the vulnerabilities of the report that block the push, the most severe first
+func (p Policy) Violations(report *model.ScanReport) []model.Vulnerability {
+	if p.BlockSeverity == "" {
+		return nil
+	}
+	allowed := make(map[string]bool, len(p.Allowlist))
+	for _, id := range p.Allowlist {
+		allowed[id] = true
+	}
+
+	var violations []model.Vulnerability
+	for _, v := range report.Vulnerabilities {
+		if !v.Severity.AtLeast(p.BlockSeverity) || allowed[v.ID] || (p.IgnoreUnfixed && v.FixedVersion == "") {
+			continue
+		}
+		violations = append(violations, v)
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Severity.AtLeast(violations[j].Severity) && violations[i].Severity != violations[j].Severity
+	})
+	return violations
+}
@@ -0,0 +1,120 @@
+package scanners_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
+	"github.com/ipaas-org/image-builder/providers/registry/oci/ocitest"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+	"github.com/ipaas-org/image-builder/providers/scanners/harbor"
+	"gotest.tools/assert"
+)
+
+// adapter is a scanner adapter that answers 302 to the first report request
+type adapter struct {
+	mu       sync.Mutex
+	requests []map[string]map[string]string
+	polls    int
+	status   int
+}
+
+func (a *adapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/scan":
+		if a.status != 0 {
+			http.Error(w, "scanner unavailable", a.status)
+			return
+		}
+		var request map[string]map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.requests = append(a.requests, request)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id":"scan-1"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/scan/scan-1/report":
+		a.polls++
+		if a.polls == 1 {
+			w.Header().Set("Location", "/api/v1/scan/scan-1/report")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		w.Write([]byte(`{"vulnerabilities":[{"id":"CVE-2024-0727","package":"libssl3","version":"3.1.4-r2","fix_version":"3.1.4-r5","severity":"Critical","description":"openssl: denial of service"}]}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeArchive(t *testing.T) string {
+	t.Helper()
+	return distribution.ArchivePrefix + ocitest.WriteArchive(t, [][2]string{{"app/layer", "layer"}})
+}
+
+func newHarborScanner(t *testing.T, a *adapter, server *ocitest.Registry) *harbor.HarborScanner {
+	t.Helper()
+	adapterServer := httptest.NewServer(a)
+	t.Cleanup(adapterServer.Close)
+	staging, err := distribution.NewDistributionRegistry(server.Host(), "", "")
+	assert.NilError(t, err)
+	s, err := harbor.NewHarborScanner(adapterServer.URL, staging, harbor.HarborScannerOptions{
+		RegistryURL:  "http://" + server.Host(),
+		Username:     "robot$scanner",
+		Password:     "secret",
+		PollInterval: 10 * time.Millisecond,
+	})
+	assert.NilError(t, err)
+	return s
+}
+
+func TestHarborScan(t *testing.T) {
+	server := ocitest.NewRegistry()
+	defer server.Close()
+	a := new(adapter)
+	s := newHarborScanner(t, a, server)
+
+	report, err := s.Scan(context.Background(), writeArchive(t))
+	assert.NilError(t, err)
+	assert.Equal(t, report.Scanner, harbor.ScannerName)
+	assert.DeepEqual(t, report.Vulnerabilities, []model.Vulnerability{{
+		ID:               "CVE-2024-0727",
+		Package:          "libssl3",
+		InstalledVersion: "3.1.4-r2",
+		FixedVersion:     "3.1.4-r5",
+		Severity:         model.SeverityCritical,
+		Title:            "openssl: denial of service",
+	}})
+	assert.Equal(t, a.polls, 2)
+
+	// the image was pushed to the staging namespace and the adapter pulls it from there
+	assert.Equal(t, len(a.requests), 1)
+	request := a.requests[0]
+	assert.Equal(t, request["registry"]["url"], "http://"+server.Host())
+	assert.Equal(t, request["registry"]["authorization"], "Basic "+base64.StdEncoding.EncodeToString([]byte("robot$scanner:secret")))
+	repository := request["artifact"]["repository"]
+	assert.Assert(t, strings.HasPrefix(repository, "scans/"), repository)
+	_, _, ok := server.Manifest(repository, request["artifact"]["digest"])
+	assert.Assert(t, ok)
+}
+
+func TestHarborScanFailed(t *testing.T) {
+	server := ocitest.NewRegistry()
+	defer server.Close()
+	s := newHarborScanner(t, &adapter{status: http.StatusServiceUnavailable}, server)
+
+	_, err := s.Scan(context.Background(), writeArchive(t))
+	assert.Assert(t, errors.Is(err, scanners.ErrScanFailed))
+	assert.ErrorContains(t, err, "scanner unavailable")
+}
@@ -0,0 +1,74 @@
+package scanners_test
+
+import (
+	"testing"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+	"gotest.tools/assert"
+)
+
+var report = &model.ScanReport{
+	Scanner: "test",
+	Vulnerabilities: []model.Vulnerability{
+		{ID: "CVE-1", Package: "zlib", Severity: model.SeverityMedium, FixedVersion: "1.3"},
+		{ID: "CVE-2", Package: "openssl", Severity: model.SeverityHigh, FixedVersion: "3.0.2"},
+		{ID: "CVE-3", Package: "curl", Severity: model.SeverityCritical},
+		{ID: "CVE-4", Package: "musl", Severity: model.SeverityCritical, FixedVersion: "1.2.5"},
+		{ID: "CVE-5", Package: "bash", Severity: model.SeverityUnknown},
+	},
+}
+
+func ids(vulnerabilities []model.Vulnerability) []string {
+	ids := make([]string, 0, len(vulnerabilities))
+	for _, v := range vulnerabilities {
+		ids = append(ids, v.ID)
+	}
+	return ids
+}
+
+func TestParseSeverity(t *testing.T) {
+	assert.Equal(t, model.ParseSeverity("Critical"), model.SeverityCritical)
+	assert.Equal(t, model.ParseSeverity(" high "), model.SeverityHigh)
+	assert.Equal(t, model.ParseSeverity("negligible"), model.SeverityUnknown)
+	assert.Assert(t, model.SeverityCritical.AtLeast(model.SeverityHigh))
+	assert.Assert(t, model.SeverityHigh.AtLeast(model.SeverityHigh))
+	assert.Assert(t, !model.SeverityLow.AtLeast(model.SeverityMedium))
+}
+
+func TestViolations(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy scanners.Policy
+		want   []string
+	}{
+		{"no block severity", scanners.Policy{}, []string{}},
+		{"critical", scanners.Policy{BlockSeverity: model.SeverityCritical}, []string{"CVE-3", "CVE-4"}},
+		{"high, most severe first", scanners.Policy{BlockSeverity: model.SeverityHigh}, []string{"CVE-3", "CVE-4", "CVE-2"}},
+		{"ignore unfixed", scanners.Policy{BlockSeverity: model.SeverityHigh, IgnoreUnfixed: true}, []string{"CVE-4", "CVE-2"}},
+		{"allowlist", scanners.Policy{BlockSeverity: model.SeverityMedium, Allowlist: []string{"CVE-3", "CVE-1"}}, []string{"CVE-4", "CVE-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.DeepEqual(t, ids(tt.policy.Violations(report)), tt.want)
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	violations := scanners.Policy{BlockSeverity: model.SeverityCritical}.Violations(report)
+	summary := report.Summary(violations)
+	assert.Assert(t, summary.Blocked)
+	assert.Equal(t, summary.Scanner, "test")
+	assert.DeepEqual(t, summary.Severities, map[model.Severity]int{
+		model.SeverityUnknown:  1,
+		model.SeverityMedium:   1,
+		model.SeverityHigh:     1,
+		model.SeverityCritical: 2,
+	})
+	assert.DeepEqual(t, ids(summary.Violations), []string{"CVE-3", "CVE-4"})
+
+	summary = report.Summary(nil)
+	assert.Assert(t, !summary.Blocked)
+	assert.Assert(t, summary.Violations == nil)
+}
@@ -0,0 +1,79 @@
+package scanners_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+	"github.com/ipaas-org/image-builder/providers/scanners/trivy"
+	"gotest.tools/assert"
+)
+
+const trivyReport = `{
+  "SchemaVersion": 2,
+  "Results": [
+    {"Target": "alpine 3.19.1", "Vulnerabilities": [
+      {"VulnerabilityID": "CVE-2024-0727", "PkgName": "libssl3", "InstalledVersion": "3.1.4-r2", "FixedVersion": "3.1.4-r5", "Severity": "MEDIUM", "Title": "openssl: denial of service"}
+    ]},
+    {"Target": "Node.js", "Vulnerabilities": [
+      {"VulnerabilityID": "CVE-2022-25883", "PkgName": "semver", "InstalledVersion": "7.5.1", "FixedVersion": "7.5.2", "Severity": "HIGH"}
+    ]},
+    {"Target": "Python"}
+  ]
+}`
+
+// fakeTrivy writes an executable that logs its arguments and prints the report
+func fakeTrivy(t *testing.T, report string, exitCode int) (path, argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	path = filepath.Join(dir, "trivy")
+	argsFile = filepath.Join(dir, "args")
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "report.json"), []byte(report), 0644))
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" > " + argsFile + "\n" +
+		"cat " + filepath.Join(dir, "report.json") + "\n" +
+		"echo 'scan error' >&2\n" +
+		"exit " + strconv.Itoa(exitCode) + "\n"
+	assert.NilError(t, os.WriteFile(path, []byte(script), 0755))
+	return path, argsFile
+}
+
+func TestTrivyScan(t *testing.T) {
+	path, argsFile := fakeTrivy(t, trivyReport, 0)
+	s := trivy.NewTrivyScanner(trivy.TrivyScannerOptions{Path: path, CacheDir: "/cache"})
+
+	report, err := s.Scan(context.Background(), builders.OCILayoutPrefix+"/tmp/layout")
+	assert.NilError(t, err)
+	assert.Equal(t, report.Scanner, trivy.ScannerName)
+	assert.DeepEqual(t, report.Vulnerabilities, []model.Vulnerability{
+		{ID: "CVE-2024-0727", Package: "libssl3", InstalledVersion: "3.1.4-r2", FixedVersion: "3.1.4-r5", Severity: model.SeverityMedium, Title: "openssl: denial of service"},
+		{ID: "CVE-2022-25883", Package: "semver", InstalledVersion: "7.5.1", FixedVersion: "7.5.2", Severity: model.SeverityHigh},
+	})
+	args, err := os.ReadFile(argsFile)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.TrimSpace(string(args)), "image --quiet --format json --scanners vuln --cache-dir /cache --input /tmp/layout")
+
+	_, err = s.Scan(context.Background(), "sha256:abc")
+	assert.NilError(t, err)
+	args, err = os.ReadFile(argsFile)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasSuffix(strings.TrimSpace(string(args)), "--image-src docker sha256:abc"))
+}
+
+func TestTrivyScanFailed(t *testing.T) {
+	path, _ := fakeTrivy(t, "{}", 1)
+	_, err := trivy.NewTrivyScanner(trivy.TrivyScannerOptions{Path: path}).Scan(context.Background(), "sha256:abc")
+	assert.Assert(t, errors.Is(err, scanners.ErrScanFailed))
+	assert.ErrorContains(t, err, "scan error")
+
+	path, _ = fakeTrivy(t, "not json", 0)
+	_, err = trivy.NewTrivyScanner(trivy.TrivyScannerOptions{Path: path}).Scan(context.Background(), "sha256:abc")
+	assert.Assert(t, errors.Is(err, scanners.ErrScanFailed))
+}
@@ -0,0 +1,108 @@
+package trivy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/builders"
+	"github.com/ipaas-org/image-builder/providers/registry/distribution"
+	"github.com/ipaas-org/image-builder/providers/scanners"
+)
+
+const ScannerName = "trivy"
+
+var _ scanners.Scanner = new(TrivyScanner)
+
+// TrivyScanner scans the images with the trivy cli, the images of the daemon are read
+// from the daemon, the oci layouts and the docker save tarballs are read from disk
+type TrivyScanner struct {
+	path     string
+	cacheDir string
+	timeout  time.Duration
+}
+
+type TrivyScannerOptions struct {
+	// path of the trivy executable, trivy in the PATH by default
+	Path string
+	// where trivy stores its vulnerability database, trivy's default if empty
+	CacheDir string
+	// timeout of each scan, trivy's default (5 minutes) if zero
+	Timeout time.Duration
+}
+
+func NewTrivyScanner(opt ...TrivyScannerOptions) *TrivyScanner {
+	s := &TrivyScanner{path: "trivy"}
+	if len(opt) > 0 {
+		if opt[0].Path != "" {
+			s.path = opt[0].Path
+		}
+		s.cacheDir = opt[0].CacheDir
+		s.timeout = opt[0].Timeout
+	}
+	return s
+}
+
+// report is the json report of trivy image, only the fields used are decoded
+type report struct {
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			Title            string `json:"Title"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+func (s *TrivyScanner) Scan(ctx context.Context, imageID string) (*model.ScanReport, error) {
+	args := []string{"image", "--quiet", "--format", "json", "--scanners", "vuln"}
+	if s.cacheDir != "" {
+		args = append(args, "--cache-dir", s.cacheDir)
+	}
+	if s.timeout > 0 {
+		args = append(args, "--timeout", s.timeout.String())
+	}
+	if layoutPath, ok := builders.OCILayoutPath(imageID); ok {
+		args = append(args, "--input", layoutPath)
+	} else if archivePath, ok := strings.CutPrefix(imageID, distribution.ArchivePrefix); ok {
+		args = append(args, "--input", archivePath)
+	} else {
+		args = append(args, "--image-src", "docker", imageID)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %v: %s", scanners.ErrScanFailed, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var r report
+	if err := json.Unmarshal(stdout.Bytes(), &r); err != nil {
+		return nil, fmt.Errorf("%w: invalid trivy report: %v", scanners.ErrScanFailed, err)
+	}
+	result := &model.ScanReport{Scanner: ScannerName, Vulnerabilities: []model.Vulnerability{}}
+	for _, target := range r.Results {
+		for _, v := range target.Vulnerabilities {
+			result.Vulnerabilities = append(result.Vulnerabilities, model.Vulnerability{
+				ID:               v.VulnerabilityID,
+				Package:          v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				FixedVersion:     v.FixedVersion,
+				Severity:         model.ParseSeverity(v.Severity),
+				Title:            v.Title,
+			})
+		}
+	}
+	return result, nil
+}
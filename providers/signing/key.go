@@ -0,0 +1,125 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrInvalidKey = errors.New("invalid signing key")
+
+// pem types of the keys written by cosign generate-key-pair
+const (
+	pemTypeSigstore = "ENCRYPTED SIGSTORE PRIVATE KEY"
+	pemTypeCosign   = "ENCRYPTED COSIGN PRIVATE KEY"
+)
+
+// encryptedKey is the body of the encrypted cosign keys
+type encryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadKey reads the private key the images are signed with, see ParseKey
+func LoadKey(path, password string) (crypto.Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(raw, password)
+}
+
+// ParseKey parses a pem private key, the keys generated by cosign generate-key-pair
+// (encrypted with the password) and the unencrypted pkcs8, ec and rsa keys are supported.
+// The signatures are verified by cosign with the public key of the pair
+func ParseKey(raw []byte, password string) (crypto.Signer, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no pem block found", ErrInvalidKey)
+	}
+
+	der := block.Bytes
+	switch block.Type {
+	case pemTypeSigstore, pemTypeCosign:
+		var err error
+		if der, err = decrypt(block.Bytes, password); err != nil {
+			return nil, err
+		}
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return key, nil
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+	default:
+		return nil, fmt.Errorf("%w: unsupported pem type %q", ErrInvalidKey, block.Type)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidKey, key)
+}
+
+// decrypt returns the pkcs8 key encrypted by cosign (scrypt and nacl secretbox)
+func decrypt(body []byte, password string) ([]byte, error) {
+	var enc encryptedKey
+	if err := json.Unmarshal(body, &enc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if enc.KDF.Name != "scrypt" || enc.Cipher.Name != "nacl/secretbox" || len(enc.Cipher.Nonce) != 24 {
+		return nil, fmt.Errorf("%w: unsupported encryption %s %s", ErrInvalidKey, enc.KDF.Name, enc.Cipher.Name)
+	}
+	params := enc.KDF.Params
+	secret, err := scrypt.Key([]byte(password), enc.KDF.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	var key [32]byte
+	var nonce [24]byte
+	copy(key[:], secret)
+	copy(nonce[:], enc.Cipher.Nonce)
+	der, ok := secretbox.Open(nil, enc.Ciphertext, &nonce, &key)
+	if !ok {
+		return nil, fmt.Errorf("%w: wrong password", ErrInvalidKey)
+	}
+	return der, nil
+}
@@ -0,0 +1,128 @@
+package signing
+
+import (
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+)
+
+const (
+	InTotoPayloadType           = "application/vnd.in-toto+json"
+	InTotoStatementType         = "https://in-toto.io/Statement/v0.1"
+	SLSAProvenancePredicateType = "https://slsa.dev/provenance/v0.2"
+
+	// BuilderID identifies the service as the builder, the version is appended (@version)
+	BuilderID = "https://github.com/ipaas-org/image-builder"
+)
+
+// Provenance describes how an image was built, the same data of the labels
+// of the images (repo, builder kind and version) plus the commit and the plan
+type Provenance struct {
+	BuilderVersion string
+	BuilderKind    model.BuilderKind
+	// url of the repository (https://github.com/user/repo), the commit is the one built
+	SourceURI string
+	Branch    string
+	Commit    string
+	// the plan without the secrets and its hash (see model.BuildConfig.Hash)
+	Plan     *model.BuildConfig
+	PlanHash string
+
+	BuildID       string
+	RequestID     string
+	ApplicationID string
+	UserID        string
+	StartedOn     time.Time
+	FinishedOn    time.Time
+}
+
+type (
+	statement struct {
+		Type          string              `json:"_type"`
+		PredicateType string              `json:"predicateType"`
+		Subject       []statementSubject  `json:"subject"`
+		Predicate     provenancePredicate `json:"predicate"`
+	}
+
+	statementSubject struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	}
+
+	// provenancePredicate is a slsa provenance v0.2
+	provenancePredicate struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		BuildType  string `json:"buildType"`
+		Invocation struct {
+			ConfigSource provenanceMaterial `json:"configSource"`
+			Parameters   provenanceParams   `json:"parameters"`
+		} `json:"invocation"`
+		Metadata  provenanceMetadata   `json:"metadata"`
+		Materials []provenanceMaterial `json:"materials"`
+	}
+
+	provenanceMaterial struct {
+		URI        string            `json:"uri"`
+		Digest     map[string]string `json:"digest,omitempty"`
+		EntryPoint string            `json:"entryPoint,omitempty"`
+	}
+
+	provenanceParams struct {
+		Builder       model.BuilderKind  `json:"builder"`
+		Plan          *model.BuildConfig `json:"plan,omitempty"`
+		PlanHash      string             `json:"planHash"`
+		ApplicationID string             `json:"applicationID"`
+		UserID        string             `json:"userID"`
+		RequestID     string             `json:"requestID,omitempty"`
+	}
+
+	provenanceMetadata struct {
+		BuildInvocationID string     `json:"buildInvocationId,omitempty"`
+		BuildStartedOn    *time.Time `json:"buildStartedOn,omitempty"`
+		BuildFinishedOn   *time.Time `json:"buildFinishedOn,omitempty"`
+		Reproducible      bool       `json:"reproducible"`
+	}
+)
+
+// statement returns the in-toto statement of the provenance of the image
+func (p *Provenance) statement(repository string, subject map[string]string) statement {
+	predicate := provenancePredicate{
+		BuildType: BuilderID + "/" + string(p.BuilderKind) + "@v1",
+	}
+	predicate.Builder.ID = BuilderID + "@" + p.BuilderVersion
+
+	source := provenanceMaterial{URI: "git+" + p.SourceURI, Digest: map[string]string{"sha1": p.Commit}}
+	predicate.Materials = []provenanceMaterial{source}
+	if p.Branch != "" {
+		source.URI += "@refs/heads/" + p.Branch
+	}
+	if p.Plan != nil {
+		source.EntryPoint = p.Plan.RootDirectory
+	}
+	predicate.Invocation.ConfigSource = source
+	predicate.Invocation.Parameters = provenanceParams{
+		Builder:       p.BuilderKind,
+		Plan:          p.Plan.WithoutSecrets(),
+		PlanHash:      p.PlanHash,
+		ApplicationID: p.ApplicationID,
+		UserID:        p.UserID,
+		RequestID:     p.RequestID,
+	}
+
+	predicate.Metadata.BuildInvocationID = p.BuildID
+	if !p.StartedOn.IsZero() {
+		predicate.Metadata.BuildStartedOn = &p.StartedOn
+	}
+	if !p.FinishedOn.IsZero() {
+		predicate.Metadata.BuildFinishedOn = &p.FinishedOn
+	}
+
+	return statement{
+		Type:          InTotoStatementType,
+		PredicateType: SLSAProvenancePredicateType,
+		Subject:       []statementSubject{{Name: repository, Digest: subject}},
+		Predicate:     predicate,
+	}
+}
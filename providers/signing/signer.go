@@ -0,0 +1,173 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/ipaas-org/image-builder/providers/registry/oci"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	DSSEMediaType          = "application/vnd.dsse.envelope.v1+json"
+	// annotation of the layers of the signatures with the base64 signature of the layer
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// annotation of the layers of the attestations with the type of the predicate
+	PredicateTypeAnnotation = "predicateType"
+
+	simpleSigningType = "cosign container image signature"
+)
+
+// Signer signs the pushed images the way cosign does, the signatures and the attestations
+// are stored in the repository of the image with the tags sha256-<digest>.sig and .att,
+// so cosign verify and cosign verify-attestation work with the public key
+type Signer struct {
+	key crypto.Signer
+}
+
+func NewSigner(key crypto.Signer) *Signer {
+	return &Signer{key: key}
+}
+
+// PublicKey returns the pem public key the signatures are verified with
+func (s *Signer) PublicKey() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// SignatureTag is the tag of the signatures of the image with digest d
+func SignatureTag(d digest.Digest) string {
+	return d.Algorithm().String() + "-" + d.Encoded() + ".sig"
+}
+
+// AttestationTag is the tag of the attestations of the image with digest d
+func AttestationTag(d digest.Digest) string {
+	return d.Algorithm().String() + "-" + d.Encoded() + ".att"
+}
+
+// simpleSigning is the payload signed by cosign, it binds the digest to the repository
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// Signature returns the signature of the image d pushed in repository (server/user/app,
+// without the tag), annotations are added to the optional section of the payload
+func (s *Signer) Signature(repository string, d digest.Digest, annotations map[string]string) (*oci.Artifact, error) {
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = repository
+	payload.Critical.Image.DockerManifestDigest = d.String()
+	payload.Critical.Type = simpleSigningType
+	payload.Optional = annotations
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.sign(raw)
+	if err != nil {
+		return nil, err
+	}
+	layer := oci.Blob{
+		MediaType:   SimpleSigningMediaType,
+		Content:     raw,
+		Annotations: map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+	}
+	return cosignArtifact(layer)
+}
+
+// envelope is a dsse envelope, the signature covers the payload type and the payload
+type envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"`
+	Signatures  []envelopeSignature `json:"signatures"`
+}
+
+type envelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Attestation returns the signed slsa provenance of the image d pushed in repository
+func (s *Signer) Attestation(repository string, d digest.Digest, provenance *Provenance) (*oci.Artifact, error) {
+	statement := provenance.statement(repository, map[string]string{d.Algorithm().String(): d.Encoded()})
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.sign(PAE(InTotoPayloadType, payload))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(envelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []envelopeSignature{{Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	layer := oci.Blob{
+		MediaType: DSSEMediaType,
+		Content:   raw,
+		Annotations: map[string]string{
+			SignatureAnnotation:     "",
+			PredicateTypeAnnotation: SLSAProvenancePredicateType,
+		},
+	}
+	return cosignArtifact(layer)
+}
+
+// PAE is the pre-authentication encoding of dsse, it's what the envelopes sign
+func PAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// sign signs the sha256 of the payload, ed25519 keys sign the payload itself
+func (s *Signer) sign(payload []byte) ([]byte, error) {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		return s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	sum := sha256.Sum256(payload)
+	return s.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+}
+
+// cosignArtifact wraps the layer in an image with the config written by cosign
+func cosignArtifact(layer oci.Blob) (*oci.Artifact, error) {
+	config, err := json.Marshal(map[string]any{
+		"architecture": "",
+		"os":           "",
+		"created":      "0001-01-01T00:00:00Z",
+		"history":      []map[string]string{{"created": "0001-01-01T00:00:00Z"}},
+		"rootfs": map[string]any{
+			"type":     "layers",
+			"diff_ids": []string{layer.Descriptor().Digest.String()},
+		},
+		"config": map[string]any{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &oci.Artifact{
+		Config: oci.Blob{MediaType: "application/vnd.oci.image.config.v1+json", Content: config},
+		Layers: []oci.Blob{layer},
+	}, nil
+}
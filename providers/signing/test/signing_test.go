@@ -0,0 +1,184 @@
+package signing_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/providers/signing"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"gotest.tools/assert"
+)
+
+func ecKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	return key
+}
+
+// cosignKey encrypts the key like cosign generate-key-pair
+func cosignKey(t *testing.T, key any, password string) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NilError(t, err)
+	salt := []byte("0123456789abcdef0123456789abcdef")
+	secret, err := scrypt.Key([]byte(password), salt, 1<<10, 8, 1, 32)
+	assert.NilError(t, err)
+	var box [32]byte
+	var nonce [24]byte
+	copy(box[:], secret)
+	copy(nonce[:], "0123456789abcdef01234567")
+	body, err := json.Marshal(map[string]any{
+		"kdf":        map[string]any{"name": "scrypt", "params": map[string]int{"N": 1 << 10, "r": 8, "p": 1}, "salt": salt},
+		"cipher":     map[string]any{"name": "nacl/secretbox", "nonce": nonce[:]},
+		"ciphertext": secretbox.Seal(nil, der, &nonce, &box),
+	})
+	assert.NilError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: body})
+}
+
+func TestParseKey(t *testing.T) {
+	key := ecKey(t)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+	parsed, err := signing.ParseKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), "")
+	assert.NilError(t, err)
+	assert.Assert(t, key.Equal(parsed))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NilError(t, err)
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NilError(t, err)
+	parsed, err = signing.ParseKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), "")
+	assert.NilError(t, err)
+	assert.Assert(t, edKey.Equal(parsed))
+
+	parsed, err = signing.ParseKey(cosignKey(t, key, "secret"), "secret")
+	assert.NilError(t, err)
+	assert.Assert(t, key.Equal(parsed))
+
+	_, err = signing.ParseKey(cosignKey(t, key, "secret"), "wrong")
+	assert.Assert(t, errors.Is(err, signing.ErrInvalidKey), "got %v", err)
+
+	_, err = signing.ParseKey([]byte("not a key"), "")
+	assert.Assert(t, errors.Is(err, signing.ErrInvalidKey), "got %v", err)
+}
+
+func TestSignature(t *testing.T) {
+	key := ecKey(t)
+	s := signing.NewSigner(key)
+	d := digest.FromString("image")
+
+	artifact, err := s.Signature("registry/user/app", d, map[string]string{"commit": "abc"})
+	assert.NilError(t, err)
+	assert.Equal(t, signing.SignatureTag(d), "sha256-"+d.Encoded()+".sig")
+	assert.Equal(t, len(artifact.Layers), 1)
+	layer := artifact.Layers[0]
+	assert.Equal(t, layer.MediaType, signing.SimpleSigningMediaType)
+
+	var payload struct {
+		Critical struct {
+			Identity struct {
+				DockerReference string `json:"docker-reference"`
+			} `json:"identity"`
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+		Optional map[string]string `json:"optional"`
+	}
+	assert.NilError(t, json.Unmarshal(layer.Content, &payload))
+	assert.Equal(t, payload.Critical.Identity.DockerReference, "registry/user/app")
+	assert.Equal(t, payload.Critical.Image.DockerManifestDigest, d.String())
+	assert.Equal(t, payload.Critical.Type, "cosign container image signature")
+	assert.Equal(t, payload.Optional["commit"], "abc")
+
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[signing.SignatureAnnotation])
+	assert.NilError(t, err)
+	sum := sha256.Sum256(layer.Content)
+	assert.Assert(t, ecdsa.VerifyASN1(&key.PublicKey, sum[:], sig))
+}
+
+func TestAttestation(t *testing.T) {
+	key := ecKey(t)
+	s := signing.NewSigner(key)
+	d := digest.FromString("image")
+	plan := &model.BuildConfig{Builder: "docker", RootDirectory: "/", Secrets: []model.KeyValue{{Key: "TOKEN", Value: "secret"}}}
+
+	artifact, err := s.Attestation("registry/user/app", d, &signing.Provenance{
+		BuilderVersion: "1.2.3",
+		BuilderKind:    "docker",
+		SourceURI:      "https://github.com/user/repo",
+		Branch:         "main",
+		Commit:         "abc",
+		Plan:           plan,
+		PlanHash:       plan.Hash(),
+		StartedOn:      time.Unix(100, 0),
+		FinishedOn:     time.Unix(200, 0),
+	})
+	assert.NilError(t, err)
+	layer := artifact.Layers[0]
+	assert.Equal(t, layer.MediaType, signing.DSSEMediaType)
+	assert.Equal(t, layer.Annotations[signing.PredicateTypeAnnotation], signing.SLSAProvenancePredicateType)
+
+	var envelope struct {
+		PayloadType string `json:"payloadType"`
+		Payload     []byte `json:"payload"`
+		Signatures  []struct {
+			Sig []byte `json:"sig"`
+		} `json:"signatures"`
+	}
+	assert.NilError(t, json.Unmarshal(layer.Content, &envelope))
+	assert.Equal(t, envelope.PayloadType, signing.InTotoPayloadType)
+	assert.Equal(t, len(envelope.Signatures), 1)
+	sum := sha256.Sum256(signing.PAE(envelope.PayloadType, envelope.Payload))
+	assert.Assert(t, ecdsa.VerifyASN1(&key.PublicKey, sum[:], envelope.Signatures[0].Sig))
+
+	var statement struct {
+		PredicateType string `json:"predicateType"`
+		Subject       []struct {
+			Name   string            `json:"name"`
+			Digest map[string]string `json:"digest"`
+		} `json:"subject"`
+		Predicate struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+			Invocation struct {
+				ConfigSource struct {
+					URI    string            `json:"uri"`
+					Digest map[string]string `json:"digest"`
+				} `json:"configSource"`
+				Parameters struct {
+					Builder  string             `json:"builder"`
+					PlanHash string             `json:"planHash"`
+					Plan     *model.BuildConfig `json:"plan"`
+				} `json:"parameters"`
+			} `json:"invocation"`
+		} `json:"predicate"`
+	}
+	assert.NilError(t, json.Unmarshal(envelope.Payload, &statement))
+	assert.Equal(t, statement.PredicateType, signing.SLSAProvenancePredicateType)
+	assert.Equal(t, statement.Subject[0].Name, "registry/user/app")
+	assert.Equal(t, statement.Subject[0].Digest["sha256"], d.Encoded())
+	assert.Equal(t, statement.Predicate.Builder.ID, signing.BuilderID+"@1.2.3")
+	assert.Equal(t, statement.Predicate.Invocation.ConfigSource.URI, "git+https://github.com/user/repo@refs/heads/main")
+	assert.Equal(t, statement.Predicate.Invocation.ConfigSource.Digest["sha1"], "abc")
+	assert.Equal(t, statement.Predicate.Invocation.Parameters.Builder, "docker")
+	assert.Equal(t, statement.Predicate.Invocation.Parameters.PlanHash, plan.Hash())
+	assert.Equal(t, len(statement.Predicate.Invocation.Parameters.Plan.Secrets), 0)
+}
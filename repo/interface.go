@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/ipaas-org/image-builder/model"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -10,9 +11,35 @@ import (
 
 type ApplicationRepoer interface {
 	UpdateStateByID(ctx context.Context, state model.ApplicationState, id primitive.ObjectID) (bool, error)
+	// CompareAndSwapStateByID sets the state only if the current one is expected,
+	// false is returned if it's not (or the application doesn't exist)
+	CompareAndSwapStateByID(ctx context.Context, expected, state model.ApplicationState, id primitive.ObjectID) (bool, error)
 	GetStateByID(ctx context.Context, id primitive.ObjectID) (model.ApplicationState, error)
 }
 
+// BuildRepoer stores the history of the builds
+type BuildRepoer interface {
+	// Create stores a new build, its id is set if empty
+	Create(ctx context.Context, build *model.Build) error
+	Update(ctx context.Context, build *model.Build) error
+	GetByID(ctx context.Context, id string) (*model.Build, error)
+	// ListByApplicationID returns the last builds of the application, the newest first
+	ListByApplicationID(ctx context.Context, applicationID string, limit int64) ([]*model.Build, error)
+	// GetByRequestID returns the last build of the request
+	GetByRequestID(ctx context.Context, requestID string) (*model.Build, error)
+	// GetLastSuccessful returns the last successful build of the commit of the application with the plan hash
+	GetLastSuccessful(ctx context.Context, applicationID, commit, planHash string) (*model.Build, error)
+}
+
+// LockRepoer stores the leases that make sure only one replica builds an application at a time
+type LockRepoer interface {
+	// AcquireLease takes the lease of key for ttl, false is returned if another owner holds
+	// it and it's not expired. The owner acquires the lease again to renew it
+	AcquireLease(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// ReleaseLease releases the lease if it's still held by owner
+	ReleaseLease(ctx context.Context, key, owner string) error
+}
+
 var (
 	ErrNotFound error = errors.New("not found")
 )
@@ -0,0 +1,61 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewApplicationRepoer returns an in-memory ApplicationRepoer, the state of
+// the applications is lost when the service stops. Applications that are
+// not seeded are not found, like in the database
+func NewApplicationRepoer(applications ...*Application) *ApplicationRepoerMemory {
+	r := &ApplicationRepoerMemory{
+		states: make(map[primitive.ObjectID]model.ApplicationState),
+	}
+	for _, app := range applications {
+		r.states[app.ID] = app.State
+	}
+	return r
+}
+
+var _ repo.ApplicationRepoer = new(ApplicationRepoerMemory)
+
+type ApplicationRepoerMemory struct {
+	mu     sync.RWMutex
+	states map[primitive.ObjectID]model.ApplicationState
+}
+
+func (r *ApplicationRepoerMemory) UpdateStateByID(ctx context.Context, state model.ApplicationState, id primitive.ObjectID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.states[id]; !ok {
+		return false, nil
+	}
+	r.states[id] = state
+	return true, nil
+}
+
+func (r *ApplicationRepoerMemory) CompareAndSwapStateByID(ctx context.Context, expected, state model.ApplicationState, id primitive.ObjectID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.states[id]
+	if !ok || current != expected {
+		return false, nil
+	}
+	r.states[id] = state
+	return true, nil
+}
+
+func (r *ApplicationRepoerMemory) GetStateByID(ctx context.Context, id primitive.ObjectID) (model.ApplicationState, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	state, ok := r.states[id]
+	if !ok {
+		return "", repo.ErrNotFound
+	}
+	return state, nil
+}
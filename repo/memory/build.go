@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewBuildRepoer returns an in-memory BuildRepoer, the builds are copied
+// when stored and returned so the callers can't change the stored ones
+func NewBuildRepoer(builds ...*model.Build) *BuildRepoerMemory {
+	r := &BuildRepoerMemory{
+		builds: make(map[string]*model.Build),
+	}
+	for _, build := range builds {
+		r.builds[build.ID] = copyBuild(build)
+	}
+	return r
+}
+
+var _ repo.BuildRepoer = new(BuildRepoerMemory)
+
+type BuildRepoerMemory struct {
+	mu     sync.RWMutex
+	builds map[string]*model.Build
+}
+
+func (r *BuildRepoerMemory) Create(ctx context.Context, build *model.Build) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if build.ID == "" {
+		build.ID = primitive.NewObjectID().Hex()
+	}
+	r.builds[build.ID] = copyBuild(build)
+	return nil
+}
+
+func (r *BuildRepoerMemory) Update(ctx context.Context, build *model.Build) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.builds[build.ID]; !ok {
+		return repo.ErrNotFound
+	}
+	r.builds[build.ID] = copyBuild(build)
+	return nil
+}
+
+func (r *BuildRepoerMemory) GetByID(ctx context.Context, id string) (*model.Build, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	build, ok := r.builds[id]
+	if !ok {
+		return nil, repo.ErrNotFound
+	}
+	return copyBuild(build), nil
+}
+
+func (r *BuildRepoerMemory) ListByApplicationID(ctx context.Context, applicationID string, limit int64) ([]*model.Build, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	builds := make([]*model.Build, 0)
+	for _, build := range r.builds {
+		if build.ApplicationID == applicationID {
+			builds = append(builds, copyBuild(build))
+		}
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].StartedAt.After(builds[j].StartedAt)
+	})
+	if limit > 0 && int64(len(builds)) > limit {
+		builds = builds[:limit]
+	}
+	return builds, nil
+}
+
+func (r *BuildRepoerMemory) GetByRequestID(ctx context.Context, requestID string) (*model.Build, error) {
+	return r.findLast(func(build *model.Build) bool {
+		return build.RequestID == requestID
+	})
+}
+
+func (r *BuildRepoerMemory) GetLastSuccessful(ctx context.Context, applicationID, commit, planHash string) (*model.Build, error) {
+	return r.findLast(func(build *model.Build) bool {
+		return build.ApplicationID == applicationID && build.Commit == commit &&
+			build.PlanHash == planHash && build.Status == model.BuildStatusSuccess
+	})
+}
+
+func (r *BuildRepoerMemory) findLast(match func(*model.Build) bool) (*model.Build, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var last *model.Build
+	for _, build := range r.builds {
+		if match(build) && (last == nil || build.StartedAt.After(last.StartedAt)) {
+			last = build
+		}
+	}
+	if last == nil {
+		return nil, repo.ErrNotFound
+	}
+	return copyBuild(last), nil
+}
+
+// copyBuild deep copies the build, the build is made of plain data
+// so a json round trip copies every nested slice and pointer
+func copyBuild(build *model.Build) *model.Build {
+	raw, err := json.Marshal(build)
+	if err != nil {
+		panic(err)
+	}
+	copied := new(model.Build)
+	if err := json.Unmarshal(raw, copied); err != nil {
+		panic(err)
+	}
+	return copied
+}
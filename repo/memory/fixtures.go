@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ipaas-org/image-builder/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	// Fixtures is the content the in-memory repositories are seeded with, it's read from a json file:
+	//
+	//	{
+	//		"applications": [{"id": "665f1c2e8b3e4a0012345678", "state": "failed"}],
+	//		"builds": [{"id": "...", "applicationID": "665f1c2e8b3e4a0012345678", "status": "success"}]
+	//	}
+	Fixtures struct {
+		Applications []*Application `json:"applications"`
+		Builds       []*model.Build `json:"builds"`
+	}
+
+	Application struct {
+		ID    primitive.ObjectID     `json:"id"`
+		State model.ApplicationState `json:"state"`
+	}
+)
+
+// LoadFixtures reads the fixtures from path, an empty path returns no fixtures
+func LoadFixtures(path string) (*Fixtures, error) {
+	fixtures := new(Fixtures)
+	if path == "" {
+		return fixtures, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, fixtures); err != nil {
+		return nil, fmt.Errorf("invalid fixtures %s: %w", path, err)
+	}
+	for i, app := range fixtures.Applications {
+		if app.ID.IsZero() {
+			return nil, fmt.Errorf("invalid fixtures %s: application %d has no id", path, i)
+		}
+	}
+	for i, build := range fixtures.Builds {
+		if build.ID == "" {
+			return nil, fmt.Errorf("invalid fixtures %s: build %d has no id", path, i)
+		}
+	}
+	return fixtures, nil
+}
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipaas-org/image-builder/repo"
+)
+
+// NewLockRepoer returns an in-memory LockRepoer, it only locks
+// the builds of a single replica
+func NewLockRepoer() *LockRepoerMemory {
+	return &LockRepoerMemory{
+		leases: make(map[string]lease),
+		now:    time.Now,
+	}
+}
+
+var _ repo.LockRepoer = new(LockRepoerMemory)
+
+type LockRepoerMemory struct {
+	mu     sync.Mutex
+	leases map[string]lease
+	now    func() time.Time
+}
+
+type lease struct {
+	owner     string
+	expiresAt time.Time
+}
+
+// SetClock changes the source of the expirations, used in the tests
+func (r *LockRepoerMemory) SetClock(now func() time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = now
+}
+
+func (r *LockRepoerMemory) AcquireLease(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if l, ok := r.leases[key]; ok && l.owner != owner && l.expiresAt.After(now) {
+		return false, nil
+	}
+	r.leases[key] = lease{owner: owner, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (r *LockRepoerMemory) ReleaseLease(ctx context.Context, key, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l, ok := r.leases[key]; ok && l.owner == owner {
+		delete(r.leases, key)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/repo"
+	"github.com/ipaas-org/image-builder/repo/memory"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gotest.tools/assert"
+)
+
+func TestApplicationRepoer(t *testing.T) {
+	ctx := context.Background()
+	fixtures, err := memory.LoadFixtures("fixtures.json")
+	assert.NilError(t, err)
+	r := memory.NewApplicationRepoer(fixtures.Applications...)
+
+	id, _ := primitive.ObjectIDFromHex("665f1c2e8b3e4a0012345678")
+	state, err := r.GetStateByID(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, state, model.ApplicationStateFailed)
+
+	updated, err := r.UpdateStateByID(ctx, model.ApplicationStateBuilding, id)
+	assert.NilError(t, err)
+	assert.Assert(t, updated)
+	state, err = r.GetStateByID(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, state, model.ApplicationStateBuilding)
+
+	// like mongo, unknown applications are not created
+	unknown := primitive.NewObjectID()
+	updated, err = r.UpdateStateByID(ctx, model.ApplicationStateBuilding, unknown)
+	assert.NilError(t, err)
+	assert.Assert(t, !updated)
+	_, err = r.GetStateByID(ctx, unknown)
+	assert.Equal(t, err, repo.ErrNotFound)
+}
+
+func TestBuildRepoer(t *testing.T) {
+	ctx := context.Background()
+	fixtures, err := memory.LoadFixtures("fixtures.json")
+	assert.NilError(t, err)
+	r := memory.NewBuildRepoer(fixtures.Builds...)
+
+	builds, err := r.ListByApplicationID(ctx, "665f1c2e8b3e4a0012345678", 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(builds), 2)
+	assert.Equal(t, builds[0].Commit, "9b8e7d6")
+	assert.Equal(t, builds[1].Commit, "4f2a9c1")
+
+	builds, err = r.ListByApplicationID(ctx, "665f1c2e8b3e4a0012345678", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(builds), 1)
+
+	build := &model.Build{ApplicationID: "665f1c2e8b3e4a0012345679", Status: model.BuildStatusRunning}
+	assert.NilError(t, r.Create(ctx, build))
+	assert.Assert(t, build.ID != "")
+
+	// the stored build is a copy
+	build.Status = model.BuildStatusSuccess
+	stored, err := r.GetByID(ctx, build.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, stored.Status, model.BuildStatusRunning)
+
+	assert.NilError(t, r.Update(ctx, build))
+	stored, err = r.GetByID(ctx, build.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, stored.Status, model.BuildStatusSuccess)
+
+	assert.Equal(t, r.Update(ctx, &model.Build{ID: "missing"}), repo.ErrNotFound)
+	_, err = r.GetByID(ctx, "missing")
+	assert.Equal(t, err, repo.ErrNotFound)
+}
+
+func TestLoadFixtures(t *testing.T) {
+	fixtures, err := memory.LoadFixtures("")
+	assert.NilError(t, err)
+	assert.Equal(t, len(fixtures.Applications), 0)
+
+	_, err = memory.LoadFixtures("missing.json")
+	assert.Assert(t, err != nil)
+}
+
+func TestBuildRepoerLookups(t *testing.T) {
+	ctx := context.Background()
+	r := memory.NewBuildRepoer()
+	builds := []*model.Build{
+		{RequestID: "req-1", ApplicationID: "app", Commit: "abc", PlanHash: "plan", Status: model.BuildStatusSuccess, StartedAt: time.Unix(100, 0)},
+		{RequestID: "req-1", ApplicationID: "app", Commit: "abc", PlanHash: "plan", Status: model.BuildStatusFailed, StartedAt: time.Unix(200, 0)},
+		{RequestID: "req-2", ApplicationID: "app", Commit: "abc", PlanHash: "other", Status: model.BuildStatusSuccess, StartedAt: time.Unix(300, 0)},
+	}
+	for _, build := range builds {
+		assert.NilError(t, r.Create(ctx, build))
+	}
+
+	build, err := r.GetByRequestID(ctx, "req-1")
+	assert.NilError(t, err)
+	assert.Equal(t, build.ID, builds[1].ID)
+
+	build, err = r.GetLastSuccessful(ctx, "app", "abc", "plan")
+	assert.NilError(t, err)
+	assert.Equal(t, build.ID, builds[0].ID)
+
+	_, err = r.GetLastSuccessful(ctx, "app", "def", "plan")
+	assert.Equal(t, err, repo.ErrNotFound)
+}
+
+func TestLockRepoer(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	r := memory.NewLockRepoer()
+	r.SetClock(func() time.Time { return now })
+
+	acquired, err := r.AcquireLease(ctx, "build:app", "replica-1", time.Minute)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	acquired, err = r.AcquireLease(ctx, "build:app", "replica-2", time.Minute)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired)
+
+	// the owner renews it
+	now = now.Add(50 * time.Second)
+	acquired, err = r.AcquireLease(ctx, "build:app", "replica-1", time.Minute)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	// once expired anyone can take it
+	now = now.Add(2 * time.Minute)
+	acquired, err = r.AcquireLease(ctx, "build:app", "replica-2", time.Minute)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+
+	// only the owner releases it
+	assert.NilError(t, r.ReleaseLease(ctx, "build:app", "replica-1"))
+	acquired, err = r.AcquireLease(ctx, "build:app", "replica-1", time.Minute)
+	assert.NilError(t, err)
+	assert.Assert(t, !acquired)
+	assert.NilError(t, r.ReleaseLease(ctx, "build:app", "replica-2"))
+	acquired, err = r.AcquireLease(ctx, "build:app", "replica-1", time.Minute)
+	assert.NilError(t, err)
+	assert.Assert(t, acquired)
+}
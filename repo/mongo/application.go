@@ -38,6 +38,28 @@ func (r *ApplicationRepoerMongo) UpdateStateByID(ctx context.Context, state mode
 	return result.MatchedCount > 0, err
 }
 
+func (r *ApplicationRepoerMongo) CompareAndSwapStateByID(ctx context.Context, expected, state model.ApplicationState, id primitive.ObjectID) (bool, error) {
+	var current any = expected
+	if expected == "" {
+		// the applications never built may not have the field at all
+		current = bson.M{"$in": bson.A{nil, ""}}
+	}
+	// the filter and the update are atomic, if another replica changed
+	// the state in the meantime nothing is matched
+	result, err := r.collection.UpdateOne(ctx, bson.M{
+		"_id":   id,
+		"state": current,
+	}, bson.M{
+		"$set": bson.M{
+			"state": state,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
 func (r *ApplicationRepoerMongo) GetStateByID(ctx context.Context, id primitive.ObjectID) (model.ApplicationState, error) {
 	type State struct {
 		State string `bson:"state"`
@@ -0,0 +1,113 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/ipaas-org/image-builder/model"
+	"github.com/ipaas-org/image-builder/repo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func NewBuildRepoer(collection *mongo.Collection) repo.BuildRepoer {
+	return &BuildRepoerMongo{
+		collection: collection,
+	}
+}
+
+type BuildRepoerMongo struct {
+	collection *mongo.Collection
+}
+
+func (r *BuildRepoerMongo) Create(ctx context.Context, build *model.Build) error {
+	if build.ID == "" {
+		build.ID = primitive.NewObjectID().Hex()
+	}
+	_, err := r.collection.InsertOne(ctx, build)
+	return err
+}
+
+func (r *BuildRepoerMongo) Update(ctx context.Context, build *model.Build) error {
+	result, err := r.collection.ReplaceOne(ctx, bson.M{
+		"_id": build.ID,
+	}, build)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return repo.ErrNotFound
+	}
+	return nil
+}
+
+func (r *BuildRepoerMongo) GetByID(ctx context.Context, id string) (*model.Build, error) {
+	build := new(model.Build)
+	err := r.collection.FindOne(ctx, bson.M{
+		"_id": id,
+	}).Decode(build)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, repo.ErrNotFound
+		}
+		return nil, err
+	}
+	return build, nil
+}
+
+func (r *BuildRepoerMongo) ListByApplicationID(ctx context.Context, applicationID string, limit int64) ([]*model.Build, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "startedAt", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"applicationID": applicationID,
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	builds := make([]*model.Build, 0)
+	if err := cursor.All(ctx, &builds); err != nil {
+		return nil, err
+	}
+	return builds, nil
+}
+
+func (r *BuildRepoerMongo) GetByRequestID(ctx context.Context, requestID string) (*model.Build, error) {
+	return r.findLast(ctx, bson.M{
+		"requestID": requestID,
+	})
+}
+
+func (r *BuildRepoerMongo) GetLastSuccessful(ctx context.Context, applicationID, commit, planHash string) (*model.Build, error) {
+	return r.findLast(ctx, bson.M{
+		"applicationID": applicationID,
+		"commit":        commit,
+		"planHash":      planHash,
+		"status":        model.BuildStatusSuccess,
+	})
+}
+
+func (r *BuildRepoerMongo) findLast(ctx context.Context, filter bson.M) (*model.Build, error) {
+	build := new(model.Build)
+	err := r.collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "startedAt", Value: -1}})).Decode(build)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, repo.ErrNotFound
+		}
+		return nil, err
+	}
+	return build, nil
+}
+
+// EnsureBuildIndexes creates the indexes used to list the builds of an application,
+// to find the builds of a request and the successful builds of a commit
+func EnsureBuildIndexes(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "applicationID", Value: 1}, {Key: "startedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "requestID", Value: 1}}},
+		{Keys: bson.D{{Key: "applicationID", Value: 1}, {Key: "commit", Value: 1}, {Key: "planHash", Value: 1}}},
+	})
+	return err
+}
@@ -0,0 +1,56 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipaas-org/image-builder/repo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func NewLockRepoer(collection *mongo.Collection) repo.LockRepoer {
+	return &LockRepoerMongo{
+		collection: collection,
+	}
+}
+
+// LockRepoerMongo stores a document per lease, the _id is the key so
+// two replicas can't insert the same lease at the same time
+type LockRepoerMongo struct {
+	collection *mongo.Collection
+}
+
+func (r *LockRepoerMongo) AcquireLease(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	// the lease is updated only if it's expired or already ours, if it's held by
+	// another owner the filter doesn't match and the upsert fails on the _id
+	_, err := r.collection.UpdateOne(ctx, bson.M{
+		"_id": key,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expiresAt": bson.M{"$lte": now}},
+		},
+	}, bson.M{
+		"$set": bson.M{
+			"owner":     owner,
+			"expiresAt": now.Add(ttl),
+		},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *LockRepoerMongo) ReleaseLease(ctx context.Context, key, owner string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{
+		"_id":   key,
+		"owner": owner,
+	})
+	return err
+}